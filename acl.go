@@ -0,0 +1,59 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+)
+
+// ACLConfig restricts which clients may query the server. Deny wins over
+// allow, and an empty allow list allows every client not denied.
+type ACLConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// ForwardAllow restricts forwarding to these networks, other clients
+	// only get answers from local records. Forwarding is open when empty.
+	ForwardAllow []string `json:"forward_allow,omitempty"`
+}
+
+// acl is the parsed form of ACLConfig. A nil acl allows everything.
+type acl struct {
+	allow        []*net.IPNet
+	deny         []*net.IPNet
+	forwardAllow []*net.IPNet
+}
+
+// newACL parses the networks of cfg, returning nil when it has none
+func newACL(cfg ACLConfig) (*acl, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 && len(cfg.ForwardAllow) == 0 {
+		return nil, nil
+	}
+	allow, err := parseNetworks(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %v", err)
+	}
+	deny, err := parseNetworks(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny: %v", err)
+	}
+	forwardAllow, err := parseNetworks(cfg.ForwardAllow)
+	if err != nil {
+		return nil, fmt.Errorf("forward_allow: %v", err)
+	}
+	return &acl{allow: allow, deny: deny, forwardAllow: forwardAllow}, nil
+}
+
+// allows reports whether client may query the server
+func (a *acl) allows(client net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if containsIP(a.deny, client) {
+		return false
+	}
+	return len(a.allow) == 0 || containsIP(a.allow, client)
+}
+
+// allowsForwarding reports whether queries of client may be forwarded
+func (a *acl) allowsForwarding(client net.IP) bool {
+	return a == nil || len(a.forwardAllow) == 0 || containsIP(a.forwardAllow, client)
+}
@@ -0,0 +1,117 @@
+package easydns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestACLAllows(t *testing.T) {
+	a, err := newACL(ACLConfig{
+		Allow:        []string{"192.168.0.0/16", "2001:db8::/32"},
+		Deny:         []string{"192.168.66.0/24", "192.168.1.13"},
+		ForwardAllow: []string{"192.168.1.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		client         string
+		wantAllowed    bool
+		wantForwarding bool
+	}{
+		{client: "192.168.1.10", wantAllowed: true, wantForwarding: true},
+		{client: "192.168.2.10", wantAllowed: true},
+		{client: "192.168.66.1"},
+		{client: "192.168.1.13", wantForwarding: true},
+		{client: "10.0.0.1"},
+		{client: "2001:db8::1", wantAllowed: true},
+		{client: "2001:db9::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client, func(t *testing.T) {
+			client := net.ParseIP(tt.client)
+			if got := a.allows(client); got != tt.wantAllowed {
+				t.Errorf("allows is %v, want %v", got, tt.wantAllowed)
+			}
+			if got := a.allowsForwarding(client); got != tt.wantForwarding {
+				t.Errorf("allowsForwarding is %v, want %v", got, tt.wantForwarding)
+			}
+		})
+	}
+	var open *acl
+	if !open.allows(net.ParseIP("10.0.0.1")) || !open.allowsForwarding(net.ParseIP("10.0.0.1")) {
+		t.Error("nil acl restricts clients")
+	}
+}
+
+func TestNewACLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ACLConfig
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "addresses and networks", config: ACLConfig{Allow: []string{"10.0.0.1", "10.0.0.0/8", "::1"}}},
+		{name: "invalid allow", config: ACLConfig{Allow: []string{"10.0.0.0/33"}}, wantErr: true},
+		{name: "invalid deny", config: ACLConfig{Deny: []string{"not-an-address"}}, wantErr: true},
+		{name: "invalid forward_allow", config: ACLConfig{ForwardAllow: []string{"10.0.0"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newACL(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACLResponses(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Records:    Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+		ACL: ACLConfig{
+			Deny:         []string{"203.0.113.0/24"},
+			ForwardAllow: []string{"192.168.1.0/24"},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		client    string
+		qname     string
+		wantRcode int
+		want      []string
+	}{
+		{name: "trusted client forwards", client: "192.168.1.10", qname: "www.example.com.", wantRcode: dns.RcodeSuccess, want: []string{"192.0.2.1"}},
+		{name: "trusted client gets local records", client: "192.168.1.10", qname: "app.test.com.", wantRcode: dns.RcodeSuccess, want: []string{"10.0.0.1"}},
+		{name: "other client gets local records", client: "10.1.1.1", qname: "app.test.com.", wantRcode: dns.RcodeSuccess, want: []string{"10.0.0.1"}},
+		{name: "other client may not forward", client: "10.1.1.1", qname: "www.example.com.", wantRcode: dns.RcodeRefused},
+		{name: "denied client", client: "203.0.113.5", qname: "app.test.com.", wantRcode: dns.RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, dns.TypeA)
+			w := newRecorder("udp", tt.client)
+			s.ServeDNS(w, query)
+			if w.msg == nil {
+				t.Fatal("query was dropped")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[w.msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := answerValues(w.msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
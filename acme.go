@@ -0,0 +1,73 @@
+package easydns
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// acmeChallengeTTL is kept short so validators never see a stale token
+const acmeChallengeTTL = 10
+
+// acmeChallenges holds the in-memory _acme-challenge TXT values presented
+// through the API. A name can carry several values at once, e.g. when a
+// certificate covers both example.com and *.example.com.
+type acmeChallenges struct {
+	mu     sync.RWMutex
+	values map[string][]string
+}
+
+func newACMEChallenges() *acmeChallenges {
+	return &acmeChallenges{values: map[string][]string{}}
+}
+
+func normalizeChallengeName(fqdn string) string {
+	return strings.ToLower(strings.TrimSuffix(fqdn, "."))
+}
+
+// present adds a TXT value for the given challenge name
+func (c *acmeChallenges) present(fqdn, value string) {
+	name := normalizeChallengeName(fqdn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.values[name] {
+		if existing == value {
+			return
+		}
+	}
+	c.values[name] = append(c.values[name], value)
+}
+
+// cleanup removes a TXT value for the given challenge name
+func (c *acmeChallenges) cleanup(fqdn, value string) {
+	name := normalizeChallengeName(fqdn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	remaining := c.values[name][:0]
+	for _, existing := range c.values[name] {
+		if existing != value {
+			remaining = append(remaining, existing)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(c.values, name)
+	} else {
+		c.values[name] = remaining
+	}
+}
+
+// answer returns TXT RRs for a presented challenge name, or nil if none exist
+func (c *acmeChallenges) answer(q dns.Question) []dns.RR {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	values := c.values[normalizeChallengeName(q.Name)]
+	rrs := make([]dns.RR, 0, len(values))
+	for _, value := range values {
+		rrs = append(rrs, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: acmeChallengeTTL},
+			Txt: []string{value},
+		})
+	}
+	return rrs
+}
@@ -0,0 +1,37 @@
+package easydns
+
+import "github.com/miekg/dns"
+
+// anyHINFOTTL is the TTL of the HINFO record answering ANY queries. It may
+// be long since the answer never changes, RFC 8482 4.2.
+const anyHINFOTTL = 3600
+
+// answersANYWithHINFO reports whether q is an ANY query to be answered with
+// a single HINFO record instead of all records of the name
+func (c ServerConfig) answersANYWithHINFO(q dns.Question) bool {
+	return q.Qtype == dns.TypeANY && c.AnyQueries == "hinfo"
+}
+
+// hinfoANY returns the minimal answer to the ANY query q, RFC 8482 4.2
+func hinfoANY(q dns.Question) dns.RR {
+	return &dns.HINFO{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: anyHINFOTTL},
+		Cpu: "RFC8482",
+	}
+}
+
+// minimizeResponse drops the authority and additional records of positive
+// answers. Negative answers keep their SOA so clients can cache them.
+func minimizeResponse(msg *dns.Msg) {
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) == 0 {
+		return
+	}
+	msg.Ns = nil
+	var extra []dns.RR
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	msg.Extra = extra
+}
@@ -0,0 +1,146 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestANYQueries(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.10")
+		resp.Answer = append(resp.Answer, rr)
+		w.WriteMsg(resp)
+	})
+	records := Records{"app.test.com": {
+		{Type: "A", Value: "10.0.0.1", TTL: 60},
+		{Type: "TXT", Value: "hello", TTL: 60},
+	}}
+	tests := []struct {
+		name        string
+		mode        string
+		qname       string
+		want        int // Answer records
+		wantHINFO   bool
+		wantQueries int64
+	}{
+		{name: "local name", mode: "hinfo", qname: "app.test.com.", want: 1, wantHINFO: true},
+		{name: "forwarded name", mode: "hinfo", qname: "example.org.", want: 1, wantHINFO: true},
+		{name: "all local records", mode: "all", qname: "app.test.com.", want: 2},
+		{name: "forwarded by default", qname: "example.org.", want: 1, wantQueries: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(&Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53", AnyQueries: tt.mode},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+				Records:    records,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := up.queries.Load()
+			resp := ask(t, s, tt.qname, dns.TypeANY)
+			if len(resp.Answer) != tt.want {
+				t.Fatalf("got answers %v, want %d", resp.Answer, tt.want)
+			}
+			hinfo, ok := resp.Answer[0].(*dns.HINFO)
+			if ok != tt.wantHINFO {
+				t.Errorf("got answer %v, want HINFO: %v", resp.Answer[0], tt.wantHINFO)
+			}
+			if ok && (hinfo.Cpu != "RFC8482" || hinfo.Hdr.Ttl != anyHINFOTTL || hinfo.Hdr.Name != tt.qname) {
+				t.Errorf("got HINFO %v, want RFC 8482 answer for %s", hinfo, tt.qname)
+			}
+			if queries := up.queries.Load() - before; queries != tt.wantQueries {
+				t.Errorf("upstream was asked %d times, want %d", queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestMinimalResponses(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		for _, s := range []string{
+			r.Question[0].Name + " 60 IN A 192.0.2.10",
+			"example.org. 60 IN NS ns.example.org.",
+			"ns.example.org. 60 IN A 192.0.2.53",
+		} {
+			rr, _ := dns.NewRR(s)
+			switch rr.Header().Rrtype {
+			case dns.TypeNS:
+				resp.Ns = append(resp.Ns, rr)
+			case dns.TypeA:
+				if rr.Header().Name == "ns.example.org." {
+					resp.Extra = append(resp.Extra, rr)
+				} else {
+					resp.Answer = append(resp.Answer, rr)
+				}
+			}
+		}
+		w.WriteMsg(resp)
+	})
+	s, err := New(&Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53", MinimalResponses: true},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.org.", dns.TypeA)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	resp := serve(s, query)
+	if resp == nil {
+		t.Fatal("query was dropped")
+	}
+	if got, want := answerValues(resp), []string{"192.0.2.10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got answers %v, want %v", got, want)
+	}
+	if len(resp.Ns) != 0 {
+		t.Errorf("got authority records %v, want none", resp.Ns)
+	}
+	// Only the OPT record of the client is left
+	if len(resp.Extra) != 1 || resp.IsEdns0() == nil {
+		t.Errorf("got additional records %v, want only OPT", resp.Extra)
+	}
+}
+
+func TestMinimizeResponseKeepsNegativeAnswers(t *testing.T) {
+	soa := mustRR(t, "test.com. 60 IN SOA ns.test.com. admin.test.com. 1 3600 600 86400 60")
+	tests := []struct {
+		name  string
+		rcode int
+	}{
+		{name: "NXDOMAIN", rcode: dns.RcodeNameError},
+		{name: "NODATA", rcode: dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.Rcode = tt.rcode
+			msg.Ns = []dns.RR{soa}
+			minimizeResponse(msg)
+			if len(msg.Ns) != 1 {
+				t.Errorf("got authority records %v, want the SOA", msg.Ns)
+			}
+		})
+	}
+}
+
+func TestValidateANYQueries(t *testing.T) {
+	for _, mode := range []string{"", "all", "hinfo"} {
+		if err := ValidateConfig(&Config{Version: currentConfigVersion, Server: ServerConfig{Port: "53", AnyQueries: mode}}); err != nil {
+			t.Errorf("got error %v for any_queries %q, want none", err, mode)
+		}
+	}
+	if err := ValidateConfig(&Config{Version: currentConfigVersion, Server: ServerConfig{Port: "53", AnyQueries: "none"}}); err == nil {
+		t.Error("unknown any_queries was accepted")
+	}
+}
@@ -0,0 +1,141 @@
+package easydns
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// acmeRequest is the body accepted by the ACME endpoints. It matches the
+// format used by lego's httpreq provider.
+type acmeRequest struct {
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+}
+
+func decodeACMERequest(w http.ResponseWriter, r *http.Request) (acmeRequest, bool) {
+	var req acmeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed request body", http.StatusBadRequest)
+		return req, false
+	}
+	if !strings.HasPrefix(strings.ToLower(req.FQDN), "_acme-challenge.") || req.Value == "" {
+		http.Error(w, "fqdn must start with _acme-challenge. and value must be set", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func (s *Server) handleACMEPresent(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeACMERequest(w, r)
+	if !ok {
+		return
+	}
+	s.challenges.present(req.FQDN, req.Value)
+	log.Printf("acme challenge presented for %s", req.FQDN)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleACMECleanup(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeACMERequest(w, r)
+	if !ok {
+		return
+	}
+	s.challenges.cleanup(req.FQDN, req.Value)
+	log.Printf("acme challenge cleaned up for %s", req.FQDN)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleCacheFlush empties the cache, or with the name parameter removes
+// the answers for the name and the names below it
+func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if name := r.URL.Query().Get("name"); name != "" {
+		if _, ok := dns.IsDomainName(name); !ok {
+			http.Error(w, fmt.Sprintf("%q is not a domain name", name), http.StatusBadRequest)
+			return
+		}
+		flushed := s.cache.flushName(name)
+		log.Printf("flushed %d cache entries of %s", flushed, name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"flushed": flushed})
+		return
+	}
+	flushed := s.cache.flush()
+	log.Printf("flushed %d cache entries", flushed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"flushed": flushed})
+}
+
+// redactedConfig returns a copy of cfg without the API token, the storage
+// password and the TSIG secrets
+func redactedConfig(cfg *Config) *Config {
+	redacted := *cfg
+	redacted.API.Token = ""
+	redacted.Storage.Password = ""
+	redacted.Update.Keys = withoutSecrets(cfg.Update.Keys)
+	redacted.Transfer.Keys = withoutSecrets(cfg.Transfer.Keys)
+	redacted.TSIG.Keys = withoutSecrets(cfg.TSIG.Keys)
+	return &redacted
+}
+
+func withoutSecrets(keys []TSIGKey) []TSIGKey {
+	var redacted []TSIGKey
+	for _, key := range keys {
+		key.Secret = ""
+		redacted = append(redacted, key)
+	}
+	return redacted
+}
+
+// handleGetConfig returns the running config for config -diff, with its
+// secrets left out
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, redactedConfig(s.currentConfig()))
+}
+
+// requireToken rejects requests that don't carry token as a bearer token.
+// An empty token lets every request through.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiHandler routes the HTTP API
+func (s *Server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /acme/present", s.handleACMEPresent)
+	mux.HandleFunc("POST /acme/cleanup", s.handleACMECleanup)
+	mux.HandleFunc("GET /records/stats", s.handleRecordStats)
+	mux.HandleFunc("POST /cache/flush", s.handleCacheFlush)
+	mux.HandleFunc("GET /api/v1/records", s.handleListRecords)
+	mux.HandleFunc("GET /api/v1/records/{name}", s.handleGetRecord)
+	mux.HandleFunc("PUT /api/v1/records/{name}", s.handlePutRecord)
+	mux.HandleFunc("DELETE /api/v1/records/{name}", s.handleDeleteRecord)
+	mux.HandleFunc("GET /api/v1/stats", s.handleDashboardStats)
+	mux.HandleFunc("GET /api/v1/queries", s.handleRecentQueries)
+	mux.HandleFunc("GET /api/v1/config", s.handleGetConfig)
+	cfg := s.currentConfig().API
+	if !cfg.Dashboard {
+		return requireToken(cfg.Token, mux)
+	}
+	routes := http.NewServeMux()
+	routes.Handle("GET /dashboard/", dashboardHandler())
+	routes.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+	routes.Handle("/", requireToken(cfg.Token, mux))
+	return routes
+}
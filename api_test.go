@@ -0,0 +1,39 @@
+package easydns
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGetConfigLeavesOutSecrets(t *testing.T) {
+	key := TSIGKey{Name: "dhcp-key", Algorithm: "hmac-sha256", Secret: "c2VjcmV0"}
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		API:     APIConfig{Enabled: true, Address: "127.0.0.1:0", Token: "secret"},
+		Update:  UpdateConfig{Keys: []TSIGKey{key}},
+		TSIG:    TSIGConfig{Keys: []TSIGKey{key}},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if status := dashboardGet(t, s, "/api/v1/config", "secret", &cfg); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	if cfg.API.Token != "" || cfg.Update.Keys[0].Secret != "" || cfg.TSIG.Keys[0].Secret != "" {
+		t.Errorf("got secrets in the config: token %q, keys %v and %v", cfg.API.Token, cfg.Update.Keys, cfg.TSIG.Keys)
+	}
+	if cfg.Update.Keys[0].Name != key.Name {
+		t.Errorf("got update keys %v, want %s without its secret", cfg.Update.Keys, key.Name)
+	}
+	if want := (Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}}); !reflect.DeepEqual(cfg.Records, want) {
+		t.Errorf("got records %v, want %v", cfg.Records, want)
+	}
+	// The running config itself keeps them
+	if s.currentConfig().API.Token != "secret" || s.currentConfig().TSIG.Keys[0].Secret == "" {
+		t.Error("the secrets of the running config were removed")
+	}
+}
@@ -0,0 +1,41 @@
+package easydns
+
+import "github.com/miekg/dns"
+
+// authoritativeZone returns the most specific of zones containing the
+// normalized name
+func authoritativeZone(zones []string, name string) (string, bool) {
+	best, found := "", false
+	fqdn := dns.Fqdn(name)
+	for _, zone := range zones {
+		z := recordName(zone)
+		if dns.IsSubDomain(dns.Fqdn(z), fqdn) && (!found || dns.CountLabel(z) > dns.CountLabel(best)) {
+			best, found = z, true
+		}
+	}
+	return best, found
+}
+
+// appendZoneSOA adds the SOA record of zone to the authority section of
+// msg, as negative answers from an authoritative server carry it. Nothing
+// is added when the zone has no SOA record.
+func appendZoneSOA(msg *dns.Msg, records Records, zone string) {
+	var soa *Record
+	for i, record := range records[zone] {
+		if record.Type == "SOA" {
+			soa = &records[zone][i]
+			break
+		}
+	}
+	if soa == nil {
+		return
+	}
+	rr, err := newRR(dns.Fqdn(zone), *soa)
+	if err != nil {
+		return
+	}
+	// Negative answers may be cached for the lower of the TTL and the
+	// SOA minimum, see RFC 2308
+	rr.Header().Ttl = min(rr.Header().Ttl, rr.(*dns.SOA).Minttl)
+	msg.Ns = append(msg.Ns, rr)
+}
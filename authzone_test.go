@@ -0,0 +1,97 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAuthoritativeZone(t *testing.T) {
+	zones := []string{"test.com", "Lab.Test.com.", "example.org"}
+	tests := []struct {
+		name      string
+		wantZone  string
+		wantFound bool
+	}{
+		{name: "test.com", wantZone: "test.com", wantFound: true},
+		{name: "app.test.com", wantZone: "test.com", wantFound: true},
+		{name: "host.lab.test.com", wantZone: "lab.test.com", wantFound: true},
+		{name: "nottest.com"},
+		{name: "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, found := authoritativeZone(zones, tt.name)
+			if zone != tt.wantZone || found != tt.wantFound {
+				t.Errorf("got %q, %v, want %q, %v", zone, found, tt.wantZone, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestAuthoritativeAnswers(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	const soa = "ns1.test.com. hostmaster.test.com. 2024010101 7200 3600 1209600 300"
+	cfg := &Config{
+		Version:            currentConfigVersion,
+		Server:             ServerConfig{Port: "53"},
+		Forwarding:         ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		AuthoritativeZones: []string{"test.com"},
+		Records: Records{
+			"test.com":     {{Type: "SOA", Value: soa, TTL: 3600}},
+			"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name              string
+		qname             string
+		qtype             uint16
+		wantRcode         int
+		wantAuthoritative bool
+		want              []string
+		wantSOA           bool
+		wantForward       bool
+	}{
+		{name: "local answer", qname: "app.test.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, wantAuthoritative: true, want: []string{"10.0.0.1"}},
+		{name: "SOA query", qname: "test.com", qtype: dns.TypeSOA, wantRcode: dns.RcodeSuccess, wantAuthoritative: true, want: []string{soa}},
+		{name: "NXDOMAIN in the zone", qname: "missing.test.com", qtype: dns.TypeA, wantRcode: dns.RcodeNameError, wantAuthoritative: true, wantSOA: true},
+		{name: "NODATA in the zone", qname: "app.test.com", qtype: dns.TypeTXT, wantRcode: dns.RcodeSuccess, wantAuthoritative: true, wantSOA: true},
+		{name: "name outside the zone", qname: "www.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"192.0.2.1"}, wantForward: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := up.queries.Load()
+			resp := ask(t, s, tt.qname, tt.qtype)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if resp.Authoritative != tt.wantAuthoritative {
+				t.Errorf("got AA %v, want %v", resp.Authoritative, tt.wantAuthoritative)
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if forwarded := up.queries.Load() > before; forwarded != tt.wantForward {
+				t.Errorf("forwarded is %v, want %v", forwarded, tt.wantForward)
+			}
+			if !tt.wantSOA {
+				if len(resp.Ns) != 0 {
+					t.Errorf("got authority %v, want none", resp.Ns)
+				}
+				return
+			}
+			if len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA {
+				t.Fatalf("got authority %v, want the zone SOA", resp.Ns)
+			}
+			// The negative answer is cached for the SOA minimum
+			if got := resp.Ns[0].Header().Ttl; got != 300 {
+				t.Errorf("got SOA TTL %d, want 300", got)
+			}
+		})
+	}
+}
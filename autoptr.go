@@ -0,0 +1,48 @@
+package easydns
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// withAutoPTRs adds a PTR record pointing back at the name of every A and
+// AAAA record. Configured PTR records win, and when several names share an
+// address the first one in sorted order is used. Wildcard names are skipped.
+func withAutoPTRs(records Records) Records {
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	withPTRs := make(Records, len(records))
+	for name, set := range records {
+		withPTRs[name] = set
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+		for _, record := range records[name] {
+			if record.Type != "A" && record.Type != "AAAA" {
+				continue
+			}
+			ip := net.ParseIP(record.Value)
+			if ip == nil {
+				continue
+			}
+			reverse, err := dns.ReverseAddr(ip.String())
+			if err != nil {
+				continue
+			}
+			key := recordName(reverse)
+			if _, found := withPTRs[key]; found {
+				continue
+			}
+			withPTRs[key] = []Record{{Type: "PTR", Value: dns.Fqdn(name), TTL: record.TTL}}
+		}
+	}
+	return withPTRs
+}
@@ -0,0 +1,78 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWithAutoPTRs(t *testing.T) {
+	records := Records{
+		"host.test.com":           {{Type: "A", Value: "192.0.2.10", TTL: 300}},
+		"alias.test.com":          {{Type: "A", Value: "192.0.2.10", TTL: 60}},
+		"v6.test.com":             {{Type: "AAAA", Value: "2001:db8::1", TTL: 300}},
+		"*.test.com":              {{Type: "A", Value: "192.0.2.99"}},
+		"named.test.com":          {{Type: "A", Value: "192.0.2.20"}},
+		"20.2.0.192.in-addr.arpa": {{Type: "PTR", Value: "configured.test.com."}},
+	}
+	got := withAutoPTRs(records)
+	tests := []struct {
+		address string
+		want    []Record
+	}{
+		{address: "192.0.2.10", want: []Record{{Type: "PTR", Value: "alias.test.com.", TTL: 60}}},
+		{address: "2001:db8::1", want: []Record{{Type: "PTR", Value: "v6.test.com.", TTL: 300}}},
+		{address: "192.0.2.20", want: []Record{{Type: "PTR", Value: "configured.test.com."}}},
+		{address: "192.0.2.99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			reverse, err := dns.ReverseAddr(tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := recordName(reverse); !reflect.DeepEqual(got[name], tt.want) {
+				t.Errorf("got %v, want %v", got[name], tt.want)
+			}
+		})
+	}
+	if _, found := records["10.2.0.192.in-addr.arpa"]; found {
+		t.Error("withAutoPTRs modified its input")
+	}
+}
+
+func TestAutoPTRAnswers(t *testing.T) {
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		AutoPTR: true,
+		Records: Records{
+			"host.test.com": {{Type: "A", Value: "192.0.2.10", TTL: 60}},
+			"v6.test.com":   {{Type: "AAAA", Value: "2001:db8::1", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		want    []string
+	}{
+		{address: "192.0.2.10", want: []string{"host.test.com."}},
+		{address: "2001:db8::1", want: []string{"v6.test.com."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			reverse, err := dns.ReverseAddr(tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, reverse, dns.TypePTR)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
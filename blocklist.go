@@ -0,0 +1,293 @@
+package easydns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	blockedTTL           = 60
+	blocklistHTTPTimeout = 30 * time.Second
+)
+
+// BlocklistConfig sinkholes queries for unwanted names, e.g. ad and tracker
+// domains. Blocking a name also blocks all of its subdomains.
+type BlocklistConfig struct {
+	Names []string `json:"names,omitempty"`
+	// HostsFile is a hosts-format file such as the lists used by Pi-hole
+	HostsFile string `json:"hosts_file,omitempty"`
+	// Sources are further lists in hosts, domain list or AdGuard format,
+	// given as file paths or http(s) URLs
+	Sources []string `json:"sources,omitempty"`
+	// Allow lists names that are never blocked, along with their subdomains
+	Allow []string `json:"allow,omitempty"`
+	// BlockMode is "null" (answer 0.0.0.0 or ::, the default), "nxdomain"
+	// or "address"
+	BlockMode string `json:"block_mode,omitempty"`
+	// Address is answered for A or AAAA queries in "address" mode
+	Address string `json:"address,omitempty"`
+	// Refresh reloads the lists periodically, e.g. "24h"
+	Refresh string `json:"refresh,omitempty"`
+}
+
+// validate checks the block mode, sources and refresh interval
+func (c BlocklistConfig) validate() error {
+	switch c.BlockMode {
+	case "", "null", "nxdomain":
+	case "address":
+		if net.ParseIP(c.Address) == nil {
+			return fmt.Errorf("address %q is not an IP address", c.Address)
+		}
+	default:
+		return fmt.Errorf("unknown block_mode %q, expected null, nxdomain or address", c.BlockMode)
+	}
+	for _, source := range c.Sources {
+		if source == "" {
+			return fmt.Errorf("sources must not be empty")
+		}
+	}
+	if _, err := c.refreshInterval(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// refreshInterval returns how often the lists are reloaded, 0 when they are
+// only loaded at startup
+func (c BlocklistConfig) refreshInterval() (time.Duration, error) {
+	if c.Refresh == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(c.Refresh)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid refresh %q", c.Refresh)
+	}
+	return interval, nil
+}
+
+// blocklist is a set of blocked names. A nil blocklist blocks nothing.
+type blocklist struct {
+	names   map[string]struct{}
+	allowed map[string]struct{}
+	sources map[string]map[string]struct{} // Names loaded from each source
+	mode    string
+	address net.IP
+}
+
+// hostsFileSkipped are names found in hosts files that must never be blocked
+var hostsFileSkipped = map[string]bool{
+	"localhost.":             true,
+	"localhost.localdomain.": true,
+	"local.":                 true,
+	"broadcasthost.":         true,
+	"ip6-localhost.":         true,
+	"ip6-loopback.":          true,
+	"0.0.0.0.":               true,
+}
+
+// isRemoteSource reports whether a blocklist source is fetched over HTTP
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// loadBlocklist builds the blocklist from its config, returning nil when
+// nothing is blocked. A source that can't be loaded keeps the names it had
+// in previous. Without previous names, a local file is an error and a URL
+// is skipped, so an unreachable list doesn't keep the server from starting.
+func loadBlocklist(cfg BlocklistConfig, previous *blocklist) (*blocklist, error) {
+	if len(cfg.Names) == 0 && cfg.HostsFile == "" && len(cfg.Sources) == 0 {
+		return nil, nil
+	}
+	b := &blocklist{
+		names:   map[string]struct{}{},
+		allowed: map[string]struct{}{},
+		sources: map[string]map[string]struct{}{},
+		mode:    cfg.BlockMode,
+		address: net.ParseIP(cfg.Address),
+	}
+	for _, name := range cfg.Names {
+		b.names[dns.CanonicalName(name)] = struct{}{}
+	}
+	for _, name := range cfg.Allow {
+		b.allowed[dns.CanonicalName(name)] = struct{}{}
+	}
+	sources := cfg.Sources
+	if cfg.HostsFile != "" {
+		sources = append([]string{cfg.HostsFile}, sources...)
+	}
+	for _, source := range sources {
+		names, err := loadSource(source)
+		if err != nil {
+			previousNames, found := previous.sourceNames(source)
+			switch {
+			case found:
+				log.Printf("failed to reload blocklist %s, keeping its previous names: %v", source, err)
+				names = previousNames
+			case isRemoteSource(source):
+				log.Printf("skipping blocklist %s: %v", source, err)
+				continue
+			default:
+				return nil, err
+			}
+		}
+		b.sources[source] = names
+		for name := range names {
+			b.names[name] = struct{}{}
+		}
+	}
+	return b, nil
+}
+
+// sourceNames returns the names loaded from source
+func (b *blocklist) sourceNames(source string) (map[string]struct{}, bool) {
+	if b == nil {
+		return nil, false
+	}
+	names, found := b.sources[source]
+	return names, found
+}
+
+// loadSource reads the names of a blocklist file or URL
+func loadSource(source string) (map[string]struct{}, error) {
+	if !isRemoteSource(source) {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readList(source, file)
+	}
+	client := http.Client{Timeout: blocklistHTTPTimeout}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", source, resp.Status)
+	}
+	return readList(source, resp.Body)
+}
+
+// readList reads the names of a hosts-format list. Lines holding only a
+// name, as in plain domain lists, are accepted too, as are AdGuard style
+// "||name^" rules. Other AdGuard rules, such as exceptions, are skipped.
+func readList(source string, r io.Reader) (map[string]struct{}, error) {
+	names := map[string]struct{}{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		if rule, found := strings.CutPrefix(line, "||"); found {
+			line, found = strings.CutSuffix(rule, "^")
+			if !found {
+				continue
+			}
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, field := range fields {
+			name := dns.CanonicalName(field)
+			if _, ok := dns.IsDomainName(name); ok && !hostsFileSkipped[name] {
+				names[name] = struct{}{}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", source, err)
+	}
+	return names, nil
+}
+
+// blocks reports whether name or one of its parent domains is blocked.
+// The most specific listed name decides, so an allowed name can lift the
+// block of its parent domain.
+func (b *blocklist) blocks(name string) bool {
+	if b == nil {
+		return false
+	}
+	name = dns.CanonicalName(name)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if _, found := b.allowed[name[off:]]; found {
+			return false
+		}
+		if _, found := b.names[name[off:]]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// answer writes the sinkhole answer for q to msg
+func (b *blocklist) answer(msg *dns.Msg, q dns.Question) {
+	if b.mode == "nxdomain" {
+		msg.Rcode = dns.RcodeNameError
+		return
+	}
+	ipv4, ipv6 := net.IPv4zero, net.IPv6zero
+	if b.mode == "address" {
+		// Only the family of the configured address is answered
+		ipv4, ipv6 = b.address.To4(), nil
+		if ipv4 == nil {
+			ipv6 = b.address
+		}
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: blockedTTL}
+	switch {
+	case q.Qtype == dns.TypeA && ipv4 != nil:
+		msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ipv4})
+	case q.Qtype == dns.TypeAAAA && ipv6 != nil:
+		msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ipv6})
+	}
+}
+
+// refreshBlocklist reloads the blocklist every interval until the server
+// is shut down. Lists that can't be loaded keep their previous names.
+func (s *Server) refreshBlocklist(cfg BlocklistConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		if _, err := s.updateBlocklist(cfg); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// updateBlocklist reloads the blocklist now and returns the number of
+// blocked names
+func (s *Server) updateBlocklist(cfg BlocklistConfig) (int, error) {
+	b, err := loadBlocklist(cfg, s.blocklist.Load())
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh blocklist, keeping the previous one: %v", err)
+	}
+	s.blocklist.Store(b)
+	log.Printf("refreshed blocklist, %d names blocked", b.size())
+	return b.size(), nil
+}
+
+// size returns the number of blocked names
+func (b *blocklist) size() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.names)
+}
@@ -0,0 +1,187 @@
+package easydns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestReadList(t *testing.T) {
+	list := `# hosts format
+0.0.0.0 ads.example.com tracker.example.com
+127.0.0.1 localhost
+plain.example.net # a plain domain list entry
+||adguard.example.org^
+@@||allowed.example.org^
+! an AdGuard comment
+||no-anchor.example.org
+`
+	got, err := readList("test", strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct{}{
+		"ads.example.com.":     {},
+		"tracker.example.com.": {},
+		"plain.example.net.":   {},
+		"adguard.example.org.": {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBlocklistBlocks(t *testing.T) {
+	b, err := loadBlocklist(BlocklistConfig{
+		Names: []string{"doubleclick.net", "Ads.Example.com"},
+		Allow: []string{"safe.doubleclick.net"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "doubleclick.net.", want: true},
+		{name: "ad.doubleclick.net.", want: true},
+		{name: "AD.DoubleClick.Net.", want: true},
+		{name: "safe.doubleclick.net.", want: false},
+		{name: "www.safe.doubleclick.net.", want: false},
+		{name: "ads.example.com.", want: true},
+		{name: "example.com.", want: false},
+		{name: "notdoubleclick.net.", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.blocks(tt.name); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	var none *blocklist
+	if none.blocks("ad.doubleclick.net.") {
+		t.Error("nil blocklist blocks names")
+	}
+}
+
+func TestBlocklistAnswers(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("0.0.0.0 tracker.test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		blocklist BlocklistConfig
+		qname     string
+		qtype     uint16
+		wantRcode int
+		want      []string
+	}{
+		{name: "null A", blocklist: BlocklistConfig{Names: []string{"ads.test"}}, qname: "x.ads.test", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"0.0.0.0"}},
+		{name: "null AAAA", blocklist: BlocklistConfig{Names: []string{"ads.test"}}, qname: "x.ads.test", qtype: dns.TypeAAAA, wantRcode: dns.RcodeSuccess, want: []string{"::"}},
+		{name: "null other type", blocklist: BlocklistConfig{Names: []string{"ads.test"}}, qname: "x.ads.test", qtype: dns.TypeTXT, wantRcode: dns.RcodeSuccess},
+		{name: "nxdomain", blocklist: BlocklistConfig{Names: []string{"ads.test"}, BlockMode: "nxdomain"}, qname: "ads.test", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+		{name: "address", blocklist: BlocklistConfig{Names: []string{"ads.test"}, BlockMode: "address", Address: "10.0.0.99"}, qname: "ads.test", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"10.0.0.99"}},
+		{name: "address of the other family", blocklist: BlocklistConfig{Names: []string{"ads.test"}, BlockMode: "address", Address: "10.0.0.99"}, qname: "ads.test", qtype: dns.TypeAAAA, wantRcode: dns.RcodeSuccess},
+		{name: "hosts file", blocklist: BlocklistConfig{HostsFile: hostsFile}, qname: "tracker.test", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"0.0.0.0"}},
+		{name: "not blocked", blocklist: BlocklistConfig{Names: []string{"ads.test"}}, qname: "app.test.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version:   currentConfigVersion,
+				Server:    ServerConfig{Port: "53"},
+				Records:   Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+				Blocklist: tt.blocklist,
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, tt.qname, tt.qtype)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadBlocklistKeepsPreviousSource(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(source, []byte("ads.test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := BlocklistConfig{Sources: []string{source}}
+	previous, err := loadBlocklist(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadBlocklist(cfg, nil); err == nil {
+		t.Error("missing file without previous names loaded")
+	}
+	b, err := loadBlocklist(cfg, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.blocks("ads.test.") {
+		t.Error("previous names of the missing file are not blocked")
+	}
+}
+
+func TestLoadBlocklistURLSource(t *testing.T) {
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("0.0.0.0 ads.test\n"))
+	}))
+	defer list.Close()
+	b, err := loadBlocklist(BlocklistConfig{Names: []string{"tracker.test"}, Sources: []string{list.URL + "/list.txt", list.URL + "/missing.txt"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The missing list is skipped, the others are loaded
+	for _, name := range []string{"ads.test.", "tracker.test."} {
+		if !b.blocks(name) {
+			t.Errorf("%s is not blocked", name)
+		}
+	}
+}
+
+func TestValidateBlocklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  BlocklistConfig
+		wantErr bool
+	}{
+		{name: "default mode", config: BlocklistConfig{Names: []string{"ads.test"}}},
+		{name: "nxdomain", config: BlocklistConfig{BlockMode: "nxdomain"}},
+		{name: "address", config: BlocklistConfig{BlockMode: "address", Address: "::1"}},
+		{name: "address without an address", config: BlocklistConfig{BlockMode: "address"}, wantErr: true},
+		{name: "unknown mode", config: BlocklistConfig{BlockMode: "drop"}, wantErr: true},
+		{name: "empty source", config: BlocklistConfig{Sources: []string{""}}, wantErr: true},
+		{name: "refresh", config: BlocklistConfig{Refresh: "24h"}},
+		{name: "invalid refresh", config: BlocklistConfig{Refresh: "daily"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -10,7 +10,7 @@ build() {
     local output="build/${os}_${arch}/easydns"
 
     echo "Building for ${os}/${arch}..."
-    GOOS=${os} GOARCH=${arch} go build -o ${output}
+    GOOS=${os} GOARCH=${arch} go build -o ${output} ./cmd/easydns
     echo "Build completed: ${output}"
     chmod +x ${output}
 }
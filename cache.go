@@ -0,0 +1,292 @@
+package easydns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type CacheConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxEntries int  `json:"max_entries,omitempty"` // Unlimited when 0
+	// MaxTTL and MaxNegativeTTL cap how long positive and negative
+	// answers are cached, in seconds. Unlimited when 0.
+	MaxTTL         uint32 `json:"max_ttl,omitempty"`
+	MaxNegativeTTL uint32 `json:"max_negative_ttl,omitempty"`
+	// MaxStale is how many seconds past their expiry answers are still
+	// served when the upstream servers fail, see RFC 8767. Off when 0.
+	MaxStale uint32 `json:"max_stale,omitempty"`
+}
+
+// staleTTL is the TTL of stale answers, as recommended by RFC 8767 4
+const staleTTL = 30
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	cacheScope
+}
+
+// cacheScope is what an answer was asked for beyond its question. Clients
+// without the DO bit must not get the DNSSEC records fetched for one with
+// it, clients with the CD bit get answers that are not validated, and
+// answers forwarded with a client subnet only suit clients in it.
+type cacheScope struct {
+	subnet           string // Client subnet, see subnetKey
+	dnssecOK         bool
+	checkingDisabled bool
+}
+
+// queryScope returns the scope the answer to r is cached in
+func queryScope(r *dns.Msg) cacheScope {
+	opt := r.IsEdns0()
+	return cacheScope{subnet: subnetKey(r), dnssecOK: opt != nil && opt.Do(), checkingDisabled: r.CheckingDisabled}
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// responseCache is a concurrency safe LRU cache of upstream responses.
+// Entries expire after the lowest TTL in the response and are served
+// with their TTLs counted down. A nil cache caches nothing.
+type responseCache struct {
+	mu             sync.Mutex
+	maxEntries     int
+	maxTTL         uint32
+	maxNegativeTTL uint32
+	maxStale       time.Duration
+	entries        map[cacheKey]*list.Element
+	lru            *list.List
+	hits, misses   uint64
+}
+
+func newResponseCache(cfg CacheConfig) *responseCache {
+	return &responseCache{
+		maxEntries:     cfg.MaxEntries,
+		maxTTL:         cfg.MaxTTL,
+		maxNegativeTTL: cfg.MaxNegativeTTL,
+		maxStale:       time.Duration(cfg.MaxStale) * time.Second,
+		entries:        map[cacheKey]*list.Element{},
+		lru:            list.New(),
+	}
+}
+
+func newCacheKey(q dns.Question, scope cacheScope) cacheKey {
+	return cacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass, cacheScope: scope}
+}
+
+// minTTL returns the lowest TTL of the records in msg, ignoring the OPT record
+func minTTL(msg *dns.Msg) (uint32, bool) {
+	var ttl uint32
+	found := false
+	eachRR(msg, func(rr dns.RR) {
+		if !found || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+			found = true
+		}
+	})
+	return ttl, found
+}
+
+// get returns a copy of the cached response to q with TTLs reduced by the
+// time spent in the cache and names in the casing of q. Answers are cached
+// per scope, see cacheScope.
+func (c *responseCache) get(q dns.Question, scope cacheScope) (*dns.Msg, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := newCacheKey(q, scope)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[key]
+	if !found {
+		c.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	current := now()
+	if !current.Before(entry.expires) {
+		// Expired entries are kept while they may still be served stale
+		if !current.Before(entry.expires.Add(c.maxStale)) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+
+	elapsed := uint32(current.Sub(entry.stored) / time.Second)
+	msg := entry.msg.Copy()
+	eachRR(msg, func(rr dns.RR) {
+		rr.Header().Ttl -= min(elapsed, rr.Header().Ttl)
+		if strings.EqualFold(rr.Header().Name, q.Name) {
+			rr.Header().Name = q.Name
+		}
+	})
+	msg.Question = []dns.Question{q}
+	return msg, true
+}
+
+// stale returns a copy of the expired response to q if it expired at most
+// the configured staleness ago, with all TTLs set to staleTTL
+func (c *responseCache) stale(q dns.Question, scope cacheScope) (*dns.Msg, bool) {
+	if c == nil || c.maxStale == 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[newCacheKey(q, scope)]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	current := now()
+	if current.Before(entry.expires) || !current.Before(entry.expires.Add(c.maxStale)) {
+		return nil, false
+	}
+	msg := entry.msg.Copy()
+	eachRR(msg, func(rr dns.RR) {
+		rr.Header().Ttl = staleTTL
+		if strings.EqualFold(rr.Header().Name, q.Name) {
+			rr.Header().Name = q.Name
+		}
+	})
+	msg.Question = []dns.Question{q}
+	return msg, true
+}
+
+// negativeTTL returns how long a negative response may be cached: the
+// lower of the SOA TTL and its MINIMUM field, see RFC 2308. Negative
+// responses without a SOA record are not cached.
+func negativeTTL(resp *dns.Msg) (uint32, bool) {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return min(soa.Hdr.Ttl, soa.Minttl), true
+		}
+	}
+	return 0, false
+}
+
+// cacheTTL returns how long resp may be cached, within the configured caps
+func (c *responseCache) cacheTTL(resp *dns.Msg) (uint32, bool) {
+	if isNegativeResponse(resp) {
+		ttl, found := negativeTTL(resp)
+		if found && c.maxNegativeTTL > 0 {
+			ttl = min(ttl, c.maxNegativeTTL)
+		}
+		return ttl, found
+	}
+	ttl, found := minTTL(resp)
+	if found && c.maxTTL > 0 {
+		ttl = min(ttl, c.maxTTL)
+	}
+	return ttl, found
+}
+
+// set caches the response to q in scope, including NXDOMAIN and NODATA
+// answers. Responses without records or with a zero TTL are not cached. The
+// entry expires with the lowest TTL of the response, while each record keeps
+// its own TTL within max_ttl, e.g. a CNAME and the A record it points to.
+// The SOA of negative answers is lowered to the negative caching TTL.
+func (c *responseCache) set(q dns.Question, scope cacheScope, resp *dns.Msg) {
+	if c == nil {
+		return
+	}
+	ttl, found := c.cacheTTL(resp)
+	if !found || ttl == 0 {
+		return
+	}
+	limit := c.maxTTL
+	if isNegativeResponse(resp) {
+		limit = ttl
+	}
+	current := now()
+	key := newCacheKey(q, scope)
+	msg := resp.Copy()
+	if limit > 0 {
+		eachRR(msg, func(rr dns.RR) {
+			rr.Header().Ttl = min(rr.Header().Ttl, limit)
+		})
+	}
+	entry := &cacheEntry{
+		key:     key,
+		msg:     msg,
+		stored:  current,
+		expires: current.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.entries[key]; found {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// flush removes all entries and returns how many there were
+func (c *responseCache) flush() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	flushed := c.lru.Len()
+	c.entries = map[cacheKey]*list.Element{}
+	c.lru.Init()
+	return flushed
+}
+
+// flushName removes the entries for name and the names below it and
+// returns how many there were
+func (c *responseCache) flushName(name string) int {
+	if c == nil {
+		return 0
+	}
+	name = dns.CanonicalName(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	flushed := 0
+	for key, elem := range c.entries {
+		if dns.IsSubDomain(name, key.name) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			flushed++
+		}
+	}
+	return flushed
+}
+
+type cacheStats struct {
+	Enabled    bool   `json:"enabled"`
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+}
+
+// stats returns the size of the cache and how many lookups it answered
+func (c *responseCache) stats() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{Enabled: true, Entries: c.lru.Len(), MaxEntries: c.maxEntries, Hits: c.hits, Misses: c.misses}
+}
@@ -0,0 +1,389 @@
+package easydns
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheServesRepeatedQueries(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	tests := []struct {
+		name        string
+		cache       CacheConfig
+		after       time.Duration // Between the two queries
+		wantQueries int64
+		wantTTL     uint32 // Of the second answer
+	}{
+		{name: "second query is served from cache", cache: CacheConfig{Enabled: true}, after: 20 * time.Second, wantQueries: 1, wantTTL: 40},
+		{name: "expired entry is asked again", cache: CacheConfig{Enabled: true}, after: time.Minute, wantQueries: 2, wantTTL: 60},
+		{name: "disabled cache", after: 20 * time.Second, wantQueries: 2, wantTTL: 60},
+		{name: "ttl lowered to max_ttl", cache: CacheConfig{Enabled: true, MaxTTL: 30}, after: 20 * time.Second, wantQueries: 1, wantTTL: 10},
+		{name: "entry expires after max_ttl", cache: CacheConfig{Enabled: true, MaxTTL: 30}, after: 40 * time.Second, wantQueries: 2, wantTTL: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+				Cache:      tt.cache,
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := up.queries.Load()
+			now = func() time.Time { return base }
+			ask(t, s, "www.example.com", dns.TypeA)
+			now = func() time.Time { return base.Add(tt.after) }
+			resp := ask(t, s, "www.example.com", dns.TypeA)
+			if queries := up.queries.Load() - before; queries != tt.wantQueries {
+				t.Errorf("upstream was asked %d times, want %d", queries, tt.wantQueries)
+			}
+			if len(resp.Answer) != 1 || resp.Answer[0].Header().Ttl != tt.wantTTL {
+				t.Errorf("got answer %v, want TTL %d", resp.Answer, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	stored := dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	tests := []struct {
+		name      string
+		q         dns.Question
+		scope     cacheScope
+		wantFound bool
+	}{
+		{name: "same question", q: stored, wantFound: true},
+		{name: "other casing", q: dns.Question{Name: "WWW.Example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, wantFound: true},
+		{name: "other type", q: dns.Question{Name: "www.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}},
+		{name: "other class", q: dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassCHAOS}},
+		{name: "other name", q: dns.Question{Name: "mail.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}},
+		{name: "DO bit", q: stored, scope: cacheScope{dnssecOK: true}},
+		{name: "CD bit", q: stored, scope: cacheScope{checkingDisabled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newResponseCache(CacheConfig{Enabled: true})
+			resp := new(dns.Msg)
+			resp.SetQuestion(stored.Name, stored.Qtype)
+			rr, _ := dns.NewRR("www.example.com. 60 IN A 192.0.2.1")
+			resp.Answer = []dns.RR{rr}
+			cache.set(stored, cacheScope{}, resp)
+			if _, found := cache.get(tt.q, tt.scope); found != tt.wantFound {
+				t.Errorf("found is %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestQueryScope(t *testing.T) {
+	query := func(do, cd bool) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion("www.example.com.", dns.TypeA)
+		r.SetEdns0(1232, do)
+		r.CheckingDisabled = cd
+		return r
+	}
+	tests := []struct {
+		name string
+		r    *dns.Msg
+		want cacheScope
+	}{
+		{name: "plain", r: query(false, false), want: cacheScope{}},
+		{name: "DO", r: query(true, false), want: cacheScope{dnssecOK: true}},
+		{name: "CD", r: query(false, true), want: cacheScope{checkingDisabled: true}},
+		{name: "DO and CD", r: query(true, true), want: cacheScope{dnssecOK: true, checkingDisabled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryScope(tt.r); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheKeepsDNSSECRecordsFromPlainClients(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		a, _ := dns.NewRR("www.example.com. 60 IN A 192.0.2.1")
+		resp.Answer = append(resp.Answer, a)
+		if opt := r.IsEdns0(); opt != nil && opt.Do() {
+			sig, _ := dns.NewRR("www.example.com. 60 IN RRSIG A 13 3 60 20300101000000 20240101000000 12345 example.com. c2lnbmF0dXJl")
+			resp.Answer = append(resp.Answer, sig)
+		}
+		w.WriteMsg(resp)
+	})
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Cache:      CacheConfig{Enabled: true},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		do        bool
+		wantTypes []uint16
+	}{
+		{name: "DNSSEC client", do: true, wantTypes: []uint16{dns.TypeA, dns.TypeRRSIG}},
+		{name: "plain client", wantTypes: []uint16{dns.TypeA}},
+		{name: "DNSSEC client from cache", do: true, wantTypes: []uint16{dns.TypeA, dns.TypeRRSIG}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion("www.example.com.", dns.TypeA)
+			if tt.do {
+				query.SetEdns0(1232, true)
+			}
+			resp := serve(s, query)
+			var got []uint16
+			for _, rr := range resp.Answer {
+				got = append(got, rr.Header().Rrtype)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantTypes) {
+				t.Errorf("got types %v, want %v", got, tt.wantTypes)
+			}
+		})
+	}
+	if queries := up.queries.Load(); queries != 2 {
+		t.Errorf("upstream was asked %d times, want once per DO bit", queries)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := newResponseCache(CacheConfig{Enabled: true, MaxEntries: 2})
+	question := func(name string) dns.Question {
+		return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	}
+	for _, name := range []string{"a.example.com.", "b.example.com.", "c.example.com."} {
+		resp := new(dns.Msg)
+		resp.SetQuestion(name, dns.TypeA)
+		rr, _ := dns.NewRR(name + " 60 IN A 192.0.2.1")
+		resp.Answer = []dns.RR{rr}
+		cache.set(question(name), cacheScope{}, resp)
+		if name == "b.example.com." {
+			// a is now the most recently used
+			cache.get(question("a.example.com."), cacheScope{})
+		}
+	}
+	tests := []struct {
+		name      string
+		wantFound bool
+	}{
+		{name: "a.example.com.", wantFound: true},
+		{name: "b.example.com."},
+		{name: "c.example.com.", wantFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, found := cache.get(question(tt.name), cacheScope{}); found != tt.wantFound {
+				t.Errorf("found is %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	cache := newResponseCache(CacheConfig{Enabled: true, MaxEntries: 16})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("host%d.example.com.", j%32)
+				q := dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+				resp := new(dns.Msg)
+				resp.SetQuestion(name, dns.TypeA)
+				rr, _ := dns.NewRR(name + " 60 IN A 192.0.2.1")
+				resp.Answer = []dns.RR{rr}
+				cache.set(q, cacheScope{}, resp)
+				cache.get(q, cacheScope{})
+			}
+		}()
+	}
+	wg.Wait()
+	if entries := cache.lru.Len(); entries > 16 {
+		t.Errorf("cache holds %d entries, over max_entries", entries)
+	}
+}
+
+func TestCacheKeepsTTLsPerRecord(t *testing.T) {
+	cache := newResponseCache(CacheConfig{Enabled: true})
+	q := dns.Question{Name: "www.test.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	resp := new(dns.Msg)
+	resp.SetQuestion(q.Name, q.Qtype)
+	cname, _ := dns.NewRR("www.test.com. 3600 IN CNAME app.test.com.")
+	a, _ := dns.NewRR("app.test.com. 300 IN A 10.0.0.1")
+	resp.Answer = []dns.RR{cname, a}
+	cache.set(q, cacheScope{}, resp)
+	cached, found := cache.get(q, cacheScope{})
+	if !found {
+		t.Fatal("response was not cached")
+	}
+	// A second may pass between set and get
+	if ttl := cached.Answer[0].Header().Ttl; ttl < 3599 || ttl > 3600 {
+		t.Errorf("CNAME has TTL %d, want 3600", ttl)
+	}
+	if ttl := cached.Answer[1].Header().Ttl; ttl < 299 || ttl > 300 {
+		t.Errorf("A has TTL %d, want 300", ttl)
+	}
+}
+
+func TestCacheNegativeAnswers(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	q := dns.Question{Name: "typo.test.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	soa, _ := dns.NewRR("test.com. 3600 IN SOA ns.test.com. admin.test.com. 1 3600 600 86400 300")
+	tests := []struct {
+		name      string
+		cache     CacheConfig
+		rcode     int
+		authority []dns.RR
+		after     time.Duration // Between set and get
+		wantFound bool
+		wantTTL   uint32 // Of the SOA
+	}{
+		{name: "nxdomain is cached for the soa minimum", rcode: dns.RcodeNameError, authority: []dns.RR{soa}, after: 100 * time.Second, wantFound: true, wantTTL: 200},
+		{name: "nxdomain expires after the soa minimum", rcode: dns.RcodeNameError, authority: []dns.RR{soa}, after: 300 * time.Second},
+		{name: "nodata is cached", rcode: dns.RcodeSuccess, authority: []dns.RR{soa}, after: 100 * time.Second, wantFound: true, wantTTL: 200},
+		{name: "max_negative_ttl", cache: CacheConfig{MaxNegativeTTL: 60}, rcode: dns.RcodeNameError, authority: []dns.RR{soa}, after: 60 * time.Second},
+		{name: "without soa", rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cache.Enabled = true
+			cache := newResponseCache(tt.cache)
+			resp := new(dns.Msg)
+			resp.SetQuestion(q.Name, q.Qtype)
+			resp.Rcode = tt.rcode
+			for _, rr := range tt.authority {
+				resp.Ns = append(resp.Ns, dns.Copy(rr))
+			}
+			now = func() time.Time { return base }
+			cache.set(q, cacheScope{}, resp)
+			now = func() time.Time { return base.Add(tt.after) }
+			cached, found := cache.get(q, cacheScope{})
+			if found != tt.wantFound {
+				t.Fatalf("got found %v, want %v", found, tt.wantFound)
+			}
+			if !found {
+				return
+			}
+			if cached.Rcode != tt.rcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[cached.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if len(cached.Ns) != 1 || cached.Ns[0].Header().Ttl != tt.wantTTL {
+				t.Errorf("got authority %v, want SOA with TTL %d", cached.Ns, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Cache:   CacheConfig{Enabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := new(dns.Msg)
+	resp.SetQuestion("www.example.com.", dns.TypeA)
+	rr, _ := dns.NewRR("www.example.com. 60 IN A 192.0.2.1")
+	resp.Answer = []dns.RR{rr}
+	s.cache.set(resp.Question[0], cacheScope{}, resp)
+
+	rec := httptest.NewRecorder()
+	s.apiHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/flush", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"flushed":1}` {
+		t.Errorf("got body %s, want {\"flushed\":1}", body)
+	}
+	if _, found := s.cache.get(resp.Question[0], cacheScope{}); found {
+		t.Error("entry is still cached after the flush")
+	}
+}
+
+func TestStaleOnUpstreamFailure(t *testing.T) {
+	// The upstream answers until failing is set, then with its rcode, or
+	// not at all when it is -1
+	var failing atomic.Int64
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		switch rcode := int(failing.Load()); rcode {
+		case dns.RcodeSuccess:
+			answerA("192.0.2.1")(w, r)
+		case -1:
+		default:
+			answerRcode(rcode)(w, r)
+		}
+	})
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	tests := []struct {
+		name      string
+		maxStale  uint32
+		failure   int
+		wantRcode int
+		want      []string
+	}{
+		{name: "SERVFAIL serves stale", maxStale: 3600, failure: dns.RcodeServerFailure, wantRcode: dns.RcodeSuccess, want: []string{"192.0.2.1"}},
+		{name: "REFUSED serves stale", maxStale: 3600, failure: dns.RcodeRefused, wantRcode: dns.RcodeSuccess, want: []string{"192.0.2.1"}},
+		{name: "timeout serves stale", maxStale: 3600, failure: -1, wantRcode: dns.RcodeSuccess, want: []string{"192.0.2.1"}},
+		{name: "SERVFAIL is passed on without stale answers", failure: dns.RcodeServerFailure, wantRcode: dns.RcodeServerFailure},
+		{name: "REFUSED is passed on without stale answers", failure: dns.RcodeRefused, wantRcode: dns.RcodeRefused},
+		{name: "timeout without stale answers", failure: -1, wantRcode: dns.RcodeServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, Timeout: "100ms"},
+				Cache:      CacheConfig{Enabled: true, MaxStale: tt.maxStale},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failing.Store(dns.RcodeSuccess)
+			now = func() time.Time { return base }
+			ask(t, s, "www.example.com", dns.TypeA)
+
+			failing.Store(int64(tt.failure))
+			now = func() time.Time { return base.Add(2 * time.Minute) }
+			resp := ask(t, s, "www.example.com", dns.TypeA)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if len(resp.Answer) > 0 && resp.Answer[0].Header().Ttl != staleTTL {
+				t.Errorf("got TTL %d, want %d", resp.Answer[0].Header().Ttl, staleTTL)
+			}
+		})
+	}
+}
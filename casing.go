@@ -0,0 +1,54 @@
+package easydns
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// randomizeCase flips the case of the letters in each question name at
+// random (0x20 encoding). Upstreams echo the question verbatim, so an
+// off-path attacker has to guess the casing to spoof a response.
+func randomizeCase(m *dns.Msg) {
+	for i, q := range m.Question {
+		name := []byte(q.Name)
+		for j, c := range name {
+			if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && rand.Intn(2) == 0 {
+				name[j] = c ^ 0x20
+			}
+		}
+		m.Question[i].Name = string(name)
+	}
+}
+
+// checkEchoedQuestion verifies that resp answers the question sent in
+// query. With strictCase the name casing must match exactly, as required
+// for 0x20 encoding. On success the question section and the matching
+// owner names in resp are restored to the casing of original.
+func checkEchoedQuestion(original, query, resp *dns.Msg, strictCase bool) error {
+	if len(resp.Question) != len(query.Question) {
+		return fmt.Errorf("upstream response has %d questions, expected %d", len(resp.Question), len(query.Question))
+	}
+	for i, q := range query.Question {
+		got := resp.Question[i]
+		if got.Qtype != q.Qtype || got.Qclass != q.Qclass || !strings.EqualFold(got.Name, q.Name) {
+			return fmt.Errorf("upstream response question %q does not match the query %q", got.String(), q.String())
+		}
+		if strictCase && got.Name != q.Name {
+			return fmt.Errorf("upstream response question %q does not match the query casing %q, possible spoofing", got.Name, q.Name)
+		}
+	}
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			for i, q := range query.Question {
+				if strings.EqualFold(rr.Header().Name, q.Name) {
+					rr.Header().Name = original.Question[i].Name
+				}
+			}
+		}
+	}
+	resp.Question = append([]dns.Question(nil), original.Question...)
+	return nil
+}
@@ -0,0 +1,106 @@
+package easydns
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCaseRandomization(t *testing.T) {
+	// Enough letters that randomizing leaves none flipped only once in 2^27
+	const qname = "Case.Randomization.Example.com."
+	echoing := func(lower bool) func(w dns.ResponseWriter, r *dns.Msg) {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(r)
+			name := r.Question[0].Name
+			if lower {
+				name = strings.ToLower(name)
+				resp.Question[0].Name = name
+			}
+			rr, _ := dns.NewRR(name + " 60 IN A 192.0.2.1")
+			resp.Answer = append(resp.Answer, rr)
+			w.WriteMsg(resp)
+		}
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		lower      bool // Upstream lowercases the question it echoes
+		wantAnswer bool
+	}{
+		{name: "preserved case is accepted", enabled: true, wantAnswer: true},
+		{name: "altered case is rejected", enabled: true, lower: true},
+		{name: "altered case is accepted when disabled", lower: true, wantAnswer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamName atomic.Value
+			up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				upstreamName.Store(r.Question[0].Name)
+				echoing(tt.lower)(w, r)
+			})
+			s, err := New(&Config{
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, CaseRandomization: tt.enabled},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, qname, dns.TypeA)
+			if answered := len(resp.Answer) > 0; answered != tt.wantAnswer {
+				t.Fatalf("answered is %v, want %v", answered, tt.wantAnswer)
+			}
+			sent, _ := upstreamName.Load().(string)
+			if tt.enabled && sent == qname {
+				t.Errorf("upstream was sent %s unchanged", sent)
+			}
+			if !strings.EqualFold(sent, qname) {
+				t.Errorf("upstream was sent %s for %s", sent, qname)
+			}
+			if resp.Question[0].Name != qname {
+				t.Errorf("response question is %s, want %s", resp.Question[0].Name, qname)
+			}
+			for _, rr := range resp.Answer {
+				if rr.Header().Name != qname {
+					t.Errorf("answer is owned by %s, want %s", rr.Header().Name, qname)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckEchoedQuestion(t *testing.T) {
+	original := new(dns.Msg)
+	original.SetQuestion("www.example.com.", dns.TypeA)
+	query := original.Copy()
+	query.Question[0].Name = "wWw.ExaMple.cOm."
+	tests := []struct {
+		name       string
+		echoed     string
+		qtype      uint16
+		strictCase bool
+		wantErr    bool
+	}{
+		{name: "same casing", echoed: "wWw.ExaMple.cOm.", qtype: dns.TypeA, strictCase: true},
+		{name: "other casing", echoed: "www.example.com.", qtype: dns.TypeA, strictCase: true, wantErr: true},
+		{name: "other casing without strict case", echoed: "www.example.com.", qtype: dns.TypeA},
+		{name: "other name", echoed: "mail.example.com.", qtype: dns.TypeA, wantErr: true},
+		{name: "other type", echoed: "wWw.ExaMple.cOm.", qtype: dns.TypeAAAA, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := new(dns.Msg)
+			resp.SetQuestion(tt.echoed, tt.qtype)
+			err := checkEchoedQuestion(original, query, resp, tt.strictCase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && resp.Question[0].Name != original.Question[0].Name {
+				t.Errorf("question is %s, want it restored to %s", resp.Question[0].Name, original.Question[0].Name)
+			}
+		})
+	}
+}
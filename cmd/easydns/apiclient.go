@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/phasi/easydns"
+)
+
+const envAPIToken = "EASYDNS_API_TOKEN"
+
+// apiClient changes the records of a running server through its API
+type apiClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newAPIClient(base, token string) *apiClient {
+	return &apiClient{
+		base:   strings.TrimSuffix(base, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// do sends a request with body encoded as JSON and decodes the response
+// into out. It returns the status code; statuses of 400 and above are
+// returned together with the error message of the server.
+func (c *apiClient) do(method, path string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("the API answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("malformed API response: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// localAPIURL returns the URL the API listening on address is reached at
+// from the same host
+func localAPIURL(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "http://" + address
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// config returns the running config of the server, without its secrets
+func (c *apiClient) config() (*easydns.Config, error) {
+	var cfg easydns.Config
+	_, err := c.do(http.MethodGet, "/api/v1/config", nil, &cfg)
+	return &cfg, err
+}
+
+func recordPath(name string) string {
+	return "/api/v1/records/" + url.PathEscape(name)
+}
+
+// records returns all records served by the server
+func (c *apiClient) records() (easydns.Records, error) {
+	records := easydns.Records{}
+	_, err := c.do(http.MethodGet, "/api/v1/records", nil, &records)
+	return records, err
+}
+
+// recordSet returns the records of name, nil if it has none
+func (c *apiClient) recordSet(name string) ([]easydns.Record, error) {
+	var set []easydns.Record
+	status, err := c.do(http.MethodGet, recordPath(name), nil, &set)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	return set, err
+}
+
+// putRecordSet replaces the records of name with set
+func (c *apiClient) putRecordSet(name string, set []easydns.Record) error {
+	_, err := c.do(http.MethodPut, recordPath(name), set, nil)
+	return err
+}
+
+// deleteRecordSet removes all records of name
+func (c *apiClient) deleteRecordSet(name string) error {
+	_, err := c.do(http.MethodDelete, recordPath(name), nil, nil)
+	return err
+}
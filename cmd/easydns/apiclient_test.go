@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phasi/easydns"
+)
+
+// fakeAPI serves the records API of easydns from records
+type fakeAPI struct {
+	mu      sync.Mutex
+	records easydns.Records
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/records/")
+	switch r.Method {
+	case http.MethodGet:
+		set, found := f.records[name]
+		if !found {
+			http.Error(w, "no records", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(set)
+	case http.MethodPut:
+		var set []easydns.Record
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.records[name] = set
+	case http.MethodDelete:
+		delete(f.records, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestRemoveServedRecord(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		recordName string
+		recordType string
+		want       easydns.Records
+		wantErr    bool
+	}{
+		{
+			name:       "one type",
+			token:      "secret",
+			recordName: "app.test.com",
+			recordType: "A",
+			want:       easydns.Records{"app.test.com": {{Type: "TXT", Value: "v=1", TTL: 60}}},
+		},
+		{name: "all types", token: "secret", recordName: "app.test.com", want: easydns.Records{}},
+		{
+			name:       "missing name",
+			token:      "secret",
+			recordName: "new.test.com",
+			wantErr:    true,
+			want: easydns.Records{"app.test.com": {
+				{Type: "A", Value: "10.0.0.1", TTL: 60},
+				{Type: "TXT", Value: "v=1", TTL: 60},
+			}},
+		},
+		{
+			name:       "wrong token",
+			token:      "wrong",
+			recordName: "app.test.com",
+			wantErr:    true,
+			want: easydns.Records{"app.test.com": {
+				{Type: "A", Value: "10.0.0.1", TTL: 60},
+				{Type: "TXT", Value: "v=1", TTL: 60},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{records: easydns.Records{"app.test.com": {
+				{Type: "A", Value: "10.0.0.1", TTL: 60},
+				{Type: "TXT", Value: "v=1", TTL: 60},
+			}}}
+			server := httptest.NewServer(api)
+			defer server.Close()
+			err := removeServedRecord(newAPIClient(server.URL+"/", tt.token), tt.recordName, tt.recordType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(api.records, tt.want) {
+				t.Errorf("got served records %v, want %v", api.records, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalAPIURL(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: ":8080", want: "http://127.0.0.1:8080"},
+		{address: "0.0.0.0:8080", want: "http://127.0.0.1:8080"},
+		{address: "[::]:8080", want: "http://127.0.0.1:8080"},
+		{address: "10.0.0.5:8080", want: "http://10.0.0.5:8080"},
+		{address: "[::1]:8080", want: "http://[::1]:8080"},
+		{address: "api.lab:8080", want: "http://api.lab:8080"},
+	}
+	for _, tt := range tests {
+		if got := localAPIURL(tt.address); got != tt.want {
+			t.Errorf("localAPIURL(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/phasi/easydns"
+)
+
+func ctlUsage() {
+	fmt.Printf("Usage: %s ctl stats [-socket <path>]\n", "easydns")
+	fmt.Printf("       %s ctl cache flush [domain] [-socket <path>]\n", "easydns")
+	fmt.Printf("       %s ctl reload [-socket <path>]\n", "easydns")
+	fmt.Printf("       %s ctl records list [-socket <path>]\n", "easydns")
+	fmt.Printf("       %s ctl blocklist update [-socket <path>]\n", "easydns")
+}
+
+// newControlClient returns a client for the control socket of a running
+// server at socket
+func newControlClient(socket string) *apiClient {
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	// Reloading may load remote blocklists, which takes longer than
+	// answering API requests
+	return &apiClient{base: "http://easydns", client: &http.Client{Transport: transport, Timeout: time.Minute}}
+}
+
+// ctlStats is the part of the stats of the control API printed by ctl stats
+type ctlStats struct {
+	Queries    uint64 `json:"queries"`
+	Records    int    `json:"records"`
+	TopClients []struct {
+		Name  string `json:"name"`
+		Count uint64 `json:"count"`
+	} `json:"top_clients"`
+	TopNames []struct {
+		Name  string `json:"name"`
+		Count uint64 `json:"count"`
+	} `json:"top_names"`
+	Cache struct {
+		Enabled bool   `json:"enabled"`
+		Entries int    `json:"entries"`
+		Hits    uint64 `json:"hits"`
+		Misses  uint64 `json:"misses"`
+	} `json:"cache"`
+}
+
+func printStats(stats ctlStats) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "queries\t%d\n", stats.Queries)
+	fmt.Fprintf(tw, "records\t%d\n", stats.Records)
+	if stats.Cache.Enabled {
+		fmt.Fprintf(tw, "cache entries\t%d\n", stats.Cache.Entries)
+		fmt.Fprintf(tw, "cache hits\t%d\n", stats.Cache.Hits)
+		fmt.Fprintf(tw, "cache misses\t%d\n", stats.Cache.Misses)
+	} else {
+		fmt.Fprintf(tw, "cache\toff\n")
+	}
+	fmt.Fprintln(tw, "\nTOP CLIENTS\tQUERIES")
+	for _, client := range stats.TopClients {
+		fmt.Fprintf(tw, "%s\t%d\n", client.Name, client.Count)
+	}
+	fmt.Fprintln(tw, "\nTOP NAMES\tQUERIES")
+	for _, name := range stats.TopNames {
+		fmt.Fprintf(tw, "%s\t%d\n", name.Name, name.Count)
+	}
+	return tw.Flush()
+}
+
+// runCtlCommand implements the ctl subcommands, which control a running
+// server through its control socket
+func runCtlCommand(args []string) {
+	ctlCmd := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := ctlCmd.String("socket", easydns.DefaultControlSocket, "Path to the control socket of the server")
+	positional := parseInterspersed(ctlCmd, args)
+	if len(positional) < 1 {
+		ctlUsage()
+		os.Exit(1)
+	}
+	client := newControlClient(*socket)
+	command := positional[0]
+	if len(positional) > 1 {
+		command += " " + positional[1]
+	}
+	switch {
+	case command == "stats" && len(positional) == 1:
+		var stats ctlStats
+		if _, err := client.do(http.MethodGet, "/stats", nil, &stats); err != nil {
+			log.Fatalf("cannot get stats because %v", err)
+		}
+		if err := printStats(stats); err != nil {
+			log.Fatal(err)
+		}
+	case command == "cache flush" && len(positional) <= 3:
+		path := "/cache/flush"
+		if len(positional) == 3 {
+			path += "?name=" + url.QueryEscape(positional[2])
+		}
+		var result struct {
+			Flushed int `json:"flushed"`
+		}
+		if _, err := client.do(http.MethodPost, path, nil, &result); err != nil {
+			log.Fatalf("cannot flush cache because %v", err)
+		}
+		fmt.Printf("flushed %d cache entries\n", result.Flushed)
+	case command == "reload" && len(positional) == 1:
+		if _, err := client.do(http.MethodPost, "/reload", nil, nil); err != nil {
+			log.Fatalf("cannot reload because %v", err)
+		}
+		fmt.Println("reloaded")
+	case command == "records list" && len(positional) == 2:
+		records := easydns.Records{}
+		if _, err := client.do(http.MethodGet, "/records", nil, &records); err != nil {
+			log.Fatalf("cannot list records because %v", err)
+		}
+		if err := printRecordTable(os.Stdout, records); err != nil {
+			log.Fatal(err)
+		}
+	case command == "blocklist update" && len(positional) == 2:
+		var result struct {
+			Blocked int `json:"blocked"`
+		}
+		if _, err := client.do(http.MethodPost, "/blocklist/update", nil, &result); err != nil {
+			log.Fatalf("cannot update blocklist because %v", err)
+		}
+		fmt.Printf("%d names blocked\n", result.Blocked)
+	default:
+		ctlUsage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestControlClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		if name := r.URL.Query().Get("name"); name != "app.test.com" {
+			http.Error(w, "unexpected name "+name, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"flushed": 2}`))
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	var result struct {
+		Flushed int `json:"flushed"`
+	}
+	client := newControlClient(socket)
+	if _, err := client.do(http.MethodPost, "/cache/flush?name=app.test.com", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Flushed != 2 {
+		t.Errorf("got %d flushed entries, want 2", result.Flushed)
+	}
+	if _, err := client.do(http.MethodPost, "/reload", nil, nil); err == nil {
+		t.Error("got no error for an unknown endpoint")
+	}
+}
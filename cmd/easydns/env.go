@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phasi/easydns"
+)
+
+const (
+	envRecords      = "EASYDNS_RECORDS"
+	envRecordPrefix = "EASYDNS_RECORD_"
+)
+
+// namedRecord is a single record together with its name, as given in an
+// EASYDNS_RECORD_<n> variable
+type namedRecord struct {
+	Name string `json:"name"`
+	easydns.Record
+}
+
+// loadEnvRecords reads records from the environment. EASYDNS_RECORDS holds
+// a JSON record map in the same format as the config file, and each
+// EASYDNS_RECORD_<n> holds one JSON record with a "name" field. Numbered
+// variables are applied in order, add to the records of their name and
+// override the records of that name in EASYDNS_RECORDS.
+func loadEnvRecords(environ []string) (easydns.Records, error) {
+	records := easydns.Records{}
+	numbered := map[int]string{}
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		switch {
+		case key == envRecords:
+			if err := json.Unmarshal([]byte(value), &records); err != nil {
+				return nil, fmt.Errorf("%s is malformed: %v", envRecords, err)
+			}
+		case strings.HasPrefix(key, envRecordPrefix):
+			n, err := strconv.Atoi(strings.TrimPrefix(key, envRecordPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("%s: expected %s<n>", key, envRecordPrefix)
+			}
+			numbered[n] = value
+		}
+	}
+	indexes := make([]int, 0, len(numbered))
+	for n := range numbered {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+	numberedNames := map[string]bool{}
+	for _, n := range indexes {
+		var record namedRecord
+		if err := json.Unmarshal([]byte(numbered[n]), &record); err != nil {
+			return nil, fmt.Errorf("%s%d is malformed: %v", envRecordPrefix, n, err)
+		}
+		name := strings.TrimSuffix(record.Name, ".")
+		if name == "" {
+			return nil, fmt.Errorf("%s%d has no name", envRecordPrefix, n)
+		}
+		if !numberedNames[name] {
+			// The first numbered record of a name replaces its records from EASYDNS_RECORDS
+			records[name] = nil
+			numberedNames[name] = true
+		}
+		records[name] = append(records[name], record.Record)
+	}
+	if err := easydns.ValidateRecords(records); err != nil {
+		return nil, fmt.Errorf("invalid record in the environment: %v", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/phasi/easydns"
+)
+
+// defaultShutdownTimeout is how long in-flight queries are drained when
+// shutdown_timeout is not set
+const defaultShutdownTimeout = 10 * time.Second
+
+var config *easydns.Config
+var configPath string
+var defaultConfigPath = "~/.easydns/config.json"
+
+func addGenericFlags(flagSets ...*flag.FlagSet) {
+	for _, cmd := range flagSets {
+		cmd.StringVar(&configPath, "config-path", defaultConfigPath, "Path to the config file")
+	}
+}
+
+func printUsages(flagSets ...*flag.FlagSet) {
+	for _, cmd := range flagSets {
+		cmd.Usage()
+	}
+}
+
+// printConfigProblems prints every problem ValidateConfig found
+func printConfigProblems(err error) {
+	if invalid, ok := err.(easydns.ConfigInvalidError); ok {
+		for _, problem := range invalid.Problems() {
+			fmt.Println(problem)
+		}
+	}
+}
+
+// apiToken returns the token of the API, read from EASYDNS_API_TOKEN when
+// the config sets none
+func apiToken(cfg easydns.APIConfig) string {
+	if cfg.Token != "" {
+		return cfg.Token
+	}
+	return os.Getenv(envAPIToken)
+}
+
+func main() {
+
+	configCmd := flag.NewFlagSet("config", flag.ExitOnError)
+	saveConfig := configCmd.Bool("save", false, "Save config template in ~/.easydns/config.json (change dir with -config-path flag)")
+	printConfig := configCmd.Bool("print", false, "Prints configuration to stdout")
+	printDefault := configCmd.Bool("template", false, "Instead of printing the current configuration, print the sample configuration")
+	templateType := configCmd.String("type", "default", "Kind of sample configuration to print or save: default, forwarder, authoritative or blocker")
+	migrate := configCmd.Bool("migrate", false, "Upgrade the config file to the current config version")
+	diffConfig := configCmd.String("diff", "", "Compare the current configuration against the given config file and print the record changes")
+	checkConfig := configCmd.Bool("check", false, "Validate the config file and print every problem found")
+	format := configCmd.String("format", "", "Format of the saved or printed config: json, yaml or toml (default from the extension of -config-path)")
+
+	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+
+	addGenericFlags(configCmd, runCmd)
+
+	if len(os.Args) < 2 {
+		fmt.Printf("Usage: %s [config|run|records|zone|ctl]\n", "easydns")
+		fmt.Printf("       %s config validate [-config-path <path>]\n\n\n", "easydns")
+		printUsages(configCmd, runCmd)
+		recordsUsage()
+		zoneUsage()
+		ctlUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "config":
+		args := os.Args[2:]
+		if len(args) > 0 && args[0] == "validate" {
+			// config validate is another way to write config -check
+			args = append([]string{"-check"}, args[1:]...)
+		}
+		configCmd.Parse(args)
+		template, err := configTemplate(*templateType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *format == "" {
+			*format = easydns.ConfigFormat(configPath)
+		}
+		if *saveConfig {
+			data, err := easydns.EncodeConfig(template, *format)
+			if err != nil {
+				log.Fatalf("failed to marshal default config: %v", err)
+			}
+			err = os.WriteFile(configPath, data, 0644)
+			if err != nil {
+				log.Fatalf("failed to save default config: %v", err)
+			}
+
+			// Exit after saving the default config
+		} else if *printConfig {
+			if *printDefault {
+				config = template
+			} else {
+				config, err = easydns.LoadConfig(configPath)
+				if err != nil {
+					log.Fatalf("cannot print config because %v", err)
+				}
+			}
+			data, err := easydns.EncodeConfig(config, *format)
+			if err != nil {
+				log.Fatalf("failed to marshal default config: %v", err)
+			}
+			fmt.Println(strings.TrimSuffix(string(data), "\n"))
+		} else if *migrate {
+			config, err = easydns.LoadConfig(configPath)
+			if err != nil {
+				log.Fatalf("cannot migrate config because %v", err)
+			}
+			err = easydns.WriteConfigFile(configPath, config)
+			if err != nil {
+				log.Fatalf("failed to save migrated config: %v", err)
+			}
+		} else if *checkConfig {
+			config, err = easydns.LoadConfig(configPath)
+			if malformed, ok := err.(easydns.ConfigMalformedError); ok {
+				for _, problem := range malformed.Problems() {
+					fmt.Println(problem)
+				}
+				fmt.Printf("%s is invalid\n", configPath)
+				os.Exit(1)
+			}
+			if err != nil {
+				log.Fatalf("cannot check config because %v", err)
+			}
+			if err := easydns.ValidateConfig(config); err != nil {
+				printConfigProblems(err)
+				fmt.Printf("%s is invalid\n", configPath)
+				os.Exit(1)
+			}
+			for _, warning := range easydns.ConfigWarnings(config) {
+				fmt.Printf("warning: %s\n", warning)
+			}
+			fmt.Printf("%s is valid\n", configPath)
+		} else if *diffConfig != "" {
+			config, err = easydns.LoadConfig(configPath)
+			if err != nil {
+				log.Fatalf("cannot diff config because %v", err)
+			}
+			candidate, err := easydns.LoadConfig(*diffConfig)
+			if err != nil {
+				log.Fatalf("cannot diff config because %v", err)
+			}
+			if err := easydns.ValidateConfig(candidate); err != nil {
+				printConfigProblems(err)
+				fmt.Printf("%s is invalid\n", *diffConfig)
+				os.Exit(1)
+			}
+			current := config.Records
+			if config.API.Enabled {
+				// Compare against what the server runs, which includes
+				// changes made through the API since it was started
+				running, err := newAPIClient(localAPIURL(config.API.Address), apiToken(config.API)).config()
+				if err != nil {
+					log.Printf("cannot get the running config because %v, comparing against %s", err, configPath)
+				} else {
+					current = running.Records
+				}
+			}
+			if easydns.DiffRecords(os.Stdout, current, candidate.Records) == 0 {
+				fmt.Println("no record changes")
+			}
+		} else {
+			configCmd.Usage()
+		}
+		os.Exit(0)
+	case "run":
+		runCmd.Parse(os.Args[2:])
+	case "records", "record":
+		runRecordsCommand(os.Args[2:])
+		os.Exit(0)
+	case "zone":
+		runZoneCommand(os.Args[2:])
+		os.Exit(0)
+	case "ctl":
+		runCtlCommand(os.Args[2:])
+		os.Exit(0)
+	default:
+		break
+	}
+
+	config, err = loadRunConfig(configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	server, err := easydns.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reload := func() error {
+		reloaded, err := loadRunConfig(configPath)
+		if err == nil {
+			err = server.Reload(reloaded)
+		}
+		if rebindErr := server.RebindInterfaces(); rebindErr != nil {
+			log.Println(rebindErr)
+		}
+		return err
+	}
+	server.SetReloader(reload)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload(); err != nil {
+				log.Printf("failed to reload config, keeping the running config: %v", err)
+			}
+		}
+	}()
+
+	shutdownTimeout := defaultShutdownTimeout
+	if config.Server.ShutdownTimeout != "" {
+		shutdownTimeout, _ = time.ParseDuration(config.Server.ShutdownTimeout)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		sig := <-stop
+		log.Printf("received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shut down cleanly: %v", err)
+		}
+		close(stopped)
+	}()
+
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+	<-stopped
+	log.Println("stopped")
+}
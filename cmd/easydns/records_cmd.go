@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/phasi/easydns"
+)
+
+// filterRecords returns the records whose type matches recordType and whose
+// name contains nameSubstring. Empty filters match everything.
+func filterRecords(records easydns.Records, recordType, nameSubstring string) easydns.Records {
+	filtered := easydns.Records{}
+	for name, set := range records {
+		if !strings.Contains(name, nameSubstring) {
+			continue
+		}
+		for _, record := range set {
+			if recordType == "" || strings.EqualFold(record.Type, recordType) {
+				filtered[name] = append(filtered[name], record)
+			}
+		}
+	}
+	return filtered
+}
+
+// printRecordTable writes records as a table sorted by name, one row per
+// record
+func printRecordTable(out io.Writer, records easydns.Records) error {
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tVALUE\tPRIORITY\tTTL")
+	for _, name := range names {
+		for _, record := range records[name] {
+			priority, value := "", record.Value
+			if record.Type == "MX" || record.Type == "SRV" {
+				priority = fmt.Sprint(record.Priority)
+			}
+			if record.Type == "SRV" {
+				value = fmt.Sprintf("%d %d %s", record.Weight, record.Port, record.Value)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", name, record.Type, value, priority, record.TTL)
+		}
+	}
+	return tw.Flush()
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments
+func parseInterspersed(cmd *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		cmd.Parse(args)
+		args = cmd.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func recordsUsage() {
+	fmt.Printf("Usage: %s records list [flags]\n", "easydns")
+	fmt.Printf("       %s records add <name> <type> <value> [-ttl <ttl>] [-priority <priority>] [-weight <weight>] [-port <port>]\n", "easydns")
+	fmt.Printf("       %s records rm <name> [type]\n", "easydns")
+	fmt.Printf("Add -api <url> to change the records of a running server through its API instead of the config file\n")
+}
+
+// addAPIFlags adds the flags selecting the API of a running server to cmd
+// and returns a function creating a client for it, nil without -api
+func addAPIFlags(cmd *flag.FlagSet) func() *apiClient {
+	api := cmd.String("api", "", "URL of the API of a running server to use instead of the config file, e.g. http://127.0.0.1:8053")
+	token := cmd.String("token", "", "API token, read from "+envAPIToken+" by default")
+	return func() *apiClient {
+		if *api == "" {
+			return nil
+		}
+		if *token == "" {
+			*token = os.Getenv(envAPIToken)
+		}
+		return newAPIClient(*api, *token)
+	}
+}
+
+// addRecord validates record and adds it to the records of name, replacing
+// a record of the same type and value. The records are stored under the
+// existing key of name, whatever its case. It reports whether a record was
+// replaced.
+func addRecord(records easydns.Records, name string, record easydns.Record) (bool, error) {
+	if key, found := easydns.FindRecordKey(records, name); found {
+		name = key
+	}
+	set := append([]easydns.Record(nil), records[name]...)
+	replaced := false
+	for i, existing := range set {
+		if existing.Type == record.Type && existing.Value == record.Value {
+			set[i] = record
+			replaced = true
+		}
+	}
+	if !replaced {
+		set = append(set, record)
+	}
+	if err := easydns.ValidateRecords(easydns.Records{name: set}); err != nil {
+		return false, err
+	}
+	records[name] = set
+	return replaced, nil
+}
+
+// removeRecord deletes the records stored under name, whatever its case. If
+// recordType is set only the records of that type are removed.
+func removeRecord(records easydns.Records, name, recordType string) error {
+	key, found := easydns.FindRecordKey(records, name)
+	if !found {
+		return fmt.Errorf("no record for %s", name)
+	}
+	if recordType == "" {
+		delete(records, key)
+		return nil
+	}
+	set := records[key]
+	var kept []easydns.Record
+	for _, record := range set {
+		if !strings.EqualFold(record.Type, recordType) {
+			kept = append(kept, record)
+		}
+	}
+	if len(kept) == len(set) {
+		return fmt.Errorf("no %s record for %s", strings.ToUpper(recordType), name)
+	}
+	if len(kept) == 0 {
+		delete(records, key)
+	} else {
+		records[key] = kept
+	}
+	return nil
+}
+
+// updateConfigFile loads the config file at path, changes its records with
+// update and writes it back if the whole config is still valid
+func updateConfigFile(path string, update func(records easydns.Records) error) error {
+	config, err := easydns.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if config.Records == nil {
+		config.Records = easydns.Records{}
+	}
+	if err := update(config.Records); err != nil {
+		return err
+	}
+	if err := easydns.ValidateConfig(config); err != nil {
+		return err
+	}
+	if err := easydns.WriteConfigFile(path, config); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	return nil
+}
+
+// removeServedRecord removes the records of name, or only those of
+// recordType, from a running server
+func removeServedRecord(client *apiClient, name, recordType string) error {
+	set, err := client.recordSet(name)
+	if err != nil {
+		return err
+	}
+	records := easydns.Records{}
+	if set != nil {
+		records[name] = set
+	}
+	if err := removeRecord(records, name, recordType); err != nil {
+		return err
+	}
+	if kept, found := records[name]; found {
+		return client.putRecordSet(name, kept)
+	}
+	return client.deleteRecordSet(name)
+}
+
+// runRecordsCommand implements the records subcommands
+func runRecordsCommand(args []string) {
+	if len(args) < 1 {
+		recordsUsage()
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		listCmd := flag.NewFlagSet("records list", flag.ExitOnError)
+		recordType := listCmd.String("type", "", "Only list records of this type")
+		name := listCmd.String("name", "", "Only list records whose name contains this string")
+		asJSON := listCmd.Bool("json", false, "Print the matching records as JSON")
+		apiClient := addAPIFlags(listCmd)
+		addGenericFlags(listCmd)
+		listCmd.Parse(args[1:])
+
+		var records easydns.Records
+		if client := apiClient(); client != nil {
+			served, err := client.records()
+			if err != nil {
+				log.Fatalf("cannot list records because %v", err)
+			}
+			records = served
+		} else {
+			config, err := easydns.LoadConfig(configPath)
+			if err != nil {
+				log.Fatalf("cannot list records because %v", err)
+			}
+			records = config.Records
+		}
+		records = filterRecords(records, *recordType, *name)
+		if *asJSON {
+			data, err := json.MarshalIndent(records, "", "  ")
+			if err != nil {
+				log.Fatalf("failed to marshal records: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		if err := printRecordTable(os.Stdout, records); err != nil {
+			log.Fatalf("failed to print records: %v", err)
+		}
+	case "add":
+		addCmd := flag.NewFlagSet("records add", flag.ExitOnError)
+		ttl := addCmd.Uint("ttl", 0, "TTL of the record")
+		priority := addCmd.Int("priority", 0, "Priority of MX and SRV records")
+		weight := addCmd.Int("weight", 0, "Weight of SRV records and of records answered in weighted order")
+		port := addCmd.Int("port", 0, "Port of SRV records")
+		apiClient := addAPIFlags(addCmd)
+		addGenericFlags(addCmd)
+		positional := parseInterspersed(addCmd, args[1:])
+		if len(positional) != 3 {
+			recordsUsage()
+			os.Exit(1)
+		}
+
+		name := strings.TrimSuffix(positional[0], ".")
+		record := easydns.Record{
+			Type:     strings.ToUpper(positional[1]),
+			Value:    positional[2],
+			Priority: *priority,
+			Weight:   *weight,
+			Port:     *port,
+			TTL:      uint32(*ttl),
+		}
+		var replaced bool
+		if client := apiClient(); client != nil {
+			set, err := client.recordSet(name)
+			if err != nil {
+				log.Fatalf("cannot add record because %v", err)
+			}
+			records := easydns.Records{name: set}
+			if replaced, err = addRecord(records, name, record); err != nil {
+				log.Fatalf("cannot add record because %v", err)
+			}
+			if err := client.putRecordSet(name, records[name]); err != nil {
+				log.Fatalf("cannot add record because %v", err)
+			}
+		} else {
+			err := updateConfigFile(configPath, func(records easydns.Records) (err error) {
+				replaced, err = addRecord(records, name, record)
+				return err
+			})
+			if err != nil {
+				log.Fatalf("cannot add record because %v", err)
+			}
+		}
+		if replaced {
+			fmt.Printf("replaced %s %s\n", name, record)
+		} else {
+			fmt.Printf("added %s %s\n", name, record)
+		}
+	case "rm":
+		rmCmd := flag.NewFlagSet("records rm", flag.ExitOnError)
+		apiClient := addAPIFlags(rmCmd)
+		addGenericFlags(rmCmd)
+		positional := parseInterspersed(rmCmd, args[1:])
+		if len(positional) < 1 || len(positional) > 2 {
+			recordsUsage()
+			os.Exit(1)
+		}
+		recordType := ""
+		if len(positional) == 2 {
+			recordType = positional[1]
+		}
+
+		name := strings.TrimSuffix(positional[0], ".")
+		if client := apiClient(); client != nil {
+			if err := removeServedRecord(client, name, recordType); err != nil {
+				log.Fatalf("cannot remove record because %v", err)
+			}
+		} else {
+			err := updateConfigFile(configPath, func(records easydns.Records) error {
+				return removeRecord(records, name, recordType)
+			})
+			if err != nil {
+				log.Fatalf("cannot remove record because %v", err)
+			}
+		}
+		fmt.Printf("removed %s\n", name)
+	default:
+		recordsUsage()
+		os.Exit(1)
+	}
+}
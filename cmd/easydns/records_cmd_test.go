@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/phasi/easydns"
+)
+
+func TestRecordsRoundTrip(t *testing.T) {
+	a := easydns.Record{Type: "A", Value: "10.0.0.1", TTL: 300}
+	tests := []struct {
+		name     string
+		holdDown map[string]string
+		update   func(records easydns.Records) error
+		want     easydns.Records
+		wantErr  bool
+	}{
+		{
+			name:   "add to a new name",
+			update: func(records easydns.Records) error { _, err := addRecord(records, "app.test.com", a); return err },
+			want: easydns.Records{
+				"test.com":     {{Type: "A", Value: "10.0.0.10", TTL: 60}},
+				"www.test.com": {{Type: "A", Value: "10.0.0.20", TTL: 60}},
+				"app.test.com": {a},
+			},
+		},
+		{
+			name:   "add under the existing key in another case",
+			update: func(records easydns.Records) error { _, err := addRecord(records, "WWW.Test.com", a); return err },
+			want: easydns.Records{
+				"test.com":     {{Type: "A", Value: "10.0.0.10", TTL: 60}},
+				"www.test.com": {{Type: "A", Value: "10.0.0.20", TTL: 60}, a},
+			},
+		},
+		{
+			name: "replace a record of the same type and value",
+			update: func(records easydns.Records) error {
+				_, err := addRecord(records, "www.test.com", easydns.Record{Type: "A", Value: "10.0.0.20", TTL: 900})
+				return err
+			},
+			want: easydns.Records{
+				"test.com":     {{Type: "A", Value: "10.0.0.10", TTL: 60}},
+				"www.test.com": {{Type: "A", Value: "10.0.0.20", TTL: 900}},
+			},
+		},
+		{
+			name: "add an MX record with priority 0",
+			update: func(records easydns.Records) error {
+				_, err := addRecord(records, "test.com", easydns.Record{Type: "MX", Value: "mail.test.com.", Priority: 0, TTL: 60})
+				return err
+			},
+			want: easydns.Records{
+				"test.com":     {{Type: "A", Value: "10.0.0.10", TTL: 60}, {Type: "MX", Value: "mail.test.com.", TTL: 60}},
+				"www.test.com": {{Type: "A", Value: "10.0.0.20", TTL: 60}},
+			},
+		},
+		{
+			name: "refuse a CNAME next to other records",
+			update: func(records easydns.Records) error {
+				_, err := addRecord(records, "WWW.Test.com", easydns.Record{Type: "CNAME", Value: "test.com."})
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "refuse an invalid record",
+			update: func(records easydns.Records) error {
+				_, err := addRecord(records, "app.test.com", easydns.Record{Type: "A", Value: "not-an-address"})
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name:     "refuse to write an invalid config",
+			holdDown: map[string]string{"test.com": "soon"},
+			update:   func(records easydns.Records) error { _, err := addRecord(records, "app.test.com", a); return err },
+			wantErr:  true,
+		},
+		{
+			name:   "remove a name in another case",
+			update: func(records easydns.Records) error { return removeRecord(records, "Test.com", "") },
+			want:   easydns.Records{"www.test.com": {{Type: "A", Value: "10.0.0.20", TTL: 60}}},
+		},
+		{
+			name:   "remove by type",
+			update: func(records easydns.Records) error { return removeRecord(records, "www.test.com.", "a") },
+			want:   easydns.Records{"test.com": {{Type: "A", Value: "10.0.0.10", TTL: 60}}},
+		},
+		{
+			name:    "remove a missing type",
+			update:  func(records easydns.Records) error { return removeRecord(records, "www.test.com", "MX") },
+			wantErr: true,
+		},
+		{
+			name:    "remove a missing name",
+			update:  func(records easydns.Records) error { return removeRecord(records, "mail.test.com", "") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			config := &easydns.Config{
+				Version:  1,
+				Server:   easydns.ServerConfig{Port: "53"},
+				HoldDown: tt.holdDown,
+				Records: easydns.Records{
+					"test.com":     {{Type: "A", Value: "10.0.0.10", TTL: 60}},
+					"www.test.com": {{Type: "A", Value: "10.0.0.20", TTL: 60}},
+				},
+			}
+			if err := easydns.WriteConfigFile(path, config); err != nil {
+				t.Fatal(err)
+			}
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = updateConfigFile(path, tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				after, _ := os.ReadFile(path)
+				if string(after) != string(before) {
+					t.Errorf("config was written despite the error")
+				}
+				return
+			}
+			loaded, err := easydns.LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded.Records, tt.want) {
+				t.Errorf("got records %v, want %v", loaded.Records, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/phasi/easydns"
+)
+
+// loadRunConfig loads the config to serve from path, with records from the
+// environment overriding records of the same name in the file. Without a
+// config file the default settings are used if the environment has records.
+func loadRunConfig(path string) (*easydns.Config, error) {
+	loaded, err := easydns.LoadConfig(path)
+	envRecords, envErr := loadEnvRecords(os.Environ())
+	if envErr != nil {
+		return nil, fmt.Errorf("failed to load records from the environment: %v", envErr)
+	}
+	if _, notFound := err.(easydns.ConfigNotFoundError); notFound && len(envRecords) > 0 {
+		log.Printf("no config file at %s, using the default settings with records from the environment", path)
+		defaults := easydns.DefaultConfig
+		defaults.Records = easydns.Records{}
+		loaded, err = &defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if loaded.Records == nil {
+		loaded.Records = easydns.Records{}
+	}
+	for name, record := range envRecords {
+		loaded.Records[name] = record
+	}
+	return loaded, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/phasi/easydns"
+)
+
+// configTemplates builds the starter configs offered by config -template
+var configTemplates = map[string]func() easydns.Config{
+	"default":       func() easydns.Config { return easydns.DefaultConfig },
+	"forwarder":     forwarderTemplate,
+	"authoritative": authoritativeTemplate,
+	"blocker":       blockerTemplate,
+}
+
+// forwarderTemplate forwards everything and serves no local records
+func forwarderTemplate() easydns.Config {
+	config := easydns.DefaultConfig
+	config.Forwarding = easydns.ForwardingConfig{
+		Enabled:         true,
+		Servers:         []string{"1.1.1.1:53", "8.8.8.8:53"},
+		TopLevelQueries: "refuse",
+	}
+	config.Records = easydns.Records{}
+	return config
+}
+
+// authoritativeTemplate only answers for its own records and never forwards
+func authoritativeTemplate() easydns.Config {
+	config := easydns.DefaultConfig
+	config.Forwarding = easydns.ForwardingConfig{Enabled: false, Servers: []string{}}
+	config.Zones = map[string]easydns.ZoneConfig{
+		"example.internal": {
+			MName: "ns1.example.internal",
+			RName: "hostmaster@example.internal",
+			NS:    []string{"ns1.example.internal"},
+			TTL:   86400,
+		},
+	}
+	config.Records = easydns.Records{
+		"ns1.example.internal": {{
+			Type:  "A",
+			Value: "10.0.0.53",
+			TTL:   86400,
+		}},
+		"www.example.internal": {{
+			Type:  "A",
+			Value: "10.0.0.10",
+			TTL:   3600,
+		}},
+		"mail.example.internal": {{
+			Type:  "A",
+			Value: "10.0.0.25",
+			TTL:   3600,
+		}},
+	}
+	return config
+}
+
+// blockerTemplate forwards everything except a sample blocklist, whose
+// names and their subdomains resolve to an unroutable address
+func blockerTemplate() easydns.Config {
+	config := forwarderTemplate()
+	config.Blocklist = easydns.BlocklistConfig{
+		Names:     []string{"doubleclick.net", "ads.example.com"},
+		BlockMode: "null",
+	}
+	return config
+}
+
+// configTemplate returns the starter config of the given kind
+func configTemplate(kind string) (*easydns.Config, error) {
+	build, found := configTemplates[kind]
+	if !found {
+		kinds := make([]string, 0, len(configTemplates))
+		for k := range configTemplates {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		return nil, fmt.Errorf("unknown template type %q, expected one of %s", kind, strings.Join(kinds, ", "))
+	}
+	config := build()
+	return &config, nil
+}
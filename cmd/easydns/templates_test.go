@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/phasi/easydns"
+)
+
+func TestConfigTemplatesAreValid(t *testing.T) {
+	for kind, template := range configTemplates {
+		t.Run(kind, func(t *testing.T) {
+			config := template()
+			if err := easydns.ValidateConfig(&config); err != nil {
+				t.Errorf("template is invalid: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/phasi/easydns"
+)
+
+func zoneUsage() {
+	fmt.Printf("Usage: %s zone import <file> [-replace]\n", "easydns")
+	fmt.Printf("       %s zone export [-name <substring>] [-type <type>]\n", "easydns")
+	fmt.Printf("       %s zone ds <zone>\n", "easydns")
+}
+
+// importRecords merges imported into records. Names that already exist, in
+// any case, are an error unless replace is set, in which case their records
+// are replaced.
+func importRecords(records, imported easydns.Records, replace bool) error {
+	for name := range imported {
+		if _, found := easydns.FindRecordKey(records, name); found && !replace {
+			return fmt.Errorf("record %s already exists, use -replace to overwrite it", name)
+		}
+	}
+	for name, set := range imported {
+		if key, found := easydns.FindRecordKey(records, name); found {
+			name = key
+		}
+		records[name] = set
+	}
+	return nil
+}
+
+// runZoneCommand implements the zone subcommands
+func runZoneCommand(args []string) {
+	if len(args) < 1 {
+		zoneUsage()
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "import":
+		importCmd := flag.NewFlagSet("zone import", flag.ExitOnError)
+		replace := importCmd.Bool("replace", false, "Replace the records of names that already exist")
+		addGenericFlags(importCmd)
+		positional := parseInterspersed(importCmd, args[1:])
+		if len(positional) != 1 {
+			zoneUsage()
+			os.Exit(1)
+		}
+
+		imported, err := easydns.LoadZoneFile(positional[0])
+		if err != nil {
+			log.Fatalf("cannot import zone because %v", err)
+		}
+		if err := easydns.ValidateRecords(imported); err != nil {
+			log.Fatalf("cannot import zone because %v", err)
+		}
+		config, err := easydns.LoadConfig(configPath)
+		if err != nil {
+			log.Fatalf("cannot import zone because %v", err)
+		}
+		if config.Records == nil {
+			config.Records = easydns.Records{}
+		}
+		if err := importRecords(config.Records, imported, *replace); err != nil {
+			log.Fatalf("cannot import zone because %v", err)
+		}
+		if err := easydns.WriteConfigFile(configPath, config); err != nil {
+			log.Fatalf("failed to save config: %v", err)
+		}
+		fmt.Printf("imported %d names from %s\n", len(imported), positional[0])
+	case "export":
+		exportCmd := flag.NewFlagSet("zone export", flag.ExitOnError)
+		recordType := exportCmd.String("type", "", "Only export records of this type")
+		name := exportCmd.String("name", "", "Only export records whose name contains this string")
+		addGenericFlags(exportCmd)
+		exportCmd.Parse(args[1:])
+
+		config, err := easydns.LoadConfig(configPath)
+		if err != nil {
+			log.Fatalf("cannot export zone because %v", err)
+		}
+		records := filterRecords(config.Records, *recordType, *name)
+		if err := easydns.WriteZoneFile(os.Stdout, records); err != nil {
+			log.Fatalf("cannot export zone because %v", err)
+		}
+	case "ds":
+		dsCmd := flag.NewFlagSet("zone ds", flag.ExitOnError)
+		addGenericFlags(dsCmd)
+		positional := parseInterspersed(dsCmd, args[1:])
+		if len(positional) != 1 {
+			zoneUsage()
+			os.Exit(1)
+		}
+
+		config, err := easydns.LoadConfig(configPath)
+		if err != nil {
+			log.Fatalf("cannot print DS record because %v", err)
+		}
+		ds, err := easydns.ZoneDS(config, positional[0])
+		if err != nil {
+			log.Fatalf("cannot print DS record because %v", err)
+		}
+		fmt.Println(ds)
+	default:
+		zoneUsage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/phasi/easydns"
+)
+
+func TestImportRecords(t *testing.T) {
+	imported := easydns.Records{"www.test.com": {{Type: "A", Value: "10.0.0.30", TTL: 600}}}
+	tests := []struct {
+		name    string
+		records easydns.Records
+		replace bool
+		want    easydns.Records
+		wantErr bool
+	}{
+		{
+			name:    "new name",
+			records: easydns.Records{"test.com": {{Type: "A", Value: "10.0.0.10"}}},
+			want: easydns.Records{
+				"test.com":     {{Type: "A", Value: "10.0.0.10"}},
+				"www.test.com": {{Type: "A", Value: "10.0.0.30", TTL: 600}},
+			},
+		},
+		{
+			name:    "existing name in another case",
+			records: easydns.Records{"WWW.Test.com": {{Type: "A", Value: "10.0.0.20"}}},
+			wantErr: true,
+		},
+		{
+			name:    "existing name replaced",
+			records: easydns.Records{"WWW.Test.com": {{Type: "A", Value: "10.0.0.20"}}},
+			replace: true,
+			want:    easydns.Records{"WWW.Test.com": {{Type: "A", Value: "10.0.0.30", TTL: 600}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := importRecords(tt.records, imported, tt.replace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.records, tt.want) {
+				t.Errorf("got %v, want %v", tt.records, tt.want)
+			}
+		})
+	}
+}
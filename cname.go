@@ -0,0 +1,70 @@
+package easydns
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain caps how many local CNAMEs are followed for one answer
+const maxCNAMEChain = 8
+
+// appendCNAMETarget appends the records of target, the CNAME target of q,
+// to msg. They are looked up in records and, once the chain leaves them,
+// forwarded if the client may have its queries forwarded.
+func (s *Server) appendCNAMETarget(ctx context.Context, msg, r *dns.Msg, q dns.Question, records Records, target string, cfg *Config, client net.IP) error {
+	rrs, external := s.followCNAME(records, recordName(q.Name), target, q.Qtype, client)
+	msg.Answer = append(msg.Answer, rrs...)
+	if external == "" || !cfg.Forwarding.Enabled || !cfg.Forwarding.allowsType(q.Qtype) || !s.acl.allowsForwarding(client) {
+		return nil
+	}
+	upstreamResponse, _, err := s.forwardQuestion(ctx, r, dns.Question{Name: external, Qtype: q.Qtype, Qclass: q.Qclass}, cfg.Forwarding)
+	if upstreamResponse == nil {
+		return err
+	}
+	appendUpstream(msg, upstreamResponse, cfg.Forwarding.NegativeMinTTL)
+	return nil
+}
+
+// followCNAME resolves the CNAME chain from name to target against the
+// local records and returns the records to append to the answer. When the
+// chain leaves the local records the remaining target is returned so it can
+// be forwarded; it is empty when the chain ends locally or is broken.
+func (s *Server) followCNAME(records Records, name, target string, qtype uint16, client net.IP) ([]dns.RR, string) {
+	seen := map[string]bool{name: true}
+	var rrs []dns.RR
+	for len(rrs) < maxCNAMEChain {
+		domain := recordName(target)
+		if seen[domain] {
+			log.Printf("CNAME loop at %s while resolving %s", domain, name)
+			return rrs, ""
+		}
+		seen[domain] = true
+		key, set, found := records.lookup(domain)
+		if !found {
+			return rrs, dns.Fqdn(target)
+		}
+		next := ""
+		for _, record := range forClient(s.recordHealth.filter(answering(set, qtype)), client) {
+			record = record.activeAt(now())
+			rr, err := newRR(dns.Fqdn(target), record)
+			if err != nil {
+				log.Printf("Failed to create RR: %v", err)
+				return rrs, ""
+			}
+			rrs = append(rrs, rr)
+			s.hits.hit(key, record)
+			if record.Type == "CNAME" {
+				next = record.Value
+			}
+		}
+		if next == "" {
+			return rrs, ""
+		}
+		target = next
+	}
+	log.Printf("CNAME chain from %s is longer than %d records", name, maxCNAMEChain)
+	return rrs, ""
+}
@@ -0,0 +1,66 @@
+package easydns
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCNAMEChains(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Records: Records{
+			"test.com":      {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"app.test.com":  {{Type: "CNAME", Value: "test.com.", TTL: 60}},
+			"www.test.com":  {{Type: "CNAME", Value: "app.test.com.", TTL: 60}},
+			"self.test.com": {{Type: "CNAME", Value: "self.test.com.", TTL: 60}},
+			"a.test.com":    {{Type: "CNAME", Value: "b.test.com.", TTL: 60}},
+			"b.test.com":    {{Type: "CNAME", Value: "a.test.com.", TTL: 60}},
+			"ext.test.com":  {{Type: "CNAME", Value: "www.example.com.", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "app.test.com", want: []string{"test.com.", "10.0.0.1"}},
+		{name: "www.test.com", want: []string{"app.test.com.", "test.com.", "10.0.0.1"}},
+		{name: "self.test.com", want: []string{"self.test.com."}},
+		{name: "a.test.com", want: []string{"b.test.com.", "a.test.com."}},
+		{name: "ext.test.com", want: []string{"www.example.com.", "192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ask(t, s, tt.name, dns.TypeA)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCNAMEChainDepth(t *testing.T) {
+	records := Records{"hop20.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}}
+	for i := 0; i < 20; i++ {
+		records[fmt.Sprintf("hop%d.test.com", i)] = []Record{{Type: "CNAME", Value: fmt.Sprintf("hop%d.test.com.", i+1), TTL: 60}}
+	}
+	cfg := &Config{Version: currentConfigVersion, Server: ServerConfig{Port: "53"}, Records: records}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := ask(t, s, "hop0.test.com", dns.TypeA)
+	// The CNAME asked for and at most maxCNAMEChain records following it
+	if len(resp.Answer) > maxCNAMEChain+1 {
+		t.Errorf("got %d answer records, want at most %d", len(resp.Answer), maxCNAMEChain+1)
+	}
+}
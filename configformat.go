@@ -0,0 +1,124 @@
+package easydns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormats are the formats config files can be written in
+var ConfigFormats = []string{"json", "yaml", "toml"}
+
+// ConfigFormat returns the format of a config file from its extension,
+// JSON unless it ends in .yaml, .yml or .toml
+func ConfigFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// configToJSON converts a config file in format to JSON, the config is
+// migrated and decoded from JSON in every format
+func configToJSON(data []byte, format string) ([]byte, error) {
+	var config any
+	switch format {
+	case "yaml":
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+	case "toml":
+		if err := toml.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+	if config == nil {
+		config = map[string]any{}
+	}
+	return json.Marshal(config)
+}
+
+// EncodeConfig encodes config in format, one of ConfigFormats
+func EncodeConfig(config *Config, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "json":
+		return data, nil
+	case "yaml":
+		// Decoding the JSON into a node keeps the order of the settings
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		blockStyle(&node)
+		var out bytes.Buffer
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), enc.Close()
+	case "toml":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var generic any
+		if err := dec.Decode(&generic); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		enc := toml.NewEncoder(&out)
+		enc.Indent = ""
+		if err := enc.Encode(compact(generic)); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown config format %q, use %s", format, strings.Join(ConfigFormats, ", "))
+}
+
+// blockStyle clears the flow style of node and its children, which they
+// have when decoded from JSON
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// compact drops null values, which TOML can't represent, and the sections
+// left empty from a decoded JSON value
+func compact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, element := range v {
+			element = compact(element)
+			if section, isMap := element.(map[string]any); element == nil || isMap && len(section) == 0 {
+				delete(v, key)
+			} else {
+				v[key] = element
+			}
+		}
+	case []any:
+		kept := v[:0]
+		for _, element := range v {
+			if element != nil {
+				kept = append(kept, compact(element))
+			}
+		}
+		return kept
+	}
+	return value
+}
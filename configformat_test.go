@@ -0,0 +1,70 @@
+package easydns
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigFormats(t *testing.T) {
+	want := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "5353"},
+		Cache:   CacheConfig{Enabled: true},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	tests := []struct {
+		file   string
+		config string
+	}{
+		{file: "config.json", config: `{"version": 1, "server": {"port": "5353"}, "cache": {"enabled": true}, "records": {"app.test.com": [{"type": "A", "value": "10.0.0.1", "ttl": 60}]}}`},
+		{file: "config.yaml", config: "version: 1\nserver:\n  port: \"5353\"\ncache:\n  enabled: true\nrecords:\n  app.test.com:\n    - type: A\n      value: 10.0.0.1\n      ttl: 60\n"},
+		{file: "config.yml", config: "version: 1\nserver: {port: \"5353\"}\ncache: {enabled: true}\nrecords:\n  app.test.com: [{type: A, value: 10.0.0.1, ttl: 60}]\n"},
+		{file: "config.toml", config: "version = 1\n\n[server]\nport = \"5353\"\n\n[cache]\nenabled = true\n\n[[records.\"app.test.com\"]]\ntype = \"A\"\nvalue = \"10.0.0.1\"\nttl = 60\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded.Records, want.Records) || loaded.Server.Port != want.Server.Port || !loaded.Cache.Enabled {
+				t.Errorf("got %+v, want %+v", loaded, want)
+			}
+			// Written back in the same format
+			if err := WriteConfigFile(path, loaded); err != nil {
+				t.Fatal(err)
+			}
+			written, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ConfigFormat(tt.file) != "json" && strings.HasPrefix(strings.TrimSpace(string(written)), "{") {
+				t.Errorf("got JSON written to %s:\n%s", tt.file, written)
+			}
+			again, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("reading the written config: %v", err)
+			}
+			if !reflect.DeepEqual(again, loaded) {
+				t.Errorf("got %+v after writing, want %+v", again, loaded)
+			}
+		})
+	}
+}
+
+func TestConfigFormatUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("version: 1\nserver:\n  port: \"53\"\n  prot: udp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), `unknown key "server.prot"`) {
+		t.Errorf("got error %v, want the unknown key", err)
+	}
+}
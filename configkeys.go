@@ -0,0 +1,161 @@
+package easydns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// configKeys finds the keys of a JSON config that don't match a setting,
+// e.g. misspelled ones that would otherwise be ignored silently
+type configKeys struct {
+	data      []byte
+	dec       *json.Decoder
+	positions bool // Whether offsets in data are those of the config file
+	unknown   []string
+}
+
+// unknownConfigKeys lists the keys of data that are not settings of Config.
+// With positions set the problems carry the line they are found at.
+func unknownConfigKeys(data []byte, positions bool) ([]string, error) {
+	k := &configKeys{data: data, dec: json.NewDecoder(bytes.NewReader(data)), positions: positions}
+	if err := k.value(reflect.TypeOf(Config{}), ""); err != nil {
+		return nil, err
+	}
+	return k.unknown, nil
+}
+
+// lineAt returns the line of data at offset, counting from 1
+func lineAt(data []byte, offset int64) int {
+	offset = min(offset, int64(len(data)))
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// located prefixes the error of a malformed config with its line, if known
+func located(data []byte, err error, positions bool) error {
+	var syntax *json.SyntaxError
+	var mistyped *json.UnmarshalTypeError
+	switch {
+	case !positions:
+	case errors.As(err, &syntax):
+		return fmt.Errorf("line %d: %v", lineAt(data, syntax.Offset), err)
+	case errors.As(err, &mistyped):
+		return fmt.Errorf("line %d: %v", lineAt(data, mistyped.Offset), err)
+	}
+	return err
+}
+
+// value walks the next JSON value, which is decoded into a t
+func (k *configKeys) value(t reflect.Type, path string) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	token, err := k.dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, isDelim := token.(json.Delim)
+	if !isDelim {
+		return nil
+	}
+	switch {
+	case delim == '{' && t.Kind() == reflect.Struct:
+		return k.object(t, path)
+	case delim == '{' && t.Kind() == reflect.Map:
+		return k.entries(func(string) (reflect.Type, bool) { return t.Elem(), true }, path)
+	case delim == '{' && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+		// The records of a name may be given as a single record object
+		return k.object(t.Elem(), path)
+	case delim == '[' && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for i := 0; k.dec.More(); i++ {
+			if err := k.value(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err := k.dec.Token()
+		return err
+	case delim == '{':
+		return k.entries(func(string) (reflect.Type, bool) { return nil, true }, path)
+	}
+	for depth := 1; depth > 0; {
+		token, err := k.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// object walks the keys of a JSON object decoded into the struct t
+func (k *configKeys) object(t reflect.Type, path string) error {
+	return k.entries(func(key string) (reflect.Type, bool) { return jsonField(t, key) }, path)
+}
+
+// entries walks the remaining entries of a JSON object, field returns the
+// type of the value of a key or reports that the key is unknown
+func (k *configKeys) entries(field func(key string) (reflect.Type, bool), path string) error {
+	for k.dec.More() {
+		token, err := k.dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		t, found := field(key)
+		if !found {
+			problem := fmt.Sprintf("unknown key %q", keyPath)
+			if k.positions {
+				problem = fmt.Sprintf("line %d: %s", lineAt(k.data, k.dec.InputOffset()), problem)
+			}
+			k.unknown = append(k.unknown, problem)
+		}
+		if t == nil {
+			t = reflect.TypeOf((*any)(nil)).Elem()
+		}
+		if err := k.value(t, keyPath); err != nil {
+			return err
+		}
+	}
+	_, err := k.dec.Token()
+	return err
+}
+
+// jsonField returns the type of the field of struct t that key is decoded
+// into, matching names without regard to case like encoding/json does
+func jsonField(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if embedded, found := jsonField(field.Type, key); found {
+				return embedded, true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,75 @@
+package easydns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigReportsProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []string // Problems of the ConfigMalformedError
+		wantErr string   // Error contains it when want is not set
+	}{
+		{
+			name: "unknown keys",
+			config: `{
+  "version": 1,
+  "server": {"port": "53", "bind_adress": "0.0.0.0"},
+  "records": {
+    "app.test.com": [{"type": "A", "value": "10.0.0.1", "tll": 60}]
+  },
+  "cashe": {"enabled": true}
+}`,
+			want: []string{
+				`line 3: unknown key "server.bind_adress"`,
+				`line 5: unknown key "records.app.test.com[0].tll"`,
+				`line 7: unknown key "cashe"`,
+			},
+		},
+		{name: "keys match without regard to case", config: `{"version": 1, "Server": {"Port": "53"}}`},
+		{
+			name:    "syntax error",
+			config:  "{\n  \"version\": 1,\n  \"server\": {\"port\": \"53\",}\n}",
+			wantErr: "line 3:",
+		},
+		{
+			name:    "wrong type",
+			config:  "{\n  \"version\": 1,\n  \"server\": {\"port\": 53}\n}",
+			wantErr: "line 3:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path)
+			if tt.want == nil && tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			var malformed ConfigMalformedError
+			if !errors.As(err, &malformed) {
+				t.Fatalf("got error %v, want a ConfigMalformedError", err)
+			}
+			if tt.want != nil {
+				if got := malformed.Problems(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got problems %q, want %q", got, tt.want)
+				}
+				return
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// DefaultControlSocket is where the control socket is created when the
+// control section sets no socket
+const DefaultControlSocket = "/run/easydns.sock"
+
+// ControlConfig is a local control channel used by easydns ctl. It serves
+// the control API over HTTP on a unix socket only the server's user can
+// connect to, so it needs no token.
+type ControlConfig struct {
+	Enabled bool   `json:"enabled"`
+	Socket  string `json:"socket,omitempty"` // Defaults to /run/easydns.sock
+}
+
+func (c ControlConfig) socket() string {
+	if c.Socket == "" {
+		return DefaultControlSocket
+	}
+	return c.Socket
+}
+
+// listenControl listens on the control socket, replacing the socket left
+// behind by a server that didn't shut down cleanly
+func listenControl(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// SetReloader sets the function the control socket calls to reload the
+// config, usually the one also run on SIGHUP. Reloading is refused while it
+// is not set.
+func (s *Server) SetReloader(reload func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloader = reload
+}
+
+func (s *Server) handleControlReload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	reload := s.reloader
+	s.mu.Unlock()
+	if reload == nil {
+		http.Error(w, "the server can't reload its config", http.StatusNotImplemented)
+		return
+	}
+	if err := reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("reloaded config through the control socket")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleBlocklistUpdate(w http.ResponseWriter, r *http.Request) {
+	size, err := s.updateBlocklist(s.currentConfig().Blocklist)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"blocked": size})
+}
+
+// controlHandler routes the control API served on the control socket
+func (s *Server) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", s.handleDashboardStats)
+	mux.HandleFunc("POST /cache/flush", s.handleCacheFlush)
+	mux.HandleFunc("POST /reload", s.handleControlReload)
+	mux.HandleFunc("GET /records", s.handleListRecords)
+	mux.HandleFunc("POST /blocklist/update", s.handleBlocklistUpdate)
+	return mux
+}
@@ -0,0 +1,110 @@
+package easydns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// controlClient returns an HTTP client connecting to the unix socket at path
+func controlClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListenControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket has mode %o, want 600", perm)
+	}
+	if _, err := listenControl(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("got error %v for a socket in use, want it refused", err)
+	}
+
+	// A socket left behind by a server that is gone is replaced
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = listenControl(path)
+	if err != nil {
+		t.Fatalf("stale socket was not replaced: %v", err)
+	}
+	listener.Close()
+}
+
+func TestControlSocket(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Cache:   CacheConfig{Enabled: true},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: s.controlHandler()}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	client := controlClient(path)
+
+	request := func(method, path string) int {
+		t.Helper()
+		r, err := http.NewRequest(method, "http://easydns"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// No token is needed on the socket
+	for _, path := range []string{"/stats", "/records"} {
+		if status := request(http.MethodGet, path); status != http.StatusOK {
+			t.Errorf("got status %d for %s, want %d", status, path, http.StatusOK)
+		}
+	}
+	if status := request(http.MethodPost, "/cache/flush"); status != http.StatusOK {
+		t.Errorf("got status %d for a cache flush, want %d", status, http.StatusOK)
+	}
+
+	if status := request(http.MethodPost, "/reload"); status != http.StatusNotImplemented {
+		t.Errorf("got status %d without a reloader, want %d", status, http.StatusNotImplemented)
+	}
+	reloads := 0
+	s.SetReloader(func() error {
+		reloads++
+		return nil
+	})
+	if status := request(http.MethodPost, "/reload"); status != http.StatusOK || reloads != 1 {
+		t.Errorf("got status %d and %d reloads, want %d and 1", status, reloads, http.StatusOK)
+	}
+	s.SetReloader(func() error { return errors.New("invalid config") })
+	if status := request(http.MethodPost, "/reload"); status != http.StatusInternalServerError {
+		t.Errorf("got status %d for a failed reload, want %d", status, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,154 @@
+package easydns
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	recentQueries   = 500   // Queries kept for the live query log
+	maxTrackedNames = 10000 // Clients and names counted for the top lists
+	topListSize     = 20
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// activityEntry is a query in the live query log, ID counts up from 1
+type activityEntry struct {
+	ID uint64 `json:"id"`
+	queryLogEntry
+}
+
+type activityCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// queryActivity keeps the recent queries and counts the queries of every
+// client and name for the dashboard. Once maxTrackedNames clients or names
+// are counted new ones are no longer added.
+type queryActivity struct {
+	mu      sync.Mutex
+	total   uint64
+	recent  []activityEntry // Ring buffer of the last recentQueries queries
+	clients map[string]uint64
+	names   map[string]uint64
+}
+
+func newQueryActivity() *queryActivity {
+	return &queryActivity{clients: map[string]uint64{}, names: map[string]uint64{}}
+}
+
+// record adds a logged question, a nil activity records nothing
+func (a *queryActivity) record(entry queryLogEntry) {
+	if a == nil {
+		return
+	}
+	client := entry.Client
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.total++
+	if len(a.recent) < recentQueries {
+		a.recent = append(a.recent, activityEntry{})
+	}
+	a.recent[(a.total-1)%recentQueries] = activityEntry{ID: a.total, queryLogEntry: entry}
+	count(a.clients, client)
+	if entry.Name != "" {
+		count(a.names, strings.ToLower(entry.Name))
+	}
+}
+
+func count(counts map[string]uint64, key string) {
+	if _, found := counts[key]; found || len(counts) < maxTrackedNames {
+		counts[key]++
+	}
+}
+
+// since returns the recent queries with an ID above after, oldest first
+func (a *queryActivity) since(after uint64) []activityEntry {
+	entries := []activityEntry{}
+	if a == nil {
+		return entries
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	first := a.total - uint64(len(a.recent)) + 1
+	for id := max(first, after+1); id <= a.total; id++ {
+		entries = append(entries, a.recent[(id-1)%recentQueries])
+	}
+	return entries
+}
+
+func top(counts map[string]uint64) []activityCount {
+	list := make([]activityCount, 0, len(counts))
+	for name, n := range counts {
+		list = append(list, activityCount{Name: name, Count: n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	if len(list) > topListSize {
+		list = list[:topListSize]
+	}
+	return list
+}
+
+type dashboardStats struct {
+	Queries    uint64          `json:"queries"`
+	Records    int             `json:"records"`
+	TopClients []activityCount `json:"top_clients"`
+	TopNames   []activityCount `json:"top_names"`
+	Cache      cacheStats      `json:"cache"`
+}
+
+func (s *Server) handleDashboardStats(w http.ResponseWriter, r *http.Request) {
+	stats := dashboardStats{
+		Records:    len(s.currentRecordSet().records),
+		TopClients: []activityCount{},
+		TopNames:   []activityCount{},
+		Cache:      s.cache.stats(),
+	}
+	if a := s.queryLog.activity; a != nil {
+		a.mu.Lock()
+		stats.Queries, stats.TopClients, stats.TopNames = a.total, top(a.clients), top(a.names)
+		a.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleRecentQueries returns the queries of the live query log with an ID
+// above the after parameter
+func (s *Server) handleRecentQueries(w http.ResponseWriter, r *http.Request) {
+	var after uint64
+	if param := r.URL.Query().Get("after"); param != "" {
+		var err error
+		if after, err = strconv.ParseUint(param, 10, 64); err != nil {
+			http.Error(w, "after must be a query ID", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.queryLog.activity.since(after))
+}
+
+// dashboardHandler serves the files of the dashboard. They are public, the
+// dashboard sends the API token with its requests to the API.
+func dashboardHandler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	return http.StripPrefix("/dashboard/", http.FileServerFS(files))
+}
@@ -0,0 +1,103 @@
+package easydns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// dashboardGet returns the status of a GET request for path to the API of s
+// and decodes the JSON body into v, if given
+func dashboardGet(t *testing.T, s *Server, path, token string, v any) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.apiHandler().ServeHTTP(rec, r)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestDashboard(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		API:     APIConfig{Enabled: true, Address: "127.0.0.1:0", Token: "secret", Dashboard: true},
+		Cache:   CacheConfig{Enabled: true},
+		Records: Records{
+			"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"db.test.com":  {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []struct{ client, name string }{
+		{"10.1.0.1", "app.test.com"},
+		{"10.1.0.1", "APP.test.com"},
+		{"10.1.0.2", "db.test.com"},
+	} {
+		askFrom(t, s, query.client, query.name)
+	}
+
+	// The page itself is public, the data needs the token
+	r := httptest.NewRequest(http.MethodGet, "/dashboard/", nil)
+	rec := httptest.NewRecorder()
+	s.apiHandler().ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("got status %d for the dashboard page, want it served", rec.Code)
+	}
+	if status := dashboardGet(t, s, "/api/v1/stats", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d without a token, want %d", status, http.StatusUnauthorized)
+	}
+
+	var stats dashboardStats
+	if status := dashboardGet(t, s, "/api/v1/stats", "secret", &stats); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	if stats.Queries != 3 || stats.Records != 2 || !stats.Cache.Enabled {
+		t.Errorf("got stats %+v, want 3 queries, 2 names and the cache enabled", stats)
+	}
+	if want := []activityCount{{Name: "10.1.0.1", Count: 2}, {Name: "10.1.0.2", Count: 1}}; !reflect.DeepEqual(stats.TopClients, want) {
+		t.Errorf("got top clients %v, want %v", stats.TopClients, want)
+	}
+	if want := []activityCount{{Name: "app.test.com.", Count: 2}, {Name: "db.test.com.", Count: 1}}; !reflect.DeepEqual(stats.TopNames, want) {
+		t.Errorf("got top names %v, want %v", stats.TopNames, want)
+	}
+
+	var recent []activityEntry
+	if status := dashboardGet(t, s, "/api/v1/queries?after=1", "secret", &recent); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	if len(recent) != 2 || recent[0].ID != 2 || recent[1].Name != "db.test.com." {
+		t.Errorf("got queries %+v, want the last two", recent)
+	}
+	if status := dashboardGet(t, s, "/api/v1/queries?after=soon", "secret", nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid ID, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestQueryActivityKeepsRecentQueries(t *testing.T) {
+	a := newQueryActivity()
+	for range recentQueries + 10 {
+		a.record(queryLogEntry{Client: "10.0.0.1:53000", Name: "app.test.com.", Type: dns.TypeToString[dns.TypeA]})
+	}
+	recent := a.since(0)
+	if len(recent) != recentQueries || recent[0].ID != 11 || recent[len(recent)-1].ID != recentQueries+10 {
+		t.Errorf("got %d queries from %d to %d, want the last %d", len(recent), recent[0].ID, recent[len(recent)-1].ID, recentQueries)
+	}
+	if got := a.clients["10.0.0.1"]; got != recentQueries+10 {
+		t.Errorf("got %d queries of the client, want all %d", got, recentQueries+10)
+	}
+}
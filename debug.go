@@ -0,0 +1,38 @@
+package easydns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// debugAnnotationName is the owner name of the synthetic TXT record that
+// describes where an answer came from
+const debugAnnotationName = "source.easydns.debug."
+
+type DebugConfig struct {
+	// AnnotateSource adds a TXT record to the additional section telling
+	// where the answer came from and its remaining TTL. Never enable this
+	// outside of debugging.
+	AnnotateSource bool `json:"annotate_source,omitempty"`
+}
+
+// appendSourceAnnotation adds the debug TXT record describing source to
+// the additional section, unless it would push the response past maxSize
+func appendSourceAnnotation(msg *dns.Msg, source string, maxSize int) {
+	text := "source=" + source
+	if len(msg.Answer) > 0 {
+		ttl := msg.Answer[0].Header().Ttl
+		for _, rr := range msg.Answer[1:] {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		text += fmt.Sprintf(" ttl=%d", ttl)
+	}
+	msg.Extra = append(msg.Extra, &dns.TXT{
+		Hdr: dns.RR_Header{Name: debugAnnotationName, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{text},
+	})
+	if msg.Len() > maxSize {
+		msg.Extra = msg.Extra[:len(msg.Extra)-1]
+	}
+}
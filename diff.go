@@ -0,0 +1,87 @@
+package easydns
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// String formats a record the way it is shown in diffs and listings
+func (r Record) String() string {
+	var s string
+	switch r.Type {
+	case "MX":
+		s = fmt.Sprintf("%s %d %s ttl=%d", r.Type, r.Priority, r.Value, r.TTL)
+	case "SRV":
+		s = fmt.Sprintf("%s %d %d %d %s ttl=%d", r.Type, r.Priority, r.Weight, r.Port, r.Value, r.TTL)
+	default:
+		s = fmt.Sprintf("%s %s ttl=%d", r.Type, r.Value, r.TTL)
+	}
+	for _, entry := range r.Schedule {
+		s += fmt.Sprintf(" [%s-%s", entry.From, entry.To)
+		if len(entry.Days) > 0 {
+			s += " " + strings.Join(entry.Days, ",")
+		}
+		s += ": " + entry.Value + "]"
+	}
+	return s
+}
+
+// DiffRecords writes a record-level diff between two record sets to out,
+// ordered by name. A name holding a single record before and after is shown
+// as one change, otherwise removed and added records are listed. It returns
+// the number of differences found.
+func DiffRecords(out io.Writer, current, candidate Records) int {
+	names := make([]string, 0, len(current)+len(candidate))
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range candidate {
+		if _, found := current[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := 0
+	for _, name := range names {
+		oldSet, newSet := current[name], candidate[name]
+		if reflect.DeepEqual(oldSet, newSet) {
+			continue
+		}
+		if len(oldSet) == 1 && len(newSet) == 1 {
+			fmt.Fprintf(out, "~ %s %s -> %s\n", name, oldSet[0], newSet[0])
+			changes++
+			continue
+		}
+		for _, record := range missingRecords(oldSet, newSet) {
+			fmt.Fprintf(out, "- %s %s\n", name, record)
+			changes++
+		}
+		for _, record := range missingRecords(newSet, oldSet) {
+			fmt.Fprintf(out, "+ %s %s\n", name, record)
+			changes++
+		}
+	}
+	return changes
+}
+
+// missingRecords returns the records of set that are not in other
+func missingRecords(set, other []Record) []Record {
+	var missing []Record
+	for _, record := range set {
+		found := false
+		for _, o := range other {
+			if reflect.DeepEqual(record, o) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, record)
+		}
+	}
+	return missing
+}
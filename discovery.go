@@ -0,0 +1,486 @@
+package easydns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDiscoveryInterval = 10 * time.Second
+	defaultDiscoveryTTL      = 60
+	defaultDockerSocket      = "/var/run/docker.sock"
+	kubernetesTokenFile      = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubernetesCAFile         = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	discoveryTimeout         = 10 * time.Second
+
+	// hostnameLabel lists the names of a container, service or ingress,
+	// separated by commas
+	hostnameLabel = "easydns.hostname"
+	// networkLabel selects the Docker network whose address is published
+	networkLabel = "easydns.network"
+	// publishAnnotation publishes the hosts of the rules of an ingress
+	publishAnnotation = "easydns.publish"
+)
+
+// DiscoveryConfig publishes A and AAAA records for Docker containers and
+// Kubernetes services and ingresses, which name themselves with an
+// easydns.hostname label or annotation
+type DiscoveryConfig struct {
+	Docker     DockerDiscoveryConfig     `json:"docker"`
+	Kubernetes KubernetesDiscoveryConfig `json:"kubernetes"`
+	// Interval between two lookups of the containers and services,
+	// defaults to 10s
+	Interval string `json:"interval,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"` // TTL of the published records, defaults to 60
+}
+
+type DockerDiscoveryConfig struct {
+	Enabled bool   `json:"enabled"`
+	Socket  string `json:"socket,omitempty"` // Defaults to /var/run/docker.sock
+	// Network whose address of the containers is published, the first
+	// network of a container by name when empty. The easydns.network label
+	// of a container overrides it.
+	Network string `json:"network,omitempty"`
+}
+
+type KubernetesDiscoveryConfig struct {
+	Enabled bool `json:"enabled"`
+	// APIServer is the URL of the Kubernetes API, the in-cluster address
+	// from KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT when empty
+	APIServer string `json:"api_server,omitempty"`
+	// TokenFile and CAFile default to the files of the pod's service account
+	TokenFile string `json:"token_file,omitempty"`
+	CAFile    string `json:"ca_file,omitempty"`
+	Namespace string `json:"namespace,omitempty"` // All namespaces when empty
+}
+
+func (c DiscoveryConfig) enabled() bool {
+	return c.Docker.Enabled || c.Kubernetes.Enabled
+}
+
+func (c DiscoveryConfig) validate() error {
+	if _, err := c.interval(); err != nil {
+		return err
+	}
+	if c.TTL > maxRecordTTL {
+		return fmt.Errorf("ttl %d is above the maximum of %d", c.TTL, maxRecordTTL)
+	}
+	if c.Kubernetes.Enabled {
+		if _, err := c.Kubernetes.apiServer(); err != nil {
+			return fmt.Errorf("kubernetes: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c DiscoveryConfig) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultDiscoveryInterval, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", c.Interval)
+	}
+	return interval, nil
+}
+
+func (c DiscoveryConfig) ttl() uint32 {
+	if c.TTL == 0 {
+		return defaultDiscoveryTTL
+	}
+	return c.TTL
+}
+
+func (c KubernetesDiscoveryConfig) apiServer() (string, error) {
+	if c.APIServer != "" {
+		return strings.TrimSuffix(c.APIServer, "/"), nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return "", fmt.Errorf("api_server must be set outside of a cluster")
+	}
+	return "https://" + net.JoinHostPort(host, port), nil
+}
+
+// discoveredRecords holds the records found by each discovery source. They
+// are served beneath the records of the config, so a configured name
+// always wins over a discovered one.
+type discoveredRecords struct {
+	mu      sync.Mutex
+	sources map[string]Records
+}
+
+func newDiscoveredRecords() *discoveredRecords {
+	return &discoveredRecords{sources: map[string]Records{}}
+}
+
+// set replaces the records of source and reports whether they changed
+func (d *discoveredRecords) set(source string, records Records) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if reflect.DeepEqual(d.sources[source], records) {
+		return false
+	}
+	d.sources[source] = records
+	return true
+}
+
+// merge adds the discovered names that records doesn't have to records
+func (d *discoveredRecords) merge(records Records) Records {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	discovered := Records{}
+	for _, source := range d.sources {
+		for name, set := range source {
+			discovered[name] = append(discovered[name], set...)
+		}
+	}
+	for name, set := range discovered {
+		if _, found := records[name]; !found {
+			records[name] = set
+		}
+	}
+	return records
+}
+
+// addressRecords appends A and AAAA records of addresses to the records of
+// each of the comma separated names. Invalid names and wildcards are
+// skipped, they are checked on every lookup so they aren't logged.
+func addressRecords(records Records, names string, addresses []net.IP, ttl uint32) {
+	for _, name := range strings.Split(names, ",") {
+		name = recordName(strings.TrimSpace(name))
+		if _, ok := dns.IsDomainName(name); !ok || name == "" || strings.Contains(name, "*") {
+			continue
+		}
+		for _, ip := range addresses {
+			record := Record{Type: "AAAA", Value: ip.String(), TTL: ttl}
+			if ip.To4() != nil {
+				record.Type = "A"
+			}
+			records[name] = append(records[name], record)
+		}
+	}
+}
+
+type discoverySource struct {
+	name    string
+	records func() (Records, error)
+}
+
+// watchDiscovery looks up the containers and services every interval until
+// the server is shut down and reloads the records when they change. A
+// source that can't be reached keeps its previous records.
+func (s *Server) watchDiscovery(cfg DiscoveryConfig) {
+	interval, err := cfg.interval()
+	if err != nil {
+		interval = defaultDiscoveryInterval
+	}
+	var sources []discoverySource
+	if cfg.Docker.Enabled {
+		docker := newDockerClient(cfg.Docker)
+		sources = append(sources, discoverySource{"docker", func() (Records, error) { return docker.records(cfg.ttl()) }})
+	}
+	if cfg.Kubernetes.Enabled {
+		kubernetes, err := newKubernetesClient(cfg.Kubernetes)
+		if err != nil {
+			log.Printf("discovery: %v", err)
+		} else {
+			sources = append(sources, discoverySource{"kubernetes", func() (Records, error) { return kubernetes.records(cfg.ttl()) }})
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		changed := false
+		for _, source := range sources {
+			records, err := source.records()
+			if err != nil {
+				log.Printf("discovery: failed to list %s names, keeping the previous ones: %v", source.name, err)
+				continue
+			}
+			if s.discovered.set(source.name, records) {
+				log.Printf("discovery: found %d %s names", len(records), source.name)
+				changed = true
+			}
+		}
+		if changed {
+			if err := s.reloadRecords(); err != nil {
+				log.Printf("discovery: failed to apply discovered names: %v", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// getJSON decodes the JSON response to a GET of url into out
+func getJSON(client *http.Client, url, token string, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s answered %s: %s", url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dockerClient lists the running containers through the Docker Engine API
+type dockerClient struct {
+	client  *http.Client
+	network string
+}
+
+func newDockerClient(cfg DockerDiscoveryConfig) *dockerClient {
+	socket := cfg.Socket
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &dockerClient{client: &http.Client{Transport: transport}, network: cfg.Network}
+}
+
+type dockerContainer struct {
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// addresses returns the addresses of the container in network, or in its
+// first network by name when network is empty
+func (c dockerContainer) addresses(network string) []net.IP {
+	if network == "" {
+		names := make([]string, 0, len(c.NetworkSettings.Networks))
+		for name := range c.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if c.NetworkSettings.Networks[name].IPAddress != "" || c.NetworkSettings.Networks[name].GlobalIPv6Address != "" {
+				network = name
+				break
+			}
+		}
+	}
+	settings, found := c.NetworkSettings.Networks[network]
+	if !found {
+		return nil
+	}
+	var addresses []net.IP
+	for _, address := range []string{settings.IPAddress, settings.GlobalIPv6Address} {
+		if ip := net.ParseIP(address); ip != nil {
+			addresses = append(addresses, ip)
+		}
+	}
+	return addresses
+}
+
+// records returns the records of the running containers with a hostname
+// label
+func (d *dockerClient) records(ttl uint32) (Records, error) {
+	var containers []dockerContainer
+	if err := getJSON(d.client, "http://docker/containers/json", "", &containers); err != nil {
+		return nil, err
+	}
+	records := Records{}
+	for _, container := range containers {
+		names, found := container.Labels[hostnameLabel]
+		if !found {
+			continue
+		}
+		network := d.network
+		if label, found := container.Labels[networkLabel]; found {
+			network = label
+		}
+		addresses := container.addresses(network)
+		addressRecords(records, names, addresses, ttl)
+	}
+	return records, nil
+}
+
+// kubernetesClient lists the services and ingresses through the
+// Kubernetes API
+type kubernetesClient struct {
+	client    *http.Client
+	server    string
+	tokenFile string
+	namespace string
+}
+
+func newKubernetesClient(cfg KubernetesDiscoveryConfig) (*kubernetesClient, error) {
+	server, err := cfg.apiServer()
+	if err != nil {
+		return nil, err
+	}
+	tokenFile, caFile := cfg.TokenFile, cfg.CAFile
+	if tokenFile == "" {
+		tokenFile = kubernetesTokenFile
+	}
+	if caFile == "" {
+		caFile = kubernetesCAFile
+	}
+	tlsConfig := &tls.Config{}
+	if pem, err := os.ReadFile(caFile); err == nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	} else if cfg.CAFile != "" {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	return &kubernetesClient{
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		server:    server,
+		tokenFile: tokenFile,
+		namespace: cfg.Namespace,
+	}, nil
+}
+
+type kubernetesMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type kubernetesLoadBalancer struct {
+	Ingress []struct {
+		IP string `json:"ip"`
+	} `json:"ingress"`
+}
+
+// addresses returns the addresses the load balancer is reachable at
+func (lb kubernetesLoadBalancer) addresses() []net.IP {
+	var addresses []net.IP
+	for _, ingress := range lb.Ingress {
+		if ip := net.ParseIP(ingress.IP); ip != nil {
+			addresses = append(addresses, ip)
+		}
+	}
+	return addresses
+}
+
+type kubernetesServices struct {
+	Items []struct {
+		Metadata kubernetesMetadata `json:"metadata"`
+		Spec     struct {
+			ClusterIPs []string `json:"clusterIPs"`
+		} `json:"spec"`
+		Status struct {
+			LoadBalancer kubernetesLoadBalancer `json:"loadBalancer"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type kubernetesIngresses struct {
+	Items []struct {
+		Metadata kubernetesMetadata `json:"metadata"`
+		Spec     struct {
+			Rules []struct {
+				Host string `json:"host"`
+			} `json:"rules"`
+		} `json:"spec"`
+		Status struct {
+			LoadBalancer kubernetesLoadBalancer `json:"loadBalancer"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// path returns the API path of resources in the configured namespace
+func (k *kubernetesClient) path(group, resource string) string {
+	if k.namespace != "" {
+		return fmt.Sprintf("%s/%s/namespaces/%s/%s", k.server, group, k.namespace, resource)
+	}
+	return fmt.Sprintf("%s/%s/%s", k.server, group, resource)
+}
+
+// records returns the records of the services with a hostname annotation,
+// at their load balancer or cluster addresses, and of the ingresses with a
+// hostname or publish annotation, at their load balancer addresses
+func (k *kubernetesClient) records(ttl uint32) (Records, error) {
+	// Service account tokens are rotated, so the file is read every time
+	token, err := os.ReadFile(k.tokenFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read token: %v", err)
+	}
+	var services kubernetesServices
+	if err := getJSON(k.client, k.path("api/v1", "services"), strings.TrimSpace(string(token)), &services); err != nil {
+		return nil, err
+	}
+	var ingresses kubernetesIngresses
+	if err := getJSON(k.client, k.path("apis/networking.k8s.io/v1", "ingresses"), strings.TrimSpace(string(token)), &ingresses); err != nil {
+		return nil, err
+	}
+	records := Records{}
+	for _, service := range services.Items {
+		names, found := service.Metadata.Annotations[hostnameLabel]
+		if !found {
+			continue
+		}
+		addresses := service.Status.LoadBalancer.addresses()
+		if len(addresses) == 0 {
+			for _, clusterIP := range service.Spec.ClusterIPs {
+				if ip := net.ParseIP(clusterIP); ip != nil {
+					addresses = append(addresses, ip)
+				}
+			}
+		}
+		addressRecords(records, names, addresses, ttl)
+	}
+	for _, ingress := range ingresses.Items {
+		names, found := ingress.Metadata.Annotations[hostnameLabel]
+		if !found && ingress.Metadata.Annotations[publishAnnotation] == "true" {
+			var hosts []string
+			for _, rule := range ingress.Spec.Rules {
+				if rule.Host != "" && !strings.HasPrefix(rule.Host, "*.") {
+					hosts = append(hosts, rule.Host)
+				}
+			}
+			names, found = strings.Join(hosts, ","), len(hosts) > 0
+		}
+		if !found {
+			continue
+		}
+		addresses := ingress.Status.LoadBalancer.addresses()
+		addressRecords(records, names, addresses, ttl)
+	}
+	return records, nil
+}
@@ -0,0 +1,209 @@
+package easydns
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// serveUnix serves handler over HTTP on a unix socket until the test ends
+// and returns the path of the socket
+func serveUnix(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return socket
+}
+
+// serveJSON is a handler answering the requests for each path with its
+// JSON document
+func serveJSON(documents map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, found := documents[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(document))
+	}
+}
+
+func TestDockerDiscovery(t *testing.T) {
+	socket := serveUnix(t, serveJSON(map[string]string{"/containers/json": `[
+		{"Names": ["/grafana"], "Labels": {"easydns.hostname": "grafana.lab, Metrics.lab"},
+		 "NetworkSettings": {"Networks": {"lab": {"IPAddress": "172.18.0.2", "GlobalIPv6Address": "fd00::2"}, "bridge": {"IPAddress": "172.17.0.2"}}}},
+		{"Names": ["/db"], "Labels": {"easydns.hostname": "db.lab", "easydns.network": "bridge"},
+		 "NetworkSettings": {"Networks": {"lab": {"IPAddress": "172.18.0.3"}, "bridge": {"IPAddress": "172.17.0.3"}}}},
+		{"Names": ["/wild"], "Labels": {"easydns.hostname": "*.lab"},
+		 "NetworkSettings": {"Networks": {"lab": {"IPAddress": "172.18.0.4"}}}},
+		{"Names": ["/unnamed"], "Labels": {},
+		 "NetworkSettings": {"Networks": {"lab": {"IPAddress": "172.18.0.5"}}}}
+	]`}))
+	tests := []struct {
+		name    string
+		network string
+		want    Records
+	}{
+		{
+			name:    "configured network",
+			network: "lab",
+			want: Records{
+				"grafana.lab": {{Type: "A", Value: "172.18.0.2", TTL: 60}, {Type: "AAAA", Value: "fd00::2", TTL: 60}},
+				"metrics.lab": {{Type: "A", Value: "172.18.0.2", TTL: 60}, {Type: "AAAA", Value: "fd00::2", TTL: 60}},
+				"db.lab":      {{Type: "A", Value: "172.17.0.3", TTL: 60}},
+			},
+		},
+		{
+			name: "first network by name",
+			want: Records{
+				"grafana.lab": {{Type: "A", Value: "172.17.0.2", TTL: 60}},
+				"metrics.lab": {{Type: "A", Value: "172.17.0.2", TTL: 60}},
+				"db.lab":      {{Type: "A", Value: "172.17.0.3", TTL: 60}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docker := newDockerClient(DockerDiscoveryConfig{Enabled: true, Socket: socket, Network: tt.network})
+			got, err := docker.records(60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	documents := serveJSON(map[string]string{
+		"/api/v1/namespaces/default/services": `{"items": [
+			{"metadata": {"name": "web", "annotations": {"easydns.hostname": "web.lab"}},
+			 "spec": {"clusterIPs": ["10.96.0.10"]}, "status": {"loadBalancer": {"ingress": [{"ip": "192.0.2.10"}]}}},
+			{"metadata": {"name": "api", "annotations": {"easydns.hostname": "api.lab"}},
+			 "spec": {"clusterIPs": ["10.96.0.11", "fd00::11"]}},
+			{"metadata": {"name": "internal"}, "spec": {"clusterIPs": ["10.96.0.12"]}}
+		]}`,
+		"/apis/networking.k8s.io/v1/namespaces/default/ingresses": `{"items": [
+			{"metadata": {"name": "shop", "annotations": {"easydns.publish": "true"}},
+			 "spec": {"rules": [{"host": "shop.lab"}, {"host": "*.shop.lab"}]}, "status": {"loadBalancer": {"ingress": [{"ip": "192.0.2.20"}]}}},
+			{"metadata": {"name": "docs", "annotations": {"easydns.hostname": "docs.lab"}},
+			 "spec": {"rules": [{"host": "ignored.lab"}]}, "status": {"loadBalancer": {"ingress": [{"ip": "192.0.2.21"}]}}},
+			{"metadata": {"name": "private"}, "spec": {"rules": [{"host": "private.lab"}]}}
+		]}`,
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		documents(w, r)
+	}))
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newKubernetesClient(KubernetesDiscoveryConfig{
+		Enabled:   true,
+		APIServer: server.URL,
+		CAFile:    filepath.Join(t.TempDir(), "missing.crt"),
+	}); err == nil {
+		t.Error("missing CA file was accepted")
+	}
+	kubernetes, err := newKubernetesClient(KubernetesDiscoveryConfig{
+		Enabled:   true,
+		APIServer: server.URL + "/",
+		TokenFile: tokenFile,
+		Namespace: "default",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := kubernetes.records(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Records{
+		"web.lab":  {{Type: "A", Value: "192.0.2.10", TTL: 30}},
+		"api.lab":  {{Type: "A", Value: "10.96.0.11", TTL: 30}, {Type: "AAAA", Value: "fd00::11", TTL: 30}},
+		"shop.lab": {{Type: "A", Value: "192.0.2.20", TTL: 30}},
+		"docs.lab": {{Type: "A", Value: "192.0.2.21", TTL: 30}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %v, want %v", got, want)
+	}
+
+	// A rotated token is picked up on the next lookup
+	if err := os.WriteFile(tokenFile, []byte("expired"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kubernetes.records(30); err == nil {
+		t.Error("got no error for a rejected token")
+	}
+}
+
+func TestDiscoveredRecordsServedBeneathConfig(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"grafana.lab": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovered := Records{
+		"grafana.lab": {{Type: "A", Value: "172.18.0.2", TTL: 60}},
+		"db.lab":      {{Type: "A", Value: "172.18.0.3", TTL: 60}},
+	}
+	if !s.discovered.set("docker", discovered) {
+		t.Fatal("new records were not reported as changed")
+	}
+	if s.discovered.set("docker", discovered) {
+		t.Error("the same records were reported as changed")
+	}
+	if err := s.reloadRecords(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]string{"grafana.lab": {"10.0.0.1"}, "db.lab": {"172.18.0.3"}} {
+		if got := answerValues(ask(t, s, name, dns.TypeA)); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v for %s, want %v", got, name, want)
+		}
+	}
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		discovery DiscoveryConfig
+		wantErr   bool
+	}{
+		{name: "defaults", discovery: DiscoveryConfig{Docker: DockerDiscoveryConfig{Enabled: true}}},
+		{name: "interval", discovery: DiscoveryConfig{Interval: "30s"}},
+		{name: "invalid interval", discovery: DiscoveryConfig{Interval: "often"}, wantErr: true},
+		{name: "negative interval", discovery: DiscoveryConfig{Interval: "-1s"}, wantErr: true},
+		{name: "ttl above the maximum", discovery: DiscoveryConfig{TTL: maxRecordTTL + 1}, wantErr: true},
+		{name: "kubernetes with an API server", discovery: DiscoveryConfig{Kubernetes: KubernetesDiscoveryConfig{Enabled: true, APIServer: "https://k8s.lab:6443"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.discovery.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
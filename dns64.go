@@ -0,0 +1,206 @@
+package easydns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultDNS64Prefix = "64:ff9b::/96"
+
+// DNS64Config synthesizes AAAA answers from A records for names without
+// AAAA records, so IPv6-only clients can reach them through NAT64. Reverse
+// lookups of the synthesized addresses are answered with the PTR records of
+// the IPv4 address they embed (RFC 6147).
+type DNS64Config struct {
+	Enabled bool `json:"enabled"`
+	// Prefix of the NAT64 gateway, 64:ff9b::/96 by default. It must be a
+	// /32, /40, /48, /56, /64 or /96 as in RFC 6052.
+	Prefix  string   `json:"prefix,omitempty"`
+	Clients []string `json:"clients,omitempty"` // Client networks, all clients when empty
+}
+
+func (c DNS64Config) validate() error {
+	if _, err := c.prefix(); err != nil {
+		return err
+	}
+	if _, err := parseNetworks(c.Clients); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c DNS64Config) prefix() (*net.IPNet, error) {
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = defaultDNS64Prefix
+	}
+	ip, network, err := net.ParseCIDR(prefix)
+	if err != nil || ip.To4() != nil {
+		return nil, fmt.Errorf("prefix %q is not an IPv6 network", prefix)
+	}
+	switch ones, _ := network.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64:
+	case 96:
+		// Bits 64 to 71 are reserved and must be zero
+		if network.IP[8] != 0 {
+			return nil, fmt.Errorf("prefix %q must have zeros in bits 64 to 71", prefix)
+		}
+	default:
+		return nil, fmt.Errorf("prefix %q must be a /32, /40, /48, /56, /64 or /96", prefix)
+	}
+	return network, nil
+}
+
+// embedIPv4 returns the IPv6 address of ipv4 within prefix, as laid out by
+// RFC 6052 2.2
+func embedIPv4(prefix *net.IPNet, ipv4 net.IP) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16())
+	ones, _ := prefix.Mask.Size()
+	octet := ones / 8
+	for _, b := range ipv4.To4() {
+		if octet == 8 {
+			// Skip the reserved bits 64 to 71
+			octet++
+		}
+		ip[octet] = b
+		octet++
+	}
+	return ip
+}
+
+// extractIPv4 returns the IPv4 address embedded in ip within prefix, the
+// reverse of embedIPv4
+func extractIPv4(prefix *net.IPNet, ip net.IP) net.IP {
+	ipv4 := make(net.IP, net.IPv4len)
+	ones, _ := prefix.Mask.Size()
+	octet := ones / 8
+	for i := range ipv4 {
+		if octet == 8 {
+			octet++
+		}
+		ipv4[i] = ip[octet]
+		octet++
+	}
+	return ipv4
+}
+
+// ip6ArpaAddress returns the address of a full ip6.arpa name, nil if name
+// is not one
+func ip6ArpaAddress(name string) net.IP {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	if len(labels) != 34 || labels[32] != "ip6" || labels[33] != "arpa" {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < 32; i++ {
+		// The first label is the lowest nibble
+		label := labels[31-i]
+		nibble, err := strconv.ParseUint(label, 16, 8)
+		if err != nil || len(label) != 1 {
+			return nil
+		}
+		ip[i/2] |= byte(nibble) << (4 * (1 - i%2))
+	}
+	return ip
+}
+
+// reverseQuery returns r with the PTR questions for addresses within the
+// prefix asking for the in-addr.arpa name of the IPv4 address they embed
+// instead, so the IPv4 PTR is served or forwarded as usual, along with the
+// names asked for by in-addr.arpa name. Names with local records are kept.
+func (c DNS64Config) reverseQuery(r *dns.Msg, records Records, client net.IP) (*dns.Msg, map[string]string) {
+	if !c.Enabled || (wantsDNSSEC(r) && r.CheckingDisabled) {
+		return r, nil
+	}
+	prefix, err := c.prefix()
+	if err != nil {
+		return r, nil
+	}
+	if clients, _ := parseNetworks(c.Clients); len(clients) > 0 && !containsIP(clients, client) {
+		return r, nil
+	}
+	var query *dns.Msg
+	var original map[string]string
+	for i, q := range r.Question {
+		if q.Qtype != dns.TypePTR {
+			continue
+		}
+		ip := ip6ArpaAddress(q.Name)
+		if ip == nil || !prefix.Contains(ip) {
+			continue
+		}
+		if _, _, found := records.lookup(recordName(q.Name)); found {
+			continue
+		}
+		name, err := dns.ReverseAddr(extractIPv4(prefix, ip).String())
+		if err != nil {
+			continue
+		}
+		if query == nil {
+			query, original = r.Copy(), map[string]string{}
+		}
+		query.Question[i].Name = name
+		original[name] = q.Name
+	}
+	if query == nil {
+		return r, nil
+	}
+	return query, original
+}
+
+// synthesizeDNS64 replaces the answer of an AAAA query that found no AAAA
+// records with AAAA records synthesized from the A records of the name. It
+// reports whether it did.
+func (s *Server) synthesizeDNS64(ctx context.Context, span trace.Span, w dns.ResponseWriter, r, msg *dns.Msg, records Records, cfg *Config, client net.IP) bool {
+	if !cfg.DNS64.Enabled || len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeAAAA || msg.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	// Validating clients would reject the synthesized records, RFC 6147 5.5
+	if wantsDNSSEC(r) && r.CheckingDisabled {
+		return false
+	}
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			return false
+		}
+	}
+	prefix, err := cfg.DNS64.prefix()
+	if err != nil {
+		return false
+	}
+	if clients, _ := parseNetworks(cfg.DNS64.Clients); len(clients) > 0 && !containsIP(clients, client) {
+		return false
+	}
+	query := r.Copy()
+	query.Question[0].Qtype = dns.TypeA
+	answer := new(dns.Msg)
+	answer.SetReply(query)
+	if _, _, answered := s.answerQuestions(ctx, span, w, query, answer, records, cfg, client); !answered || answer.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	var synthesized []dns.RR
+	found := false
+	for _, rr := range answer.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			hdr := dns.RR_Header{Name: rr.Hdr.Name, Rrtype: dns.TypeAAAA, Class: rr.Hdr.Class, Ttl: rr.Hdr.Ttl}
+			synthesized = append(synthesized, &dns.AAAA{Hdr: hdr, AAAA: embedIPv4(prefix, rr.A)})
+			found = true
+		case *dns.CNAME, *dns.DNAME:
+			synthesized = append(synthesized, rr)
+		}
+	}
+	if !found {
+		return false
+	}
+	msg.Answer = synthesized
+	msg.Ns = answer.Ns
+	return true
+}
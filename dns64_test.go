@@ -0,0 +1,228 @@
+package easydns
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestEmbedIPv4(t *testing.T) {
+	// The examples of RFC 6052 2.4
+	tests := []struct {
+		prefix string
+		ip     string
+	}{
+		{prefix: "2001:db8::/32", ip: "2001:db8:c000:221::"},
+		{prefix: "2001:db8:100::/40", ip: "2001:db8:1c0:2:21::"},
+		{prefix: "2001:db8:122::/48", ip: "2001:db8:122:c000:2:2100::"},
+		{prefix: "2001:db8:122:300::/56", ip: "2001:db8:122:3c0:0:221::"},
+		{prefix: "2001:db8:122:344::/64", ip: "2001:db8:122:344:c0:2:2100::"},
+		{prefix: "64:ff9b::/96", ip: "64:ff9b::192.0.2.33"},
+	}
+	want := net.IPv4(192, 0, 2, 33).To4()
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			network, err := DNS64Config{Prefix: tt.prefix}.prefix()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := embedIPv4(network, want); !got.Equal(net.ParseIP(tt.ip)) {
+				t.Errorf("got %s for %s, want %s", got, want, tt.ip)
+			}
+			if got := extractIPv4(network, net.ParseIP(tt.ip)); !got.Equal(want) {
+				t.Errorf("got %s from %s, want %s", got, tt.ip, want)
+			}
+		})
+	}
+}
+
+func TestDNS64Prefix(t *testing.T) {
+	for _, prefix := range []string{"", "64:ff9b::/96", "2001:db8::/32"} {
+		if _, err := (DNS64Config{Prefix: prefix}).prefix(); err != nil {
+			t.Errorf("prefix %q: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"10.0.0.0/8", "2001:db8::/33", "2001:db8:0:0:100::/96", "64:ff9b::"} {
+		if _, err := (DNS64Config{Prefix: prefix}).prefix(); err == nil {
+			t.Errorf("prefix %q was accepted", prefix)
+		}
+	}
+	if err := (DNS64Config{Clients: []string{"10.0.0.0/33"}}).validate(); err == nil {
+		t.Error("invalid client network was accepted")
+	}
+}
+
+func TestIP6ArpaAddress(t *testing.T) {
+	reverse, _ := dns.ReverseAddr("64:ff9b::c000:221")
+	tests := []struct {
+		name string
+		want net.IP
+	}{
+		{name: reverse, want: net.ParseIP("64:ff9b::c000:221")},
+		{name: strings.ToUpper(reverse), want: net.ParseIP("64:ff9b::c000:221")},
+		{name: "33.2.0.192.in-addr.arpa.", want: nil},
+		{name: "1.2.ip6.arpa.", want: nil},
+		{name: "x" + reverse[1:], want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ip6ArpaAddress(tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNS64ReversePTR(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		asked []string
+	)
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		asked = append(asked, r.Question[0].Name)
+		mu.Unlock()
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		if r.Question[0].Name == "34.2.0.192.in-addr.arpa." {
+			rr, _ := dns.NewRR("34.2.0.192.in-addr.arpa. 60 IN PTR forwarded.example.com.")
+			resp.Answer = append(resp.Answer, rr)
+		}
+		w.WriteMsg(resp)
+	})
+	reverse := func(address string) string {
+		name, _ := dns.ReverseAddr(address)
+		return name
+	}
+	tests := []struct {
+		name      string
+		disabled  bool
+		clients   []string
+		qname     string
+		want      []string
+		wantAsked string // Name forwarded upstream, if any
+	}{
+		{name: "local IPv4 PTR", qname: reverse("64:ff9b::c000:221"), want: []string{"local.test.com."}},
+		{name: "forwarded IPv4 PTR", qname: reverse("64:ff9b::c000:222"), want: []string{"forwarded.example.com."}, wantAsked: "34.2.0.192.in-addr.arpa."},
+		{name: "local ip6.arpa record wins", qname: reverse("64:ff9b::c000:223"), want: []string{"ipv6.test.com."}},
+		{name: "address outside the prefix", qname: reverse("2001:db8::1"), wantAsked: reverse("2001:db8::1")},
+		{name: "disabled", disabled: true, qname: reverse("64:ff9b::c000:222"), wantAsked: reverse("64:ff9b::c000:222")},
+		{name: "client outside the networks", clients: []string{"10.0.0.0/8"}, qname: reverse("64:ff9b::c000:222"), wantAsked: reverse("64:ff9b::c000:222")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(&Config{
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+				DNS64:      DNS64Config{Enabled: !tt.disabled, Clients: tt.clients},
+				Records: Records{
+					"33.2.0.192.in-addr.arpa":                             {{Type: "PTR", Value: "local.test.com.", TTL: 60}},
+					strings.TrimSuffix(reverse("64:ff9b::c000:223"), "."): {{Type: "PTR", Value: "ipv6.test.com.", TTL: 60}},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			asked = nil
+			mu.Unlock()
+			resp := ask(t, s, tt.qname, dns.TypePTR)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for _, rr := range resp.Answer {
+				if rr.Header().Name != tt.qname {
+					t.Errorf("answer is owned by %s, want %s", rr.Header().Name, tt.qname)
+				}
+			}
+			if resp.Question[0].Name != tt.qname {
+				t.Errorf("question is %s, want %s", resp.Question[0].Name, tt.qname)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			var wantAsked []string
+			if tt.wantAsked != "" {
+				wantAsked = []string{tt.wantAsked}
+			}
+			if !reflect.DeepEqual(asked, wantAsked) {
+				t.Errorf("forwarded %v, want %v", asked, wantAsked)
+			}
+		})
+	}
+}
+
+func TestDNS64Synthesis(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		if r.Question[0].Name == "v4only.example.com." && r.Question[0].Qtype == dns.TypeA {
+			rr, _ := dns.NewRR("v4only.example.com. 60 IN A 192.0.2.34")
+			resp.Answer = append(resp.Answer, rr)
+		} else if r.Question[0].Name != "v4only.example.com." {
+			resp.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(resp)
+	})
+	tests := []struct {
+		name     string
+		clients  []string
+		client   string
+		qname    string
+		checking bool // Query with the DO and CD bits set
+		want     []string
+	}{
+		{name: "local A record", qname: "v4.test.com", want: []string{"64:ff9b::c000:221"}},
+		{name: "forwarded A record", qname: "v4only.example.com", want: []string{"64:ff9b::c000:222"}},
+		{name: "AAAA record wins", qname: "dual.test.com", want: []string{"2001:db8::1"}},
+		{name: "through a CNAME", qname: "alias.test.com", want: []string{"v4.test.com.", "64:ff9b::c000:221"}},
+		{name: "nxdomain", qname: "nosuch.example.com"},
+		{name: "validating client", qname: "v4.test.com", checking: true},
+		{name: "client in the networks", clients: []string{"10.0.0.0/8"}, client: "10.1.2.3", qname: "v4.test.com", want: []string{"64:ff9b::c000:221"}},
+		{name: "client outside the networks", clients: []string{"10.0.0.0/8"}, client: "192.168.1.2", qname: "v4.test.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(&Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+				DNS64:      DNS64Config{Enabled: true, Clients: tt.clients},
+				Records: Records{
+					"v4.test.com":    {{Type: "A", Value: "192.0.2.33", TTL: 60}},
+					"dual.test.com":  {{Type: "A", Value: "192.0.2.35", TTL: 60}, {Type: "AAAA", Value: "2001:db8::1", TTL: 60}},
+					"alias.test.com": {{Type: "CNAME", Value: "v4.test.com.", TTL: 60}},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := new(dns.Msg)
+			query.SetQuestion(dns.Fqdn(tt.qname), dns.TypeAAAA)
+			if tt.checking {
+				query.SetEdns0(dns.DefaultMsgSize, true)
+				query.CheckingDisabled = true
+			}
+			client := tt.client
+			if client == "" {
+				client = "127.0.0.1"
+			}
+			w := newRecorder("udp", client)
+			s.ServeDNS(w, query)
+			if w.msg == nil {
+				t.Fatal("query was dropped")
+			}
+			if got := answerValues(w.msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+			for _, rr := range w.msg.Answer {
+				if rr.Header().Rrtype == dns.TypeA {
+					t.Errorf("got %v in the answer to an AAAA query", rr)
+				}
+			}
+		})
+	}
+}
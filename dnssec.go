@@ -0,0 +1,665 @@
+package easydns
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rootTrustAnchors are the DS records of the root zone's key signing keys,
+// used unless trust anchors are configured
+var rootTrustAnchors = []string{
+	". 86400 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 86400 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+const (
+	minTrustTTL = time.Minute
+	maxTrustTTL = time.Hour
+	// maxTrustEntries bounds the chain of trust results kept, as every
+	// name asked about gets one
+	maxTrustEntries = 10000
+)
+
+// supportedDNSSECAlgorithms are the signing algorithms that can be
+// validated. Zones signed only with other algorithms are treated as
+// unsigned, see RFC 4035 5.2.
+var supportedDNSSECAlgorithms = map[uint8]bool{
+	dns.RSASHA1:          true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.RSASHA256:        true,
+	dns.RSASHA512:        true,
+	dns.ECDSAP256SHA256:  true,
+	dns.ECDSAP384SHA384:  true,
+	dns.ED25519:          true,
+}
+
+var supportedDSDigests = map[uint8]bool{
+	dns.SHA1:   true,
+	dns.SHA256: true,
+	dns.SHA384: true,
+}
+
+// DNSSECBogusError is returned for upstream responses that fail DNSSEC
+// validation
+type DNSSECBogusError struct {
+	name   string
+	reason string
+}
+
+func (e DNSSECBogusError) Error() string {
+	return fmt.Sprintf("DNSSEC validation of %s failed: %s", e.name, e.reason)
+}
+
+// parseTrustAnchors parses DS records of the root zone in zone file
+// notation
+func parseTrustAnchors(anchors []string) ([]*dns.DS, error) {
+	if len(anchors) == 0 {
+		anchors = rootTrustAnchors
+	}
+	parsed := make([]*dns.DS, 0, len(anchors))
+	for _, anchor := range anchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor %q: %v", anchor, err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok || ds.Hdr.Name != "." {
+			return nil, fmt.Errorf("invalid trust anchor %q: expected a DS record of the root zone", anchor)
+		}
+		parsed = append(parsed, ds)
+	}
+	return parsed, nil
+}
+
+// requestDNSSEC asks for DNSSEC records by setting the DO bit, and for
+// answers that failed validation upstream with the CD bit, so they can be
+// validated here
+func requestDNSSEC(query *dns.Msg) {
+	if opt := query.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		query.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	query.CheckingDisabled = true
+}
+
+// wantsDNSSEC reports whether the client asked for DNSSEC records
+func wantsDNSSEC(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// stripDNSSEC removes signatures and denial of existence records from msg,
+// for clients that did not ask for them
+func stripDNSSEC(msg *dns.Msg) {
+	qtype := uint16(0)
+	if len(msg.Question) > 0 {
+		qtype = msg.Question[0].Qtype
+	}
+	strip := func(section []dns.RR) []dns.RR {
+		kept := section[:0:0]
+		for _, rr := range section {
+			switch rr.Header().Rrtype {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+				if rr.Header().Rrtype != qtype {
+					continue
+				}
+			}
+			kept = append(kept, rr)
+		}
+		return kept
+	}
+	msg.Answer = strip(msg.Answer)
+	msg.Ns = strip(msg.Ns)
+	msg.Extra = strip(msg.Extra)
+}
+
+// canonicalCompare orders domain names in the canonical order of RFC 4034
+// 6.1
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(dns.CanonicalName(a))
+	lb := dns.SplitDomainName(dns.CanonicalName(b))
+	for i := 1; i <= min(len(la), len(lb)); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(la), len(lb))
+}
+
+// nsecCovers reports whether nsec proves that name does not exist
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	if canonicalCompare(nsec.Hdr.Name, name) >= 0 {
+		return false
+	}
+	// The last NSEC record of a zone points back at the apex
+	return canonicalCompare(name, nsec.NextDomain) < 0 || canonicalCompare(nsec.NextDomain, nsec.Hdr.Name) <= 0
+}
+
+func hasType(bitmap []uint16, rrtype uint16) bool {
+	for _, t := range bitmap {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// lastLabels returns the name made of the last n labels of name
+func lastLabels(name string, n int) string {
+	labels := dns.Split(name)
+	if n <= 0 || len(labels) == 0 {
+		return "."
+	}
+	if n >= len(labels) {
+		return name
+	}
+	return name[labels[len(labels)-n]:]
+}
+
+// wildcardName returns the wildcard name directly below encloser
+func wildcardName(encloser string) string {
+	if encloser == "." {
+		return "*."
+	}
+	return "*." + encloser
+}
+
+// nsecDenies reports whether nsecs prove that name does not exist: one of
+// them covers name and one covers the wildcard at the closest encloser of
+// name, see RFC 4035 5.4
+func nsecDenies(nsecs []*dns.NSEC, name string) bool {
+	for _, nsec := range nsecs {
+		if !nsecCovers(nsec, name) {
+			continue
+		}
+		// The closest encloser is the longest ancestor shared with the
+		// names around the gap
+		labels := max(dns.CompareDomainName(name, nsec.Hdr.Name), dns.CompareDomainName(name, nsec.NextDomain))
+		wildcard := wildcardName(lastLabels(name, labels))
+		if slices.ContainsFunc(nsecs, func(nsec *dns.NSEC) bool { return nsecCovers(nsec, wildcard) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsec3Denies reports whether nsec3s prove that name does not exist in
+// zone: the closest encloser of name exists, and the next closer name and
+// the wildcard at the closest encloser are covered, see RFC 5155 8.4
+func nsec3Denies(nsec3s []*dns.NSEC3, zone, name string) bool {
+	covered := func(name string) bool {
+		return slices.ContainsFunc(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3.Cover(name) })
+	}
+	for next, encloser := name, parentName(name); dns.IsSubDomain(zone, encloser); next, encloser = encloser, parentName(encloser) {
+		if slices.ContainsFunc(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3.Match(encloser) }) {
+			return covered(next) && covered(wildcardName(encloser))
+		}
+		if encloser == "." {
+			break
+		}
+	}
+	return false
+}
+
+// parentName returns the name one label up from name
+func parentName(name string) string {
+	if name == "." {
+		return "."
+	}
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// rrset is the records of one name and type and their signatures
+type rrset struct {
+	rrs  []dns.RR
+	sigs []*dns.RRSIG
+}
+
+// rrsets groups section into RRsets
+func rrsets(section []dns.RR) map[dns.RR_Header]*rrset {
+	sets := map[dns.RR_Header]*rrset{}
+	get := func(name string, rrtype, class uint16) *rrset {
+		key := dns.RR_Header{Name: dns.CanonicalName(name), Rrtype: rrtype, Class: class}
+		set, found := sets[key]
+		if !found {
+			set = &rrset{}
+			sets[key] = set
+		}
+		return set
+	}
+	for _, rr := range section {
+		h := rr.Header()
+		switch rr := rr.(type) {
+		case *dns.RRSIG:
+			set := get(h.Name, rr.TypeCovered, h.Class)
+			set.sigs = append(set.sigs, rr)
+		case *dns.OPT:
+		default:
+			set := get(h.Name, h.Rrtype, h.Class)
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+	return sets
+}
+
+// zoneTrust is what the chain of trust says about a name: the closest
+// enclosing zone known and its validated keys, or that the name is below
+// an insecure delegation
+type zoneTrust struct {
+	secure  bool
+	zone    string
+	keys    []*dns.DNSKEY
+	expires time.Time
+}
+
+// verify checks that one of the signatures of set is a valid signature by
+// the keys of the zone
+func (t zoneTrust) verify(set *rrset) error {
+	if len(set.sigs) == 0 {
+		return fmt.Errorf("no signature")
+	}
+	var err error = fmt.Errorf("no signature by a key of %s", t.zone)
+	for _, sig := range set.sigs {
+		if !strings.EqualFold(dns.Fqdn(sig.SignerName), t.zone) {
+			continue
+		}
+		if !sig.ValidityPeriod(now()) {
+			err = fmt.Errorf("signature by key %d of %s expired or not yet valid", sig.KeyTag, t.zone)
+			continue
+		}
+		for _, key := range t.keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if verifyErr := sig.Verify(key, set.rrs); verifyErr == nil {
+				return nil
+			} else {
+				err = fmt.Errorf("signature by key %d of %s: %v", sig.KeyTag, t.zone, verifyErr)
+			}
+		}
+	}
+	return err
+}
+
+// dnssecValidator validates upstream responses against the chain of trust
+// from the root trust anchors
+type dnssecValidator struct {
+	anchors []*dns.DS
+	// query sends a DNSSEC query for name and qtype upstream
+	query func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+
+	mu         sync.Mutex
+	trust      map[string]zoneTrust // By name
+	maxEntries int
+}
+
+func newDNSSECValidator(anchors []*dns.DS, query func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)) *dnssecValidator {
+	return &dnssecValidator{anchors: anchors, query: query, trust: map[string]zoneTrust{}, maxEntries: maxTrustEntries}
+}
+
+// queryDNSSEC asks the upstream servers for the DNSSEC records of name
+func (s *Server) queryDNSSEC(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	requestDNSSEC(query)
+	return s.requestFromUpsreamServers(ctx, query, s.currentConfig().Forwarding.forName(name))
+}
+
+// trustTTL returns how long a result built from rrs may be cached
+func trustTTL(rrs []dns.RR) time.Duration {
+	ttl := maxTrustTTL
+	for _, rr := range rrs {
+		ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+	}
+	return max(ttl, minTrustTTL)
+}
+
+// zoneKeys fetches the DNSKEY records of zone and returns them if one of
+// the keys matching a DS record in ds signed them. Without a DS record of a
+// supported algorithm the zone is insecure.
+func (v *dnssecValidator) zoneKeys(ctx context.Context, zone string, ds []*dns.DS) (zoneTrust, error) {
+	var supported []*dns.DS
+	for _, d := range ds {
+		if supportedDNSSECAlgorithms[d.Algorithm] && supportedDSDigests[d.DigestType] {
+			supported = append(supported, d)
+		}
+	}
+	if len(supported) == 0 {
+		return zoneTrust{secure: false, zone: zone, expires: now().Add(maxTrustTTL)}, nil
+	}
+	resp, err := v.query(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return zoneTrust{}, err
+	}
+	set, found := rrsets(resp.Answer)[dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET}]
+	if !found || len(set.rrs) == 0 {
+		return zoneTrust{}, DNSSECBogusError{name: zone, reason: "no DNSKEY records"}
+	}
+	var keys []*dns.DNSKEY
+	for _, rr := range set.rrs {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+	var signing []*dns.DNSKEY
+	for _, d := range supported {
+		for _, key := range keys {
+			if key.KeyTag() == d.KeyTag && key.Algorithm == d.Algorithm && strings.EqualFold(key.ToDS(d.DigestType).Digest, d.Digest) {
+				signing = append(signing, key)
+			}
+		}
+	}
+	if len(signing) == 0 {
+		return zoneTrust{}, DNSSECBogusError{name: zone, reason: "no DNSKEY matches the DS records"}
+	}
+	if err := (zoneTrust{zone: zone, keys: signing}).verify(set); err != nil {
+		return zoneTrust{}, DNSSECBogusError{name: zone, reason: fmt.Sprintf("DNSKEY records: %v", err)}
+	}
+	return zoneTrust{secure: true, zone: zone, keys: keys, expires: now().Add(trustTTL(set.rrs))}, nil
+}
+
+// trustFor walks the chain of trust from the root down to name
+func (v *dnssecValidator) trustFor(ctx context.Context, name string) (zoneTrust, error) {
+	name = dns.CanonicalName(name)
+	v.mu.Lock()
+	cached, found := v.trust[name]
+	v.mu.Unlock()
+	if found && now().Before(cached.expires) {
+		return cached, nil
+	}
+	trust, err := v.walk(ctx, name)
+	if err != nil {
+		return zoneTrust{}, err
+	}
+	v.mu.Lock()
+	if len(v.trust) >= v.maxEntries {
+		v.evict()
+	}
+	v.trust[name] = trust
+	v.mu.Unlock()
+	return trust, nil
+}
+
+// evict makes room for a new chain of trust result by forgetting the
+// expired ones, or some arbitrary ones if none expired. The caller holds
+// v.mu.
+func (v *dnssecValidator) evict() {
+	for name, trust := range v.trust {
+		if !now().Before(trust.expires) {
+			delete(v.trust, name)
+		}
+	}
+	for name := range v.trust {
+		if len(v.trust) < v.maxEntries {
+			break
+		}
+		delete(v.trust, name)
+	}
+}
+
+// walk finds out whether name is a securely delegated zone, below an
+// insecure delegation or inside the zone of its parent
+func (v *dnssecValidator) walk(ctx context.Context, name string) (zoneTrust, error) {
+	if name == "." {
+		return v.zoneKeys(ctx, ".", v.anchors)
+	}
+	parent, err := v.trustFor(ctx, parentName(name))
+	if err != nil || !parent.secure {
+		return parent, err
+	}
+	resp, err := v.query(ctx, name, dns.TypeDS)
+	if err != nil {
+		return zoneTrust{}, err
+	}
+	inParent := parent
+	inParent.expires = now().Add(minTrustTTL)
+	answer := rrsets(resp.Answer)
+	if set, found := answer[dns.RR_Header{Name: name, Rrtype: dns.TypeDS, Class: dns.ClassINET}]; found && len(set.rrs) > 0 {
+		if err := parent.verify(set); err != nil {
+			return zoneTrust{}, DNSSECBogusError{name: name, reason: fmt.Sprintf("DS records: %v", err)}
+		}
+		var ds []*dns.DS
+		for _, rr := range set.rrs {
+			ds = append(ds, rr.(*dns.DS))
+		}
+		return v.zoneKeys(ctx, name, ds)
+	}
+	if resp.Rcode == dns.RcodeNameError || len(resp.Answer) > 0 {
+		// The name does not exist or is a CNAME, so it is no zone cut. The
+		// answer for it still has to be signed by the parent zone.
+		return inParent, nil
+	}
+	// No DS records: the parent has to prove their absence, and whether
+	// the name is an unsigned delegation
+	authority := rrsets(resp.Ns)
+	proven := false
+	for header, set := range authority {
+		if header.Rrtype != dns.TypeNSEC && header.Rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if err := parent.verify(set); err != nil {
+			return zoneTrust{}, DNSSECBogusError{name: name, reason: fmt.Sprintf("denial of DS records: %v", err)}
+		}
+		for _, rr := range set.rrs {
+			var bitmap []uint16
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				if !strings.EqualFold(rr.Hdr.Name, name) {
+					if nsecCovers(rr, name) {
+						// An empty non-terminal
+						proven = true
+					}
+					continue
+				}
+				bitmap = rr.TypeBitMap
+			case *dns.NSEC3:
+				if !rr.Match(name) {
+					if rr.Cover(name) && rr.Flags&1 == 1 {
+						// Opt-out: an unsigned delegation may be here
+						return zoneTrust{secure: false, zone: name, expires: now().Add(trustTTL(set.rrs))}, nil
+					}
+					continue
+				}
+				bitmap = rr.TypeBitMap
+			}
+			if hasType(bitmap, dns.TypeDS) {
+				return zoneTrust{}, DNSSECBogusError{name: name, reason: "DS records were denied but exist"}
+			}
+			if hasType(bitmap, dns.TypeNS) && !hasType(bitmap, dns.TypeSOA) {
+				return zoneTrust{secure: false, zone: name, expires: now().Add(trustTTL(set.rrs))}, nil
+			}
+			proven = true
+		}
+	}
+	if !proven {
+		return zoneTrust{}, DNSSECBogusError{name: name, reason: "the absence of DS records is not proven"}
+	}
+	return inParent, nil
+}
+
+// verifySet validates an RRset with owner name. Signed sets are validated
+// with the keys of their signer, unsigned sets must be below an insecure
+// delegation. It reports whether the set is secure.
+func (v *dnssecValidator) verifySet(ctx context.Context, header dns.RR_Header, set *rrset) (bool, error) {
+	signer := header.Name
+	if header.Rrtype == dns.TypeDS {
+		// DS records belong to the parent zone
+		signer = parentName(signer)
+	}
+	if len(set.sigs) > 0 {
+		signer = dns.CanonicalName(set.sigs[0].SignerName)
+		if !dns.IsSubDomain(signer, header.Name) {
+			return false, DNSSECBogusError{name: header.Name, reason: fmt.Sprintf("signed by %s, which is not a parent zone", signer)}
+		}
+	}
+	trust, err := v.trustFor(ctx, signer)
+	if err != nil {
+		return false, err
+	}
+	if !trust.secure {
+		return false, nil
+	}
+	if err := trust.verify(set); err != nil {
+		return false, DNSSECBogusError{name: header.Name, reason: fmt.Sprintf("%s records: %v", dns.TypeToString[header.Rrtype], err)}
+	}
+	return true, nil
+}
+
+// verifyExpansion checks that the answer for name, expanded from the
+// wildcard with labels labels, comes with a proof in authority that no
+// closer match for name exists, see RFC 4035 5.3.4 and RFC 5155 8.8
+func (v *dnssecValidator) verifyExpansion(ctx context.Context, name, signer string, labels int, authority []dns.RR) error {
+	trust, err := v.trustFor(ctx, signer)
+	if err != nil {
+		return err
+	}
+	nextCloser := lastLabels(name, labels+1)
+	for header, set := range rrsets(authority) {
+		if header.Rrtype != dns.TypeNSEC && header.Rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if err := trust.verify(set); err != nil {
+			return DNSSECBogusError{name: name, reason: fmt.Sprintf("%s records: %v", dns.TypeToString[header.Rrtype], err)}
+		}
+		for _, rr := range set.rrs {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				if nsecCovers(rr, name) {
+					return nil
+				}
+			case *dns.NSEC3:
+				if rr.Cover(nextCloser) {
+					return nil
+				}
+			}
+		}
+	}
+	return DNSSECBogusError{name: name, reason: "wildcard answer without proof that the name does not exist"}
+}
+
+// verifyDenial validates a negative response for name and qtype: the
+// NSEC or NSEC3 records of the zone have to prove that the name or the
+// type does not exist
+func (v *dnssecValidator) verifyDenial(ctx context.Context, name string, qtype uint16, resp *dns.Msg) (bool, error) {
+	authority := rrsets(resp.Ns)
+	zone := ""
+	for header := range authority {
+		if header.Rrtype == dns.TypeSOA {
+			zone = header.Name
+		}
+	}
+	if zone == "" {
+		trust, err := v.trustFor(ctx, name)
+		if err != nil || !trust.secure {
+			return false, err
+		}
+		return false, DNSSECBogusError{name: name, reason: "negative answer without SOA record"}
+	}
+	trust, err := v.trustFor(ctx, zone)
+	if err != nil || !trust.secure {
+		return false, err
+	}
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for header, set := range authority {
+		if err := trust.verify(set); err != nil {
+			return false, DNSSECBogusError{name: name, reason: fmt.Sprintf("%s records: %v", dns.TypeToString[header.Rrtype], err)}
+		}
+		for _, rr := range set.rrs {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		// Neither the name nor a wildcard that could have answered for it
+		// may exist
+		if nsecDenies(nsecs, name) || nsec3Denies(nsec3s, zone, name) {
+			return true, nil
+		}
+		return false, DNSSECBogusError{name: name, reason: "the absence of the name is not proven"}
+	}
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) && !hasType(nsec.TypeBitMap, qtype) && !hasType(nsec.TypeBitMap, dns.TypeCNAME) {
+			return true, nil
+		}
+		if nsecCovers(nsec, name) {
+			// An empty non-terminal
+			return true, nil
+		}
+	}
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) && !hasType(nsec3.TypeBitMap, qtype) && !hasType(nsec3.TypeBitMap, dns.TypeCNAME) {
+			return true, nil
+		}
+		if qtype == dns.TypeDS && nsec3.Cover(name) && nsec3.Flags&1 == 1 {
+			return false, nil
+		}
+	}
+	return false, DNSSECBogusError{name: name, reason: fmt.Sprintf("the absence of %s records is not proven", dns.TypeToString[qtype])}
+}
+
+// validate checks resp, the upstream response to q, and reports whether it
+// is secure. Bogus responses return a DNSSECBogusError.
+func (v *dnssecValidator) validate(ctx context.Context, q dns.Question, resp *dns.Msg) (bool, error) {
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return false, nil
+	}
+	secure := true
+	answer := rrsets(resp.Answer)
+	for header, set := range answer {
+		if len(set.rrs) == 0 {
+			continue
+		}
+		ok, err := v.verifySet(ctx, header, set)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && ok
+		if !ok {
+			continue
+		}
+		// A signature with fewer labels than the owner name was made for
+		// a wildcard
+		labels := dns.CountLabel(header.Name)
+		for _, sig := range set.sigs {
+			labels = min(labels, int(sig.Labels))
+		}
+		if labels < dns.CountLabel(header.Name) {
+			if err := v.verifyExpansion(ctx, header.Name, dns.CanonicalName(set.sigs[0].SignerName), labels, resp.Ns); err != nil {
+				return false, err
+			}
+		}
+	}
+	// Follow CNAMEs to the name the answer is about
+	name := dns.CanonicalName(q.Name)
+	for range answer {
+		set, found := answer[dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: q.Qclass}]
+		if !found || len(set.rrs) == 0 || q.Qtype == dns.TypeCNAME {
+			break
+		}
+		name = dns.CanonicalName(set.rrs[0].(*dns.CNAME).Target)
+	}
+	if set, found := answer[dns.RR_Header{Name: name, Rrtype: q.Qtype, Class: q.Qclass}]; !found || len(set.rrs) == 0 {
+		if q.Qtype != dns.TypeANY || resp.Rcode == dns.RcodeNameError {
+			ok, err := v.verifyDenial(ctx, name, q.Qtype, resp)
+			if err != nil {
+				return false, err
+			}
+			secure = secure && ok
+		}
+	}
+	return secure, nil
+}
@@ -0,0 +1,386 @@
+package easydns
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// signedZone signs the records of a test zone with a single key
+type signedZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ED25519,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signedZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+// signFor returns the RRset rrs followed by its signature, valid from
+// inception to expiration relative to now
+func (z *signedZone) signFor(t *testing.T, inception, expiration time.Duration, rrs ...dns.RR) []dns.RR {
+	t.Helper()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrs[0].Header().Ttl},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(time.Now().Add(inception).Unix()),
+		Expiration: uint32(time.Now().Add(expiration).Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+// sign signs rrs with a signature valid for the next day
+func (z *signedZone) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	return z.signFor(t, -time.Hour, 24*time.Hour, rrs...)
+}
+
+// ds returns the DS record of the zone's key
+func (z *signedZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+// soa returns the signed SOA record of the zone
+func (z *signedZone) soa(t *testing.T) []dns.RR {
+	return z.sign(t, mustRR(t, z.name+" 60 IN SOA ns."+z.name+" admin."+z.name+" 1 3600 600 86400 60"))
+}
+
+// nsecs returns the signed NSEC chain of a zone holding names, in
+// canonical order
+func (z *signedZone) nsecs(t *testing.T, names ...string) []dns.RR {
+	var rrs []dns.RR
+	for i, name := range names {
+		nsec := &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 60},
+			NextDomain: names[(i+1)%len(names)],
+			TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+		}
+		rrs = append(rrs, z.sign(t, nsec)...)
+	}
+	return rrs
+}
+
+// nsec3s returns the signed NSEC3 chain of a zone holding names
+func (z *signedZone) nsec3s(t *testing.T, names ...string) []dns.RR {
+	hashes := make([]string, 0, len(names))
+	for _, name := range names {
+		hashes = append(hashes, dns.HashName(name, dns.SHA1, 0, ""))
+	}
+	sort.Strings(hashes)
+	var rrs []dns.RR
+	for i, hash := range hashes {
+		nsec3 := &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(hash) + "." + z.name, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 60},
+			Hash:       dns.SHA1,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			HashLength: 20,
+			TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG},
+		}
+		rrs = append(rrs, z.sign(t, nsec3)...)
+	}
+	return rrs
+}
+
+// ownedBy returns the records of rrs owned by one of names, with NSEC3
+// owners given by the name they were hashed from
+func ownedBy(rrs []dns.RR, zone string, names ...string) []dns.RR {
+	var owned []dns.RR
+	for _, rr := range rrs {
+		for _, name := range names {
+			hashed := strings.ToLower(dns.HashName(name, dns.SHA1, 0, "")) + "." + zone
+			if owner := rr.Header().Name; owner == name || owner == hashed {
+				owned = append(owned, rr)
+			}
+		}
+	}
+	return owned
+}
+
+// dnssecUpstream answers the DS and DNSKEY queries of the chain of trust
+// of the root, the signed zone example. and the unsigned delegation
+// insecure.
+type dnssecUpstream struct {
+	root, example *signedZone
+	answers       map[dns.Question]*dns.Msg
+}
+
+func newDNSSECUpstream(t *testing.T) *dnssecUpstream {
+	u := &dnssecUpstream{
+		root:    newSignedZone(t, "."),
+		example: newSignedZone(t, "example."),
+		answers: map[dns.Question]*dns.Msg{},
+	}
+	u.add(dns.Question{Name: ".", Qtype: dns.TypeDNSKEY}, u.root.sign(t, u.root.key), nil)
+	u.add(dns.Question{Name: "example.", Qtype: dns.TypeDS}, u.root.sign(t, u.example.ds()), nil)
+	u.add(dns.Question{Name: "example.", Qtype: dns.TypeDNSKEY}, u.example.sign(t, u.example.key), nil)
+	// The delegation of insecure. has no DS records
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "insecure.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 60},
+		NextDomain: "org.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	u.add(dns.Question{Name: "insecure.", Qtype: dns.TypeDS}, nil, u.root.sign(t, nsec))
+	return u
+}
+
+func (u *dnssecUpstream) add(q dns.Question, answer, authority []dns.RR) {
+	q.Qclass = dns.ClassINET
+	resp := new(dns.Msg)
+	resp.SetQuestion(q.Name, q.Qtype)
+	resp.Response = true
+	resp.Answer = answer
+	resp.Ns = authority
+	u.answers[q] = resp
+}
+
+func (u *dnssecUpstream) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	resp, found := u.answers[dns.Question{Name: dns.CanonicalName(name), Qtype: qtype, Qclass: dns.ClassINET}]
+	if !found {
+		return nil, fmt.Errorf("unexpected query for %s %s", name, dns.TypeToString[qtype])
+	}
+	return resp.Copy(), nil
+}
+
+// handle is an upstream handler answering from u
+func (u *dnssecUpstream) handle(w dns.ResponseWriter, r *dns.Msg) {
+	resp, err := u.query(context.Background(), r.Question[0].Name, r.Question[0].Qtype)
+	if err != nil {
+		resp = new(dns.Msg)
+		resp.SetRcode(r, dns.RcodeServerFailure)
+	}
+	resp.Id = r.Id
+	w.WriteMsg(resp)
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	u := newDNSSECUpstream(t)
+	example := u.example
+	tampered := example.sign(t, mustRR(t, "www.example. 60 IN A 192.0.2.1"))
+	tampered[0].(*dns.A).A[3] = 2
+	// Expanded from *.example. for host.example.
+	expanded := example.sign(t, mustRR(t, "*.example. 60 IN A 192.0.2.9"))
+	for _, rr := range expanded {
+		rr.Header().Name = "host.example."
+	}
+	nsecs := example.nsecs(t, "example.", "a.example.", "www.example.")
+	wildcardNSECs := example.nsecs(t, "example.", "*.example.", "a.example.", "www.example.")
+	// www.example. is no zone cut
+	u.add(dns.Question{Name: "www.example.", Qtype: dns.TypeDS}, nil, ownedBy(nsecs, "example.", "www.example."))
+	nsec3s := example.nsec3s(t, "example.", "a.example.", "www.example.")
+	tests := []struct {
+		name       string
+		qname      string
+		qtype      uint16
+		rcode      int
+		answer     []dns.RR
+		authority  []dns.RR
+		wantSecure bool
+		wantBogus  bool
+	}{
+		{name: "signed answer", qname: "www.example.", answer: example.sign(t, mustRR(t, "www.example. 60 IN A 192.0.2.1")), wantSecure: true},
+		{name: "tampered answer", qname: "www.example.", answer: tampered, wantBogus: true},
+		{name: "unsigned answer in a signed zone", qname: "www.example.", answer: []dns.RR{mustRR(t, "www.example. 60 IN A 192.0.2.1")}, wantBogus: true},
+		{name: "expired signature", qname: "www.example.", answer: example.signFor(t, -2*time.Hour, -time.Hour, mustRR(t, "www.example. 60 IN A 192.0.2.1")), wantBogus: true},
+		{name: "unsigned delegation", qname: "www.insecure.", answer: []dns.RR{mustRR(t, "www.insecure. 60 IN A 192.0.2.1")}},
+		{
+			name:       "nxdomain with nsec",
+			qname:      "nosuch.example.",
+			rcode:      dns.RcodeNameError,
+			authority:  append(example.soa(t), ownedBy(nsecs, "example.", "example.", "a.example.")...),
+			wantSecure: true,
+		},
+		{
+			name:      "nxdomain with nsec without the wildcard proof",
+			qname:     "nosuch.example.",
+			rcode:     dns.RcodeNameError,
+			authority: append(example.soa(t), ownedBy(nsecs, "example.", "a.example.")...),
+			wantBogus: true,
+		},
+		{
+			name:       "nxdomain with nsec3",
+			qname:      "nosuch.example.",
+			rcode:      dns.RcodeNameError,
+			authority:  append(example.soa(t), nsec3s...),
+			wantSecure: true,
+		},
+		{
+			name:      "nxdomain with nsec3 without the wildcard proof",
+			qname:     "nosuch.example.",
+			rcode:     dns.RcodeNameError,
+			authority: append(example.soa(t), ownedBy(nsec3s, "example.", "example.")...),
+			wantBogus: true,
+		},
+		{name: "nxdomain without proof", qname: "nosuch.example.", rcode: dns.RcodeNameError, authority: example.soa(t), wantBogus: true},
+		{
+			name:       "nodata with nsec",
+			qname:      "www.example.",
+			qtype:      dns.TypeAAAA,
+			authority:  append(example.soa(t), ownedBy(nsecs, "example.", "www.example.")...),
+			wantSecure: true,
+		},
+		{
+			name:      "nodata denied by an nsec listing the type",
+			qname:     "www.example.",
+			authority: append(example.soa(t), ownedBy(nsecs, "example.", "www.example.")...),
+			wantBogus: true,
+		},
+		{
+			name:       "nodata with nsec3",
+			qname:      "www.example.",
+			qtype:      dns.TypeAAAA,
+			authority:  append(example.soa(t), ownedBy(nsec3s, "example.", "www.example.")...),
+			wantSecure: true,
+		},
+		{
+			name:       "wildcard answer with nsec",
+			qname:      "host.example.",
+			answer:     expanded,
+			authority:  ownedBy(wildcardNSECs, "example.", "a.example."),
+			wantSecure: true,
+		},
+		{
+			name:       "wildcard answer with nsec3",
+			qname:      "host.example.",
+			answer:     expanded,
+			authority:  ownedBy(nsec3s, "example.", "www.example."),
+			wantSecure: true,
+		},
+		{name: "wildcard answer without proof", qname: "host.example.", answer: expanded, wantBogus: true},
+		{
+			name:      "wildcard answer with the proof for another name",
+			qname:     "host.example.",
+			answer:    expanded,
+			authority: ownedBy(wildcardNSECs, "example.", "example."),
+			wantBogus: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchors := []*dns.DS{u.root.ds()}
+			v := newDNSSECValidator(anchors, u.query)
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dns.TypeA
+			}
+			resp := new(dns.Msg)
+			resp.SetQuestion(tt.qname, qtype)
+			resp.Rcode = tt.rcode
+			resp.Answer = tt.answer
+			resp.Ns = tt.authority
+			secure, err := v.validate(context.Background(), resp.Question[0], resp)
+			var bogus DNSSECBogusError
+			if isBogus := errors.As(err, &bogus); isBogus != tt.wantBogus {
+				t.Fatalf("got error %v, want bogus %v", err, tt.wantBogus)
+			}
+			if secure != tt.wantSecure {
+				t.Errorf("got secure %v, want %v", secure, tt.wantSecure)
+			}
+		})
+	}
+}
+
+func TestDNSSECTrustCacheIsBounded(t *testing.T) {
+	u := newDNSSECUpstream(t)
+	v := newDNSSECValidator([]*dns.DS{u.root.ds()}, u.query)
+	v.maxEntries = 10
+	for i := range 50 {
+		// Names below the unsigned delegation need no further queries
+		if _, err := v.trustFor(context.Background(), fmt.Sprintf("host%d.insecure.", i)); err != nil {
+			t.Fatal(err)
+		}
+		if entries := len(v.trust); entries > v.maxEntries {
+			t.Fatalf("got %d chain of trust results, want at most %d", entries, v.maxEntries)
+		}
+	}
+
+	// Expired results are forgotten first
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return time.Now().Add(2 * maxTrustTTL) }
+	if _, err := v.trustFor(context.Background(), "www.insecure."); err != nil {
+		t.Fatal(err)
+	}
+	// The chain walked again: the root, insecure. and the name
+	if entries := len(v.trust); entries != 3 {
+		t.Errorf("got %d chain of trust results after they expired, want 3", entries)
+	}
+}
+
+func TestDNSSECResponses(t *testing.T) {
+	u := newDNSSECUpstream(t)
+	u.add(dns.Question{Name: "www.example.", Qtype: dns.TypeA}, u.example.sign(t, mustRR(t, "www.example. 60 IN A 192.0.2.1")), nil)
+	bogus := u.example.sign(t, mustRR(t, "bogus.example. 60 IN A 192.0.2.1"))
+	bogus[0].(*dns.A).A[3] = 2
+	u.add(dns.Question{Name: "bogus.example.", Qtype: dns.TypeA}, bogus, nil)
+	upstream := startUpstream(t, u.handle)
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{
+			Enabled:      true,
+			Servers:      []string{upstream.addr},
+			DNSSEC:       true,
+			TrustAnchors: []string{u.root.ds().String()},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		qname     string
+		do        bool
+		wantRcode int
+		wantAD    bool
+		wantSigs  bool
+	}{
+		{name: "validated answer", qname: "www.example.", do: true, wantAD: true, wantSigs: true},
+		{name: "client without DO", qname: "www.example."},
+		{name: "bogus answer", qname: "bogus.example.", do: true, wantRcode: dns.RcodeServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, dns.TypeA)
+			if tt.do {
+				query.SetEdns0(dns.DefaultMsgSize, true)
+			}
+			resp := serve(s, query)
+			if resp.Rcode != tt.wantRcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if resp.AuthenticatedData != tt.wantAD {
+				t.Errorf("got AD %v, want %v", resp.AuthenticatedData, tt.wantAD)
+			}
+			sigs := false
+			for _, rr := range resp.Answer {
+				sigs = sigs || rr.Header().Rrtype == dns.TypeRRSIG
+			}
+			if sigs != tt.wantSigs {
+				t.Errorf("got signatures %v in %v, want %v", sigs, resp.Answer, tt.wantSigs)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package easydns
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var errDoHTSIG = errors.New("TSIG is not supported over DNS-over-HTTPS")
+
+const (
+	dohMediaType     = "application/dns-message"
+	dohJSONMediaType = "application/dns-json"
+)
+
+// DoHConfig enables the DNS-over-HTTPS listener (RFC 8484)
+type DoHConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Address  string `json:"address,omitempty"` // e.g. :443
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// validate checks that an enabled listener has an address and a certificate
+func (c DoHConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Address == "" || c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("address, cert_file and key_file must be set")
+	}
+	return nil
+}
+
+// dohResponseWriter captures the response of the DNS handler for an HTTP
+// request. It reports TCP addresses so responses are never truncated.
+type dohResponseWriter struct {
+	local, remote net.Addr
+	msg           *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+func (w *dohResponseWriter) Close() error        { return nil }
+func (w *dohResponseWriter) TsigStatus() error   { return errDoHTSIG }
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+func (w *dohResponseWriter) Hijack()             {}
+
+// tcpAddr turns a host:port string into a TCP address
+func tcpAddr(hostport string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", hostport)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+// readDoHQuery extracts the wire-format query from a GET or POST request
+func readDoHQuery(r *http.Request) ([]byte, error) {
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			return nil, errors.New("missing dns parameter")
+		}
+		return base64.RawURLEncoding.DecodeString(param)
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			return nil, fmt.Errorf("content type must be %s", dohMediaType)
+		}
+		return io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+	default:
+		return nil, fmt.Errorf("method %s is not allowed", r.Method)
+	}
+}
+
+// dohJSONQuery builds the query of a JSON API request such as
+// ?name=example.com&type=AAAA, as served by Google and Cloudflare
+func dohJSONQuery(params url.Values) (*dns.Msg, error) {
+	name := params.Get("name")
+	if _, ok := dns.IsDomainName(name); !ok || name == "" {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	qtype := dns.TypeA
+	if t := params.Get("type"); t != "" {
+		if n, err := strconv.ParseUint(t, 10, 16); err == nil {
+			qtype = uint16(n)
+		} else if known, found := dns.StringToType[strings.ToUpper(t)]; found {
+			qtype = known
+		} else {
+			return nil, fmt.Errorf("unknown type %q", t)
+		}
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	query.CheckingDisabled = isTrue(params.Get("cd"))
+	if isTrue(params.Get("do")) {
+		query.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	return query, nil
+}
+
+func isTrue(param string) bool {
+	return param == "1" || param == "true"
+}
+
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dohJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// dohJSONResponse is a response in the JSON API format
+type dohJSONResponse struct {
+	Status     int               `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AD         bool              `json:"AD"`
+	CD         bool              `json:"CD"`
+	Question   []dohJSONQuestion `json:"Question"`
+	Answer     []dohJSONRecord   `json:"Answer,omitempty"`
+	Authority  []dohJSONRecord   `json:"Authority,omitempty"`
+	Additional []dohJSONRecord   `json:"Additional,omitempty"`
+}
+
+func dohJSONRecords(rrs []dns.RR) []dohJSONRecord {
+	var records []dohJSONRecord
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		records = append(records, dohJSONRecord{Name: rr.Header().Name, Type: rr.Header().Rrtype, TTL: rr.Header().Ttl, Data: rdata(rr)})
+	}
+	return records
+}
+
+func newDoHJSONResponse(msg *dns.Msg) dohJSONResponse {
+	resp := dohJSONResponse{
+		Status:     msg.Rcode,
+		TC:         msg.Truncated,
+		RD:         msg.RecursionDesired,
+		RA:         msg.RecursionAvailable,
+		AD:         msg.AuthenticatedData,
+		CD:         msg.CheckingDisabled,
+		Answer:     dohJSONRecords(msg.Answer),
+		Authority:  dohJSONRecords(msg.Ns),
+		Additional: dohJSONRecords(msg.Extra),
+	}
+	for _, q := range msg.Question {
+		resp.Question = append(resp.Question, dohJSONQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	return resp
+}
+
+// handleDoH answers DNS-over-HTTPS requests with the same handler as the
+// UDP and TCP listeners. GET requests with a name parameter use the JSON
+// API instead of the wire format.
+func (s *Server) handleDoH(w http.ResponseWriter, r *http.Request) {
+	jsonAPI := r.Method == http.MethodGet && r.URL.Query().Has("name")
+	var query *dns.Msg
+	if jsonAPI {
+		var err error
+		if query, err = dohJSONQuery(r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		data, err := readDoHQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = new(dns.Msg)
+		if err := query.Unpack(data); err != nil {
+			http.Error(w, "malformed DNS message", http.StatusBadRequest)
+			return
+		}
+	}
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	rw := &dohResponseWriter{local: local, remote: tcpAddr(r.RemoteAddr)}
+	s.ServeDNS(rw, query)
+	if rw.msg == nil {
+		// The query was dropped by the fallback
+		http.Error(w, "no response", http.StatusServiceUnavailable)
+		return
+	}
+	contentType := dohMediaType
+	var body []byte
+	var err error
+	if jsonAPI {
+		contentType = dohJSONMediaType
+		body, err = json.Marshal(newDoHJSONResponse(rw.msg))
+	} else {
+		body, err = rw.msg.Pack()
+	}
+	if err != nil {
+		http.Error(w, "failed to pack response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if ttl, found := minTTL(rw.msg); found {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	w.Write(body)
+}
+
+// dohHandler routes the DoH endpoint
+func (s *Server) dohHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", s.handleDoH)
+	return mux
+}
@@ -0,0 +1,108 @@
+package easydns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoH(t *testing.T) {
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("app.test.com.", dns.TypeA)
+	wire, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(contentType string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(wire))
+		r.Header.Set("Content-Type", contentType)
+		return r
+	}
+	tests := []struct {
+		name        string
+		request     *http.Request
+		wantStatus  int
+		wantType    string
+		wantAnswers []string
+	}{
+		{name: "POST", request: post(dohMediaType), wantStatus: http.StatusOK, wantType: dohMediaType, wantAnswers: []string{"10.0.0.1"}},
+		{name: "GET", request: httptest.NewRequest(http.MethodGet, "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(wire), nil), wantStatus: http.StatusOK, wantType: dohMediaType, wantAnswers: []string{"10.0.0.1"}},
+		{name: "JSON API", request: httptest.NewRequest(http.MethodGet, "/dns-query?name=app.test.com&type=A", nil), wantStatus: http.StatusOK, wantType: dohJSONMediaType, wantAnswers: []string{"10.0.0.1"}},
+		{name: "wrong content type", request: post("text/plain"), wantStatus: http.StatusBadRequest},
+		{name: "missing dns parameter", request: httptest.NewRequest(http.MethodGet, "/dns-query", nil), wantStatus: http.StatusBadRequest},
+		{name: "malformed message", request: httptest.NewRequest(http.MethodGet, "/dns-query?dns=AAAA", nil), wantStatus: http.StatusBadRequest},
+		{name: "method not allowed", request: httptest.NewRequest(http.MethodPut, "/dns-query", nil), wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.dohHandler().ServeHTTP(rec, tt.request)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("got content type %q, want %q", got, tt.wantType)
+			}
+			var got []string
+			if tt.wantType == dohJSONMediaType {
+				var resp dohJSONResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				for _, answer := range resp.Answer {
+					got = append(got, answer.Data)
+				}
+			} else {
+				resp := new(dns.Msg)
+				if err := resp.Unpack(rec.Body.Bytes()); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Id != query.Id {
+					t.Errorf("got id %d, want %d", resp.Id, query.Id)
+				}
+				got = answerValues(resp)
+			}
+			if !reflect.DeepEqual(got, tt.wantAnswers) {
+				t.Errorf("got answers %v, want %v", got, tt.wantAnswers)
+			}
+		})
+	}
+}
+
+func TestValidateDoH(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DoHConfig
+		wantErr bool
+	}{
+		{name: "disabled", config: DoHConfig{}},
+		{name: "complete", config: DoHConfig{Enabled: true, Address: ":443", CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{name: "missing certificate", config: DoHConfig{Enabled: true, Address: ":443"}, wantErr: true},
+		{name: "missing address", config: DoHConfig{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package easydns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+var errDoQTSIG = errors.New("TSIG is not supported over DNS-over-QUIC")
+
+const (
+	defaultDoQAddress = ":853"
+	doqALPN           = "doq"
+	// Error codes of RFC 9250 4.3
+	doqNoError       quic.ApplicationErrorCode = 0x0
+	doqProtocolError quic.ApplicationErrorCode = 0x2
+)
+
+// DoQConfig enables the DNS-over-QUIC listener (RFC 9250). It uses the
+// certificate of the DoT listener unless it has one of its own.
+type DoQConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Address  string `json:"address,omitempty"` // UDP, defaults to :853
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// certificate returns the certificate and key files of the listener
+func (c DoQConfig) certificate(dot DoTConfig) (certFile, keyFile string) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		return dot.CertFile, dot.KeyFile
+	}
+	return c.CertFile, c.KeyFile
+}
+
+// validate checks that an enabled listener has a certificate
+func (c DoQConfig) validate(dot DoTConfig) error {
+	if certFile, keyFile := c.certificate(dot); c.Enabled && (certFile == "" || keyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set, here or in dot")
+	}
+	return nil
+}
+
+// doqServer answers DoQ queries with handler, one query per stream
+type doqServer struct {
+	address     string
+	tlsConfig   *tls.Config
+	idleTimeout time.Duration
+	handler     dns.Handler
+
+	transport *quic.Transport
+	listener  *quic.Listener
+	mu        sync.Mutex
+	conns     map[quic.Connection]struct{}
+	closing   bool           // No more queries are answered once set
+	streams   sync.WaitGroup // Queries being answered
+}
+
+// newDoQServer loads the certificate and sets up a DoQ server sharing
+// handler with the other listeners. Idle connections are closed after
+// idleTimeout, like on the TCP listeners.
+func newDoQServer(cfg DoQConfig, dot DoTConfig, idleTimeout time.Duration, handler dns.Handler) (*doqServer, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.certificate(dot))
+	if err != nil {
+		return nil, err
+	}
+	address := cfg.Address
+	if address == "" {
+		address = defaultDoQAddress
+	}
+	return &doqServer{
+		address: address,
+		// QUIC always runs over TLS 1.3
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13, NextProtos: []string{doqALPN}},
+		idleTimeout: idleTimeout,
+		handler:     handler,
+		conns:       map[quic.Connection]struct{}{},
+	}, nil
+}
+
+// start listens on the address of the server and accepts connections in
+// the background
+func (s *doqServer) start() error {
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return err
+	}
+	// Connections of a listener of a transport stay open when it is
+	// closed, so they can be drained
+	transport := &quic.Transport{Conn: conn}
+	listener, err := transport.Listen(s.tlsConfig, &quic.Config{MaxIdleTimeout: s.idleTimeout})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.transport, s.listener = transport, listener
+	go s.serve()
+	return nil
+}
+
+// addr returns the address the server listens on
+func (s *doqServer) addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *doqServer) serve() {
+	for {
+		conn, err := s.listener.Accept(context.Background())
+		if err != nil {
+			// The listener is closed
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers the queries of conn until it is closed
+func (s *doqServer) serveConn(conn quic.Connection) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			stream.CancelRead(quic.StreamErrorCode(doqNoError))
+			return
+		}
+		s.streams.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.streams.Done()
+			s.serveStream(conn, stream)
+		}()
+	}
+}
+
+// serveStream answers the query sent on stream, prefixed with its length
+// as over TCP (RFC 9250 4.2)
+func (s *doqServer) serveStream(conn quic.Connection, stream quic.Stream) {
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqProtocolError))
+		stream.Close()
+		return
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(stream, data); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqProtocolError))
+		stream.Close()
+		return
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(data); err != nil || query.Id != 0 {
+		// Queries must have ID 0, RFC 9250 4.2.1
+		conn.CloseWithError(doqProtocolError, "malformed query")
+		return
+	}
+	w := &doqResponseWriter{stream: stream, local: conn.LocalAddr(), remote: streamAddr(conn.RemoteAddr())}
+	s.handler.ServeDNS(w, query)
+	// Closes the stream without a response if the query was dropped
+	stream.Close()
+}
+
+// shutdown stops accepting connections and waits for the queries being
+// answered until ctx is done, then closes the open connections
+func (s *doqServer) shutdown(ctx context.Context) error {
+	if s.listener == nil {
+		// Never started
+		return nil
+	}
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.conns) > 0 {
+		log.Printf("DNS-over-QUIC server: closing %d open connections", len(s.conns))
+	}
+	for conn := range s.conns {
+		conn.CloseWithError(doqNoError, "")
+	}
+	return errors.Join(err, s.transport.Close(), s.transport.Conn.Close())
+}
+
+// streamAddr reports the UDP address of a QUIC connection as a TCP address,
+// so responses are never truncated and not rate limited like UDP ones
+func streamAddr(addr net.Addr) net.Addr {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return &net.TCPAddr{IP: udp.IP, Port: udp.Port, Zone: udp.Zone}
+	}
+	return addr
+}
+
+// doqResponseWriter writes the response of the DNS handler to the stream
+// of the query
+type doqResponseWriter struct {
+	stream        quic.Stream
+	local, remote net.Addr
+}
+
+func (w *doqResponseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *doqResponseWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *doqResponseWriter) WriteMsg(m *dns.Msg) error {
+	// Responses have ID 0 like the queries
+	m.Id = 0
+	data, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+func (w *doqResponseWriter) Write(b []byte) (int, error) {
+	if len(b) > dns.MaxMsgSize {
+		return 0, fmt.Errorf("response of %d bytes is too large", len(b))
+	}
+	data := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(b)), uint16(len(b)))
+	if _, err := w.stream.Write(append(data, b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+func (w *doqResponseWriter) Close() error        { return w.stream.Close() }
+func (w *doqResponseWriter) TsigStatus() error   { return errDoQTSIG }
+func (w *doqResponseWriter) TsigTimersOnly(bool) {}
+func (w *doqResponseWriter) Hijack()             {}
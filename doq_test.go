@@ -0,0 +1,247 @@
+package easydns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// startDoQServer starts a DoQ server for s on a free port, shut down when
+// the test ends
+func startDoQServer(t *testing.T, s *Server, cfg DoQConfig, dot DoTConfig) *doqServer {
+	t.Helper()
+	cfg.Address = "127.0.0.1:0"
+	server, err := newDoQServer(cfg, dot, defaultTCPIdleTimeout, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.shutdown(context.Background()) })
+	return server
+}
+
+// dialDoQ connects to a DoQ server trusting pool
+func dialDoQ(t *testing.T, server *doqServer, pool *x509.CertPool) quic.Connection {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, server.addr().String(), &tls.Config{RootCAs: pool, NextProtos: []string{doqALPN}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseWithError(doqNoError, "") })
+	return conn
+}
+
+// exchangeDoQ sends query on a new stream of conn and returns the response
+func exchangeDoQ(conn quic.Connection, query *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetDeadline(time.Now().Add(2 * time.Second))
+	data, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(binary.BigEndian.AppendUint16(nil, uint16(len(data)))); err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(data); err != nil {
+		return nil, err
+	}
+	// The query is complete, RFC 9250 4.2
+	stream.Close()
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	response := make([]byte, length)
+	if _, err := io.ReadFull(stream, response); err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	return resp, resp.Unpack(response)
+}
+
+func TestDoQ(t *testing.T) {
+	certFile, keyFile, pool := writeCertificate(t)
+	records := Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}}
+	for i := range 100 {
+		records["big.test.com"] = append(records["big.test.com"], Record{Type: "A", Value: fmt.Sprintf("10.1.0.%d", i), TTL: 60})
+	}
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: records,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		doq  DoQConfig
+		dot  DoTConfig
+	}{
+		{name: "own certificate", doq: DoQConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}},
+		{name: "certificate of dot", doq: DoQConfig{Enabled: true}, dot: DoTConfig{CertFile: certFile, KeyFile: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialDoQ(t, startDoQServer(t, s, tt.doq, tt.dot), pool)
+			// Queries share the connection, one per stream
+			for range 2 {
+				query := new(dns.Msg)
+				query.SetQuestion("app.test.com.", dns.TypeA)
+				query.Id = 0
+				resp, err := exchangeDoQ(conn, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.Id != 0 {
+					t.Errorf("got response ID %d, want 0", resp.Id)
+				}
+				if got, want := answerValues(resp), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("large responses are not truncated", func(t *testing.T) {
+		conn := dialDoQ(t, startDoQServer(t, s, DoQConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, DoTConfig{}), pool)
+		query := new(dns.Msg)
+		query.SetQuestion("big.test.com.", dns.TypeA)
+		query.Id = 0
+		resp, err := exchangeDoQ(conn, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Truncated || len(resp.Answer) != 100 {
+			t.Errorf("got %d answers, truncated %v, want all 100", len(resp.Answer), resp.Truncated)
+		}
+	})
+
+	t.Run("query with an ID closes the connection", func(t *testing.T) {
+		conn := dialDoQ(t, startDoQServer(t, s, DoQConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, DoTConfig{}), pool)
+		query := new(dns.Msg)
+		query.SetQuestion("app.test.com.", dns.TypeA)
+		query.Id = 1234
+		if _, err := exchangeDoQ(conn, query); err == nil {
+			t.Fatal("got a response to a query with an ID")
+		}
+		<-conn.Context().Done()
+		var appErr *quic.ApplicationError
+		if err := context.Cause(conn.Context()); !errors.As(err, &appErr) || appErr.ErrorCode != doqProtocolError {
+			t.Errorf("connection closed with %v, want DOQ_PROTOCOL_ERROR", err)
+		}
+	})
+}
+
+func TestDoQListener(t *testing.T) {
+	certFile, keyFile, pool := writeCertificate(t)
+	// Free ports for the DNS listeners and the DoQ listener, held open
+	// together so they differ
+	var ports []string
+	var held []net.PacketConn
+	for range 2 {
+		packets, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, packets)
+		ports = append(ports, strconv.Itoa(packets.LocalAddr().(*net.UDPAddr).Port))
+	}
+	for _, packets := range held {
+		packets.Close()
+	}
+	address := net.JoinHostPort("127.0.0.1", ports[1])
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{BindAddress: "127.0.0.1", Port: ports[0]},
+		DoT:     DoTConfig{CertFile: certFile, KeyFile: keyFile},
+		DoQ:     DoQConfig{Enabled: true, Address: address},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	var conn quic.Connection
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		conn, err = quic.DialAddr(ctx, address, &tls.Config{RootCAs: pool, NextProtos: []string{doqALPN}}, nil)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("app.test.com.", dns.TypeA)
+	query.Id = 0
+	resp, err := exchangeDoQ(conn, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := answerValues(resp), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ListenAndServe returned %v", err)
+	}
+	// Open connections are closed on shutdown
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(2 * time.Second):
+		t.Error("the connection is still open after the shutdown")
+	}
+}
+
+func TestValidateDoQ(t *testing.T) {
+	tests := []struct {
+		name    string
+		doq     DoQConfig
+		dot     DoTConfig
+		wantErr bool
+	}{
+		{name: "disabled", doq: DoQConfig{}},
+		{name: "own certificate", doq: DoQConfig{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{name: "certificate of dot", doq: DoQConfig{Enabled: true}, dot: DoTConfig{CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{name: "missing certificate", doq: DoQConfig{Enabled: true}, wantErr: true},
+		{name: "missing key", doq: DoQConfig{Enabled: true, CertFile: "cert.pem"}, dot: DoTConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.doq.validate(tt.dot); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package easydns
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const defaultDoTAddress = ":853"
+
+// DoTConfig enables the DNS-over-TLS listener (RFC 7858)
+type DoTConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Address  string `json:"address,omitempty"` // Defaults to :853
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// MinTLSVersion is "1.2" (default) or "1.3"
+	MinTLSVersion string `json:"min_tls_version,omitempty"`
+}
+
+// validate checks that an enabled listener has a certificate
+func (c DoTConfig) validate() error {
+	if c.Enabled && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set")
+	}
+	if _, err := tlsVersion(c.MinTLSVersion); err != nil {
+		return err
+	}
+	return nil
+}
+
+// tlsVersion parses a min_tls_version setting, defaulting to TLS 1.2
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported min_tls_version %q, expected 1.2 or 1.3", version)
+	}
+}
+
+// newDoTServer loads the certificate and sets up a tcp-tls server sharing
+// handler with the other listeners. Idle connections are closed after
+// idleTimeout, like on the TCP listeners.
+func newDoTServer(cfg DoTConfig, idleTimeout time.Duration, tsigSecret map[string]string, handler dns.Handler) (*dns.Server, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	minVersion, err := tlsVersion(cfg.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	address := cfg.Address
+	if address == "" {
+		address = defaultDoTAddress
+	}
+	return &dns.Server{
+		Addr:          address,
+		Net:           "tcp-tls",
+		TLSConfig:     &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: minVersion},
+		Handler:       handler,
+		IdleTimeout:   func() time.Duration { return idleTimeout },
+		TsigSecret:    tsigSecret,
+		MsgAcceptFunc: acceptMsg,
+	}, nil
+}
@@ -0,0 +1,141 @@
+package easydns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key to the test directory and returns the pool trusting it
+func writeCertificate(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "easydns test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestDoT(t *testing.T) {
+	certFile, keyFile, pool := writeCertificate(t)
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		minTLSVersion string
+		clientMax     uint16
+		wantErr       bool
+	}{
+		{name: "TLS 1.3 client", clientMax: tls.VersionTLS13},
+		{name: "TLS 1.2 client", clientMax: tls.VersionTLS12},
+		{name: "TLS 1.1 client", clientMax: tls.VersionTLS11, wantErr: true},
+		{name: "TLS 1.2 client against 1.3 minimum", minTLSVersion: "1.3", clientMax: tls.VersionTLS12, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dot := DoTConfig{Enabled: true, Address: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile, MinTLSVersion: tt.minTLSVersion}
+			server, err := newDoTServer(dot, defaultTCPIdleTimeout, nil, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns := newConnTracker()
+			if err := startServer(server, conns); err != nil {
+				t.Fatal(err)
+			}
+			defer conns.drain("DNS-over-TLS server", func() error { return server.ShutdownContext(context.Background()) })
+
+			client := &dns.Client{
+				Net:       "tcp-tls",
+				TLSConfig: &tls.Config{RootCAs: pool, MaxVersion: tt.clientMax},
+				Timeout:   2 * time.Second,
+			}
+			query := new(dns.Msg)
+			query.SetQuestion("app.test.com.", dns.TypeA)
+			resp, _, err := client.Exchange(query, server.Listener.Addr().String())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := answerValues(resp), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateDoT(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DoTConfig
+		wantErr bool
+	}{
+		{name: "disabled", config: DoTConfig{}},
+		{name: "complete", config: DoTConfig{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{name: "missing certificate", config: DoTConfig{Enabled: true}, wantErr: true},
+		{name: "TLS 1.3 minimum", config: DoTConfig{MinTLSVersion: "1.3"}},
+		{name: "unsupported TLS version", config: DoTConfig{MinTLSVersion: "1.1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
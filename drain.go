@@ -0,0 +1,136 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownTimeout returns how long in-flight queries are drained on shutdown
+func (c ServerConfig) shutdownTimeout() (time.Duration, error) {
+	if c.ShutdownTimeout == "" {
+		return defaultShutdownTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid shutdown_timeout %q", c.ShutdownTimeout)
+	}
+	return timeout, nil
+}
+
+// queryTracker counts the queries being answered, so shutdown can tell how
+// many in-flight queries were drained and how many were cut off by the
+// grace period
+type queryTracker struct {
+	active atomic.Int64
+}
+
+// track returns next with the queries it answers counted
+func (t *queryTracker) track(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		t.active.Add(1)
+		defer t.active.Add(-1)
+		next.ServeDNS(w, r)
+	})
+}
+
+// count returns the number of queries being answered
+func (t *queryTracker) count() int {
+	return int(t.active.Load())
+}
+
+// drain calls shutdown, which stops accepting queries and returns once the
+// queries in flight are answered or the grace period is over, then logs how
+// many were drained and how many were still in flight
+func (t *queryTracker) drain(shutdown func() error) error {
+	open := t.count()
+	err := shutdown()
+	left := t.count()
+	log.Printf("drained %d queries in flight, cut off %d", max(open-left, 0), left)
+	return err
+}
+
+// connTracker keeps the open connections of TCP listeners, so shutdown can
+// force-close the ones still open after the grace period and tell how many
+// were drained
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: map[net.Conn]struct{}{}}
+}
+
+// track returns listener with the connections it accepts tracked
+func (t *connTracker) track(listener net.Listener) net.Listener {
+	return &trackedListener{Listener: listener, tracker: t}
+}
+
+// count returns the number of open connections
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// closeAll closes the open connections and returns how many there were
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	conns := make([]net.Conn, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// drain calls shutdown, which stops accepting connections and returns once
+// the open ones are done or the grace period is over, then force-closes the
+// connections still open and logs how many were drained and force-closed
+func (t *connTracker) drain(name string, shutdown func() error) error {
+	open := t.count()
+	err := shutdown()
+	forced := t.closeAll()
+	log.Printf("%s: drained %d connections, force-closed %d", name, max(open-forced, 0), forced)
+	return err
+}
+
+type trackedListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tracked := &trackedConn{Conn: conn, tracker: l.tracker}
+	l.tracker.mu.Lock()
+	l.tracker.conns[tracked] = struct{}{}
+	l.tracker.mu.Unlock()
+	return tracked, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+}
+
+func (c *trackedConn) Close() error {
+	c.tracker.mu.Lock()
+	delete(c.tracker.conns, c)
+	c.tracker.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,142 @@
+package easydns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestListenersDrainQueries(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration // Time the handler takes to answer
+		grace      time.Duration
+		wantAnswer bool
+	}{
+		{name: "query in flight is drained", delay: 50 * time.Millisecond, grace: 2 * time.Second, wantAnswer: true},
+		{name: "query over the grace period is cut off", delay: time.Second, grace: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handling := make(chan struct{})
+			queries := &queryTracker{}
+			handler := queries.track(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+				close(handling)
+				time.Sleep(tt.delay)
+				resp := new(dns.Msg)
+				resp.SetReply(r)
+				w.WriteMsg(resp)
+			}))
+			l := newListeners("0", []string{"udp"}, 0, defaultTCPIdleTimeout, nil, handler)
+			if err := l.update([]string{"127.0.0.1"}); err != nil {
+				t.Fatal(err)
+			}
+			var addr string
+			for _, server := range l.servers {
+				addr = server.PacketConn.LocalAddr().String()
+			}
+			answered := make(chan error, 1)
+			go func() {
+				query := new(dns.Msg)
+				query.SetQuestion("app.test.com.", dns.TypeA)
+				client := &dns.Client{Timeout: 2 * time.Second}
+				_, _, err := client.Exchange(query, addr)
+				answered <- err
+			}()
+			<-handling
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.grace)
+			defer cancel()
+			queries.drain(func() error { return l.close(ctx) })
+			if left, want := queries.count(), map[bool]int{true: 0, false: 1}[tt.wantAnswer]; left != want {
+				t.Errorf("%d queries are still in flight after the shutdown, want %d", left, want)
+			}
+			err := <-answered
+			if gotAnswer := err == nil; gotAnswer != tt.wantAnswer {
+				t.Errorf("got answer %v (%v), want answer %v", gotAnswer, err, tt.wantAnswer)
+			}
+			if len(l.servers) != 0 {
+				t.Errorf("%d servers are still running", len(l.servers))
+			}
+		})
+	}
+}
+
+func TestListenersForceCloseTCPConnections(t *testing.T) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		w.WriteMsg(resp)
+	})
+	l := newListeners("0", []string{"tcp"}, 0, time.Minute, nil, handler)
+	if err := l.update([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	var addr string
+	for _, server := range l.servers {
+		addr = server.Listener.Addr().String()
+	}
+	conn, err := dns.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	query := new(dns.Msg)
+	query.SetQuestion("app.test.com.", dns.TypeA)
+	if err := conn.WriteMsg(query); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ReadMsg(); err != nil {
+		t.Fatal(err)
+	}
+	// The connection stays open well past the grace period
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	l.close(ctx)
+	if open := l.conns.count(); open != 0 {
+		t.Errorf("%d connections are still open after the shutdown", open)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.ReadMsg(); err == nil {
+		t.Error("connection is still usable after the shutdown")
+	}
+}
+
+func TestMaxConcurrentQueries(t *testing.T) {
+	forwarded := make(chan struct{}, 1)
+	release := make(chan struct{})
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		forwarded <- struct{}{}
+		<-release
+		answerA("192.0.2.1")(w, r)
+	})
+	s, err := New(&Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53", MaxConcurrentQueries: 1},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inFlight := make(chan *dns.Msg, 1)
+	go func() {
+		query := new(dns.Msg)
+		query.SetQuestion("slow.example.com.", dns.TypeA)
+		inFlight <- serve(s, query)
+	}()
+	<-forwarded
+
+	if resp := ask(t, s, "other.example.com", dns.TypeA); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("got rcode %s over the limit, want SERVFAIL", dns.RcodeToString[resp.Rcode])
+	}
+	close(release)
+	if resp := <-inFlight; resp == nil || resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("got %v for the query in flight, want an answer", resp)
+	}
+	// The slot is free again
+	if got := answerValues(ask(t, s, "other.example.com", dns.TypeA)); len(got) != 1 {
+		t.Errorf("got answers %v once the query in flight is done, want one", got)
+	}
+}
@@ -1,71 +1,352 @@
-package main
+package easydns
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var config *Config
-var configPath string
-var defaultConfigPath = "~/.easydns/config.json"
-
 type Record struct {
 	Type     string `json:"type"`
 	Value    string `json:"value"`
 	Priority int    `json:"priority,omitempty"` // For MX and SRV records
 	TTL      uint32 `json:"ttl,omitempty"`      // TTL for the record
+	// Weight is the weight of SRV records and makes records come first more
+	// often with the weighted round_robin_mode, records without one weigh 1
+	Weight int `json:"weight,omitempty"`
+	Port   int `json:"port,omitempty"` // Port of SRV records
+	// HealthCheck serves the record only while its target is healthy
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	// Schedule holds time-based values that replace Value while active
+	Schedule []ScheduleEntry `json:"schedule,omitempty"`
+	// Subnets limit the record to clients in these networks. Records of
+	// a name without subnets answer the clients no subnet matches.
+	Subnets []string `json:"subnets,omitempty"`
+
+	subnets []*net.IPNet // Subnets, parsed by normalizeRecords
+}
+
+// Records maps names to their records. A name can hold several records,
+// e.g. an A and an MX record or one A record per address.
+type Records map[string][]Record
+
+// UnmarshalJSON accepts a single record object per name as well as a list
+// of records, so configs written before names could hold several records
+// keep working
+func (r *Records) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	records := make(Records, len(raw))
+	for name, value := range raw {
+		set, err := decodeRecordSet(value)
+		if err != nil {
+			return fmt.Errorf("record %s: %v", name, err)
+		}
+		records[name] = set
+	}
+	*r = records
+	return nil
+}
+
+// decodeRecordSet decodes the records of one name, given as a single
+// record object or a list of records
+func decodeRecordSet(data []byte) ([]Record, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		return []Record{record}, nil
+	}
+	var set []Record
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	return set, nil
 }
 
-type Records map[string]Record
+// answering returns the records of set that belong in the answer to a
+// query of type qtype
+func answering(set []Record, qtype uint16) []Record {
+	var matching []Record
+	for _, record := range set {
+		if record.answers(qtype) {
+			matching = append(matching, record)
+		}
+	}
+	return matching
+}
 
 // Config holds the DNS server configuration
 
 type ForwardingConfig struct {
 	Enabled bool     `json:"enabled"`
 	Servers []string `json:"servers"`
+	// TopLevelQueries controls how queries for the root and bare TLDs
+	// without a local record are handled: "refuse" (default) or "forward"
+	TopLevelQueries string `json:"top_level_queries,omitempty"`
+	// AllowedTypes restricts forwarding to the listed query types, all
+	// types are forwarded when empty
+	AllowedTypes []string `json:"allowed_types,omitempty"`
+	// EasyDNSServers lists the servers that are easydns instances too. Only
+	// queries forwarded to them carry the loop detection option.
+	EasyDNSServers []string `json:"easydns_servers,omitempty"`
+	// CaseRandomization enables 0x20 encoding of forwarded query names
+	CaseRandomization bool `json:"case_randomization,omitempty"`
+	// NegativeMinTTL is the lowest negative caching TTL passed on for
+	// forwarded NXDOMAIN and NODATA answers
+	NegativeMinTTL uint32 `json:"negative_min_ttl,omitempty"`
+	// Strategy is "sequential" (default) to try the servers in order,
+	// "round-robin" to start with the next server on every query, "fastest"
+	// to try the servers with the lowest response time first or "parallel"
+	// to query all of them at once and use the first answer
+	Strategy string `json:"strategy,omitempty"`
+	// ParallelServers limits the parallel strategy to racing the given
+	// number of fastest servers, the next ones are raced if all of them fail
+	ParallelServers int `json:"parallel_servers,omitempty"`
+	// FailureThreshold is how many failures in a row take a server out of
+	// rotation for a growing backoff, defaults to 3
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	// HealthCheckInterval enables probing the servers in the background,
+	// so servers that are down are put back as soon as they recover
+	HealthCheckInterval string `json:"health_check_interval,omitempty"`
+	// Timeout limits each upstream exchange, defaults to 2s
+	Timeout string `json:"timeout,omitempty"`
+	// ConditionalForwarding maps zones to the servers queries for names in
+	// them are forwarded to instead of Servers; the longest zone wins
+	ConditionalForwarding map[string][]string `json:"conditional_forwarding,omitempty"`
+	// Transport is "udp" (default), "tcp" or "tcp-tls" for DNS-over-TLS
+	// upstreams. Truncated UDP responses are always retried over TCP.
+	Transport string `json:"transport,omitempty"`
+	// Retries is how often a server is retried after a failed exchange
+	// before moving on
+	Retries int `json:"retries,omitempty"`
+	// Upstreams overrides the timeout and retries of single servers, keyed
+	// by their address as in Servers
+	Upstreams map[string]UpstreamSettings `json:"upstreams,omitempty"`
+	// DNSSEC validates forwarded answers against the chain of trust from
+	// the root: bogus answers are answered with SERVFAIL and validated ones
+	// get the AD bit. The upstream servers have to pass DNSSEC records on.
+	DNSSEC bool `json:"dnssec,omitempty"`
+	// TrustAnchors are DS records of the root zone in zone file notation,
+	// the root zone's current key signing keys when empty
+	TrustAnchors []string `json:"trust_anchors,omitempty"`
 }
+
+// allowsType reports whether queries of type qtype may be forwarded
+func (f ForwardingConfig) allowsType(qtype uint16) bool {
+	if len(f.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range f.AllowedTypes {
+		if strings.EqualFold(allowed, dns.TypeToString[qtype]) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpstreamSettings are the settings of one upstream server that differ from
+// those of the forwarding section
+type UpstreamSettings struct {
+	Timeout string `json:"timeout,omitempty"`
+	// Retries replaces the forwarding retries when set, also with 0
+	Retries *int `json:"retries,omitempty"`
+}
+
 type ServerConfig struct {
 	BindAddress string `json:"bind_address"`
 	Port        string `json:"port"`
+	// RoundRobinMode controls the order of answers: "" keeps the order
+	// as resolved, "sticky" shuffles it per client IP, "round-robin"
+	// rotates it with every query, "random" shuffles it and "weighted"
+	// shuffles it by the weight of the records
+	RoundRobinMode string `json:"round_robin_mode,omitempty"`
+	// ShutdownTimeout is how long in-flight queries are drained on SIGTERM
+	// or SIGINT before exiting, defaults to 10s
+	ShutdownTimeout string `json:"shutdown_timeout,omitempty"`
+	// TCPIdleTimeout closes idle TCP connections, defaults to 8s
+	TCPIdleTimeout string `json:"tcp_idle_timeout,omitempty"`
+	// SelfPTR, when set, answers reverse lookups of the listen addresses
+	// with this name unless a record for them is configured
+	SelfPTR string `json:"self_ptr,omitempty"`
+	// BindRetry is how long to keep retrying to bind a busy address, e.g. "30s"
+	BindRetry string `json:"bind_retry,omitempty"`
+	// Protocols to listen on, "udp" and "tcp" when empty
+	Protocols []string `json:"protocols,omitempty"`
+	// EDNSUDPSize is the UDP payload size advertised to EDNS0 clients and
+	// the largest UDP response sent to them, defaults to 1232
+	EDNSUDPSize int `json:"edns_udp_size,omitempty"`
+	// MaxConcurrentQueries caps the queries resolved at the same time,
+	// further queries are answered with SERVFAIL. Unlimited when 0.
+	MaxConcurrentQueries int `json:"max_concurrent_queries,omitempty"`
+	// AnyQueries controls the answers to ANY queries: "all" (default)
+	// returns all local records of the name and forwards the query,
+	// "hinfo" answers with a single HINFO record as in RFC 8482
+	AnyQueries string `json:"any_queries,omitempty"`
+	// MinimalResponses leaves the authority and additional sections out of
+	// positive answers to keep responses small
+	MinimalResponses bool `json:"minimal_responses,omitempty"`
+}
+type APIConfig struct {
+	Enabled bool   `json:"enabled"`
+	Address string `json:"address"`
+	// Token, when set, must be sent as a bearer token with every request
+	Token string `json:"token,omitempty"`
+	// PersistPath is the config file records changed through the API are
+	// written back to. Changes are kept in memory only when empty.
+	PersistPath string `json:"persist_path,omitempty"`
+	// Dashboard serves a web UI with the live query log, statistics and a
+	// record editor at /dashboard/
+	Dashboard bool `json:"dashboard,omitempty"`
+}
+type TracingConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Endpoint    string `json:"endpoint"` // OTLP/HTTP collector, e.g. localhost:4318
+	Insecure    bool   `json:"insecure"`
+	ServiceName string `json:"service_name,omitempty"`
 }
 type Config struct {
+	Version    int              `json:"version"`
 	Forwarding ForwardingConfig `json:"forwarding"`
 	Server     ServerConfig     `json:"server"`
+	API        APIConfig        `json:"api"`
+	Tracing    TracingConfig    `json:"tracing"`
 	Records    Records          `json:"records"`
+	RecordsDir RecordsDirConfig `json:"records_dir"`
+	// ZoneFiles are RFC 1035 zone files whose records are served along
+	// with Records
+	ZoneFiles  []string          `json:"zone_files,omitempty"`
+	Transforms []TransformConfig `json:"transforms,omitempty"`
+	// HoldDown maps zones to how long changed records of their names have
+	// to stay the same before they are served, e.g. "30s"
+	HoldDown map[string]string `json:"hold_down,omitempty"`
+	// InfoTXT maps zones to an informational TXT added to the additional
+	// section of every answer for names in that zone
+	InfoTXT map[string]string `json:"info_txt,omitempty"`
+	Debug   DebugConfig       `json:"debug"`
+	// Fallback answers queries without a record when forwarding is disabled
+	Fallback FallbackConfig `json:"fallback"`
+	Cache    CacheConfig    `json:"cache"`
+	Stats    StatsConfig    `json:"stats"`
+	Metrics  MetricsConfig  `json:"metrics"`
+	Logging  LoggingConfig  `json:"logging"`
+	DoH      DoHConfig      `json:"doh"`
+	DoT      DoTConfig      `json:"dot"`
+	DoQ      DoQConfig      `json:"doq"`
+	// Blocklist names are answered with a sinkhole response instead of
+	// being forwarded; local records still take precedence
+	Blocklist BlocklistConfig `json:"blocklist"`
+	// AutoPTR serves PTR records for the addresses of all A and AAAA
+	// records unless a PTR record for the address is configured
+	AutoPTR bool `json:"auto_ptr,omitempty"`
+	// TTL sets a default for local records and bounds the TTLs of all answers
+	TTL TTLConfig `json:"ttl"`
+	// ACL restricts which clients may query and which may have their
+	// queries forwarded
+	ACL ACLConfig `json:"acl"`
+	// AuthoritativeZones are answered from local records only, with the
+	// AA flag and the zone's SOA record on negative answers
+	AuthoritativeZones []string `json:"authoritative_zones,omitempty"`
+	// Zones are authoritative zones whose SOA and NS records are generated,
+	// keyed by zone name
+	Zones map[string]ZoneConfig `json:"zones,omitempty"`
+	// Update accepts TSIG signed dynamic updates (RFC 2136)
+	Update UpdateConfig `json:"update"`
+	// RateLimit limits queries and identical responses per client
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	// Transfer serves zones to secondary servers and pulls secondary zones
+	// from their primaries
+	Transfer TransferConfig `json:"transfer"`
+	// Signing holds the keys of the zones signed with DNSSEC
+	Signing SigningConfig `json:"signing"`
+	// Storage selects where Records are kept, in memory or in a database
+	// shared between instances
+	Storage StorageConfig `json:"storage"`
+	// Views serve their own records to clients in their networks, the
+	// most specific matching view wins
+	Views []ViewConfig `json:"views,omitempty"`
+	// HostsFiles are /etc/hosts style files whose addresses are served
+	// beneath the records of the config, zone files and records directory
+	HostsFiles []string `json:"hosts_files,omitempty"`
+	// Policies decide per client, name, type and time of day whether
+	// queries are answered, blocked, rewritten or forwarded elsewhere. The
+	// first matching rule applies.
+	Policies []PolicyConfig `json:"policies,omitempty"`
+	// DNS64 answers AAAA queries for IPv4-only names with addresses of a
+	// NAT64 gateway
+	DNS64 DNS64Config `json:"dns64"`
+	// Rewrites look up query names as other names and replace the
+	// addresses of answers
+	Rewrites []RewriteConfig `json:"rewrites,omitempty"`
+	// MDNS answers multicast DNS queries for the records of .local names
+	MDNS MDNSConfig `json:"mdns"`
+	// Control is the unix socket easydns ctl talks to
+	Control ControlConfig `json:"control"`
+	// Discovery publishes the names of Docker containers and Kubernetes
+	// services and ingresses
+	Discovery DiscoveryConfig `json:"discovery"`
+	// ECS reads and forwards the EDNS Client Subnet option
+	ECS ECSConfig `json:"ecs"`
+	// TSIG holds the keys of signed queries and where they are required
+	TSIG TSIGConfig `json:"tsig"`
 }
 
 var DefaultConfig = Config{
+	Version: currentConfigVersion,
 	Forwarding: ForwardingConfig{
-		Enabled: true,
-		Servers: []string{"8.8.8.8:53", "8.8.4.4:53"},
+		Enabled:         true,
+		Servers:         []string{"8.8.8.8:53", "8.8.4.4:53"},
+		TopLevelQueries: "refuse",
 	},
 	Server: ServerConfig{
 		BindAddress: "",
 		Port:        "53",
 	},
+	API: APIConfig{
+		Enabled: false,
+		Address: "127.0.0.1:8053",
+	},
+	Tracing: TracingConfig{
+		Enabled:  false,
+		Endpoint: "localhost:4318",
+		Insecure: true,
+	},
+	Cache: CacheConfig{
+		Enabled:    false,
+		MaxEntries: 10000,
+	},
 	Records: Records{
-		"test.com": {
+		"test.com": {{
 			Type:  "A",
 			Value: "127.0.0.1",
 			TTL:   600,
-		},
-		"www.test.com": {
+		}},
+		"www.test.com": {{
 			Type:  "CNAME",
 			Value: "test.com",
 			TTL:   600,
-		},
-		"mail.test.com": {
+		}},
+		"mail.test.com": {{
 			Type:     "MX",
 			Value:    "mail.somehost.com",
 			Priority: 10,
 			TTL:      60,
-		},
+		}},
 	},
 }
 
@@ -74,6 +355,15 @@ type ConfigNotFoundError struct {
 }
 type ConfigMalformedError struct {
 	originalError error
+	problems      []string
+}
+type UnsupportedRecordTypeError struct {
+	recordType string
+}
+type UpstreamError struct {
+	servers       int
+	attempts      int
+	originalError error
 }
 
 func (e ConfigNotFoundError) Error() string {
@@ -84,157 +374,683 @@ func (e ConfigMalformedError) Error() string {
 	return fmt.Sprintf("config file is malformed: %v", e.originalError)
 }
 
+// Problems returns every problem found, e.g. one per unknown key
+func (e ConfigMalformedError) Problems() []string {
+	if len(e.problems) > 0 {
+		return e.problems
+	}
+	return []string{e.originalError.Error()}
+}
+
+func (e UnsupportedRecordTypeError) Error() string {
+	return fmt.Sprintf("unsupported record type: %s", e.recordType)
+}
+
+func (e UpstreamError) Error() string {
+	return fmt.Sprintf("failed to get response from %d upstream servers after %d attempts each: %v", e.servers, e.attempts, e.originalError)
+}
+
 // LoadConfig reads and parses the JSON configuration file
 func LoadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, ConfigNotFoundError{originalError: err}
 	}
+	// Lines can only be told for JSON files, the other formats are
+	// converted to JSON first
+	format := ConfigFormat(filename)
+	positions := format == "json"
+	data, err = configToJSON(data, format)
+	if err != nil {
+		return nil, ConfigMalformedError{originalError: err}
+	}
+	original := data
+	data, version, err := migrateConfig(data)
+	if err != nil {
+		return nil, ConfigMalformedError{originalError: located(original, err, positions)}
+	}
+	if version != currentConfigVersion {
+		log.Printf("config version %d was upgraded to %d in memory, run config -migrate to update %s", version, currentConfigVersion, filename)
+	}
+	// Migrated configs are re-encoded, so lines are only known for
+	// configs of the current version
+	positions = positions && version == currentConfigVersion
 	var config Config
 	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, ConfigMalformedError{originalError: located(data, err, positions)}
+	}
+	unknown, err := unknownConfigKeys(data, positions)
+	if err != nil {
+		return nil, ConfigMalformedError{originalError: located(data, err, positions)}
+	}
+	if len(unknown) > 0 {
+		return nil, ConfigMalformedError{originalError: errors.New(strings.Join(unknown, "; ")), problems: unknown}
+	}
+	port := upstreamPort(config.Forwarding.Transport)
+	config.Forwarding.Servers, err = normalizeUpstreams(config.Forwarding.Servers, port)
+	if err != nil {
+		return nil, ConfigMalformedError{originalError: err}
+	}
+	config.Forwarding.EasyDNSServers, err = normalizeUpstreams(config.Forwarding.EasyDNSServers, port)
 	if err != nil {
 		return nil, ConfigMalformedError{originalError: err}
 	}
+	for zone, servers := range config.Forwarding.ConditionalForwarding {
+		config.Forwarding.ConditionalForwarding[zone], err = normalizeUpstreams(servers, port)
+		if err != nil {
+			return nil, ConfigMalformedError{originalError: fmt.Errorf("conditional forwarding for %s: %v", zone, err)}
+		}
+	}
 	return &config, nil
 }
 
-func requestFromUpsreamServers(r *dns.Msg, upstreamServers []string) (*dns.Msg, error) {
-	c := new(dns.Client)
-	c.Net = "udp"
-	for _, server := range upstreamServers {
-		resp, _, err := c.Exchange(r, server)
+// forwardQuestion resolves a single question from the cache or the upstream
+// servers and reports which of the two answered. When every server fails,
+// including answering SERVFAIL or REFUSED, a stale cached answer is served
+// if there is one. Otherwise the error is returned along with the last
+// SERVFAIL or REFUSED response, if any.
+func (s *Server) forwardQuestion(ctx context.Context, r *dns.Msg, q dns.Question, forwarding ForwardingConfig) (*dns.Msg, string, error) {
+	scope := queryScope(r)
+	if resp, cached := s.cache.get(q, scope); cached {
+		return resp, "cache", nil
+	}
+	resp, err := s.exchangeQuestion(ctx, r, q, forwarding)
+	if err != nil {
+		if stale, found := s.cache.stale(q, scope); found {
+			log.Printf("serving stale answer for %s: %v", q.Name, err)
+			return stale, "stale", nil
+		}
+		return resp, "forwarded", err
+	}
+	if isNegativeResponse(resp) {
+		// Cache the negative answer for at least negative_min_ttl too
+		raiseNegativeTTL(resp, forwarding.NegativeMinTTL)
+	}
+	if resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError {
+		s.cache.set(q, scope, resp)
+	}
+	return resp, "forwarded", nil
+}
+
+// exchangeQuestion resolves a single question from the upstream servers,
+// bypassing the cache
+func (s *Server) exchangeQuestion(ctx context.Context, r *dns.Msg, q dns.Question, forwarding ForwardingConfig) (*dns.Msg, error) {
+	// Conditionally forwarded zones are usually private and unsigned, so
+	// there is no chain of trust to validate them with
+	_, conditional := forwarding.conditionalServers(q.Name)
+	validate := forwarding.DNSSEC && !conditional
+	forwarding = forwarding.forName(q.Name)
+	query := r.Copy()
+	query.Question = []dns.Question{q}
+	if validate {
+		requestDNSSEC(query)
+	}
+	s.metrics.forwarded.Inc()
+	resp, err := s.requestFromUpsreamServers(ctx, query, forwarding)
+	if err != nil {
+		return resp, err
+	}
+	if validate {
+		// Bogus answers are not cached, so they are validated again
+		secure, err := s.validator.validate(ctx, q, resp)
+		if err != nil {
+			return nil, err
+		}
+		resp.AuthenticatedData = secure
+	}
+	return resp, nil
+}
+
+// appendUpstream adds the sections, response code and AA/RA flags of an
+// upstream response to msg. Records already in msg are not added twice, so
+// several questions answered by the same upstream data don't repeat it.
+func appendUpstream(msg, resp *dns.Msg, negativeMinTTL uint32) {
+	if isNegativeResponse(resp) {
+		// Raise the SOA so clients can cache the negative answer
+		raiseNegativeTTL(resp, negativeMinTTL)
+	}
+	msg.Answer = appendUnique(msg.Answer, resp.Answer)
+	msg.Ns = appendUnique(msg.Ns, resp.Ns)
+	msg.Extra = appendUnique(msg.Extra, resp.Extra)
+	msg.RecursionAvailable = msg.RecursionAvailable || resp.RecursionAvailable
+	if len(msg.Question) == 1 {
+		msg.Authoritative = resp.Authoritative
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		// Pass NXDOMAIN, SERVFAIL etc. on instead of turning them into NOERROR
+		msg.Rcode = resp.Rcode
+	}
+}
+
+// appendUnique appends the records of rrs that are not in section yet,
+// skipping the upstream's EDNS0 OPT record
+func appendUnique(section, rrs []dns.RR) []dns.RR {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		duplicate := false
+		for _, existing := range section {
+			if dns.IsDuplicate(existing, rr) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			section = append(section, rr)
+		}
+	}
+	return section
+}
+
+func (s *Server) requestFromUpsreamServers(ctx context.Context, r *dns.Msg, forwarding ForwardingConfig) (*dns.Msg, error) {
+	// Validated with the config
+	timeout, _ := forwarding.timeout()
+	if len(forwarding.Servers) == 0 {
+		return nil, fmt.Errorf("no upstream servers configured")
+	}
+	servers := s.health.order(forwarding.Servers, forwarding.Strategy)
+	if forwarding.Strategy == "parallel" {
+		return s.exchangeParallel(ctx, r, servers, forwarding, timeout)
+	}
+	var err error
+	var failed *dns.Msg
+	for _, server := range servers {
+		var resp *dns.Msg
+		if resp, err = s.exchangeWithRetries(ctx, r, server, forwarding, timeout); err == nil {
+			return resp, nil
+		}
+		if resp != nil {
+			failed = resp
+		}
+	}
+	// Every server failed, the last SERVFAIL or REFUSED is returned along
+	// with the error so it can be passed on
+	return failed, UpstreamError{servers: len(servers), attempts: 1 + forwarding.Retries, originalError: err}
+}
+
+// exchangeParallel queries the servers at once, forwarding.ParallelServers
+// of them at a time, and returns the first valid response
+func (s *Server) exchangeParallel(ctx context.Context, r *dns.Msg, servers []string, forwarding ForwardingConfig, timeout time.Duration) (*dns.Msg, error) {
+	batch := len(servers)
+	if forwarding.ParallelServers > 0 {
+		batch = min(batch, forwarding.ParallelServers)
+	}
+	var err error
+	var failed *dns.Msg
+	for start := 0; start < len(servers) && ctx.Err() == nil; start += batch {
+		var resp *dns.Msg
+		resp, failed, err = s.race(ctx, r, servers[start:min(start+batch, len(servers))], forwarding, timeout, failed)
 		if err == nil {
 			return resp, nil
 		}
 	}
-	return nil, fmt.Errorf("failed to get response from upstream servers")
+	return failed, UpstreamError{servers: len(servers), attempts: 1 + forwarding.Retries, originalError: err}
 }
 
-// handleDNSRequest handles incoming DNS queries
-func handleDNSRequest(records Records) dns.HandlerFunc {
-	return func(w dns.ResponseWriter, r *dns.Msg) {
-		msg := dns.Msg{}
-		msg.SetReply(r)
-		for _, q := range r.Question {
-			domain := strings.TrimSuffix(q.Name, ".")
-			if record, found := records[domain]; found {
-				var rr dns.RR
-				var err error
-				switch record.Type {
-				case "A", "AAAA", "CNAME", "TXT", "NS", "PTR":
-					rr, err = dns.NewRR(fmt.Sprintf("%s %s %s", q.Name, record.Type, record.Value))
-				case "MX":
-					rr, err = dns.NewRR(fmt.Sprintf("%s %s %d %s", q.Name, record.Type, record.Priority, record.Value))
-				case "SRV":
-					rr, err = dns.NewRR(fmt.Sprintf("%s %s %d %d %d %s", q.Name, record.Type, record.Priority, 0, 0, record.Value))
-				default:
-					log.Printf("Unsupported record type: %s", record.Type)
-					continue
-				}
-				if err == nil {
-					rr.Header().Ttl = record.TTL
-					msg.Answer = append(msg.Answer, rr)
-				} else {
-					log.Printf("Failed to create RR: %v", err)
-				}
-			} else {
-				if config.Forwarding.Enabled {
-					// Request from upstream servers
-					upstreamResponse, err := requestFromUpsreamServers(r, config.Forwarding.Servers)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
-					msg.Answer = append(msg.Answer, upstreamResponse.Answer...)
-				}
-			}
+// race queries servers at once and returns the first valid response,
+// canceling the remaining exchanges. If all of them fail it returns the
+// last SERVFAIL or REFUSED response, or failed if there is none, and the
+// last error.
+func (s *Server) race(ctx context.Context, r *dns.Msg, servers []string, forwarding ForwardingConfig, timeout time.Duration, failed *dns.Msg) (*dns.Msg, *dns.Msg, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		go func() {
+			resp, err := s.exchangeWithRetries(ctx, r, server, forwarding, timeout)
+			results <- result{resp: resp, err: err}
+		}()
+	}
+	var err error
+	for range servers {
+		result := <-results
+		if result.err == nil {
+			return result.resp, failed, nil
 		}
-		w.WriteMsg(&msg)
-		log.Printf("query: %s from: %s", r.Question[0].Name, w.RemoteAddr())
+		if result.resp != nil {
+			failed = result.resp
+		}
+		err = result.err
 	}
+	return nil, failed, err
 }
 
-func addGenericFlags(flagSets ...*flag.FlagSet) {
-	for _, cmd := range flagSets {
-		cmd.StringVar(&configPath, "config-path", defaultConfigPath, "Path to the config file")
+// exchangeWithRetries tries one upstream server up to 1 + forwarding.Retries
+// times and returns the last error if none of the attempts succeeds. A
+// server answering SERVFAIL or REFUSED is not retried, its response is
+// returned with the error.
+func (s *Server) exchangeWithRetries(ctx context.Context, r *dns.Msg, server string, forwarding ForwardingConfig, timeout time.Duration) (*dns.Msg, error) {
+	timeout, retries := forwarding.upstreamSettings(server, timeout)
+	var err error
+	for attempt := 0; attempt <= retries && ctx.Err() == nil; attempt++ {
+		var resp *dns.Msg
+		if resp, err = s.exchangeUpstream(ctx, r, server, forwarding, timeout); err == nil {
+			return resp, nil
+		}
+		if errors.As(err, &UpstreamRcodeError{}) {
+			return resp, err
+		}
 	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return nil, err
 }
 
-func printUsages(flagSets ...*flag.FlagSet) {
-	for _, cmd := range flagSets {
-		cmd.Usage()
+// exchangeUpstream sends r to one upstream server and checks that the
+// response answers the question that was asked
+func (s *Server) exchangeUpstream(ctx context.Context, r *dns.Msg, server string, forwarding ForwardingConfig, timeout time.Duration) (*dns.Msg, error) {
+	_, span := tracer.Start(ctx, "dns.upstream", trace.WithAttributes(attribute.String("dns.upstream", server)))
+	defer span.End()
+	query := tagForwardedQuery(r, server, forwarding)
+	if forwarding.CaseRandomization {
+		randomizeCase(query)
+	}
+	start := time.Now()
+	transport, address := splitUpstream(server, forwarding.Transport)
+	resp, err := s.upstreams.exchange(ctx, query, transport, address, timeout)
+	if err == nil && resp.Truncated && transport == "udp" {
+		// The answer did not fit, ask the same server again over TCP
+		resp, err = s.upstreams.exchange(ctx, query, "tcp", address, timeout)
+	}
+	rtt := time.Since(start)
+	s.metrics.upstreamLatency.Observe(rtt.Seconds())
+	if err == nil {
+		err = checkEchoedQuestion(r, query, resp, forwarding.CaseRandomization)
+		if err != nil {
+			log.Printf("rejecting response from %s: %v", server, err)
+		}
+	}
+	if err == nil && isFailureRcode(resp.Rcode) {
+		err = UpstreamRcodeError{server: server, rcode: resp.Rcode}
+		s.health.failure(server, forwarding.FailureThreshold)
+		s.metrics.upstreamFailures.Inc()
+		span.RecordError(err)
+		return resp, err
 	}
+	if err != nil {
+		if ctx.Err() == nil {
+			// Exchanges canceled in favor of another server are no failures
+			s.health.failure(server, forwarding.FailureThreshold)
+		}
+		s.metrics.upstreamFailures.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	s.health.success(server, rtt)
+	return resp, nil
 }
 
-func main() {
+// newRR builds the resource record served for a configured record under the given name
+func newRR(name string, record Record) (dns.RR, error) {
+	var rr dns.RR
+	var err error
+	switch record.Type {
+	case "A", "AAAA", "CNAME", "TXT", "NS", "PTR", "SOA", "CAA", "NAPTR", "SSHFP", "TLSA", "SVCB", "HTTPS", "DNSKEY":
+		rr, err = dns.NewRR(fmt.Sprintf("%s %s %s", name, record.Type, record.Value))
+	case "MX":
+		rr, err = dns.NewRR(fmt.Sprintf("%s %s %d %s", name, record.Type, record.Priority, record.Value))
+	case "SRV":
+		rr, err = dns.NewRR(fmt.Sprintf("%s %s %d %d %d %s", name, record.Type, record.Priority, record.Weight, record.Port, record.Value))
+	default:
+		return nil, UnsupportedRecordTypeError{recordType: record.Type}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("record %s %s has no value", name, record.Type)
+	}
+	rr.Header().Ttl = record.TTL
+	return rr, nil
+}
 
-	configCmd := flag.NewFlagSet("config", flag.ExitOnError)
-	saveConfig := configCmd.Bool("save", false, "Save config template in ~/.easydns/config.json (change dir with -config-path flag)")
-	printConfig := configCmd.Bool("print", false, "Prints configuration to stdout")
-	printDefault := configCmd.Bool("template", false, "Instead of printing the current configuration, print the sample configuration")
+// rdata returns the data of rr in zone file notation, without the header
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
 
-	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+// answers reports whether the record belongs in the answer to a query of
+// type qtype. A CNAME answers queries of every type, ANY matches everything.
+func (r Record) answers(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeANY:
+		return true
+	case dns.StringToType[r.Type]:
+		return true
+	}
+	return r.Type == "CNAME"
+}
 
-	addGenericFlags(configCmd, runCmd)
+// isTopLevelName reports whether name is the root or a single-label name such as a TLD
+func isTopLevelName(name string) bool {
+	return dns.CountLabel(name) <= 1
+}
 
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s [config|run]\n\n\n", "easydns")
-		printUsages(configCmd, runCmd)
-		os.Exit(1)
+// ServeDNS answers a query from the local records or the upstream
+// servers. It implements dns.Handler.
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	current := s.currentRecordSet()
+	cfg := s.currentConfig()
+	ctx, span := tracer.Start(context.Background(), "dns.query")
+	defer span.End()
+	if span.IsRecording() && len(r.Question) > 0 {
+		span.SetAttributes(
+			attribute.String("dns.qname", r.Question[0].Name),
+			attribute.String("dns.qtype", dns.TypeToString[r.Question[0].Qtype]),
+		)
 	}
+	start := time.Now()
+	s.metrics.queries.Inc()
+	answeredFrom := "none"
+	defer func() { span.SetAttributes(attribute.String("dns.answered_from", answeredFrom)) }()
 
-	var err error
-	switch os.Args[1] {
-	case "config":
-		configCmd.Parse(os.Args[2:])
-		if *saveConfig {
-			data, err := json.MarshalIndent(DefaultConfig, "", "  ")
-			if err != nil {
-				log.Fatalf("failed to marshal default config: %v", err)
+	msg := dns.Msg{}
+	msg.SetReply(r)
+	// SetReply only copies the first question, echo all of them
+	msg.Question = append([]dns.Question(nil), r.Question...)
+	client := clientIP(w.RemoteAddr())
+	if !s.startQuery() {
+		// Answered right away, waiting for a slot would let goroutines
+		// pile up under load
+		msg.Rcode = dns.RcodeServerFailure
+		answeredFrom = "overload"
+		s.metrics.overloaded.Inc()
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	defer s.finishQuery()
+	if !s.acl.allows(client) {
+		msg.Rcode = dns.RcodeRefused
+		answeredFrom = "acl"
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	if !s.limiter.allowQuery(client) {
+		// Dropped without a response, answering would defeat the limit
+		answeredFrom = "ratelimit"
+		s.metrics.rateLimited.Inc()
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, "dropped", start)
+		return
+	}
+	if isForwardingLoop(r) {
+		log.Printf("forwarding loop detected for query from %s, check the upstream servers", w.RemoteAddr())
+		msg.Rcode = dns.RcodeServerFailure
+		answeredFrom = "loop"
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	if opt := r.IsEdns0(); opt != nil && opt.Version() != 0 {
+		msg.Rcode = dns.RcodeBadVers
+		setEdns0(&msg, r, cfg.Server.ednsUDPSize())
+		answeredFrom = "badvers"
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	if r.Opcode == dns.OpcodeUpdate {
+		s.serveUpdate(w, r, &msg, cfg)
+		answeredFrom = "update"
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	if len(r.Question) == 1 && (r.Question[0].Qtype == dns.TypeAXFR || r.Question[0].Qtype == dns.TypeIXFR) {
+		answeredFrom = "transfer"
+		if s.serveTransfer(w, r, &msg, cfg, current.records) {
+			s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[dns.RcodeSuccess], start)
+			return
+		}
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	signedWith, rcode := cfg.TSIG.queryKey(w, r, client, tsigKeys(cfg))
+	if rcode != dns.RcodeSuccess {
+		if rcode == dns.RcodeNotAuth {
+			log.Printf("refusing query from %s with invalid TSIG key %s", w.RemoteAddr(), r.IsTsig().Hdr.Name)
+		} else {
+			log.Printf("refusing unsigned query from %s, it requires a TSIG key", w.RemoteAddr())
+		}
+		msg.Rcode = rcode
+		answeredFrom = "tsig"
+		w.WriteMsg(&msg)
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+		return
+	}
+	// Behind a trusted forwarder the subnet of its client stands in for
+	// the client from here on
+	subnet, client := cfg.ECS.clientSubnet(r, client)
+	records, chains := current.recordsFor(client)
+	query, original := s.rewrites.rewriteQuery(withoutTSIG(r), client)
+	// PTR queries for DNS64 addresses ask for the IPv4 PTR instead
+	query, desynthesized := cfg.DNS64.reverseQuery(query, records, client)
+	query = cfg.ECS.forwardedQuery(query, subnet)
+	answeredFrom, authenticated, answered := s.answerQuestions(ctx, span, w, query, &msg, records, cfg, client)
+	if !answered {
+		s.queryLog.log(w.RemoteAddr(), r, answeredFrom, "dropped", start)
+		return
+	}
+	if s.synthesizeDNS64(ctx, span, w, query, &msg, records, cfg, client) {
+		// Synthesized records are never validated
+		answeredFrom, authenticated = "dns64", 0
+	}
+	if desynthesized != nil {
+		// Signatures don't cover the names the records are renamed to
+		restoreNames(&msg, desynthesized)
+		authenticated = 0
+	}
+	if original != nil {
+		// Signatures don't cover the names the records are renamed to
+		restoreNames(&msg, original)
+		authenticated = 0
+	}
+	if cfg.Forwarding.DNSSEC {
+		// Only clients that understand DNSSEC get the AD bit and the
+		// DNSSEC records, see RFC 6840 5.8
+		msg.AuthenticatedData = authenticated == len(r.Question) && (wantsDNSSEC(r) || r.AuthenticatedData)
+		if !wantsDNSSEC(r) {
+			stripDNSSEC(&msg)
+		}
+	}
+	cfg.TTL.apply(&msg)
+	s.rewrites.rewriteAnswers(&msg, client)
+	applyTransforms(s.transforms, &msg, client)
+	if cfg.Server.MinimalResponses {
+		minimizeResponse(&msg)
+	}
+	appendInfoTXT(cfg.InfoTXT, &msg, maxResponseSize(w, r, cfg.Server.ednsUDPSize()))
+	if cfg.Debug.AnnotateSource {
+		appendSourceAnnotation(&msg, answeredFrom, maxResponseSize(w, r, cfg.Server.ednsUDPSize()))
+	}
+	s.orderAnswers(cfg.Server.RoundRobinMode, msg.Answer, client, records)
+	if wantsDNSSEC(r) {
+		s.signer.sign(&msg, records, chains)
+	}
+	setEdns0(&msg, r, cfg.Server.ednsUDPSize())
+	if cfg.ECS.Enabled {
+		echoSubnet(&msg, r, cfg.ECS.subnetScoped(query, records, answeredFrom))
+	}
+	// Sets the TC bit when the response does not fit, so the client
+	// retries over TCP
+	msg.Truncate(maxResponseSize(w, r, cfg.Server.ednsUDPSize()))
+	if answeredFrom == "local" {
+		s.metrics.localAnswers.Inc()
+	}
+	if msg.Rcode == dns.RcodeNameError {
+		s.metrics.nxdomain.Inc()
+	}
+	if w.RemoteAddr().Network() == "udp" {
+		switch s.limiter.limitResponse(client, &msg) {
+		case rrlSlip:
+			// An empty truncated response makes real clients retry over
+			// TCP, which cannot be spoofed
+			msg.Answer, msg.Ns, msg.Extra = nil, nil, nil
+			setEdns0(&msg, r, cfg.Server.ednsUDPSize())
+			msg.Truncated = true
+			answeredFrom = "ratelimit"
+			s.metrics.rateLimited.Inc()
+		case rrlDrop:
+			s.metrics.rateLimited.Inc()
+			s.queryLog.log(w.RemoteAddr(), r, "ratelimit", "dropped", start)
+			return
+		}
+	}
+	if signedWith != nil {
+		// Sign the response with the key of the query
+		msg.SetTsig(signedWith.Hdr.Name, signedWith.Algorithm, 300, time.Now().Unix())
+	}
+	w.WriteMsg(&msg)
+	s.queryLog.log(w.RemoteAddr(), r, answeredFrom, dns.RcodeToString[msg.Rcode], start)
+}
+
+// answerQuestions answers the questions of r in msg. It returns where they
+// were answered from and how many were answered with validated upstream
+// data, or false if the query is dropped without a response.
+func (s *Server) answerQuestions(ctx context.Context, span trace.Span, w dns.ResponseWriter, r, msg *dns.Msg, records Records, cfg *Config, client net.IP) (string, int, bool) {
+	answeredFrom := "none"
+	authenticated := 0 // Questions answered with validated upstream data
+	for _, q := range r.Question {
+		domain := recordName(q.Name)
+		if q.Qtype == dns.TypeTXT {
+			if rrs := s.challenges.answer(q); len(rrs) > 0 {
+				msg.Answer = append(msg.Answer, rrs...)
+				answeredFrom = "acme"
+				continue
 			}
-			err = os.WriteFile(configPath, data, 0644)
-			if err != nil {
-				log.Fatalf("failed to save default config: %v", err)
+		}
+		policy := matchPolicy(s.policies, client, q, now())
+		if policy != nil && policy.action == "block" {
+			policy.sinkhole.answer(msg, q)
+			answeredFrom = "blocked"
+			s.metrics.blocked.Inc()
+			log.Printf("blocked %s %s for %s by policy %s", q.Name, dns.TypeToString[q.Qtype], w.RemoteAddr(), policy)
+			continue
+		}
+		if policy != nil && policy.action == "rewrite" {
+			answeredFrom = "policy"
+			if err := s.rewriteAnswer(ctx, msg, r, q, policy, records, cfg, client); err != nil {
+				span.RecordError(err)
+				log.Println(err)
+				msg.Rcode = dns.RcodeServerFailure
 			}
-
-			// Exit after saving the default config
-		} else if *printConfig {
-			if *printDefault {
-				config = &DefaultConfig
-			} else {
-				config, err = LoadConfig(configPath)
+			continue
+		}
+		zone, authoritative := authoritativeZone(cfg.authoritativeZoneNames(), domain)
+		if key, set, found := records.lookup(domain); found {
+			answeredFrom = "local"
+			msg.Authoritative = true
+			if cfg.Server.answersANYWithHINFO(q) {
+				msg.Answer = append(msg.Answer, hinfoANY(q))
+				continue
+			}
+			matching := forClient(s.recordHealth.filter(answering(set, q.Qtype)), client)
+			if len(matching) == 0 {
+				// The name exists but has no data of this type (NODATA)
+				if authoritative {
+					appendZoneSOA(msg, records, zone)
+				}
+				continue
+			}
+			cname := ""
+			for _, record := range matching {
+				record = record.activeAt(now())
+				rr, err := newRR(q.Name, record)
+				if _, unsupported := err.(UnsupportedRecordTypeError); unsupported {
+					log.Printf("Unsupported record type: %s", record.Type)
+					continue
+				}
 				if err != nil {
-					log.Fatalf("cannot print config because %v", err)
+					log.Printf("Failed to create RR: %v", err)
+					continue
+				}
+				msg.Answer = append(msg.Answer, rr)
+				s.hits.hit(key, record)
+				if record.Type == "CNAME" {
+					cname = record.Value
 				}
 			}
-			data, err := json.MarshalIndent(config, "", "  ")
-			if err != nil {
-				log.Fatalf("failed to marshal default config: %v", err)
+			if cname != "" && q.Qtype != dns.TypeCNAME && q.Qtype != dns.TypeANY {
+				if err := s.appendCNAMETarget(ctx, msg, r, q, records, cname, cfg, client); err != nil {
+					span.RecordError(err)
+					log.Println(err)
+					msg.Rcode = dns.RcodeServerFailure
+				}
 			}
-			fmt.Println(string(data))
+		} else if rrs := s.ownPTRs.answer(q); len(rrs) > 0 {
+			msg.Answer = append(msg.Answer, rrs...)
+			answeredFrom = "local"
+		} else if authoritative {
+			// Names in our own zones are never forwarded
+			msg.Rcode = dns.RcodeNameError
+			msg.Authoritative = true
+			appendZoneSOA(msg, records, zone)
+			answeredFrom = "local"
+		} else if blocked := s.blocklist.Load(); !policy.allows() && blocked.blocks(q.Name) {
+			blocked.answer(msg, q)
+			answeredFrom = "blocked"
+			s.metrics.blocked.Inc()
+			log.Printf("blocked %s %s for %s", q.Name, dns.TypeToString[q.Qtype], w.RemoteAddr())
+		} else if isTopLevelName(q.Name) && cfg.Forwarding.TopLevelQueries != "forward" {
+			// Never act as a root resolver or answer for whole TLDs
+			msg.Rcode = dns.RcodeRefused
+			answeredFrom = "refused"
 		} else {
-			configCmd.Usage()
+			if forwarding := policy.forwarding(cfg.Forwarding); forwarding.Enabled {
+				if !forwarding.allowsType(q.Qtype) || !s.acl.allowsForwarding(client) {
+					msg.Rcode = dns.RcodeRefused
+					answeredFrom = "refused"
+					continue
+				}
+				if cfg.Server.answersANYWithHINFO(q) {
+					// ANY is not forwarded, upstream answers could be huge
+					msg.Answer = append(msg.Answer, hinfoANY(q))
+					answeredFrom = "local"
+					continue
+				}
+				// Forward each question on its own so answers end up with the right question
+				var upstreamResponse *dns.Msg
+				var err error
+				source := "forwarded"
+				if policy.forwards() {
+					// The cache is shared by all clients, answers of the
+					// policy's servers are only for the clients it matches
+					upstreamResponse, err = s.exchangeQuestion(ctx, r, q, forwarding)
+				} else {
+					upstreamResponse, source, err = s.forwardQuestion(ctx, r, q, forwarding)
+				}
+				if err != nil {
+					span.RecordError(err)
+					log.Println(err)
+					if upstreamResponse == nil {
+						msg.Rcode = dns.RcodeServerFailure
+						answeredFrom = "failed"
+						continue
+					}
+					// Every server answered SERVFAIL or REFUSED, pass it on
+				}
+				answeredFrom = source
+				if upstreamResponse.AuthenticatedData {
+					authenticated++
+				}
+				appendUpstream(msg, upstreamResponse, cfg.Forwarding.NegativeMinTTL)
+			} else {
+				answeredFrom = "fallback"
+				if !cfg.Fallback.apply(msg, q) {
+					return answeredFrom, authenticated, false
+				}
+			}
 		}
-		os.Exit(0)
-	case "run":
-		runCmd.Parse(os.Args[2:])
-	default:
-		break
-	}
-
-	config, err = LoadConfig(configPath)
-	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
-	}
-
-	dns.HandleFunc(".", handleDNSRequest(config.Records))
-
-	addr := strings.Join([]string{config.Server.BindAddress, config.Server.Port}, ":")
-
-	server := &dns.Server{Addr: addr, Net: "udp"}
-	log.Printf("starting DNS server on port %s", config.Server.Port)
-	err = server.ListenAndServe()
-	if err != nil {
-		log.Fatalf("failed to start server: %v", err)
 	}
+	return answeredFrom, authenticated, true
 }
@@ -0,0 +1,481 @@
+package easydns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTTLsPerType(t *testing.T) {
+	// The upstream answers with a CNAME and its target, each with its own TTL
+	u := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		cname, _ := dns.NewRR(r.Question[0].Name + " 3600 IN CNAME target.example.org.")
+		a, _ := dns.NewRR("target.example.org. 300 IN A 192.0.2.1")
+		resp.Answer = append(resp.Answer, cname, a)
+		w.WriteMsg(resp)
+	})
+	cfg := &Config{
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{u.addr}},
+		Server:     ServerConfig{Port: "53"},
+		Records: Records{
+			"app.test.com":  {{Type: "A", Value: "10.0.0.1", TTL: 300}},
+			"txt.test.com":  {{Type: "TXT", Value: "\"v=app\"", TTL: 3600}},
+			"mail.test.com": {{Type: "MX", Value: "mail.test.com.", Priority: 10, TTL: 60}},
+			"www.test.com":  {{Type: "CNAME", Value: "app.test.com.", TTL: 1800}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  map[uint16]uint32
+	}{
+		{name: "app.test.com", qtype: dns.TypeA, want: map[uint16]uint32{dns.TypeA: 300}},
+		{name: "txt.test.com", qtype: dns.TypeTXT, want: map[uint16]uint32{dns.TypeTXT: 3600}},
+		{name: "mail.test.com", qtype: dns.TypeMX, want: map[uint16]uint32{dns.TypeMX: 60}},
+		{name: "www.test.com", qtype: dns.TypeCNAME, want: map[uint16]uint32{dns.TypeCNAME: 1800}},
+		{name: "www.example.org", qtype: dns.TypeA, want: map[uint16]uint32{dns.TypeCNAME: 3600, dns.TypeA: 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			resp := ask(t, s, tt.name, tt.qtype)
+			got := map[uint16]uint32{}
+			for _, rr := range resp.Answer {
+				got[rr.Header().Rrtype] = rr.Header().Ttl
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got answers %v, want TTLs %v", resp.Answer, tt.want)
+			}
+			for rrtype, ttl := range tt.want {
+				if got[rrtype] != ttl {
+					t.Errorf("%s has TTL %d, want %d", dns.TypeToString[rrtype], got[rrtype], ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestTopLevelQueries(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	tests := []struct {
+		name      string
+		mode      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		forwarded bool
+	}{
+		{name: "root is refused", qname: ".", qtype: dns.TypeNS, wantRcode: dns.RcodeRefused},
+		{name: "tld is refused", qname: "com.", qtype: dns.TypeA, wantRcode: dns.RcodeRefused},
+		{name: "normal name is forwarded", qname: "www.example.com.", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, forwarded: true},
+		{name: "local tld record is answered", qname: "lan.", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess},
+		{name: "root is forwarded in forward mode", mode: "forward", qname: ".", qtype: dns.TypeNS, wantRcode: dns.RcodeSuccess, forwarded: true},
+		{name: "tld is forwarded in forward mode", mode: "forward", qname: "com.", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, forwarded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, TopLevelQueries: tt.mode},
+				Records:    Records{"lan": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := up.queries.Load()
+			resp := ask(t, s, tt.qname, tt.qtype)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if forwarded := up.queries.Load() > before; forwarded != tt.forwarded {
+				t.Errorf("forwarded is %v, want %v", forwarded, tt.forwarded)
+			}
+		})
+	}
+}
+
+func TestAllowedTypes(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	cfg := &Config{
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, AllowedTypes: []string{"a", "PTR"}},
+		Records:    Records{"txt.test.com": {{Type: "TXT", Value: "\"local\"", TTL: 60}}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		qtype     uint16
+		wantRcode int
+		forwarded bool
+	}{
+		{name: "www.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, forwarded: true},
+		{name: "www.example.com", qtype: dns.TypeTXT, wantRcode: dns.RcodeRefused},
+		{name: "txt.test.com", qtype: dns.TypeTXT, wantRcode: dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			before := up.queries.Load()
+			resp := ask(t, s, tt.name, tt.qtype)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if forwarded := up.queries.Load() > before; forwarded != tt.forwarded {
+				t.Errorf("forwarded is %v, want %v", forwarded, tt.forwarded)
+			}
+		})
+	}
+}
+
+func TestForwardedReplyQuestion(t *testing.T) {
+	const qname = "WwW.Example.COM."
+	tests := []struct {
+		name       string
+		alter      func(resp *dns.Msg)
+		wantAnswer bool
+	}{
+		{name: "same question", alter: func(resp *dns.Msg) {}, wantAnswer: true},
+		{name: "lowercased question", alter: func(resp *dns.Msg) {
+			resp.Question[0].Name = strings.ToLower(resp.Question[0].Name)
+			resp.Answer[0].Header().Name = strings.ToLower(resp.Answer[0].Header().Name)
+		}, wantAnswer: true},
+		{name: "uppercased question", alter: func(resp *dns.Msg) {
+			resp.Question[0].Name = strings.ToUpper(resp.Question[0].Name)
+			resp.Answer[0].Header().Name = strings.ToUpper(resp.Answer[0].Header().Name)
+		}, wantAnswer: true},
+		{name: "extra question", alter: func(resp *dns.Msg) {
+			resp.Question = append(resp.Question, dns.Question{Name: "other.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+		}},
+		{name: "other question", alter: func(resp *dns.Msg) {
+			resp.Question[0].Name = "other.example.com."
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				resp := new(dns.Msg)
+				resp.SetReply(r)
+				rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+				resp.Answer = append(resp.Answer, rr)
+				tt.alter(resp)
+				w.WriteMsg(resp)
+			})
+			s, err := New(&Config{
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, qname, dns.TypeA)
+			if answered := len(resp.Answer) > 0; answered != tt.wantAnswer {
+				t.Fatalf("answered is %v, want %v", answered, tt.wantAnswer)
+			}
+			if len(resp.Question) != 1 || resp.Question[0].Name != qname || resp.Question[0].Qtype != dns.TypeA {
+				t.Errorf("got question %v, want %s A", resp.Question, qname)
+			}
+			for _, rr := range resp.Answer {
+				if rr.Header().Name != qname {
+					t.Errorf("answer is owned by %s, want %s", rr.Header().Name, qname)
+				}
+			}
+		})
+	}
+}
+
+func TestQtypeMatching(t *testing.T) {
+	s, err := New(&Config{
+		Server: ServerConfig{Port: "53"},
+		Records: Records{
+			"test.com": {
+				{Type: "A", Value: "10.0.0.1", TTL: 60},
+				{Type: "AAAA", Value: "2001:db8::1", TTL: 60},
+				{Type: "MX", Value: "mail.test.com.", Priority: 10, TTL: 60},
+				{Type: "TXT", Value: "\"v=spf1 -all\"", TTL: 60},
+			},
+			"www.test.com": {{Type: "CNAME", Value: "test.com.", TTL: 60}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []uint16 // Types of the answer records, none for NODATA
+	}{
+		{name: "test.com", qtype: dns.TypeA, want: []uint16{dns.TypeA}},
+		{name: "test.com", qtype: dns.TypeAAAA, want: []uint16{dns.TypeAAAA}},
+		{name: "test.com", qtype: dns.TypeMX, want: []uint16{dns.TypeMX}},
+		{name: "test.com", qtype: dns.TypeTXT, want: []uint16{dns.TypeTXT}},
+		{name: "test.com", qtype: dns.TypeANY, want: []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeMX, dns.TypeTXT}},
+		{name: "test.com", qtype: dns.TypeSRV},
+		{name: "test.com", qtype: dns.TypeCNAME},
+		{name: "www.test.com", qtype: dns.TypeCNAME, want: []uint16{dns.TypeCNAME}},
+		{name: "www.test.com", qtype: dns.TypeAAAA, want: []uint16{dns.TypeCNAME, dns.TypeAAAA}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			resp := ask(t, s, tt.name, tt.qtype)
+			if resp.Rcode != dns.RcodeSuccess {
+				t.Fatalf("got rcode %s, want NOERROR", dns.RcodeToString[resp.Rcode])
+			}
+			var got []uint16
+			for _, rr := range resp.Answer {
+				got = append(got, rr.Header().Rrtype)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got types %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCodes(t *testing.T) {
+	nxdomain := startUpstream(t, answerRcode(dns.RcodeNameError))
+	servfail := startUpstream(t, answerRcode(dns.RcodeServerFailure))
+	tests := []struct {
+		name       string
+		forwarding ForwardingConfig
+		qname      string
+		wantRcode  int
+	}{
+		{name: "local record", qname: "app.test.com", wantRcode: dns.RcodeSuccess},
+		{name: "unknown local name", qname: "missing.test.com", wantRcode: dns.RcodeNameError},
+		{name: "upstream failure", forwarding: ForwardingConfig{Enabled: true, Servers: []string{deadUpstream(t)}}, qname: "www.example.com", wantRcode: dns.RcodeServerFailure},
+		{name: "upstream servfail", forwarding: ForwardingConfig{Enabled: true, Servers: []string{servfail.addr}}, qname: "www.example.com", wantRcode: dns.RcodeServerFailure},
+		{name: "upstream nxdomain", forwarding: ForwardingConfig{Enabled: true, Servers: []string{nxdomain.addr}}, qname: "missing.example.com", wantRcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: tt.forwarding,
+				Records:    Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, tt.qname, dns.TypeA)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+		})
+	}
+}
+
+func TestForwardingStrategies(t *testing.T) {
+	slow := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(500 * time.Millisecond)
+		answerA("192.0.2.1")(w, r)
+	})
+	fast := startUpstream(t, answerA("192.0.2.2"))
+	silent := deadUpstream(t)
+	tests := []struct {
+		name            string
+		strategy        string
+		parallelServers int
+		timeout         string
+		upstreams       map[string]UpstreamSettings
+		servers         []string
+		want            []string
+		wantBefore      time.Duration
+	}{
+		{name: "sequential waits for the first server", servers: []string{slow.addr, fast.addr}, want: []string{"192.0.2.1"}, wantBefore: time.Second},
+		{name: "parallel takes the fastest answer", strategy: "parallel", servers: []string{slow.addr, fast.addr}, want: []string{"192.0.2.2"}, wantBefore: 300 * time.Millisecond},
+		{name: "timeout moves on from a slow server", timeout: "100ms", servers: []string{slow.addr, fast.addr}, want: []string{"192.0.2.2"}, wantBefore: 400 * time.Millisecond},
+		{name: "parallel ignores a dead server", strategy: "parallel", servers: []string{silent, fast.addr}, want: []string{"192.0.2.2"}, wantBefore: 300 * time.Millisecond},
+		{name: "parallel_servers races only the first servers", strategy: "parallel", parallelServers: 1, servers: []string{slow.addr, fast.addr}, want: []string{"192.0.2.1"}, wantBefore: time.Second},
+		{name: "parallel_servers moves on when they fail", strategy: "parallel", parallelServers: 1, timeout: "100ms", servers: []string{silent, fast.addr}, want: []string{"192.0.2.2"}, wantBefore: 400 * time.Millisecond},
+		{name: "upstream timeout moves on from a slow server", upstreams: map[string]UpstreamSettings{slow.addr: {Timeout: "100ms"}}, servers: []string{slow.addr, fast.addr}, want: []string{"192.0.2.2"}, wantBefore: 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version: currentConfigVersion,
+				Server:  ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{
+					Enabled:         true,
+					Servers:         tt.servers,
+					Strategy:        tt.strategy,
+					ParallelServers: tt.parallelServers,
+					Timeout:         tt.timeout,
+					Upstreams:       tt.upstreams,
+				},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			resp := ask(t, s, "www.example.com", dns.TypeA)
+			if elapsed := time.Since(start); elapsed >= tt.wantBefore {
+				t.Errorf("answered after %s, want before %s", elapsed, tt.wantBefore)
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedSections(t *testing.T) {
+	mustRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+	soa := "example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"
+	tests := []struct {
+		name              string
+		qname             string
+		rcode             int
+		authoritative     bool
+		answer, ns, extra []string
+	}{
+		{
+			name:   "answer with authority and glue",
+			qname:  "www.example.com.",
+			rcode:  dns.RcodeSuccess,
+			answer: []string{"www.example.com. 60 IN A 192.0.2.1"},
+			ns:     []string{"example.com. 60 IN NS ns1.example.com."},
+			extra:  []string{"ns1.example.com. 60 IN A 192.0.2.53"},
+		},
+		{
+			name:          "authoritative NXDOMAIN with SOA",
+			qname:         "missing.example.com.",
+			rcode:         dns.RcodeNameError,
+			authoritative: true,
+			ns:            []string{soa},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				resp := new(dns.Msg)
+				resp.SetRcode(r, tt.rcode)
+				resp.Authoritative = tt.authoritative
+				resp.RecursionAvailable = true
+				for _, rr := range tt.answer {
+					resp.Answer = append(resp.Answer, mustRR(rr))
+				}
+				for _, rr := range tt.ns {
+					resp.Ns = append(resp.Ns, mustRR(rr))
+				}
+				for _, rr := range tt.extra {
+					resp.Extra = append(resp.Extra, mustRR(rr))
+				}
+				w.WriteMsg(resp)
+			})
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, tt.qname, dns.TypeA)
+			if resp.Rcode != tt.rcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if resp.Authoritative != tt.authoritative || !resp.RecursionAvailable {
+				t.Errorf("got AA %v RA %v, want AA %v RA true", resp.Authoritative, resp.RecursionAvailable, tt.authoritative)
+			}
+			sections := []struct {
+				name string
+				got  []dns.RR
+				want []string
+			}{
+				{name: "answer", got: resp.Answer, want: tt.answer},
+				{name: "authority", got: resp.Ns, want: tt.ns},
+				{name: "additional", got: resp.Extra, want: tt.extra},
+			}
+			for _, section := range sections {
+				var got []string
+				for _, rr := range section.got {
+					if rr.Header().Rrtype != dns.TypeOPT {
+						got = append(got, rdata(rr))
+					}
+				}
+				var want []string
+				for _, rr := range section.want {
+					want = append(want, rdata(mustRR(rr)))
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got %v, want %v", section.name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendUpstreamSkipsDuplicates(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.SetQuestion("www.example.com.", dns.TypeA)
+	a, _ := dns.NewRR("www.example.com. 60 IN A 192.0.2.1")
+	ns, _ := dns.NewRR("example.com. 60 IN NS ns1.example.com.")
+	resp.Answer = []dns.RR{a}
+	resp.Ns = []dns.RR{ns}
+	resp.SetEdns0(1232, false)
+
+	msg := new(dns.Msg)
+	msg.Question = []dns.Question{resp.Question[0], {Name: "www.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}}
+	appendUpstream(msg, resp, 0)
+	appendUpstream(msg, resp, 0)
+	if len(msg.Answer) != 1 || len(msg.Ns) != 1 || len(msg.Extra) != 0 {
+		t.Errorf("got %d answer, %d authority and %d additional records, want 1, 1 and 0", len(msg.Answer), len(msg.Ns), len(msg.Extra))
+	}
+}
+
+func TestServiceRecordTypes(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name   string
+		record Record
+		qtype  uint16
+		want   string
+	}{
+		{name: "NAPTR", record: Record{Type: "NAPTR", Value: `100 10 "U" "E2U+sip" "!^.*$!sip:info@test.com!" .`}, qtype: dns.TypeNAPTR, want: `100 10 "U" "E2U+sip" "!^.*$!sip:info@test.com!" .`},
+		{name: "SSHFP", record: Record{Type: "SSHFP", Value: "4 2 " + hash}, qtype: dns.TypeSSHFP, want: "4 2 " + strings.ToUpper(hash)},
+		{name: "TLSA", record: Record{Type: "TLSA", Value: "3 1 1 " + hash}, qtype: dns.TypeTLSA, want: "3 1 1 " + hash},
+		{name: "SVCB", record: Record{Type: "SVCB", Value: "1 svc.test.com. alpn=h2"}, qtype: dns.TypeSVCB, want: `1 svc.test.com. alpn="h2"`},
+		{name: "HTTPS", record: Record{Type: "HTTPS", Value: "1 . alpn=h2,h3"}, qtype: dns.TypeHTTPS, want: `1 . alpn="h2,h3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.record.TTL = 60
+			s, err := New(&Config{
+				Version: currentConfigVersion,
+				Server:  ServerConfig{Port: "53"},
+				Records: Records{"app.test.com": {tt.record}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, "app.test.com", tt.qtype)
+			if len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != tt.qtype {
+				t.Fatalf("got answers %v, want one %s record", resp.Answer, tt.name)
+			}
+			if got := rdata(resp.Answer[0]); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultECSPrefixV4 = 24
+	defaultECSPrefixV6 = 56
+)
+
+// ECSConfig handles the EDNS Client Subnet option (RFC 7871): it takes the
+// subnets of clients from trusted forwarders and sends them on to the
+// upstream servers
+type ECSConfig struct {
+	Enabled bool `json:"enabled"`
+	// TrustedClients are networks of forwarders, e.g. dnsdist, whose client
+	// subnet options stand in for their own address. The options of other
+	// clients are ignored.
+	TrustedClients []string `json:"trusted_clients,omitempty"`
+	// Forward sends the client subnet to the upstream servers, otherwise
+	// client subnet options are removed from forwarded queries
+	Forward bool `json:"forward,omitempty"`
+	// SourcePrefixV4 and SourcePrefixV6 are the prefix lengths addresses
+	// are truncated to, 24 and 56 by default
+	SourcePrefixV4 int `json:"source_prefix_v4,omitempty"`
+	SourcePrefixV6 int `json:"source_prefix_v6,omitempty"`
+
+	trusted []*net.IPNet // TrustedClients, parsed by prepare
+}
+
+// prepare parses the trusted client networks once, so they are not parsed
+// again for every query. The config has been validated before.
+func (c *ECSConfig) prepare() {
+	c.trusted, _ = parseNetworks(c.TrustedClients)
+}
+
+func (c ECSConfig) validate() error {
+	if c.SourcePrefixV4 < 0 || c.SourcePrefixV4 > 32 {
+		return fmt.Errorf("source_prefix_v4 %d is not between 0 and 32", c.SourcePrefixV4)
+	}
+	if c.SourcePrefixV6 < 0 || c.SourcePrefixV6 > 128 {
+		return fmt.Errorf("source_prefix_v6 %d is not between 0 and 128", c.SourcePrefixV6)
+	}
+	if _, err := parseNetworks(c.TrustedClients); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sourcePrefix returns the prefix length addresses of family are truncated to
+func (c ECSConfig) sourcePrefix(family uint16) int {
+	if family == 1 {
+		if c.SourcePrefixV4 == 0 {
+			return defaultECSPrefixV4
+		}
+		return c.SourcePrefixV4
+	}
+	if c.SourcePrefixV6 == 0 {
+		return defaultECSPrefixV6
+	}
+	return c.SourcePrefixV6
+}
+
+// subnetOption returns the client subnet option of r, nil if it has none
+func subnetOption(r *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, option := range opt.Option {
+		if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// newSubnetOption returns a client subnet option for ip truncated to prefix
+// bits
+func newSubnetOption(ip net.IP, prefix int) *dns.EDNS0_SUBNET {
+	family, bits := uint16(2), 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, family, bits = ip4, 1, 32
+	}
+	prefix = min(prefix, bits)
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(prefix),
+		Address:       ip.Mask(net.CIDRMask(prefix, bits)),
+	}
+}
+
+// clientSubnet returns the subnet to send upstream for the query r from
+// client and the address that stands in for the client. That is the address
+// of the subnet option of trusted forwarders and client itself otherwise.
+func (c ECSConfig) clientSubnet(r *dns.Msg, client net.IP) (*dns.EDNS0_SUBNET, net.IP) {
+	if !c.Enabled || client == nil {
+		return nil, client
+	}
+	if received := subnetOption(r); received != nil && received.Address != nil {
+		if containsIP(c.trusted, client) {
+			if received.SourceNetmask == 0 {
+				// The client asked for its subnet not to be revealed
+				return received, client
+			}
+			prefix := min(int(received.SourceNetmask), c.sourcePrefix(received.Family))
+			return newSubnetOption(received.Address, prefix), received.Address
+		}
+	}
+	family := uint16(2)
+	if client.To4() != nil {
+		family = 1
+	}
+	return newSubnetOption(client, c.sourcePrefix(family)), client
+}
+
+// forwardedQuery returns r with subnet as its client subnet option if the
+// subnet is forwarded, or with the option removed otherwise. It returns r
+// itself when ECS is disabled.
+func (c ECSConfig) forwardedQuery(r *dns.Msg, subnet *dns.EDNS0_SUBNET) *dns.Msg {
+	if !c.Enabled {
+		return r
+	}
+	forward := c.Forward && subnet != nil && subnet.SourceNetmask > 0
+	if !forward && subnetOption(r) == nil {
+		return r
+	}
+	query := r.Copy()
+	opt := query.IsEdns0()
+	if opt == nil {
+		query.SetEdns0(dns.DefaultMsgSize, false)
+		opt = query.IsEdns0()
+	}
+	var options []dns.EDNS0
+	for _, option := range opt.Option {
+		if option.Option() != dns.EDNS0SUBNET {
+			options = append(options, option)
+		}
+	}
+	if forward {
+		options = append(options, subnet)
+	}
+	opt.Option = options
+	return query
+}
+
+// echoSubnet copies the client subnet option of r into the OPT record of
+// msg. With scoped set the scope is the source prefix, the answer is only
+// claimed to be right for the subnet that was asked for. Otherwise it is 0,
+// the answer is the same for every subnet.
+func echoSubnet(msg, r *dns.Msg, scoped bool) {
+	received := subnetOption(r)
+	opt := msg.IsEdns0()
+	if received == nil || opt == nil {
+		return
+	}
+	var scope uint8
+	if scoped {
+		scope = received.SourceNetmask
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        received.Family,
+		SourceNetmask: received.SourceNetmask,
+		SourceScope:   scope,
+		Address:       received.Address,
+	})
+}
+
+// subnetScoped reports whether the answer in msg depends on the client
+// subnet: a name of its questions or answers has subnet-tagged records, or
+// the subnet was sent on to the upstream servers
+func (c ECSConfig) subnetScoped(msg *dns.Msg, records Records, answeredFrom string) bool {
+	if c.Forward && answeredFrom != "local" {
+		return true
+	}
+	names := make([]string, 0, len(msg.Question)+len(msg.Answer))
+	for _, q := range msg.Question {
+		names = append(names, q.Name)
+	}
+	for _, rr := range msg.Answer {
+		names = append(names, rr.Header().Name)
+	}
+	for _, name := range names {
+		_, set, _ := records.lookup(recordName(name))
+		for _, record := range set {
+			if len(record.Subnets) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subnetKey returns the client subnet of r for the cache key of its
+// answers, empty if it has none
+func subnetKey(r *dns.Msg) string {
+	subnet := subnetOption(r)
+	if subnet == nil || subnet.SourceNetmask == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", subnet.Address, subnet.SourceNetmask)
+}
+
+// forClient returns the records of set tagged with a subnet containing
+// client, or the untagged records if no tagged record matches
+func forClient(set []Record, client net.IP) []Record {
+	tagged := false
+	for _, record := range set {
+		tagged = tagged || len(record.Subnets) > 0
+	}
+	if !tagged {
+		return set
+	}
+	var matching, untagged []Record
+	for _, record := range set {
+		if len(record.Subnets) == 0 {
+			untagged = append(untagged, record)
+			continue
+		}
+		if client != nil && containsIP(record.subnets, client) {
+			matching = append(matching, record)
+		}
+	}
+	if len(matching) > 0 {
+		return matching
+	}
+	return untagged
+}
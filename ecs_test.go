@@ -0,0 +1,207 @@
+package easydns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// withSubnet returns a query for name from the subnet address/prefix
+func withSubnet(name, address string, prefix uint8) *dns.Msg {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	if address != "" {
+		ip := net.ParseIP(address)
+		family := uint16(2)
+		if ip.To4() != nil {
+			ip, family = ip.To4(), 1
+		}
+		opt := query.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: family, SourceNetmask: prefix, Address: ip})
+	}
+	return query
+}
+
+func TestClientSubnet(t *testing.T) {
+	cfg := ECSConfig{Enabled: true, TrustedClients: []string{"127.0.0.1"}}
+	cfg.prepare()
+	tests := []struct {
+		name       string
+		client     string
+		query      *dns.Msg
+		wantSubnet string
+		wantClient string
+	}{
+		{name: "trusted forwarder", client: "127.0.0.1", query: withSubnet("app.test.com", "10.1.2.0", 24), wantSubnet: "10.1.2.0/24", wantClient: "10.1.2.0"},
+		{name: "trusted forwarder with a longer prefix", client: "127.0.0.1", query: withSubnet("app.test.com", "10.1.2.3", 32), wantSubnet: "10.1.2.0/24", wantClient: "10.1.2.3"},
+		{name: "trusted forwarder hiding the subnet", client: "127.0.0.1", query: withSubnet("app.test.com", "0.0.0.0", 0), wantSubnet: "0.0.0.0/0", wantClient: "127.0.0.1"},
+		{name: "untrusted client", client: "192.0.2.7", query: withSubnet("app.test.com", "10.1.2.0", 24), wantSubnet: "192.0.2.0/24", wantClient: "192.0.2.7"},
+		{name: "IPv6 client without an option", client: "2001:db8:1:2::7", query: withSubnet("app.test.com", "", 0), wantSubnet: "2001:db8:1::/56", wantClient: "2001:db8:1:2::7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnet, client := cfg.clientSubnet(tt.query, net.ParseIP(tt.client))
+			got := (&net.IPNet{IP: subnet.Address, Mask: net.CIDRMask(int(subnet.SourceNetmask), 8*len(subnet.Address))}).String()
+			if got != tt.wantSubnet {
+				t.Errorf("got subnet %s, want %s", got, tt.wantSubnet)
+			}
+			if !client.Equal(net.ParseIP(tt.wantClient)) {
+				t.Errorf("got client %s, want %s", client, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestForClient(t *testing.T) {
+	records := normalizeRecords(Records{"app.test.com": {
+		{Type: "A", Value: "192.0.2.10"},
+		{Type: "A", Value: "10.1.0.10", Subnets: []string{"10.1.0.0/16"}},
+		{Type: "A", Value: "10.2.0.10", Subnets: []string{"10.2.0.0/16", "fd00:2::/48"}},
+	}})
+	tests := []struct {
+		client string
+		want   []string
+	}{
+		{client: "10.1.5.5", want: []string{"10.1.0.10"}},
+		{client: "10.2.5.5", want: []string{"10.2.0.10"}},
+		{client: "fd00:2::5", want: []string{"10.2.0.10"}},
+		{client: "203.0.113.5", want: []string{"192.0.2.10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client, func(t *testing.T) {
+			var got []string
+			for _, record := range forClient(records["app.test.com"], net.ParseIP(tt.client)) {
+				got = append(got, record.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestECSAnswers(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	records := Records{
+		"app.test.com": {
+			{Type: "A", Value: "192.0.2.10", TTL: 60},
+			{Type: "A", Value: "10.1.0.10", TTL: 60, Subnets: []string{"10.1.0.0/16"}},
+		},
+		"plain.test.com": {{Type: "A", Value: "192.0.2.20", TTL: 60}},
+	}
+	tests := []struct {
+		name      string
+		forward   bool
+		query     *dns.Msg
+		want      []string
+		wantScope uint8
+	}{
+		{name: "tagged record matches", query: withSubnet("app.test.com", "10.1.2.0", 24), want: []string{"10.1.0.10"}, wantScope: 24},
+		{name: "name with tagged records for another subnet", query: withSubnet("app.test.com", "10.9.2.0", 24), want: []string{"192.0.2.10"}, wantScope: 24},
+		{name: "name without tagged records", query: withSubnet("plain.test.com", "10.1.2.0", 24), want: []string{"192.0.2.20"}},
+		{name: "forwarded without the subnet", query: withSubnet("www.example.com", "10.1.2.0", 24), want: []string{"192.0.2.1"}},
+		{name: "forwarded with the subnet", forward: true, query: withSubnet("www.example.com", "10.1.2.0", 24), want: []string{"192.0.2.1"}, wantScope: 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+				ECS:        ECSConfig{Enabled: true, TrustedClients: []string{"127.0.0.1"}, Forward: tt.forward},
+				Records:    records,
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := serve(s, tt.query)
+			if resp == nil {
+				t.Fatal("query was dropped")
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			subnet := subnetOption(resp)
+			if subnet == nil {
+				t.Fatal("response has no client subnet option")
+			}
+			if subnet.SourceNetmask != 24 || subnet.SourceScope != tt.wantScope {
+				t.Errorf("got source prefix %d scope %d, want 24 and %d", subnet.SourceNetmask, subnet.SourceScope, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestForwardedQuerySubnet(t *testing.T) {
+	subnet := newSubnetOption(net.ParseIP("10.1.2.3"), 24)
+	tests := []struct {
+		name       string
+		config     ECSConfig
+		subnet     *dns.EDNS0_SUBNET
+		wantSubnet bool
+	}{
+		{name: "forwarded", config: ECSConfig{Enabled: true, Forward: true}, subnet: subnet, wantSubnet: true},
+		{name: "removed without forward", config: ECSConfig{Enabled: true}, subnet: subnet},
+		{name: "hidden subnet is not forwarded", config: ECSConfig{Enabled: true, Forward: true}, subnet: newSubnetOption(net.ParseIP("10.1.2.3"), 0)},
+		{name: "disabled keeps the query", config: ECSConfig{}, subnet: subnet, wantSubnet: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := withSubnet("www.example.com", "10.1.2.0", 24)
+			forwarded := tt.config.forwardedQuery(query, tt.subnet)
+			if got := subnetOption(forwarded) != nil; got != tt.wantSubnet {
+				t.Errorf("forwarded query has a subnet option: %v, want %v", got, tt.wantSubnet)
+			}
+			if subnetOption(query) == nil {
+				t.Error("forwardedQuery modified the original query")
+			}
+		})
+	}
+}
+
+func TestForwardedAnswersCachedPerSubnet(t *testing.T) {
+	// The upstream answers each subnet with another address
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		address := "192.0.2.1"
+		if subnet := subnetOption(r); subnet != nil && subnet.Address.Equal(net.ParseIP("10.2.0.0")) {
+			address = "192.0.2.2"
+		}
+		answerA(address)(w, r)
+	})
+	s, err := New(&Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Cache:      CacheConfig{Enabled: true},
+		ECS:        ECSConfig{Enabled: true, TrustedClients: []string{"127.0.0.1"}, Forward: true, SourcePrefixV4: 16},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		address     string
+		want        []string
+		wantQueries int64
+	}{
+		{name: "first subnet", address: "10.1.2.0", want: []string{"192.0.2.1"}, wantQueries: 1},
+		{name: "first subnet from cache", address: "10.1.9.0", want: []string{"192.0.2.1"}, wantQueries: 1},
+		{name: "other subnet", address: "10.2.2.0", want: []string{"192.0.2.2"}, wantQueries: 2},
+	}
+	for _, tt := range tests {
+		resp := serve(s, withSubnet("www.example.com", tt.address, 24))
+		if resp == nil {
+			t.Fatalf("%s: query was dropped", tt.name)
+		}
+		if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if queries := up.queries.Load(); queries != tt.wantQueries {
+			t.Errorf("%s: upstream was asked %d times, want %d", tt.name, queries, tt.wantQueries)
+		}
+	}
+}
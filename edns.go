@@ -0,0 +1,40 @@
+package easydns
+
+import "github.com/miekg/dns"
+
+// defaultEDNSUDPSize is the default UDP payload size advertised in
+// responses, the value recommended to avoid IP fragmentation
+const defaultEDNSUDPSize = 1232
+
+// ednsUDPSize returns the configured EDNS0 UDP payload size
+func (c ServerConfig) ednsUDPSize() int {
+	if c.EDNSUDPSize == 0 {
+		return defaultEDNSUDPSize
+	}
+	return c.EDNSUDPSize
+}
+
+// maxResponseSize returns the largest response the client can receive:
+// 512 bytes over plain UDP, the advertised EDNS0 buffer size up to udpSize
+// if there is one, and the message size limit over TCP
+func maxResponseSize(w dns.ResponseWriter, r *dns.Msg, udpSize int) int {
+	if w.RemoteAddr().Network() == "tcp" {
+		return dns.MaxMsgSize
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		// Sizes below 512 are treated as 512, see RFC 6891
+		return max(min(int(opt.UDPSize()), udpSize), dns.MinMsgSize)
+	}
+	return dns.MinMsgSize
+}
+
+// setEdns0 adds an OPT record advertising udpSize to the response to r if
+// r has one, echoing its DNSSEC OK bit. Responses to queries without EDNS0
+// are left alone.
+func setEdns0(msg, r *dns.Msg, udpSize int) {
+	opt := r.IsEdns0()
+	if opt == nil || msg.IsEdns0() != nil {
+		return
+	}
+	msg.SetEdns0(uint16(udpSize), opt.Do())
+}
@@ -0,0 +1,97 @@
+package easydns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMaxResponseSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		edns     uint16 // Advertised size, 0 for no OPT record
+		udpSize  int
+		wantSize int
+	}{
+		{name: "plain UDP", network: "udp", udpSize: 1232, wantSize: 512},
+		{name: "EDNS0 below the limit", network: "udp", edns: 1000, udpSize: 1232, wantSize: 1000},
+		{name: "EDNS0 above the limit", network: "udp", edns: 4096, udpSize: 1232, wantSize: 1232},
+		{name: "EDNS0 below 512", network: "udp", edns: 100, udpSize: 1232, wantSize: 512},
+		{name: "TCP", network: "tcp", edns: 1000, udpSize: 1232, wantSize: dns.MaxMsgSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion("app.test.com.", dns.TypeA)
+			if tt.edns != 0 {
+				r.SetEdns0(tt.edns, false)
+			}
+			if got := maxResponseSize(newRecorder(tt.network, "127.0.0.1"), r, tt.udpSize); got != tt.wantSize {
+				t.Errorf("got %d, want %d", got, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestEDNSResponses(t *testing.T) {
+	// About 1000 bytes of TXT strings, more than fits in 512 bytes
+	var texts []string
+	for _, c := range "abcdef" {
+		texts = append(texts, `"`+strings.Repeat(string(c), 150)+`"`)
+	}
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"big.test.com": {{Type: "TXT", Value: strings.Join(texts, " "), TTL: 60}}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		edns          uint16
+		do            bool
+		wantTruncated bool
+		wantSize      uint16 // Advertised size of the response, 0 for no OPT record
+	}{
+		{name: "without EDNS0", wantTruncated: true},
+		{name: "EDNS0 with a small buffer", edns: 512, wantTruncated: true, wantSize: 1232},
+		{name: "EDNS0 with a large buffer", edns: 4096, wantSize: 1232},
+		{name: "EDNS0 with DNSSEC OK", edns: 4096, do: true, wantSize: 1232},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion("big.test.com.", dns.TypeTXT)
+			if tt.edns != 0 {
+				query.SetEdns0(tt.edns, tt.do)
+			}
+			resp := serve(s, query)
+			if resp == nil {
+				t.Fatal("query was dropped")
+			}
+			if resp.Truncated != tt.wantTruncated {
+				t.Errorf("got truncated %v, want %v", resp.Truncated, tt.wantTruncated)
+			}
+			if !tt.wantTruncated && len(resp.Answer) != 1 {
+				t.Errorf("got %d answers, want 1", len(resp.Answer))
+			}
+			opt := resp.IsEdns0()
+			if tt.wantSize == 0 {
+				if opt != nil {
+					t.Errorf("got OPT record %v for a query without one", opt)
+				}
+				return
+			}
+			if opt == nil {
+				t.Fatal("no OPT record in the response")
+			}
+			if opt.UDPSize() != tt.wantSize || opt.Do() != tt.do {
+				t.Errorf("got size %d DO %v, want size %d DO %v", opt.UDPSize(), opt.Do(), tt.wantSize, tt.do)
+			}
+		})
+	}
+}
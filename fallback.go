@@ -0,0 +1,61 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const fallbackAddressTTL = 60
+
+// FallbackConfig decides how queries are answered that match no record
+// while forwarding is disabled
+type FallbackConfig struct {
+	// Mode is one of "nxdomain" (the default), "noerror" (empty answer),
+	// "refused", "notimp", "drop" (send no response) or "address"
+	Mode string `json:"mode,omitempty"`
+	// Address is answered for A or AAAA queries in "address" mode
+	Address string `json:"address,omitempty"`
+}
+
+// validate checks the fallback mode and address
+func (f FallbackConfig) validate() error {
+	switch strings.ToLower(f.Mode) {
+	case "", "noerror", "nxdomain", "refused", "notimp", "drop":
+		return nil
+	case "address":
+		if net.ParseIP(f.Address) == nil {
+			return fmt.Errorf("fallback address %q is not an IP address", f.Address)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown fallback mode %q", f.Mode)
+	}
+}
+
+// apply answers q in msg according to the fallback mode. It returns false
+// when the query should be dropped without a response.
+func (f FallbackConfig) apply(msg *dns.Msg, q dns.Question) bool {
+	switch strings.ToLower(f.Mode) {
+	case "", "nxdomain":
+		msg.Rcode = dns.RcodeNameError
+	case "noerror":
+	case "refused":
+		msg.Rcode = dns.RcodeRefused
+	case "notimp":
+		msg.Rcode = dns.RcodeNotImplemented
+	case "drop":
+		return false
+	case "address":
+		ip := net.ParseIP(f.Address)
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: fallbackAddressTTL}
+		if ip4 := ip.To4(); ip4 != nil && q.Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
+		} else if ip4 == nil && q.Qtype == dns.TypeAAAA {
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestFallbackModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		fallback  FallbackConfig
+		qname     string
+		qtype     uint16
+		wantDrop  bool
+		wantRcode int
+		want      []string
+	}{
+		{name: "default is nxdomain", qname: "missing.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+		{name: "nxdomain", fallback: FallbackConfig{Mode: "nxdomain"}, qname: "missing.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+		{name: "noerror", fallback: FallbackConfig{Mode: "noerror"}, qname: "missing.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess},
+		{name: "refused", fallback: FallbackConfig{Mode: "REFUSED"}, qname: "missing.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeRefused},
+		{name: "notimp", fallback: FallbackConfig{Mode: "notimp"}, qname: "missing.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeNotImplemented},
+		{name: "drop", fallback: FallbackConfig{Mode: "drop"}, qname: "missing.example.com", qtype: dns.TypeA, wantDrop: true},
+		{name: "address for A", fallback: FallbackConfig{Mode: "address", Address: "10.0.0.99"}, qname: "missing.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"10.0.0.99"}},
+		{name: "address for another type", fallback: FallbackConfig{Mode: "address", Address: "10.0.0.99"}, qname: "missing.example.com", qtype: dns.TypeAAAA, wantRcode: dns.RcodeSuccess},
+		{name: "local record wins", fallback: FallbackConfig{Mode: "refused"}, qname: "app.example.com", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, want: []string{"10.0.0.1"}},
+		{name: "authoritative zone answers nxdomain", fallback: FallbackConfig{Mode: "address", Address: "10.0.0.99"}, qname: "missing.zone.test", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(&Config{
+				Server:             ServerConfig{Port: "53"},
+				Records:            Records{"app.example.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+				AuthoritativeZones: []string{"zone.test"},
+				Fallback:           tt.fallback,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := new(dns.Msg)
+			query.SetQuestion(dns.Fqdn(tt.qname), tt.qtype)
+			resp := serve(s, query)
+			if tt.wantDrop {
+				if resp != nil {
+					t.Fatalf("got response %v, want it dropped", resp)
+				}
+				return
+			}
+			if resp == nil {
+				t.Fatal("query was dropped")
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFallback(t *testing.T) {
+	tests := []struct {
+		fallback FallbackConfig
+		wantErr  bool
+	}{
+		{fallback: FallbackConfig{}},
+		{fallback: FallbackConfig{Mode: "drop"}},
+		{fallback: FallbackConfig{Mode: "address", Address: "2001:db8::1"}},
+		{fallback: FallbackConfig{Mode: "address", Address: "nowhere"}, wantErr: true},
+		{fallback: FallbackConfig{Mode: "servfail"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fallback.Mode+" "+tt.fallback.Address, func(t *testing.T) {
+			if err := tt.fallback.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package easydns
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultFailureThreshold = 3
+	initialUpstreamBackoff  = 5 * time.Second
+	maxUpstreamBackoff      = 5 * time.Minute
+)
+
+// UpstreamRcodeError is returned for upstream responses that signal a
+// failure of the server rather than an answer, such as SERVFAIL
+type UpstreamRcodeError struct {
+	server string
+	rcode  int
+}
+
+func (e UpstreamRcodeError) Error() string {
+	return fmt.Sprintf("%s answered %s", e.server, dns.RcodeToString[e.rcode])
+}
+
+// isFailureRcode reports whether rcode means the server failed to answer
+func isFailureRcode(rcode int) bool {
+	return rcode == dns.RcodeServerFailure || rcode == dns.RcodeRefused
+}
+
+type upstreamState struct {
+	failures  int
+	backoff   time.Duration
+	downUntil time.Time
+	rtt       time.Duration // Smoothed exchange duration, 0 until measured
+}
+
+// upstreamHealth tracks the health and response time of upstream servers.
+// Servers failing threshold times in a row are skipped for a backoff that
+// doubles on every further failure.
+type upstreamHealth struct {
+	mu      sync.Mutex
+	servers map[string]*upstreamState
+	next    int
+}
+
+func newUpstreamHealth() *upstreamHealth {
+	return &upstreamHealth{servers: map[string]*upstreamState{}}
+}
+
+// state returns the state of server, the caller must hold mu
+func (h *upstreamHealth) state(server string) *upstreamState {
+	state, found := h.servers[server]
+	if !found {
+		state = &upstreamState{}
+		h.servers[server] = state
+	}
+	return state
+}
+
+// success records a successful exchange that took rtt
+func (h *upstreamHealth) success(server string, rtt time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.state(server)
+	if !state.downUntil.IsZero() {
+		log.Printf("upstream %s is healthy again", server)
+	}
+	state.failures, state.backoff, state.downUntil = 0, 0, time.Time{}
+	if state.rtt == 0 {
+		state.rtt = rtt
+	} else {
+		state.rtt = (7*state.rtt + 3*rtt) / 10
+	}
+}
+
+// failure records a failed exchange and takes the server out of rotation
+// once it failed threshold times in a row
+func (h *upstreamHealth) failure(server string, threshold int) {
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.state(server)
+	state.failures++
+	if state.failures < threshold {
+		return
+	}
+	wasDown := !state.downUntil.IsZero()
+	if state.backoff == 0 {
+		state.backoff = initialUpstreamBackoff
+	} else {
+		state.backoff = min(2*state.backoff, maxUpstreamBackoff)
+	}
+	state.downUntil = now().Add(state.backoff)
+	if !wasDown {
+		log.Printf("upstream %s failed %d times in a row, skipping it for %s", server, state.failures, state.backoff)
+	}
+}
+
+// order returns the servers to try for a query in the order given by
+// strategy, leaving out servers that are down. If all of them are down all
+// are returned, so queries are never left without a server.
+func (h *upstreamHealth) order(servers []string, strategy string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	current := now()
+	var ordered []string
+	for _, server := range servers {
+		if state, found := h.servers[server]; !found || !current.Before(state.downUntil) {
+			ordered = append(ordered, server)
+		}
+	}
+	if len(ordered) == 0 {
+		ordered = slices.Clone(servers)
+	}
+	switch strategy {
+	case "round-robin":
+		h.next++
+		shift := h.next % len(ordered)
+		ordered = slices.Concat(ordered[shift:], ordered[:shift])
+	case "fastest", "parallel":
+		// Unmeasured servers have an rtt of 0 and are tried first
+		slices.SortStableFunc(ordered, func(a, b string) int {
+			return cmp.Compare(h.state(a).rtt, h.state(b).rtt)
+		})
+	}
+	return ordered
+}
+
+// upstreamServers returns every distinct configured upstream server
+func upstreamServers(forwarding ForwardingConfig) []string {
+	servers := slices.Clone(forwarding.Servers)
+	for _, zoneServers := range forwarding.ConditionalForwarding {
+		servers = append(servers, zoneServers...)
+	}
+	slices.Sort(servers)
+	return slices.Compact(servers)
+}
+
+// probeUpstream sends a query for the root NS records to server and records
+// the outcome
+func (s *Server) probeUpstream(server string, forwarding ForwardingConfig, timeout time.Duration) {
+	query := new(dns.Msg)
+	query.SetQuestion(".", dns.TypeNS)
+	start := time.Now()
+	transport, address := splitUpstream(server, forwarding.Transport)
+	resp, err := s.upstreams.exchange(context.Background(), query, transport, address, timeout)
+	if err == nil && isFailureRcode(resp.Rcode) {
+		err = UpstreamRcodeError{server: server, rcode: resp.Rcode}
+	}
+	if err != nil {
+		s.health.failure(server, forwarding.FailureThreshold)
+		return
+	}
+	s.health.success(server, time.Since(start))
+}
+
+// probeUpstreams health checks the upstream servers of the active config
+// every interval until the server is shut down
+func (s *Server) probeUpstreams(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		forwarding := s.currentConfig().Forwarding
+		if !forwarding.Enabled {
+			continue
+		}
+		// Validated with the config
+		timeout, _ := forwarding.timeout()
+		var wg sync.WaitGroup
+		for _, server := range upstreamServers(forwarding) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.probeUpstream(server, forwarding, timeout)
+			}()
+		}
+		wg.Wait()
+	}
+}
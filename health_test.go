@@ -0,0 +1,126 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestUpstreamHealthOrder(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return base }
+	servers := []string{"a:53", "b:53", "c:53"}
+	tests := []struct {
+		name     string
+		strategy string
+		setup    func(h *upstreamHealth)
+		want     [][]string // Orders of consecutive queries
+	}{
+		{name: "sequential", want: [][]string{servers, servers}},
+		{
+			name:  "server below the failure threshold is kept",
+			setup: func(h *upstreamHealth) { h.failure("a:53", 2) },
+			want:  [][]string{servers},
+		},
+		{
+			name:  "failed server is skipped",
+			setup: func(h *upstreamHealth) { h.failure("a:53", 1) },
+			want:  [][]string{{"b:53", "c:53"}},
+		},
+		{
+			name: "recovered server is used again",
+			setup: func(h *upstreamHealth) {
+				h.failure("a:53", 1)
+				h.success("a:53", time.Millisecond)
+			},
+			want: [][]string{servers},
+		},
+		{
+			name: "all servers down",
+			setup: func(h *upstreamHealth) {
+				for _, server := range servers {
+					h.failure(server, 1)
+				}
+			},
+			want: [][]string{servers},
+		},
+		{name: "round-robin", strategy: "round-robin", want: [][]string{{"b:53", "c:53", "a:53"}, {"c:53", "a:53", "b:53"}, servers}},
+		{
+			name:     "fastest",
+			strategy: "fastest",
+			setup: func(h *upstreamHealth) {
+				h.success("a:53", 30*time.Millisecond)
+				h.success("b:53", 10*time.Millisecond)
+				h.success("c:53", 20*time.Millisecond)
+			},
+			want: [][]string{{"b:53", "c:53", "a:53"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newUpstreamHealth()
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+			for i, want := range tt.want {
+				if got := h.order(servers, tt.strategy); !reflect.DeepEqual(got, want) {
+					t.Errorf("query %d: got %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamBackoff(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return base }
+	h := newUpstreamHealth()
+	servers := []string{"a:53", "b:53"}
+	h.failure("a:53", 1)
+	h.failure("a:53", 1)
+	tests := []struct {
+		after time.Duration
+		want  []string
+	}{
+		// The second failure doubled the backoff
+		{after: initialUpstreamBackoff, want: []string{"b:53"}},
+		{after: 2 * initialUpstreamBackoff, want: servers},
+	}
+	for _, tt := range tests {
+		now = func() time.Time { return base.Add(tt.after) }
+		if got := h.order(servers, ""); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("after %s: got %v, want %v", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestForwardingSkipsFailedUpstream(t *testing.T) {
+	failing := startUpstream(t, answerRcode(dns.RcodeServerFailure))
+	healthy := startUpstream(t, answerA("192.0.2.1"))
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{
+			Enabled:          true,
+			Servers:          []string{failing.addr, healthy.addr},
+			FailureThreshold: 2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		resp := ask(t, s, "www.example.com", dns.TypeA)
+		if got := answerValues(resp); !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+			t.Fatalf("got %v, want [192.0.2.1]", got)
+		}
+	}
+	// Asked until it reached the failure threshold, then skipped
+	if queries := failing.queries.Load(); queries != 2 {
+		t.Errorf("failing upstream was asked %d times, want 2", queries)
+	}
+}
@@ -0,0 +1,148 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// recordHoldDown keeps changed records of names in hold-down zones back
+// until they have been stable for the zone's hold-down, so flapping record
+// sources such as service discovery don't make answers flip back and forth
+type recordHoldDown struct {
+	mu      sync.Mutex
+	pending map[string]pendingChange // Kept back changes by record name
+	timer   *time.Timer
+}
+
+// pendingChange is the latest records of a name that differ from the
+// served ones, found is false if the name is removed
+type pendingChange struct {
+	set   []Record
+	found bool
+	since time.Time
+}
+
+func newRecordHoldDown() *recordHoldDown {
+	return &recordHoldDown{pending: map[string]pendingChange{}}
+}
+
+// parseHoldDowns parses the hold-down of each zone
+func parseHoldDowns(holdDowns map[string]string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(holdDowns))
+	for zone, value := range holdDowns {
+		if _, ok := dns.IsDomainName(zone); !ok || zone == "" {
+			return nil, fmt.Errorf("zone %q is not a valid domain name", zone)
+		}
+		holdDown, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %v", zone, err)
+		}
+		if holdDown < 0 {
+			return nil, fmt.Errorf("zone %s: hold-down %s is negative", zone, value)
+		}
+		parsed[recordName(zone)] = holdDown
+	}
+	return parsed, nil
+}
+
+// holdDownFor returns the hold-down of the most specific zone containing
+// name, 0 if there is none
+func holdDownFor(holdDowns map[string]time.Duration, name string) time.Duration {
+	best, holdDown := -1, time.Duration(0)
+	for zone, duration := range holdDowns {
+		if labels := dns.CountLabel(zone); dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) && labels > best {
+			best, holdDown = labels, duration
+		}
+	}
+	return holdDown
+}
+
+// apply returns records with the changes against served that have not been
+// stable for their hold-down replaced by the served records, and when the
+// next of them is due. It returns records itself and 0 if nothing is kept
+// back.
+func (h *recordHoldDown) apply(holdDowns map[string]time.Duration, served, records Records, now time.Time) (Records, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := map[string]bool{}
+	for name := range served {
+		names[name] = true
+	}
+	for name := range records {
+		names[name] = true
+	}
+	for name := range h.pending {
+		names[name] = true
+	}
+	result, copied := records, false
+	var due time.Duration
+	for name := range names {
+		holdDown := holdDownFor(holdDowns, name)
+		servedSet, wasServed := served[name]
+		set, found := records[name]
+		if holdDown == 0 {
+			delete(h.pending, name)
+			continue
+		}
+		if wasServed == found && reflect.DeepEqual(servedSet, set) {
+			if _, flapped := h.pending[name]; flapped {
+				log.Printf("suppressed flap of %s, it changed back within its hold-down", name)
+				delete(h.pending, name)
+			}
+			continue
+		}
+		change, waiting := h.pending[name]
+		if !waiting || change.found != found || !reflect.DeepEqual(change.set, set) {
+			if waiting {
+				log.Printf("suppressed flap of %s, it changed again within its hold-down", name)
+			}
+			change = pendingChange{set: set, found: found, since: now}
+			h.pending[name] = change
+		}
+		if remaining := holdDown - now.Sub(change.since); remaining > 0 {
+			if !copied {
+				result, copied = copyRecords(records), true
+			}
+			if wasServed {
+				result[name] = servedSet
+			} else {
+				delete(result, name)
+			}
+			if due == 0 || remaining < due {
+				due = remaining
+			}
+			continue
+		}
+		log.Printf("applying the change of %s, it was stable for its hold-down of %s", name, holdDown)
+		delete(h.pending, name)
+	}
+	return result, due
+}
+
+// schedule calls apply after due, replacing an earlier schedule. A zero due
+// cancels it.
+func (h *recordHoldDown) schedule(due time.Duration, apply func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	if due > 0 {
+		h.timer = time.AfterFunc(due, apply)
+	}
+}
+
+// copyRecords returns a shallow copy of records
+func copyRecords(records Records) Records {
+	copied := make(Records, len(records))
+	for name, set := range records {
+		copied[name] = set
+	}
+	return copied
+}
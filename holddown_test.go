@@ -0,0 +1,160 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordHoldDown(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := []Record{{Type: "A", Value: "10.0.0.1"}}
+	changed := []Record{{Type: "A", Value: "10.0.0.2"}}
+	other := []Record{{Type: "A", Value: "10.0.0.3"}}
+	added := append(append([]Record(nil), old...), other...)
+	holdDowns := map[string]time.Duration{"svc.test": 30 * time.Second}
+
+	type step struct {
+		after   time.Duration // Since start
+		records Records
+		want    Records
+		due     time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "change outside hold-down zones is applied at once",
+			steps: []step{
+				{records: Records{"app.svc.test": old, "web.test": changed}, want: Records{"app.svc.test": old, "web.test": changed}},
+			},
+		},
+		{
+			name: "change is kept back until stable",
+			steps: []step{
+				{records: Records{"app.svc.test": changed}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 10 * time.Second, records: Records{"app.svc.test": changed}, want: Records{"app.svc.test": old}, due: 20 * time.Second},
+				{after: 30 * time.Second, records: Records{"app.svc.test": changed}, want: Records{"app.svc.test": changed}},
+			},
+		},
+		{
+			name: "flap back is suppressed",
+			steps: []step{
+				{records: Records{"app.svc.test": changed}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 5 * time.Second, records: Records{"app.svc.test": old}, want: Records{"app.svc.test": old}},
+				{after: 40 * time.Second, records: Records{"app.svc.test": old}, want: Records{"app.svc.test": old}},
+			},
+		},
+		{
+			name: "another change restarts the hold-down",
+			steps: []step{
+				{records: Records{"app.svc.test": changed}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 20 * time.Second, records: Records{"app.svc.test": other}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 30 * time.Second, records: Records{"app.svc.test": other}, want: Records{"app.svc.test": old}, due: 20 * time.Second},
+				{after: 50 * time.Second, records: Records{"app.svc.test": other}, want: Records{"app.svc.test": other}},
+			},
+		},
+		{
+			name: "record added to a name is kept back with its set",
+			steps: []step{
+				{records: Records{"app.svc.test": added}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 30 * time.Second, records: Records{"app.svc.test": added}, want: Records{"app.svc.test": added}},
+			},
+		},
+		{
+			name: "removal is kept back",
+			steps: []step{
+				{records: Records{}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 30 * time.Second, records: Records{}, want: Records{}},
+			},
+		},
+		{
+			name: "new name is kept back",
+			steps: []step{
+				{records: Records{"app.svc.test": old, "new.svc.test": changed}, want: Records{"app.svc.test": old}, due: 30 * time.Second},
+				{after: 30 * time.Second, records: Records{"app.svc.test": old, "new.svc.test": changed}, want: Records{"app.svc.test": old, "new.svc.test": changed}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holdDown := newRecordHoldDown()
+			served := Records{"app.svc.test": old}
+			for i, step := range tt.steps {
+				got, due := holdDown.apply(holdDowns, served, step.records, start.Add(step.after))
+				if !reflect.DeepEqual(got, step.want) {
+					t.Fatalf("step %d: got %v, want %v", i, got, step.want)
+				}
+				if due != step.due {
+					t.Fatalf("step %d: due in %s, want %s", i, due, step.due)
+				}
+				served = got
+			}
+		})
+	}
+}
+
+func TestParseHoldDowns(t *testing.T) {
+	tests := []struct {
+		name     string
+		holdDown map[string]string
+		want     map[string]time.Duration
+		wantErr  bool
+	}{
+		{name: "none", want: map[string]time.Duration{}},
+		{name: "zone", holdDown: map[string]string{"Svc.Test.": "30s"}, want: map[string]time.Duration{"svc.test": 30 * time.Second}},
+		{name: "invalid duration", holdDown: map[string]string{"svc.test": "soon"}, wantErr: true},
+		{name: "negative", holdDown: map[string]string{"svc.test": "-1s"}, wantErr: true},
+		{name: "invalid zone", holdDown: map[string]string{"": "1s"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHoldDowns(tt.holdDown)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServedHoldDown(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	cfg := &Config{
+		Server:   ServerConfig{Port: "53"},
+		HoldDown: map[string]string{"svc.test": "30s"},
+		Records: Records{
+			"app.svc.test": {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"web.test":     {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setRecords(Records{
+		"app.svc.test": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		"web.test":     {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+	})
+	if got := answerValues(ask(t, s, "app.svc.test", dns.TypeA)); !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Errorf("app.svc.test is answered with %v during its hold-down, want the old address", got)
+	}
+	if got := answerValues(ask(t, s, "web.test", dns.TypeA)); !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("web.test is answered with %v, want the new address", got)
+	}
+	now = func() time.Time { return start.Add(30 * time.Second) }
+	s.setRecords(Records{
+		"app.svc.test": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		"web.test":     {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+	})
+	if got := answerValues(ask(t, s, "app.svc.test", dns.TypeA)); !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("app.svc.test is answered with %v after its hold-down, want the new address", got)
+	}
+}
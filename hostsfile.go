@@ -0,0 +1,155 @@
+package easydns
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const hostsFilesInterval = 5 * time.Second
+
+type HostsFileError struct {
+	file          string
+	originalError error
+}
+
+func (e HostsFileError) Error() string {
+	return fmt.Sprintf("hosts file %s is invalid: %v", e.file, e.originalError)
+}
+
+// parseHostsFile reads the entries of a hosts file, "<address> <name>..."
+// per line, into A and AAAA records and PTR records for the first name of
+// every address. Lines that can't be served are skipped.
+func parseHostsFile(data []byte) Records {
+	records := Records{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		record := Record{Type: "AAAA", Value: ip.String()}
+		if ip.To4() != nil {
+			record = Record{Type: "A", Value: ip.To4().String()}
+		}
+		first := ""
+		for _, host := range fields[1:] {
+			name := recordName(host)
+			if _, ok := dns.IsDomainName(name); !ok || name == "" {
+				continue
+			}
+			if first == "" {
+				first = name
+			}
+			if !hasRecord(records[name], record) {
+				records[name] = append(records[name], record)
+			}
+		}
+		reverse, err := dns.ReverseAddr(record.Value)
+		if first == "" || err != nil {
+			continue
+		}
+		// The first line listing an address names it, like getnameinfo
+		if reverse = recordName(reverse); len(records[reverse]) == 0 {
+			records[reverse] = []Record{{Type: "PTR", Value: first}}
+		}
+	}
+	return records
+}
+
+func hasRecord(set []Record, record Record) bool {
+	for _, existing := range set {
+		if existing.Type == record.Type && existing.Value == record.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// loadHostsFiles merges the entries of the hosts files beneath base: names
+// with records in base keep them, and the hosts entries for them and their
+// PTR records are left out. Entries of earlier files win over later ones.
+func loadHostsFiles(files []string, base Records) (Records, error) {
+	if len(files) == 0 {
+		return base, nil
+	}
+	merged := Records{}
+	defined := map[string]bool{}
+	for name, set := range base {
+		merged[recordName(name)] = set
+		defined[recordName(name)] = true
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, HostsFileError{file: file, originalError: err}
+		}
+		for name, set := range parseHostsFile(data) {
+			if _, found := merged[name]; found {
+				continue
+			}
+			if set[0].Type == "PTR" && defined[set[0].Value] {
+				continue
+			}
+			merged[name] = set
+		}
+	}
+	return merged, nil
+}
+
+// filesFingerprint summarizes files so changes can be detected without
+// reading them
+func filesFingerprint(files []string) string {
+	var b strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s missing\n", file)
+		}
+	}
+	return b.String()
+}
+
+// watchHostsFiles polls the hosts files of the active config and applies
+// the merged records whenever one changes, until the server is shut down.
+// If a file can't be read the last good records keep being served.
+func (s *Server) watchHostsFiles() {
+	last := filesFingerprint(s.currentConfig().HostsFiles)
+	ticker := time.NewTicker(hostsFilesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		fingerprint := filesFingerprint(s.currentConfig().HostsFiles)
+		if fingerprint == last {
+			continue
+		}
+		last = fingerprint
+		s.apiMu.Lock()
+		records, err := loadRecords(s.currentConfig())
+		if err != nil {
+			s.apiMu.Unlock()
+			log.Printf("rejecting hosts file change, still serving generation %d: %v", s.records.Load().generation, err)
+			continue
+		}
+		generation := s.setRecords(records)
+		s.apiMu.Unlock()
+		log.Printf("applied hosts files, serving records generation %d (%d records)", generation, len(records))
+	}
+}
@@ -0,0 +1,67 @@
+package easydns
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHostsFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "hosts")
+	second := filepath.Join(dir, "hosts.lab")
+	for file, data := range map[string]string{
+		first: `# comment
+10.0.0.5   nas.lab.test.com nas   # trailing comment
+fd00::5    nas.lab.test.com
+10.0.0.1   app.test.com
+not-an-ip  broken.test.com
+10.0.0.6
+`,
+		second: "10.0.0.7 nas.lab.test.com\n10.0.0.8 printer.lab.test.com\n",
+	} {
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(&Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Records:    Records{"app.test.com": {{Type: "A", Value: "10.0.1.1", TTL: 60}}},
+		HostsFiles: []string{first, second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		query string
+		qtype uint16
+		want  []string
+	}{
+		{name: "hosts entry", query: "nas.lab.test.com", qtype: dns.TypeA, want: []string{"10.0.0.5"}},
+		{name: "alias", query: "nas", qtype: dns.TypeA, want: []string{"10.0.0.5"}},
+		{name: "ipv6 entry", query: "nas.lab.test.com", qtype: dns.TypeAAAA, want: []string{"fd00::5"}},
+		{name: "reverse of the first name", query: "5.0.0.10.in-addr.arpa", qtype: dns.TypePTR, want: []string{"nas.lab.test.com."}},
+		{name: "config records win", query: "app.test.com", qtype: dns.TypeA, want: []string{"10.0.1.1"}},
+		{name: "no reverse for names of the config", query: "1.0.0.10.in-addr.arpa", qtype: dns.TypePTR},
+		{name: "earlier file wins", query: "nas.lab.test.com", qtype: dns.TypeA, want: []string{"10.0.0.5"}},
+		{name: "entry of a later file", query: "printer.lab.test.com", qtype: dns.TypeA, want: []string{"10.0.0.8"}},
+		{name: "invalid line", query: "broken.test.com", qtype: dns.TypeA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerValues(ask(t, s, tt.query, tt.qtype)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A missing file fails the load
+	if _, err := New(&Config{Version: currentConfigVersion, Server: ServerConfig{Port: "53"}, HostsFiles: []string{filepath.Join(dir, "missing")}}); err == nil {
+		t.Error("got no error for a missing hosts file")
+	}
+}
@@ -0,0 +1,48 @@
+package easydns
+
+import "github.com/miekg/dns"
+
+const infoTXTTTL = 300
+
+// infoZone returns the most specific configured info zone containing name
+func infoZone(infoTXT map[string]string, name string) (string, bool) {
+	best, found := "", false
+	name = dns.CanonicalName(name)
+	for zone := range infoTXT {
+		z := dns.CanonicalName(zone)
+		if dns.IsSubDomain(z, name) && (!found || dns.CountLabel(z) > dns.CountLabel(best)) {
+			best, found = zone, true
+		}
+	}
+	return best, found
+}
+
+// splitTXT splits text into the 255 byte character strings a TXT record holds
+func splitTXT(text string) []string {
+	var parts []string
+	for len(text) > 255 {
+		parts = append(parts, text[:255])
+		text = text[255:]
+	}
+	return append(parts, text)
+}
+
+// appendInfoTXT adds the informational TXT of the query's zone to the
+// additional section, unless it would push the response past maxSize
+func appendInfoTXT(infoTXT map[string]string, msg *dns.Msg, maxSize int) {
+	if len(infoTXT) == 0 || len(msg.Question) == 0 {
+		return
+	}
+	zone, found := infoZone(infoTXT, msg.Question[0].Name)
+	if !found {
+		return
+	}
+	txt := &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: infoTXTTTL},
+		Txt: splitTXT(infoTXT[zone]),
+	}
+	msg.Extra = append(msg.Extra, txt)
+	if msg.Len() > maxSize {
+		msg.Extra = msg.Extra[:len(msg.Extra)-1]
+	}
+}
@@ -0,0 +1,75 @@
+package easydns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestInfoTXT(t *testing.T) {
+	s, err := New(&Config{
+		Server: ServerConfig{Port: "53"},
+		Records: Records{
+			"app.svc.test":     {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"db.prod.svc.test": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+			"other.test":       {{Type: "A", Value: "10.0.0.3", TTL: 60}},
+		},
+		InfoTXT: map[string]string{
+			"svc.test":      "env=staging owner=platform",
+			"prod.svc.test": "env=prod owner=platform",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		qname string
+		want  []string // TXT records in the additional section
+	}{
+		{qname: "app.svc.test", want: []string{"svc.test. env=staging owner=platform"}},
+		{qname: "db.prod.svc.test", want: []string{"prod.svc.test. env=prod owner=platform"}},
+		{qname: "missing.svc.test", want: []string{"svc.test. env=staging owner=platform"}},
+		{qname: "other.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qname, func(t *testing.T) {
+			resp := ask(t, s, tt.qname, dns.TypeA)
+			var got []string
+			for _, rr := range resp.Extra {
+				if txt, ok := rr.(*dns.TXT); ok {
+					got = append(got, txt.Hdr.Name+" "+strings.Join(txt.Txt, ""))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendInfoTXTSizeLimit(t *testing.T) {
+	infoTXT := map[string]string{"svc.test": strings.Repeat("x", 600)}
+	tests := []struct {
+		name    string
+		maxSize int
+		want    int // Records in the additional section
+	}{
+		{name: "fits", maxSize: 4096, want: 1},
+		{name: "too large", maxSize: 512, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.SetQuestion("app.svc.test.", dns.TypeA)
+			appendInfoTXT(infoTXT, msg, tt.maxSize)
+			if len(msg.Extra) != tt.want {
+				t.Errorf("got %d additional records, want %d", len(msg.Extra), tt.want)
+			}
+			if msg.Len() > tt.maxSize {
+				t.Errorf("response is %d bytes, over %d", msg.Len(), tt.maxSize)
+			}
+		})
+	}
+}
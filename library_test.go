@@ -0,0 +1,87 @@
+package easydns_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/phasi/easydns"
+)
+
+// freePort returns a port free for both UDP and TCP on 127.0.0.1
+func freePort(t *testing.T) string {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		conn, err := net.ListenPacket("udp", "127.0.0.1:"+strconv.Itoa(port))
+		listener.Close()
+		if err == nil {
+			conn.Close()
+			return strconv.Itoa(port)
+		}
+	}
+	t.Fatal("no free port")
+	return ""
+}
+
+func TestEmbeddedServer(t *testing.T) {
+	port := freePort(t)
+	cfg := &easydns.Config{
+		Version: 1,
+		Server:  easydns.ServerConfig{BindAddress: "127.0.0.1", Port: port},
+		Records: easydns.Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	s, err := easydns.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	query := new(dns.Msg)
+	query.SetQuestion("app.test.com.", dns.TypeA)
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			client := &dns.Client{Net: network, Timeout: 200 * time.Millisecond}
+			var resp *dns.Msg
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+				if resp, _, err = client.Exchange(query, net.JoinHostPort("127.0.0.1", port)); err == nil {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rr := range resp.Answer {
+				got = append(got, rr.(*dns.A).A.String())
+			}
+			if !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+				t.Errorf("got %v, want [10.0.0.1]", got)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ListenAndServe returned %v", err)
+		}
+	case <-ctx.Done():
+		t.Error("ListenAndServe did not return after Shutdown")
+	}
+}
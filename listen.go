@@ -0,0 +1,341 @@
+package easydns
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const interfacePrefix = "iface:"
+
+// isInterfaceBinding reports whether the bind address names a network interface
+func isInterfaceBinding(bindAddress string) bool {
+	return strings.HasPrefix(bindAddress, interfacePrefix)
+}
+
+// resolveBindAddresses turns the configured bind address into the list of
+// addresses to listen on. A value of the form "iface:<name>" resolves to the
+// current addresses of that interface, anything else is used as is.
+func resolveBindAddresses(bindAddress string) ([]string, error) {
+	if !isInterfaceBinding(bindAddress) {
+		return []string{bindAddress}, nil
+	}
+	name := strings.TrimPrefix(bindAddress, interfacePrefix)
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q not found: %v", name, err)
+	}
+	ifaceAddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read addresses of interface %q: %v", name, err)
+	}
+	var addresses []string
+	for _, addr := range ifaceAddrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		address := ipNet.IP.String()
+		if ipNet.IP.IsLinkLocalUnicast() && ipNet.IP.To4() == nil {
+			address += "%" + name
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("interface %q has no addresses", name)
+	}
+	return addresses, nil
+}
+
+// RFC 7766 6.2.3 suggests a few seconds
+const defaultTCPIdleTimeout = 8 * time.Second
+
+// tcpIdleTimeout returns how long idle TCP connections are kept open
+func (c ServerConfig) tcpIdleTimeout() (time.Duration, error) {
+	if c.TCPIdleTimeout == "" {
+		return defaultTCPIdleTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.TCPIdleTimeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid tcp_idle_timeout %q", c.TCPIdleTimeout)
+	}
+	return timeout, nil
+}
+
+const (
+	initialBindBackoff = 250 * time.Millisecond
+	maxBindBackoff     = 5 * time.Second
+)
+
+// bindRetry returns how long binding a busy address is retried
+func (c ServerConfig) bindRetry() (time.Duration, error) {
+	if c.BindRetry == "" {
+		return 0, nil
+	}
+	retry, err := time.ParseDuration(c.BindRetry)
+	if err != nil || retry < 0 {
+		return 0, fmt.Errorf("invalid bind_retry %q", c.BindRetry)
+	}
+	return retry, nil
+}
+
+// listenProtocols validates the configured protocols, defaulting to both
+// UDP and TCP as DNS requires TCP fallback for large responses
+func listenProtocols(protocols []string) ([]string, error) {
+	if len(protocols) == 0 {
+		return []string{"udp", "tcp"}, nil
+	}
+	seen := map[string]bool{}
+	for _, protocol := range protocols {
+		if protocol != "udp" && protocol != "tcp" {
+			return nil, fmt.Errorf("unsupported protocol %q", protocol)
+		}
+		if seen[protocol] {
+			return nil, fmt.Errorf("protocol %q is listed twice", protocol)
+		}
+		seen[protocol] = true
+	}
+	return protocols, nil
+}
+
+type listenerKey struct {
+	network string
+	addr    string
+}
+
+// listeners tracks one running DNS server per bind address and protocol
+type listeners struct {
+	mu          sync.Mutex
+	port        string
+	protocols   []string
+	bindRetry   time.Duration
+	idleTimeout time.Duration
+	tsigSecret  map[string]string
+	handler     dns.Handler
+	servers     map[listenerKey]*dns.Server
+	conns       *connTracker // Open TCP connections
+	activated   bool         // Serving sockets from socket activation
+}
+
+// newListeners creates an empty listener set serving queries with handler.
+// Binding an address is retried with backoff for up to bindRetry before
+// giving up, idle TCP connections are closed after idleTimeout. TSIG signed
+// messages are verified with the secrets in tsigSecret.
+func newListeners(port string, protocols []string, bindRetry, idleTimeout time.Duration, tsigSecret map[string]string, handler dns.Handler) *listeners {
+	return &listeners{
+		port:        port,
+		protocols:   protocols,
+		bindRetry:   bindRetry,
+		idleTimeout: idleTimeout,
+		tsigSecret:  tsigSecret,
+		handler:     handler,
+		servers:     map[listenerKey]*dns.Server{},
+		conns:       newConnTracker(),
+	}
+}
+
+// startWithRetry starts a server for key, retrying while the address is
+// busy, e.g. because a previous instance has not released it yet
+func (l *listeners) startWithRetry(key listenerKey) (*dns.Server, error) {
+	deadline := time.Now().Add(l.bindRetry)
+	backoff := initialBindBackoff
+	for attempt := 1; ; attempt++ {
+		server := l.newServer(key)
+		err := startServer(server, l.conns)
+		if err == nil {
+			return server, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		log.Printf("bind attempt %d on %s/%s failed: %v, retrying in %s", attempt, key.addr, key.network, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBindBackoff)
+	}
+}
+
+// newServer returns a server for one address and protocol
+func (l *listeners) newServer(key listenerKey) *dns.Server {
+	server := &dns.Server{
+		Addr:          key.addr,
+		Net:           key.network,
+		Handler:       l.handler,
+		TsigSecret:    l.tsigSecret,
+		MsgAcceptFunc: acceptMsg,
+	}
+	if key.network == "tcp" {
+		idleTimeout := l.idleTimeout
+		server.IdleTimeout = func() time.Duration { return idleTimeout }
+	}
+	return server
+}
+
+// startServer starts server and waits until it is bound. The connections
+// of a TCP or TLS server are tracked in conns.
+func startServer(server *dns.Server, conns *connTracker) error {
+	switch {
+	case server.Listener != nil:
+		// Bound already, e.g. by socket activation
+	case server.Net == "tcp":
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		server.Listener = conns.track(listener)
+	case server.Net == "tcp-tls":
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		server.Listener = tls.NewListener(conns.track(listener), server.TLSConfig)
+	}
+	started := make(chan struct{})
+	errs := make(chan error, 1)
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() {
+		if server.Listener != nil || server.PacketConn != nil {
+			errs <- server.ActivateAndServe()
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+	select {
+	case <-started:
+		go func() {
+			if err := <-errs; err != nil {
+				log.Printf("DNS server on %s/%s stopped: %v", server.Addr, server.Net, err)
+			}
+		}()
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+// update starts servers for new addresses and stops servers for addresses
+// that are no longer present. New servers are bound in parallel, so one
+// address waiting for bind_retry does not hold up the others.
+func (l *listeners) update(addresses []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.activated {
+		// The sockets are owned by systemd and cannot be rebound
+		return nil
+	}
+	wanted := map[listenerKey]bool{}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex // Guards started and errs
+		started = map[listenerKey]*dns.Server{}
+		errs    []error
+	)
+	for _, address := range addresses {
+		addr := net.JoinHostPort(address, l.port)
+		for _, network := range l.protocols {
+			key := listenerKey{network: network, addr: addr}
+			wanted[key] = true
+			if _, running := l.servers[key]; running {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				server, err := l.startWithRetry(key)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to listen on %s/%s: %v", key.addr, key.network, err))
+					return
+				}
+				log.Printf("starting DNS server on %s/%s", key.addr, key.network)
+				started[key] = server
+			}()
+		}
+	}
+	wg.Wait()
+	// Only this goroutine touches l.servers, the ones above report back
+	// through started
+	maps.Copy(l.servers, started)
+	for key, server := range l.servers {
+		if wanted[key] {
+			continue
+		}
+		if err := server.Shutdown(); err != nil {
+			log.Printf("failed to stop DNS server on %s/%s: %v", key.addr, key.network, err)
+		}
+		log.Printf("stopped DNS server on %s/%s", key.addr, key.network)
+		delete(l.servers, key)
+	}
+	return errors.Join(errs...)
+}
+
+// activate serves queries on sockets passed by socket activation instead
+// of binding addresses. Stream sockets are served as TCP, datagram sockets
+// as UDP.
+func (l *listeners) activate(files []*os.File) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.activated = true
+	var errs []error
+	for _, file := range files {
+		var server *dns.Server
+		if listener, err := net.FileListener(file); err == nil {
+			server = l.newServer(listenerKey{network: "tcp", addr: listener.Addr().String()})
+			server.Listener = l.conns.track(listener)
+		} else if conn, err := net.FilePacketConn(file); err == nil {
+			server = l.newServer(listenerKey{network: "udp", addr: conn.LocalAddr().String()})
+			server.PacketConn = conn
+		} else {
+			errs = append(errs, fmt.Errorf("socket %s is neither a stream nor a datagram socket", file.Name()))
+			continue
+		}
+		// The listener holds its own copy of the descriptor
+		file.Close()
+		if err := startServer(server, l.conns); err != nil {
+			errs = append(errs, fmt.Errorf("failed to serve socket %s: %v", file.Name(), err))
+			continue
+		}
+		log.Printf("starting DNS server on activated socket %s/%s", server.Addr, server.Net)
+		l.servers[listenerKey{network: server.Net, addr: server.Addr}] = server
+	}
+	return errors.Join(errs...)
+}
+
+// close stops accepting queries on all servers at once and waits until the
+// queries in flight are answered or ctx is done, TCP connections still open
+// then are force-closed
+func (l *listeners) close(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conns.drain("DNS servers", func() error {
+		var (
+			wg      sync.WaitGroup
+			stopped sync.Mutex
+			errs    []error
+		)
+		for key, server := range l.servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := server.ShutdownContext(ctx); err != nil {
+					stopped.Lock()
+					errs = append(errs, fmt.Errorf("failed to stop DNS server on %s/%s: %v", key.addr, key.network, err))
+					stopped.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		clear(l.servers)
+		return errors.Join(errs...)
+	})
+}
@@ -0,0 +1,255 @@
+package easydns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTCPIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		wantClosed  bool
+	}{
+		{name: "idle connection is closed", idleTimeout: 100 * time.Millisecond, wantClosed: true},
+		{name: "connection within the timeout stays open", idleTimeout: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+				resp := new(dns.Msg)
+				resp.SetReply(r)
+				w.WriteMsg(resp)
+			})
+			l := newListeners("0", []string{"tcp"}, 0, tt.idleTimeout, nil, handler)
+			server := l.newServer(listenerKey{network: "tcp", addr: "127.0.0.1:0"})
+			if err := startServer(server, l.conns); err != nil {
+				t.Fatal(err)
+			}
+			defer server.Shutdown()
+			conn, err := dns.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			query := new(dns.Msg)
+			query.SetQuestion("app.test.com.", dns.TypeA)
+			if err := conn.WriteMsg(query); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.ReadMsg(); err != nil {
+				t.Fatal(err)
+			}
+			// Sit idle for longer than the short timeout
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.ReadMsg()
+			var netErr net.Error
+			timedOut := errors.As(err, &netErr) && netErr.Timeout()
+			if closed := err != nil && !timedOut; closed != tt.wantClosed {
+				t.Errorf("connection closed is %v (%v), want %v", closed, err, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestTCPIdleTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		timeout string
+		want    time.Duration
+		wantErr bool
+	}{
+		{want: defaultTCPIdleTimeout},
+		{timeout: "30s", want: 30 * time.Second},
+		{timeout: "0s", wantErr: true},
+		{timeout: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timeout, func(t *testing.T) {
+			cfg := &Config{Server: ServerConfig{Port: "53", TCPIdleTimeout: tt.timeout}}
+			if err := ValidateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got, _ := cfg.Server.tcpIdleTimeout(); !tt.wantErr && got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindRetry(t *testing.T) {
+	busy, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := busy.LocalAddr().String()
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {})
+	key := listenerKey{network: "udp", addr: addr}
+	if _, err := newListeners("0", []string{"udp"}, 0, defaultTCPIdleTimeout, nil, handler).startWithRetry(key); err == nil {
+		t.Fatal("bound a busy address without retrying")
+	}
+	// Release the address while the listener is retrying
+	time.AfterFunc(300*time.Millisecond, func() { busy.Close() })
+	server, err := newListeners("0", []string{"udp"}, 5*time.Second, defaultTCPIdleTimeout, nil, handler).startWithRetry(key)
+	if err != nil {
+		t.Fatalf("binding with retries failed: %v", err)
+	}
+	server.Shutdown()
+}
+
+func TestListenProtocols(t *testing.T) {
+	tests := []struct {
+		protocols []string
+		want      []string
+		wantErr   bool
+	}{
+		{want: []string{"udp", "tcp"}},
+		{protocols: []string{"tcp"}, want: []string{"tcp"}},
+		{protocols: []string{"udp", "udp"}, wantErr: true},
+		{protocols: []string{"sctp"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.protocols, ","), func(t *testing.T) {
+			got, err := listenProtocols(tt.protocols)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenersUpdate(t *testing.T) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		w.WriteMsg(resp)
+	})
+	l := newListeners("0", []string{"udp", "tcp"}, 0, defaultTCPIdleTimeout, nil, handler)
+	// Both protocols of both addresses are bound at the same time
+	if err := l.update([]string{"127.0.0.1", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.servers) != 4 {
+		t.Fatalf("got %d servers, want 4", len(l.servers))
+	}
+	for key, server := range l.servers {
+		addr := ""
+		if key.network == "tcp" {
+			addr = server.Listener.Addr().String()
+		} else {
+			addr = server.PacketConn.LocalAddr().String()
+		}
+		query := new(dns.Msg)
+		query.SetQuestion("app.test.com.", dns.TypeA)
+		client := &dns.Client{Net: key.network, Timeout: 2 * time.Second}
+		if _, _, err := client.Exchange(query, addr); err != nil {
+			t.Errorf("query over %s to %s failed: %v", key.network, addr, err)
+		}
+	}
+	if err := l.update([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	for key := range l.servers {
+		if key.addr != "127.0.0.1:0" {
+			t.Errorf("server on %s/%s is still running", key.addr, key.network)
+		}
+	}
+	if err := l.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenersActivate(t *testing.T) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		w.WriteMsg(resp)
+	})
+	// Sockets as systemd would pass them
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := map[string]string{"tcp": listener.Addr().String(), "udp": conn.LocalAddr().String()}
+	stream, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagram, err := conn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	conn.Close()
+
+	l := newListeners("0", []string{"udp", "tcp"}, 0, defaultTCPIdleTimeout, nil, handler)
+	if err := l.activate([]*os.File{stream, datagram}); err != nil {
+		t.Fatal(err)
+	}
+	defer l.close(context.Background())
+	// Activated sockets are not rebound
+	if err := l.update([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.servers) != 2 {
+		t.Fatalf("got %d servers, want 2", len(l.servers))
+	}
+	for network, addr := range addrs {
+		query := new(dns.Msg)
+		query.SetQuestion("app.test.com.", dns.TypeA)
+		client := &dns.Client{Net: network, Timeout: 2 * time.Second}
+		if _, _, err := client.Exchange(query, addr); err != nil {
+			t.Errorf("query over %s to activated socket %s failed: %v", network, addr, err)
+		}
+	}
+}
+
+func TestTruncatesOversizedUDPResponses(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	s, err := New(&Config{
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"big.test.com": {{Type: "TXT", Value: fmt.Sprintf("%q %q %q", long, long, long), TTL: 60}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		network       string
+		wantTruncated bool
+	}{
+		{network: "udp", wantTruncated: true},
+		{network: "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion("big.test.com.", dns.TypeTXT)
+			w := newRecorder(tt.network, "127.0.0.1")
+			s.ServeDNS(w, query)
+			if w.msg.Truncated != tt.wantTruncated {
+				t.Errorf("TC bit is %v, want %v", w.msg.Truncated, tt.wantTruncated)
+			}
+			if size := w.msg.Len(); size > dns.MinMsgSize && tt.wantTruncated {
+				t.Errorf("truncated response is %d bytes, over %d", size, dns.MinMsgSize)
+			}
+			if len(w.msg.Answer) == 0 && !tt.wantTruncated {
+				t.Error("got no answer over TCP")
+			}
+		})
+	}
+}
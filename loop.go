@@ -0,0 +1,120 @@
+package easydns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"log"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// loopDetectOption is the EDNS0 option, from the local/experimental range,
+// used to tag queries this instance forwards upstream
+const loopDetectOption = 65001
+
+// instanceID identifies this process in forwarded queries
+var instanceID = func() []byte {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return id
+}()
+
+// tagForwardedQuery returns a copy of r to forward to server. Queries to
+// easydns servers carry this instance's loop detection option, other
+// servers would not know what to make of it.
+func tagForwardedQuery(r *dns.Msg, server string, forwarding ForwardingConfig) *dns.Msg {
+	tagged := r.Copy()
+	if !forwarding.isEasyDNS(server) {
+		return tagged
+	}
+	opt := tagged.IsEdns0()
+	if opt == nil {
+		tagged.SetEdns0(dns.DefaultMsgSize, false)
+		opt = tagged.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: loopDetectOption, Data: instanceID})
+	return tagged
+}
+
+// isEasyDNS reports whether server is listed as an easydns instance
+func (f ForwardingConfig) isEasyDNS(server string) bool {
+	for _, easydns := range f.EasyDNSServers {
+		if easydns == server {
+			return true
+		}
+	}
+	return false
+}
+
+// isForwardingLoop reports whether r is a query this instance forwarded
+// itself, i.e. an upstream sent it back to us
+func isForwardingLoop(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == loopDetectOption && bytes.Equal(local.Data, instanceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnAddress reports whether an upstream server address points at one of
+// the addresses this instance listens on
+func isOwnAddress(server string, bindAddresses []string, port string) bool {
+	_, address := splitUpstream(server, "")
+	host, serverPort, err := net.SplitHostPort(address)
+	if err != nil || serverPort != port {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, bindAddress := range bindAddresses {
+		bindIP := net.ParseIP(bindAddress)
+		if bindIP == nil || bindIP.IsUnspecified() {
+			// Listening on all addresses, so any local address is ours
+			if ip.IsLoopback() || ip.IsUnspecified() {
+				return true
+			}
+			localAddrs, _ := net.InterfaceAddrs()
+			for _, addr := range localAddrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+					return true
+				}
+			}
+		} else if bindIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutOwnAddresses drops upstream servers that would forward queries back to us
+func withoutOwnAddresses(servers []string, bindAddresses []string, port string) []string {
+	var upstreams []string
+	for _, server := range servers {
+		if isOwnAddress(server, bindAddresses, port) {
+			log.Printf("refusing to forward to own listen address %s", server)
+			continue
+		}
+		upstreams = append(upstreams, server)
+	}
+	return upstreams
+}
+
+// withoutOwnUpstreams applies withoutOwnAddresses to the global and the
+// conditional forwarding servers
+func withoutOwnUpstreams(forwarding ForwardingConfig, bindAddresses []string, port string) ForwardingConfig {
+	forwarding.Servers = withoutOwnAddresses(forwarding.Servers, bindAddresses, port)
+	conditional := make(map[string][]string, len(forwarding.ConditionalForwarding))
+	for zone, servers := range forwarding.ConditionalForwarding {
+		conditional[zone] = withoutOwnAddresses(servers, bindAddresses, port)
+	}
+	forwarding.ConditionalForwarding = conditional
+	return forwarding
+}
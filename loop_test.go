@@ -0,0 +1,45 @@
+package easydns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTagForwardedQuery(t *testing.T) {
+	forwarding := ForwardingConfig{Servers: []string{"10.0.0.53:53", "8.8.8.8:53"}, EasyDNSServers: []string{"10.0.0.53:53"}}
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	if tagged := tagForwardedQuery(query, "10.0.0.53:53", forwarding); !isForwardingLoop(tagged) {
+		t.Errorf("query to an easydns server is not tagged: %v", tagged)
+	}
+	if untagged := tagForwardedQuery(query, "8.8.8.8:53", forwarding); untagged.IsEdns0() != nil {
+		t.Errorf("query to a third-party server carries an OPT record: %v", untagged)
+	}
+	if query.IsEdns0() != nil {
+		t.Errorf("tagging changed the original query: %v", query)
+	}
+}
+
+func TestForwardingLoop(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	s, err := New(&Config{
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, EasyDNSServers: []string{up.addr}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	looped := tagForwardedQuery(query, up.addr, s.currentConfig().Forwarding)
+	if resp := serve(s, looped); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("looped query got rcode %s, want SERVFAIL", dns.RcodeToString[resp.Rcode])
+	}
+	if up.queries.Load() != 0 {
+		t.Errorf("looped query was forwarded")
+	}
+	if resp := serve(s, query); len(resp.Answer) != 1 {
+		t.Errorf("got answers %v, want the forwarded A", resp.Answer)
+	}
+}
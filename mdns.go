@@ -0,0 +1,278 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	mdnsPort = 5353
+	// mdnsTTL is served for records without a TTL, RFC 6762 10 recommends
+	// 120 seconds for records with host names
+	mdnsTTL = 120
+	// mdnsLegacyTTL caps the TTLs of answers to one-shot queries sent from
+	// other ports than 5353, RFC 6762 6.7
+	mdnsLegacyTTL = 10
+	// The top bit of the class asks for a unicast response in questions
+	// and flushes caches of other data for the name in records
+	mdnsUnicastResponse = 1 << 15
+	mdnsCacheFlush      = 1 << 15
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// MDNSConfig answers multicast DNS queries (RFC 6762) for the records whose
+// names end in .local, so devices on the LAN find them without avahi
+type MDNSConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interfaces to answer on by name, e.g. "eth0". The interface of the
+	// default route is used when empty.
+	Interfaces []string `json:"interfaces,omitempty"`
+}
+
+func (c MDNSConfig) interfaces() ([]*net.Interface, error) {
+	if len(c.Interfaces) == 0 {
+		return []*net.Interface{nil}, nil
+	}
+	ifaces := make([]*net.Interface, 0, len(c.Interfaces))
+	for _, name := range c.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown interface %q", name)
+		}
+		if iface.Flags&net.FlagMulticast == 0 {
+			return nil, fmt.Errorf("interface %s does not support multicast", name)
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+func isMDNSName(name string) bool {
+	return dns.IsSubDomain("local.", dns.CanonicalName(name)) && dns.CanonicalName(name) != "local."
+}
+
+// mdnsResponder answers mDNS queries on the multicast group of its
+// interfaces
+type mdnsResponder struct {
+	server  *Server
+	conn    *net.UDPConn
+	packets *ipv4.PacketConn
+	ifaces  []*net.Interface // A nil interface is the one of the default route
+}
+
+func newMDNSResponder(cfg MDNSConfig, s *Server) (*mdnsResponder, error) {
+	ifaces, err := cfg.interfaces()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifaces[0], mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	packets := ipv4.NewPacketConn(conn)
+	for _, iface := range ifaces[1:] {
+		if err := packets.JoinGroup(iface, mdnsGroup); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to join the mDNS group on %s: %v", iface.Name, err)
+		}
+	}
+	// Receivers discard mDNS packets with another TTL, RFC 6762 11
+	if err := packets.SetMulticastTTL(255); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := packets.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &mdnsResponder{server: s, conn: conn, packets: packets, ifaces: ifaces}, nil
+}
+
+// serves reports whether the interface with index ifIndex is answered on
+func (m *mdnsResponder) serves(ifIndex int) bool {
+	for _, iface := range m.ifaces {
+		if iface == nil || iface.Index == ifIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// serve answers queries until the responder is closed
+func (m *mdnsResponder) serve() {
+	m.announce(false)
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, cm, src, err := m.packets.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		ifIndex := 0
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+		from, ok := src.(*net.UDPAddr)
+		if !ok || !m.serves(ifIndex) {
+			continue
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(buf[:n]); err != nil || query.Response || query.Opcode != dns.OpcodeQuery {
+			continue
+		}
+		m.respond(query, from, ifIndex)
+	}
+}
+
+// respond answers query from the client at from. One-shot queries from
+// other ports and questions asking for unicast responses are answered
+// directly, all other answers go to the group.
+func (m *mdnsResponder) respond(query *dns.Msg, from *net.UDPAddr, ifIndex int) {
+	legacy := from.Port != mdnsPort
+	records := m.server.currentRecordSet().records
+	multicast, unicast := new(dns.Msg), new(dns.Msg)
+	for _, q := range query.Question {
+		if !isMDNSName(q.Name) {
+			continue
+		}
+		class := q.Qclass &^ mdnsUnicastResponse
+		if class != dns.ClassINET && class != dns.ClassANY {
+			continue
+		}
+		answers := m.answers(records, q.Name, q.Qtype, legacy)
+		answers = knownAnswersRemoved(answers, query.Answer)
+		if legacy || q.Qclass&mdnsUnicastResponse != 0 {
+			unicast.Answer = append(unicast.Answer, answers...)
+		} else {
+			multicast.Answer = append(multicast.Answer, answers...)
+		}
+	}
+	if len(unicast.Answer) > 0 {
+		if legacy {
+			// One-shot resolvers expect a regular DNS response, RFC 6762 6.7
+			unicast.Id, unicast.Question = query.Id, query.Question
+		}
+		m.send(unicast, from, ifIndex)
+	}
+	if len(multicast.Answer) > 0 {
+		m.send(multicast, mdnsGroup, ifIndex)
+	}
+}
+
+// answers returns the records of name for qtype as mDNS answers
+func (m *mdnsResponder) answers(records Records, name string, qtype uint16, legacy bool) []dns.RR {
+	_, set, found := records.lookup(recordName(name))
+	if !found {
+		return nil
+	}
+	var rrs []dns.RR
+	for _, record := range forClient(m.server.recordHealth.filter(answering(set, qtype)), nil) {
+		record = record.activeAt(now())
+		rr, err := newRR(name, record)
+		if err != nil {
+			continue
+		}
+		hdr := rr.Header()
+		if hdr.Ttl == 0 {
+			hdr.Ttl = mdnsTTL
+		}
+		if legacy {
+			hdr.Ttl = min(hdr.Ttl, mdnsLegacyTTL)
+		} else if hdr.Rrtype != dns.TypePTR {
+			// The records are ours alone, only PTR records are shared
+			hdr.Class |= mdnsCacheFlush
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// knownAnswersRemoved drops the answers the querier listed as known with
+// at least half their TTL left, RFC 6762 7.1
+func knownAnswersRemoved(answers, known []dns.RR) []dns.RR {
+	kept := answers[:0]
+	for _, rr := range answers {
+		suppressed := false
+		for _, k := range known {
+			if strings.EqualFold(k.Header().Name, rr.Header().Name) && rdata(k) == rdata(rr) && k.Header().Rrtype == rr.Header().Rrtype && k.Header().Ttl >= rr.Header().Ttl/2 {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
+
+func (m *mdnsResponder) send(msg *dns.Msg, to *net.UDPAddr, ifIndex int) {
+	msg.Response, msg.Authoritative = true, true
+	data, err := msg.Pack()
+	if err != nil {
+		log.Printf("failed to pack mDNS response: %v", err)
+		return
+	}
+	var cm *ipv4.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	if _, err := m.packets.WriteTo(data, cm, to); err != nil {
+		log.Printf("failed to send mDNS response to %s: %v", to, err)
+	}
+}
+
+// announce sends all .local records to the group on every interface, or
+// with goodbye set the same records with a TTL of 0 so they are forgotten
+func (m *mdnsResponder) announce(goodbye bool) {
+	records := m.server.currentRecordSet().records
+	msg := new(dns.Msg)
+	for name := range records {
+		if strings.Contains(name, "*") || !isMDNSName(name) {
+			continue
+		}
+		msg.Answer = append(msg.Answer, m.answers(records, dns.Fqdn(name), dns.TypeANY, false)...)
+	}
+	if len(msg.Answer) == 0 {
+		return
+	}
+	if goodbye {
+		for _, rr := range msg.Answer {
+			rr.Header().Ttl = 0
+		}
+		m.sendAll(msg)
+		return
+	}
+	m.sendAll(msg)
+	// A second announcement a second later, RFC 6762 8.3
+	time.AfterFunc(time.Second, func() {
+		select {
+		case <-m.server.done:
+		default:
+			m.sendAll(msg.Copy())
+		}
+	})
+}
+
+// sendAll sends msg to the group on every interface
+func (m *mdnsResponder) sendAll(msg *dns.Msg) {
+	for _, iface := range m.ifaces {
+		ifIndex := 0
+		if iface != nil {
+			ifIndex = iface.Index
+		}
+		m.send(msg, mdnsGroup, ifIndex)
+	}
+}
+
+// close says goodbye for the records and stops the responder
+func (m *mdnsResponder) close() error {
+	m.announce(true)
+	return m.conn.Close()
+}
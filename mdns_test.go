@@ -0,0 +1,109 @@
+package easydns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsMDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "printer.local.", want: true},
+		{name: "Printer.LOCAL", want: true},
+		{name: "_ipp._tcp.local.", want: true},
+		{name: "local.", want: false},
+		{name: "printer.test.com.", want: false},
+		{name: "printer.localhost.", want: false},
+	}
+	for _, tt := range tests {
+		if got := isMDNSName(tt.name); got != tt.want {
+			t.Errorf("isMDNSName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMDNSAnswers(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{
+			"printer.local":    {{Type: "A", Value: "10.0.0.7"}},
+			"nas.local":        {{Type: "A", Value: "10.0.0.8", TTL: 300}},
+			"_ipp._tcp.local":  {{Type: "PTR", Value: "printer._ipp._tcp.local.", TTL: 60}},
+			"printer.test.com": {{Type: "A", Value: "10.0.0.7", TTL: 60}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mdnsResponder{server: s}
+	records := s.currentRecordSet().records
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		legacy    bool
+		wantTTL   uint32
+		wantClass uint16
+	}{
+		{name: "default TTL", qname: "printer.local.", qtype: dns.TypeA, wantTTL: mdnsTTL, wantClass: dns.ClassINET | mdnsCacheFlush},
+		{name: "record TTL", qname: "nas.local.", qtype: dns.TypeA, wantTTL: 300, wantClass: dns.ClassINET | mdnsCacheFlush},
+		{name: "shared PTR", qname: "_ipp._tcp.local.", qtype: dns.TypePTR, wantTTL: 60, wantClass: dns.ClassINET},
+		{name: "legacy query", qname: "nas.local.", qtype: dns.TypeA, legacy: true, wantTTL: mdnsLegacyTTL, wantClass: dns.ClassINET},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answers := m.answers(records, tt.qname, tt.qtype, tt.legacy)
+			if len(answers) != 1 {
+				t.Fatalf("got answers %v, want one", answers)
+			}
+			if hdr := answers[0].Header(); hdr.Ttl != tt.wantTTL || hdr.Class != tt.wantClass {
+				t.Errorf("got TTL %d and class %#x, want %d and %#x", hdr.Ttl, hdr.Class, tt.wantTTL, tt.wantClass)
+			}
+		})
+	}
+	if answers := m.answers(records, "nosuch.local.", dns.TypeA, false); answers != nil {
+		t.Errorf("got answers %v for an unknown name", answers)
+	}
+}
+
+func TestKnownAnswersRemoved(t *testing.T) {
+	answer := func(ttl string) dns.RR { return mustRR(t, "printer.local. "+ttl+" IN A 10.0.0.7") }
+	tests := []struct {
+		name  string
+		known []dns.RR
+		want  int
+	}{
+		{name: "nothing known", want: 1},
+		{name: "known with enough TTL left", known: []dns.RR{answer("60")}},
+		{name: "known close to expiry", known: []dns.RR{answer("59")}, want: 1},
+		{name: "other address known", known: []dns.RR{mustRR(t, "printer.local. 120 IN A 10.0.0.9")}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := knownAnswersRemoved([]dns.RR{answer("120")}, tt.known)
+			if len(got) != tt.want {
+				t.Errorf("got answers %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMDNS(t *testing.T) {
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		MDNS:    MDNSConfig{Interfaces: []string{"nosuch0"}},
+	}
+	// Disabled settings are not checked against the interfaces of the host
+	if err := ValidateConfig(cfg); err != nil {
+		t.Errorf("got error %v for disabled mdns, want none", err)
+	}
+	cfg.MDNS.Enabled = true
+	if err := ValidateConfig(cfg); err == nil {
+		t.Error("unknown interface was accepted")
+	}
+}
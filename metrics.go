@@ -0,0 +1,80 @@
+package easydns
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsConfig enables the Prometheus metrics endpoint
+type MetricsConfig struct {
+	Address string `json:"address,omitempty"` // e.g. 127.0.0.1:9153, disabled when empty
+}
+
+// metrics are the Prometheus collectors of one server. They live in their
+// own registry so several servers can run in one process.
+type metrics struct {
+	registry         *prometheus.Registry
+	queries          prometheus.Counter
+	localAnswers     prometheus.Counter
+	forwarded        prometheus.Counter
+	upstreamFailures prometheus.Counter
+	nxdomain         prometheus.Counter
+	blocked          prometheus.Counter
+	rateLimited      prometheus.Counter
+	overloaded       prometheus.Counter
+	upstreamLatency  prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		queries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_queries_total",
+			Help: "DNS queries received.",
+		}),
+		localAnswers: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_local_answers_total",
+			Help: "Queries answered from local records.",
+		}),
+		forwarded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_forwarded_queries_total",
+			Help: "Queries forwarded to an upstream server.",
+		}),
+		upstreamFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_upstream_failures_total",
+			Help: "Failed or rejected exchanges with upstream servers.",
+		}),
+		nxdomain: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_nxdomain_responses_total",
+			Help: "Responses sent with NXDOMAIN.",
+		}),
+		blocked: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_blocked_queries_total",
+			Help: "Queries answered by the blocklist.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_rate_limited_total",
+			Help: "Queries dropped or truncated by rate limiting.",
+		}),
+		overloaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "easydns_overloaded_queries_total",
+			Help: "Queries refused because max_concurrent_queries were in flight.",
+		}),
+		upstreamLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "easydns_upstream_exchange_duration_seconds",
+			Help:    "Duration of exchanges with upstream servers.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+		}),
+	}
+	m.registry.MustRegister(m.queries, m.localAnswers, m.forwarded, m.upstreamFailures, m.nxdomain, m.blocked, m.rateLimited, m.overloaded, m.upstreamLatency)
+	return m
+}
+
+// handler serves the metrics in the Prometheus text format
+func (m *metrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return mux
+}
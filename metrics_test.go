@@ -0,0 +1,77 @@
+package easydns
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// scrape returns the value of each sample served by the metrics handler
+func scrape(t *testing.T, s *Server) map[string]float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.metrics.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := map[string]float64{}
+	for _, line := range strings.Split(string(body), "\n") {
+		name, value, found := strings.Cut(line, " ")
+		if !found || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			samples[name] = v
+		}
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == "missing.example.com." {
+			answerRcode(dns.RcodeNameError)(w, r)
+			return
+		}
+		answerA("192.0.2.1")(w, r)
+	})
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Records:    Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask(t, s, "app.test.com", dns.TypeA)
+	ask(t, s, "app.test.com", dns.TypeA)
+	ask(t, s, "missing.example.com", dns.TypeA)
+	ask(t, s, "www.example.com", dns.TypeA)
+
+	samples := scrape(t, s)
+	tests := []struct {
+		metric string
+		want   float64
+	}{
+		{metric: "easydns_queries_total", want: 4},
+		{metric: "easydns_local_answers_total", want: 2},
+		{metric: "easydns_forwarded_queries_total", want: 2},
+		{metric: "easydns_nxdomain_responses_total", want: 1},
+		{metric: "easydns_upstream_failures_total", want: 0},
+		{metric: "easydns_upstream_exchange_duration_seconds_count", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			if got, found := samples[tt.metric]; !found || got != tt.want {
+				t.Errorf("got %v (found %v), want %v", got, found, tt.want)
+			}
+		})
+	}
+}
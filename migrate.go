@@ -0,0 +1,82 @@
+package easydns
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// currentConfigVersion is the config schema version written by this build
+const currentConfigVersion = 1
+
+// rawConfig is a config document as parsed JSON, before it is decoded
+// into a Config. Migrations rewrite it in place.
+type rawConfig map[string]json.RawMessage
+
+// migrations upgrade a raw config from version i to version i+1
+var migrations = []func(rawConfig) error{
+	// Version 0 configs predate the version field and need no changes
+	func(rawConfig) error { return nil },
+}
+
+// migrateConfig upgrades a config document to currentConfigVersion and
+// reports the version it started from
+func migrateConfig(data []byte) ([]byte, int, error) {
+	var raw rawConfig
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if v, found := raw["version"]; found {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid version: %v", err)
+		}
+	}
+	if version > currentConfigVersion {
+		return nil, version, fmt.Errorf("config version %d is newer than supported version %d", version, currentConfigVersion)
+	}
+	if version == currentConfigVersion {
+		return data, version, nil
+	}
+	for v := version; v < currentConfigVersion; v++ {
+		if err := migrations[v](raw); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate config from version %d: %v", v, err)
+		}
+	}
+	raw["version"] = json.RawMessage(fmt.Sprint(currentConfigVersion))
+	migrated, err := json.Marshal(raw)
+	return migrated, version, err
+}
+
+// WriteConfigFile atomically replaces filename with the encoded config, in
+// the format of its extension. The file keeps its mode, a new file is only
+// readable by its owner as the config may hold secrets.
+func WriteConfigFile(filename string, config *Config) error {
+	data, err := EncodeConfig(config, ConfigFormat(filename))
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
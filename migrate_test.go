@@ -0,0 +1,44 @@
+package easydns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteConfigFileMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing os.FileMode // 0 if there is no file yet
+		want     os.FileMode
+	}{
+		{name: "new file", want: 0o600},
+		{name: "private file", existing: 0o600, want: 0o600},
+		{name: "group readable file", existing: 0o640, want: 0o640},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "config.json")
+			if tt.existing != 0 {
+				if err := os.WriteFile(filename, []byte("{}"), tt.existing); err != nil {
+					t.Fatal(err)
+				}
+				// WriteFile is subject to the umask
+				if err := os.Chmod(filename, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := &Config{Version: currentConfigVersion, Server: ServerConfig{Port: "53"}}
+			if err := WriteConfigFile(filename, cfg); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != tt.want {
+				t.Errorf("got mode %o, want %o", mode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package easydns
+
+import "github.com/miekg/dns"
+
+// isNegativeResponse reports whether resp says the name or the requested
+// type does not exist (NXDOMAIN or NODATA)
+func isNegativeResponse(resp *dns.Msg) bool {
+	return resp.Rcode == dns.RcodeNameError || (resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0)
+}
+
+// raiseNegativeTTL raises the SOA records in the authority section of a
+// negative response so that the negative caching TTL (the lower of the
+// SOA TTL and its MINIMUM field, RFC 2308) is at least minTTL
+func raiseNegativeTTL(resp *dns.Msg, minTTL uint32) {
+	for _, rr := range resp.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+		if soa.Hdr.Ttl < minTTL {
+			soa.Hdr.Ttl = minTTL
+		}
+		if soa.Minttl < minTTL {
+			soa.Minttl = minTTL
+		}
+	}
+}
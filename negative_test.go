@@ -0,0 +1,77 @@
+package easydns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestNegativeMinTTL(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		switch r.Question[0].Name {
+		case "typo.example.com.":
+			resp.Rcode = dns.RcodeNameError
+		case "www.example.com.":
+			if r.Question[0].Qtype == dns.TypeA {
+				rr, _ := dns.NewRR("www.example.com. 5 IN A 192.0.2.1")
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		if len(resp.Answer) == 0 {
+			// A low SOA minimum
+			soa, _ := dns.NewRR("example.com. 5 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 5")
+			resp.Ns = append(resp.Ns, soa)
+		}
+		w.WriteMsg(resp)
+	})
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	tests := []struct {
+		name        string
+		minTTL      uint32
+		qname       string
+		qtype       uint16
+		wantTTL     uint32 // Of the SOA, or of the answer when there is one
+		wantQueries int64  // Upstream queries for two queries 60s apart
+	}{
+		{name: "nxdomain without floor", qname: "typo.example.com.", qtype: dns.TypeA, wantTTL: 5, wantQueries: 2},
+		{name: "nxdomain raised to the floor", minTTL: 300, qname: "typo.example.com.", qtype: dns.TypeA, wantTTL: 300, wantQueries: 1},
+		{name: "nodata raised to the floor", minTTL: 300, qname: "www.example.com.", qtype: dns.TypeAAAA, wantTTL: 300, wantQueries: 1},
+		{name: "positive answer is kept", minTTL: 300, qname: "www.example.com.", qtype: dns.TypeA, wantTTL: 5, wantQueries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, NegativeMinTTL: tt.minTTL},
+				Cache:      CacheConfig{Enabled: true},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := up.queries.Load()
+			now = func() time.Time { return base }
+			resp := ask(t, s, tt.qname, tt.qtype)
+			var ttl uint32
+			if len(resp.Answer) > 0 {
+				ttl = resp.Answer[0].Header().Ttl
+			} else if len(resp.Ns) > 0 {
+				soa := resp.Ns[0].(*dns.SOA)
+				ttl = min(soa.Hdr.Ttl, soa.Minttl)
+			}
+			if ttl != tt.wantTTL {
+				t.Errorf("got TTL %d, want %d", ttl, tt.wantTTL)
+			}
+			now = func() time.Time { return base.Add(time.Minute) }
+			ask(t, s, tt.qname, tt.qtype)
+			if queries := up.queries.Load() - before; queries != tt.wantQueries {
+				t.Errorf("upstream was asked %d times, want %d", queries, tt.wantQueries)
+			}
+		})
+	}
+}
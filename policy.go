@@ -0,0 +1,230 @@
+package easydns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// PolicyConfig is a rule of the query policy. It matches the queries of
+// Clients for Names of Types during its time window, conditions left empty
+// match all queries. Action is "allow" to answer the query as usual without
+// the blocklist, "block", "rewrite" or "forward".
+type PolicyConfig struct {
+	Name    string   `json:"name,omitempty"`    // Shown in the log of blocked queries
+	Clients []string `json:"clients,omitempty"` // Client networks
+	// Names match themselves and their subdomains, names starting with
+	// "*." only their subdomains
+	Names []string `json:"names,omitempty"`
+	Types []string `json:"types,omitempty"` // Query types
+	// From and To restrict the rule to a daily "HH:MM" window and Days to
+	// weekdays, like the schedule of records
+	From   string   `json:"from,omitempty"`
+	To     string   `json:"to,omitempty"`
+	Days   []string `json:"days,omitempty"`
+	Action string   `json:"action"`
+	// BlockMode answers blocked queries like the blocklist, "null" (the
+	// default) or "nxdomain"
+	BlockMode string `json:"block_mode,omitempty"`
+	// Rewrite is the address answered for A or AAAA queries, or a name the
+	// query is answered with a CNAME to
+	Rewrite string `json:"rewrite,omitempty"`
+	// Servers the query is forwarded to in place of the forwarding servers
+	Servers []string `json:"servers,omitempty"`
+}
+
+type policyRule struct {
+	name     string
+	clients  []*net.IPNet
+	names    []string
+	types    []uint16
+	window   ScheduleEntry
+	action   string
+	sinkhole *blocklist // Answers of block rules and of rewrites to an address
+	rewrite  string     // Target of rewrites to a name
+	servers  []string
+}
+
+// newPolicies builds the policy rules from their config, forward servers
+// without a port get defaultPort
+func newPolicies(configs []PolicyConfig, defaultPort string) ([]policyRule, error) {
+	rules := make([]policyRule, 0, len(configs))
+	for i, cfg := range configs {
+		rule, err := newPolicyRule(cfg, defaultPort)
+		if err != nil {
+			if cfg.Name != "" {
+				return nil, fmt.Errorf("policy %s: %v", cfg.Name, err)
+			}
+			return nil, fmt.Errorf("policy %d: %v", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newPolicyRule(cfg PolicyConfig, defaultPort string) (policyRule, error) {
+	clients, err := parseNetworks(cfg.Clients)
+	if err != nil {
+		return policyRule{}, err
+	}
+	rule := policyRule{name: cfg.Name, clients: clients, action: cfg.Action}
+	for _, name := range cfg.Names {
+		if _, ok := dns.IsDomainName(strings.TrimPrefix(name, "*.")); !ok || name == "" {
+			return policyRule{}, fmt.Errorf("invalid name %q", name)
+		}
+		rule.names = append(rule.names, dns.CanonicalName(name))
+	}
+	for _, t := range cfg.Types {
+		rrtype, found := dns.StringToType[strings.ToUpper(t)]
+		if !found {
+			return policyRule{}, fmt.Errorf("unknown record type %q", t)
+		}
+		rule.types = append(rule.types, rrtype)
+	}
+	if (cfg.From == "") != (cfg.To == "") {
+		return policyRule{}, fmt.Errorf("a time window needs both from and to")
+	}
+	rule.window = ScheduleEntry{From: cfg.From, To: cfg.To, Days: cfg.Days}
+	if cfg.From != "" {
+		if _, err := rule.window.matches(now()); err != nil {
+			return policyRule{}, err
+		}
+	}
+	for _, day := range cfg.Days {
+		if !isWeekday(day) {
+			return policyRule{}, fmt.Errorf("unknown day %q, use mon, tue, ...", day)
+		}
+	}
+	switch cfg.Action {
+	case "allow":
+	case "block":
+		switch cfg.BlockMode {
+		case "", "null", "nxdomain":
+		default:
+			return policyRule{}, fmt.Errorf("unknown block_mode %q, expected null or nxdomain", cfg.BlockMode)
+		}
+		rule.sinkhole = &blocklist{mode: cfg.BlockMode}
+	case "rewrite":
+		if ip := net.ParseIP(cfg.Rewrite); ip != nil {
+			rule.sinkhole = &blocklist{mode: "address", address: ip}
+		} else if _, ok := dns.IsDomainName(cfg.Rewrite); ok && cfg.Rewrite != "" {
+			rule.rewrite = dns.Fqdn(cfg.Rewrite)
+		} else {
+			return policyRule{}, fmt.Errorf("rewrite needs an address or a name, got %q", cfg.Rewrite)
+		}
+	case "forward":
+		if len(cfg.Servers) == 0 {
+			return policyRule{}, fmt.Errorf("forward needs at least one server")
+		}
+		rule.servers, err = normalizeUpstreams(cfg.Servers, defaultPort)
+		if err != nil {
+			return policyRule{}, err
+		}
+	default:
+		return policyRule{}, fmt.Errorf("unknown action %q, expected allow, block, rewrite or forward", cfg.Action)
+	}
+	return rule, nil
+}
+
+func (p policyRule) matchesName(qname string) bool {
+	if len(p.names) == 0 {
+		return true
+	}
+	for _, name := range p.names {
+		if parent, found := strings.CutPrefix(name, "*."); found {
+			if qname != parent && dns.IsSubDomain(parent, qname) {
+				return true
+			}
+		} else if dns.IsSubDomain(name, qname) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p policyRule) matchesType(qtype uint16) bool {
+	if len(p.types) == 0 {
+		return true
+	}
+	for _, t := range p.types {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// activeAt reports whether t falls within the window of the rule
+func (p policyRule) activeAt(t time.Time) bool {
+	if p.window.From == "" {
+		return p.window.onDay(t.Weekday())
+	}
+	ok, _ := p.window.matches(t)
+	return ok
+}
+
+func (p policyRule) matches(client net.IP, q dns.Question, t time.Time) bool {
+	if len(p.clients) > 0 && !containsIP(p.clients, client) {
+		return false
+	}
+	return p.matchesName(dns.CanonicalName(q.Name)) && p.matchesType(q.Qtype) && p.activeAt(t)
+}
+
+// matchPolicy returns the first rule matching the query of client for q at
+// time t, nil if none does
+func matchPolicy(rules []policyRule, client net.IP, q dns.Question, t time.Time) *policyRule {
+	for i := range rules {
+		if rules[i].matches(client, q, t) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// allows reports whether the query skips the blocklist
+func (p *policyRule) allows() bool {
+	return p != nil && p.action == "allow"
+}
+
+// forwards reports whether the query is forwarded to the rule's servers
+func (p *policyRule) forwards() bool {
+	return p != nil && p.action == "forward"
+}
+
+// forwarding returns the forwarding settings for the query. The servers of
+// forward rules take the place of the forwarding and conditional servers,
+// even if forwarding is disabled.
+func (p *policyRule) forwarding(forwarding ForwardingConfig) ForwardingConfig {
+	if p.forwards() {
+		forwarding.Enabled = true
+		forwarding.Servers = p.servers
+		forwarding.ConditionalForwarding = nil
+	}
+	return forwarding
+}
+
+// rewriteAnswer answers q as rewritten by policy, with its address or a
+// CNAME to its name that is resolved like a local CNAME
+func (s *Server) rewriteAnswer(ctx context.Context, msg, r *dns.Msg, q dns.Question, policy *policyRule, records Records, cfg *Config, client net.IP) error {
+	if policy.rewrite == "" {
+		policy.sinkhole.answer(msg, q)
+		return nil
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: blockedTTL}
+	msg.Answer = append(msg.Answer, &dns.CNAME{Hdr: hdr, Target: policy.rewrite})
+	if q.Qtype == dns.TypeCNAME || q.Qtype == dns.TypeANY {
+		return nil
+	}
+	return s.appendCNAMETarget(ctx, msg, r, q, records, policy.rewrite, cfg, client)
+}
+
+func (p policyRule) String() string {
+	if p.name != "" {
+		return p.name
+	}
+	return p.action
+}
@@ -0,0 +1,118 @@
+package easydns
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPolicies(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local) // A Monday
+	// Read by the listener goroutines while the test changes it
+	var clock atomic.Int64
+	clock.Store(base.UnixNano())
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return time.Unix(0, clock.Load()) }
+	corp := startUpstream(t, answerA("10.20.0.1"))
+	public := startUpstream(t, answerA("192.0.2.1"))
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{public.addr}},
+		Blocklist:  BlocklistConfig{Names: []string{"tracker.test.com"}},
+		Records: Records{
+			"app.test.com":    {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"kids.test.com":   {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+			"target.test.com": {{Type: "A", Value: "10.0.0.3", TTL: 60}},
+		},
+		Policies: []PolicyConfig{
+			// Not for the clients the queries come from
+			{Name: "guests", Clients: []string{"192.0.2.0/24"}, Names: []string{"app.test.com"}, Action: "block"},
+			{Name: "games", Clients: []string{"127.0.0.0/8"}, Names: []string{"kids.test.com"}, From: "09:00", To: "17:00", Action: "block", BlockMode: "nxdomain"},
+			{Name: "no ipv6", Names: []string{"*.v6.test.com"}, Types: []string{"AAAA"}, Action: "block"},
+			{Name: "pinned", Names: []string{"pinned.test.com"}, Action: "rewrite", Rewrite: "10.9.9.9"},
+			{Name: "alias", Names: []string{"alias.test.com"}, Action: "rewrite", Rewrite: "target.test.com"},
+			{Name: "corp", Names: []string{"corp.test.com"}, Action: "forward", Servers: []string{corp.addr}},
+			{Name: "trusted", Names: []string{"tracker.test.com"}, Action: "allow"},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTransferServer(t, s, cfg)
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		at        time.Time
+		wantRcode int
+		want      []string
+	}{
+		{name: "rule for other clients", qname: "app.test.com", qtype: dns.TypeA, want: []string{"10.0.0.1"}},
+		{name: "block during the window", qname: "kids.test.com", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+		{name: "no block outside the window", qname: "kids.test.com", qtype: dns.TypeA, at: base.Add(6 * time.Hour), want: []string{"10.0.0.2"}},
+		{name: "block of a type", qname: "host.v6.test.com", qtype: dns.TypeAAAA, want: []string{"::"}},
+		{name: "other types are not blocked", qname: "host.v6.test.com", qtype: dns.TypeA, want: []string{"192.0.2.1"}},
+		{name: "rewrite to an address", qname: "pinned.test.com", qtype: dns.TypeA, want: []string{"10.9.9.9"}},
+		{name: "rewrite to a name", qname: "alias.test.com", qtype: dns.TypeA, want: []string{"target.test.com.", "10.0.0.3"}},
+		{name: "forward to the rule's servers", qname: "www.corp.test.com", qtype: dns.TypeA, want: []string{"10.20.0.1"}},
+		{name: "other names use the forwarding servers", qname: "www.example.com", qtype: dns.TypeA, want: []string{"192.0.2.1"}},
+		{name: "allow skips the blocklist", qname: "tracker.test.com", qtype: dns.TypeA, want: []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.at.IsZero() {
+				clock.Store(tt.at.UnixNano())
+				defer clock.Store(base.UnixNano())
+			}
+			query := new(dns.Msg)
+			query.SetQuestion(dns.Fqdn(tt.qname), tt.qtype)
+			resp, err := dns.Exchange(query, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := corp.queries.Load(); got != 1 {
+		t.Errorf("rule's server got %d queries, want 1", got)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PolicyConfig
+		wantErr bool
+	}{
+		{name: "block", policy: PolicyConfig{Clients: []string{"10.0.0.0/8"}, Action: "block"}},
+		{name: "unknown action", policy: PolicyConfig{Action: "drop"}, wantErr: true},
+		{name: "invalid client network", policy: PolicyConfig{Clients: []string{"10.0.0.0/33"}, Action: "block"}, wantErr: true},
+		{name: "unknown type", policy: PolicyConfig{Types: []string{"AAAAA"}, Action: "block"}, wantErr: true},
+		{name: "window without an end", policy: PolicyConfig{From: "09:00", Action: "block"}, wantErr: true},
+		{name: "unknown day", policy: PolicyConfig{Days: []string{"someday"}, Action: "block"}, wantErr: true},
+		{name: "rewrite without a target", policy: PolicyConfig{Action: "rewrite"}, wantErr: true},
+		{name: "forward without servers", policy: PolicyConfig{Action: "forward"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(&Config{
+				Version:  currentConfigVersion,
+				Server:   ServerConfig{Port: "53"},
+				Policies: []PolicyConfig{tt.policy},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
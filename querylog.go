@@ -0,0 +1,216 @@
+package easydns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// LoggingConfig controls the query log
+type LoggingConfig struct {
+	// Output is "log" (the standard log), "stdout", "file", "syslog" or
+	// "none" to disable the query log. Defaults to "file" when
+	// QueryLogPath is set and to "log" otherwise.
+	Output string `json:"output,omitempty"`
+	// QueryLogPath is the file the query log is appended to
+	QueryLogPath string `json:"query_log_path,omitempty"`
+	// Format is "text" (the default) or "json" for one JSON object per line
+	Format string `json:"format,omitempty"`
+	// MaxSizeMB rotates the query log file once it grows beyond this size,
+	// keeping MaxBackups rotated files. Rotation is off when 0.
+	MaxSizeMB  int `json:"max_size_mb,omitempty"`
+	MaxBackups int `json:"max_backups,omitempty"`
+}
+
+// output returns the configured output, applying the default
+func (c LoggingConfig) output() string {
+	if c.Output == "" && c.QueryLogPath != "" {
+		return "file"
+	}
+	if c.Output == "" {
+		return "log"
+	}
+	return c.Output
+}
+
+// validate checks the output, log format and rotation settings
+func (c LoggingConfig) validate() error {
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("unknown format %q, expected text or json", c.Format)
+	}
+	switch c.output() {
+	case "log", "stdout", "syslog", "none":
+	case "file":
+		if c.QueryLogPath == "" {
+			return fmt.Errorf("query_log_path must be set for output file")
+		}
+	default:
+		return fmt.Errorf("unknown output %q, expected log, stdout, file, syslog or none", c.Output)
+	}
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 {
+		return fmt.Errorf("max_size_mb and max_backups must not be negative")
+	}
+	return nil
+}
+
+// queryLogEntry describes how one question of a query was answered
+type queryLogEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Name      string    `json:"qname"`
+	Type      string    `json:"qtype"`
+	Source    string    `json:"source"`
+	Rcode     string    `json:"rcode"`
+	LatencyMS float64   `json:"latency_ms"`
+}
+
+// queryLog writes one line per question of every query
+type queryLog struct {
+	mu         sync.Mutex
+	format     string
+	disabled   bool
+	out        io.Writer // nil when writing to the standard log
+	closer     io.Closer
+	timestamps bool // Prefix text lines with the time
+
+	// Rotation of the query log file
+	file       *os.File
+	path       string
+	size       int64
+	maxSize    int64
+	maxBackups int
+
+	// Recent queries shown by the dashboard, nil when it is off
+	activity *queryActivity
+}
+
+// newQueryLog sets up the configured output. Files are opened in append
+// mode so they can also be rotated externally.
+func newQueryLog(cfg LoggingConfig) (*queryLog, error) {
+	l := &queryLog{format: cfg.Format}
+	switch cfg.output() {
+	case "none":
+		l.disabled = true
+	case "stdout":
+		l.out, l.timestamps = os.Stdout, true
+	case "syslog":
+		writer, err := openSyslog()
+		if err != nil {
+			return nil, err
+		}
+		l.out, l.closer = writer, writer
+	case "file":
+		l.path, l.timestamps = cfg.QueryLogPath, true
+		l.maxSize, l.maxBackups = int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups
+		if err := l.openFile(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// openFile opens the query log file for appending
+func (l *queryLog) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.out, l.closer, l.size = file, file, file, info.Size()
+	return nil
+}
+
+// rotate renames the query log file to <path>.1, shifting older rotated
+// files up to maxBackups, and starts a new file. The caller must hold mu.
+func (l *queryLog) rotate() error {
+	l.file.Close()
+	if l.maxBackups == 0 {
+		os.Remove(l.path)
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.maxBackups > 0 {
+		os.Rename(l.path, l.path+".1")
+	}
+	return l.openFile()
+}
+
+// log records a query, its source and the response code. A query without
+// questions is logged with an empty name and type.
+func (l *queryLog) log(client fmt.Stringer, r *dns.Msg, source, rcode string, start time.Time) {
+	if l.disabled && l.activity == nil {
+		return
+	}
+	entry := queryLogEntry{
+		Time:      start,
+		Client:    client.String(),
+		Source:    source,
+		Rcode:     rcode,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	questions := r.Question
+	if len(questions) == 0 {
+		questions = []dns.Question{{}}
+	}
+	for _, q := range questions {
+		entry.Name, entry.Type = q.Name, ""
+		if q.Name != "" {
+			entry.Type = dns.TypeToString[q.Qtype]
+		}
+		l.activity.record(entry)
+		if !l.disabled {
+			l.write(entry)
+		}
+	}
+}
+
+func (l *queryLog) write(entry queryLogEntry) {
+	var line string
+	if l.format == "json" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		line = string(data)
+	} else {
+		line = fmt.Sprintf("query: %s %s from: %s source: %s rcode: %s latency: %.3fms", entry.Name, entry.Type, entry.Client, entry.Source, entry.Rcode, entry.LatencyMS)
+	}
+	if l.out == nil {
+		log.Print(line)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.format != "json" && l.timestamps {
+		line = entry.Time.Format(time.RFC3339) + " " + line
+	}
+	if l.file != nil && l.maxSize > 0 && l.size+int64(len(line))+1 > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			log.Printf("failed to rotate query log %s, disabling it: %v", l.path, err)
+			l.file, l.out = nil, io.Discard
+		}
+	}
+	n, _ := fmt.Fprintln(l.out, line)
+	l.size += int64(n)
+}
+
+// close closes the query log file or syslog connection
+func (l *queryLog) close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package easydns
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// openSyslog fails, there is no syslog on this platform
+func openSyslog() (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,128 @@
+package easydns
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		check  func(t *testing.T, line string)
+	}{
+		{
+			name: "text",
+			check: func(t *testing.T, line string) {
+				if !strings.Contains(line, "query: app.test.com. A from: 127.0.0.1:40000 source: local rcode: NOERROR") {
+					t.Errorf("got %q", line)
+				}
+			},
+		},
+		{
+			name:   "json",
+			format: "json",
+			check: func(t *testing.T, line string) {
+				var entry queryLogEntry
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatal(err)
+				}
+				if entry.Name != "app.test.com." || entry.Type != "A" || entry.Source != "local" || entry.Rcode != "NOERROR" {
+					t.Errorf("got %+v", entry)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "query.log")
+			s, err := New(&Config{
+				Version: currentConfigVersion,
+				Server:  ServerConfig{Port: "53"},
+				Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+				Logging: LoggingConfig{QueryLogPath: path, Format: tt.format},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ask(t, s, "app.test.com", dns.TypeA)
+			if err := s.queryLog.close(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("got %d lines, want 1: %q", len(lines), data)
+			}
+			tt.check(t, lines[0])
+		})
+	}
+}
+
+func TestQueryLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	l, err := newQueryLog(LoggingConfig{QueryLogPath: path, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	// Room for about one line per file
+	l.maxSize = 100
+	query := new(dns.Msg)
+	for _, name := range []string{"one.test.", "two.test.", "three.test.", "four.test."} {
+		query.SetQuestion(name, dns.TypeA)
+		l.log(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}, query, "local", "NOERROR", time.Now())
+	}
+	tests := []struct {
+		file string
+		want string // Name logged in the file
+	}{
+		{file: path, want: "four.test."},
+		{file: path + ".1", want: "three.test."},
+		{file: path + ".2", want: "two.test."},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "query: "+tt.want+" ") || strings.Count(string(data), "\n") != 1 {
+			t.Errorf("%s holds %q, want one line for %s", tt.file, data, tt.want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("got a third backup, want at most 2: %v", err)
+	}
+}
+
+func TestValidateLogging(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  LoggingConfig
+		wantErr bool
+	}{
+		{name: "default"},
+		{name: "stdout", config: LoggingConfig{Output: "stdout", Format: "json"}},
+		{name: "none", config: LoggingConfig{Output: "none"}},
+		{name: "file without a path", config: LoggingConfig{Output: "file"}, wantErr: true},
+		{name: "unknown output", config: LoggingConfig{Output: "dnstap"}, wantErr: true},
+		{name: "negative size", config: LoggingConfig{QueryLogPath: "query.log", MaxSizeMB: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
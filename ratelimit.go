@@ -0,0 +1,241 @@
+package easydns
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultRateLimitSlip    = 2
+	defaultIPv4PrefixLength = 24
+	defaultIPv6PrefixLength = 56
+	rateLimitIdleTime       = time.Minute
+	maxRateLimitBucketCount = 100000
+)
+
+// RateLimitConfig limits how many queries and identical responses a client
+// gets per second, to keep the server from being used for amplification
+// attacks when it is exposed on a public interface. Zero rates disable the
+// corresponding limit.
+type RateLimitConfig struct {
+	// QueriesPerSecond limits the queries of one client address, queries
+	// above the limit are dropped without a response
+	QueriesPerSecond float64 `json:"queries_per_second,omitempty"`
+	// ResponsesPerSecond limits identical UDP responses sent to one client
+	// network (response rate limiting). Responses are identical when they
+	// have the same name, type and response code, NXDOMAIN responses are
+	// counted together regardless of the name.
+	ResponsesPerSecond float64 `json:"responses_per_second,omitempty"`
+	// Burst is how many queries or responses above the rate are allowed in
+	// a short burst, defaults to the rate
+	Burst int `json:"burst,omitempty"`
+	// Slip answers every Slip-th rate limited response with an empty
+	// truncated response instead of dropping it, so real clients retry over
+	// TCP. 1 truncates every limited response, defaults to 2, -1 drops all.
+	Slip int `json:"slip,omitempty"`
+	// IPv4PrefixLength and IPv6PrefixLength group client addresses into
+	// networks for response rate limiting, default to 24 and 56
+	IPv4PrefixLength int `json:"ipv4_prefix_length,omitempty"`
+	IPv6PrefixLength int `json:"ipv6_prefix_length,omitempty"`
+	// Exempt lists networks that are never rate limited
+	Exempt []string `json:"exempt,omitempty"`
+}
+
+func (c RateLimitConfig) validate() error {
+	if c.QueriesPerSecond < 0 {
+		return fmt.Errorf("queries_per_second must not be negative")
+	}
+	if c.ResponsesPerSecond < 0 {
+		return fmt.Errorf("responses_per_second must not be negative")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	if c.Slip < -1 {
+		return fmt.Errorf("slip must be -1 or larger")
+	}
+	if c.IPv4PrefixLength < 0 || c.IPv4PrefixLength > 32 {
+		return fmt.Errorf("ipv4_prefix_length must be between 0 and 32")
+	}
+	if c.IPv6PrefixLength < 0 || c.IPv6PrefixLength > 128 {
+		return fmt.Errorf("ipv6_prefix_length must be between 0 and 128")
+	}
+	if _, err := parseNetworks(c.Exempt); err != nil {
+		return fmt.Errorf("exempt: %v", err)
+	}
+	return nil
+}
+
+// rrlAction is what to do with a response under response rate limiting
+type rrlAction int
+
+const (
+	rrlSend rrlAction = iota
+	rrlSlip
+	rrlDrop
+)
+
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited int // Responses limited so far, to slip every n-th of them
+
+	key     string
+	buckets map[string]*list.Element // The map the bucket is stored in
+}
+
+// take refills the bucket for the time since it was last used and takes a
+// token from it, reporting whether there was one
+func (b *tokenBucket) take(rate, burst float64, current time.Time) bool {
+	b.tokens = min(burst, b.tokens+current.Sub(b.last).Seconds()*rate)
+	b.last = current
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter is the state of RateLimitConfig. A nil rateLimiter limits
+// nothing.
+type rateLimiter struct {
+	queryRate     float64
+	queryBurst    float64
+	responseRate  float64
+	responseBurst float64
+	slip          int
+	ipv4Mask      net.IPMask
+	ipv6Mask      net.IPMask
+	exempt        []*net.IPNet
+
+	mu         sync.Mutex
+	clients    map[string]*list.Element
+	responses  map[string]*list.Element
+	lru        *list.List // Buckets of clients and responses, most recently used first
+	maxBuckets int
+}
+
+// newRateLimiter sets up the limits of cfg, returning nil when both rates
+// are zero
+func newRateLimiter(cfg RateLimitConfig) (*rateLimiter, error) {
+	if cfg.QueriesPerSecond == 0 && cfg.ResponsesPerSecond == 0 {
+		return nil, nil
+	}
+	exempt, err := parseNetworks(cfg.Exempt)
+	if err != nil {
+		return nil, fmt.Errorf("exempt: %v", err)
+	}
+	l := &rateLimiter{
+		queryRate:     cfg.QueriesPerSecond,
+		queryBurst:    max(cfg.QueriesPerSecond, 1),
+		responseRate:  cfg.ResponsesPerSecond,
+		responseBurst: max(cfg.ResponsesPerSecond, 1),
+		slip:          cfg.Slip,
+		ipv4Mask:      net.CIDRMask(defaultIPv4PrefixLength, 32),
+		ipv6Mask:      net.CIDRMask(defaultIPv6PrefixLength, 128),
+		exempt:        exempt,
+		clients:       map[string]*list.Element{},
+		responses:     map[string]*list.Element{},
+		lru:           list.New(),
+		maxBuckets:    maxRateLimitBucketCount,
+	}
+	if cfg.Burst > 0 {
+		l.queryBurst = float64(cfg.Burst)
+		l.responseBurst = float64(cfg.Burst)
+	}
+	if l.slip == 0 {
+		l.slip = defaultRateLimitSlip
+	}
+	if cfg.IPv4PrefixLength > 0 {
+		l.ipv4Mask = net.CIDRMask(cfg.IPv4PrefixLength, 32)
+	}
+	if cfg.IPv6PrefixLength > 0 {
+		l.ipv6Mask = net.CIDRMask(cfg.IPv6PrefixLength, 128)
+	}
+	return l, nil
+}
+
+// bucket returns the bucket of key in buckets, creating a full one if
+// needed. The caller must hold mu.
+func (l *rateLimiter) bucket(buckets map[string]*list.Element, key string, burst float64, current time.Time) *tokenBucket {
+	if elem, found := buckets[key]; found {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*tokenBucket)
+	}
+	l.evict(current)
+	bucket := &tokenBucket{tokens: burst, last: current, key: key, buckets: buckets}
+	buckets[key] = l.lru.PushFront(bucket)
+	return bucket
+}
+
+// evict forgets buckets that were not used for a while, they would be full
+// again by now. Beyond maxBuckets the least recently used buckets are
+// forgotten too, so spoofed source addresses cannot exhaust memory while
+// the buckets of an ongoing flood are kept. The caller must hold mu.
+func (l *rateLimiter) evict(current time.Time) {
+	for oldest := l.lru.Back(); oldest != nil; oldest = l.lru.Back() {
+		bucket := oldest.Value.(*tokenBucket)
+		if l.lru.Len() < l.maxBuckets && current.Sub(bucket.last) <= rateLimitIdleTime {
+			return
+		}
+		l.lru.Remove(oldest)
+		delete(bucket.buckets, bucket.key)
+	}
+}
+
+func (l *rateLimiter) exempts(client net.IP) bool {
+	return client == nil || containsIP(l.exempt, client)
+}
+
+// allowQuery reports whether a query of client is within the query rate
+func (l *rateLimiter) allowQuery(client net.IP) bool {
+	if l == nil || l.queryRate == 0 || l.exempts(client) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := now()
+	return l.bucket(l.clients, client.String(), l.queryBurst, current).take(l.queryRate, l.queryBurst, current)
+}
+
+// clientNetwork returns the network client is grouped into for response
+// rate limiting
+func (l *rateLimiter) clientNetwork(client net.IP) string {
+	if ipv4 := client.To4(); ipv4 != nil {
+		return ipv4.Mask(l.ipv4Mask).String()
+	}
+	return client.Mask(l.ipv6Mask).String()
+}
+
+// limitResponse decides whether resp may be sent to client under response
+// rate limiting
+func (l *rateLimiter) limitResponse(client net.IP, resp *dns.Msg) rrlAction {
+	if l == nil || l.responseRate == 0 || l.exempts(client) || len(resp.Question) == 0 {
+		return rrlSend
+	}
+	q := resp.Question[0]
+	name := strings.ToLower(q.Name)
+	if resp.Rcode == dns.RcodeNameError {
+		// Counted together so random names cannot evade the limit
+		name = ""
+	}
+	key := fmt.Sprintf("%s/%s/%d/%d", l.clientNetwork(client), name, q.Qtype, resp.Rcode)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := now()
+	bucket := l.bucket(l.responses, key, l.responseBurst, current)
+	if bucket.take(l.responseRate, l.responseBurst, current) {
+		return rrlSend
+	}
+	bucket.limited++
+	if l.slip > 0 && bucket.limited%l.slip == 0 {
+		return rrlSlip
+	}
+	return rrlDrop
+}
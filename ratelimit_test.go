@@ -0,0 +1,198 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRateLimitQueries(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	l, err := newRateLimiter(RateLimitConfig{QueriesPerSecond: 1, Burst: 2, Exempt: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		client string
+		after  time.Duration // Since the first query
+		want   bool
+	}{
+		{name: "first query", client: "192.0.2.1", want: true},
+		{name: "within the burst", client: "192.0.2.1", want: true},
+		{name: "above the burst", client: "192.0.2.1"},
+		{name: "other client", client: "192.0.2.2", want: true},
+		{name: "exempt client", client: "10.0.0.1", want: true},
+		{name: "exempt client above the burst", client: "10.0.0.1", want: true},
+		{name: "exempt client still above the burst", client: "10.0.0.1", want: true},
+		{name: "refilled after a second", client: "192.0.2.1", after: time.Second, want: true},
+		{name: "refill used up", client: "192.0.2.1", after: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = func() time.Time { return base.Add(tt.after) }
+			if got := l.allowQuery(net.ParseIP(tt.client)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitResponses(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return base }
+	response := func(name string, rcode int) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Rcode = rcode
+		return msg
+	}
+	tests := []struct {
+		name   string
+		config RateLimitConfig
+		client []string // Of each response, sent in order
+		resps  []*dns.Msg
+		want   []rrlAction
+	}{
+		{
+			name:   "every second limited response slips",
+			config: RateLimitConfig{ResponsesPerSecond: 1},
+			client: []string{"192.0.2.1", "192.0.2.1", "192.0.2.1", "192.0.2.1", "192.0.2.1"},
+			resps:  []*dns.Msg{response("a.test.", 0), response("a.test.", 0), response("a.test.", 0), response("a.test.", 0), response("a.test.", 0)},
+			want:   []rrlAction{rrlSend, rrlDrop, rrlSlip, rrlDrop, rrlSlip},
+		},
+		{
+			name:   "slip 1 truncates every limited response",
+			config: RateLimitConfig{ResponsesPerSecond: 1, Slip: 1},
+			client: []string{"192.0.2.1", "192.0.2.1", "192.0.2.1"},
+			resps:  []*dns.Msg{response("a.test.", 0), response("a.test.", 0), response("a.test.", 0)},
+			want:   []rrlAction{rrlSend, rrlSlip, rrlSlip},
+		},
+		{
+			name:   "slip -1 drops every limited response",
+			config: RateLimitConfig{ResponsesPerSecond: 1, Slip: -1},
+			client: []string{"192.0.2.1", "192.0.2.1", "192.0.2.1"},
+			resps:  []*dns.Msg{response("a.test.", 0), response("a.test.", 0), response("a.test.", 0)},
+			want:   []rrlAction{rrlSend, rrlDrop, rrlDrop},
+		},
+		{
+			name:   "different names are counted apart",
+			config: RateLimitConfig{ResponsesPerSecond: 1, Slip: -1},
+			client: []string{"192.0.2.1", "192.0.2.1"},
+			resps:  []*dns.Msg{response("a.test.", 0), response("b.test.", 0)},
+			want:   []rrlAction{rrlSend, rrlSend},
+		},
+		{
+			name:   "nxdomain responses are counted together",
+			config: RateLimitConfig{ResponsesPerSecond: 1, Slip: -1},
+			client: []string{"192.0.2.1", "192.0.2.1"},
+			resps:  []*dns.Msg{response("a.test.", dns.RcodeNameError), response("b.test.", dns.RcodeNameError)},
+			want:   []rrlAction{rrlSend, rrlDrop},
+		},
+		{
+			name:   "clients in one network are counted together",
+			config: RateLimitConfig{ResponsesPerSecond: 1, Slip: -1},
+			client: []string{"192.0.2.1", "192.0.2.200", "198.51.100.1"},
+			resps:  []*dns.Msg{response("a.test.", 0), response("a.test.", 0), response("a.test.", 0)},
+			want:   []rrlAction{rrlSend, rrlDrop, rrlSend},
+		},
+		{
+			name:   "prefix length",
+			config: RateLimitConfig{ResponsesPerSecond: 1, Slip: -1, IPv4PrefixLength: 32},
+			client: []string{"192.0.2.1", "192.0.2.200"},
+			resps:  []*dns.Msg{response("a.test.", 0), response("a.test.", 0)},
+			want:   []rrlAction{rrlSend, rrlSend},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newRateLimiter(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, resp := range tt.resps {
+				if got := l.limitResponse(net.ParseIP(tt.client[i]), resp); got != tt.want[i] {
+					t.Errorf("response %d: got action %d, want %d", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitEviction(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return base }
+	l, err := newRateLimiter(RateLimitConfig{QueriesPerSecond: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.maxBuckets = 10
+	flooding := net.ParseIP("192.0.2.1")
+	l.allowQuery(flooding)
+	for i := range 50 {
+		// Spoofed addresses interleaved with the flood
+		l.allowQuery(net.ParseIP(fmt.Sprintf("198.51.100.%d", i)))
+		if l.allowQuery(flooding) {
+			t.Fatalf("flooding client allowed after %d spoofed addresses", i+1)
+		}
+	}
+	if buckets := l.lru.Len(); buckets > l.maxBuckets {
+		t.Errorf("got %d buckets, want at most %d", buckets, l.maxBuckets)
+	}
+
+	// Idle buckets are forgotten once a new one is needed
+	now = func() time.Time { return base.Add(2 * rateLimitIdleTime) }
+	l.allowQuery(net.ParseIP("203.0.113.1"))
+	if buckets := len(l.clients); buckets != 1 {
+		t.Errorf("got %d buckets after the idle time, want 1", buckets)
+	}
+}
+
+func TestRateLimitedResponses(t *testing.T) {
+	s, err := New(&Config{
+		Version:   currentConfigVersion,
+		Server:    ServerConfig{Port: "53"},
+		Records:   Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+		RateLimit: RateLimitConfig{ResponsesPerSecond: 1, Slip: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("app.test.com.", dns.TypeA)
+	tests := []struct {
+		name          string
+		network       string
+		wantDropped   bool
+		wantTruncated bool
+	}{
+		{name: "within the rate", network: "udp"},
+		{name: "dropped", network: "udp", wantDropped: true},
+		{name: "slipped", network: "udp", wantTruncated: true},
+		{name: "tcp is not limited", network: "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRecorder(tt.network, "192.0.2.1")
+			s.ServeDNS(w, query)
+			if dropped := w.msg == nil; dropped != tt.wantDropped {
+				t.Fatalf("got dropped %v, want %v", dropped, tt.wantDropped)
+			}
+			if tt.wantDropped {
+				return
+			}
+			if w.msg.Truncated != tt.wantTruncated {
+				t.Errorf("got truncated %v, want %v", w.msg.Truncated, tt.wantTruncated)
+			}
+			if wantAnswers := !tt.wantTruncated; (len(w.msg.Answer) > 0) != wantAnswers {
+				t.Errorf("got answers %v, want answers %v", w.msg.Answer, wantAnswers)
+			}
+		})
+	}
+}
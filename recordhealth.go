@@ -0,0 +1,284 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRecordCheckInterval = 10 * time.Second
+	defaultRecordCheckTimeout  = 2 * time.Second
+)
+
+// HealthCheck probes the target of an A, AAAA or SRV record, which is only
+// served while the probe succeeds
+type HealthCheck struct {
+	// Type is "tcp" to connect to Port or "http" to GET Path from it
+	Type string `json:"type"`
+	// Port defaults to the port of SRV records, for other records it is
+	// required for TCP checks and defaults to 80 for HTTP checks
+	Port int    `json:"port,omitempty"`
+	Path string `json:"path,omitempty"` // Path of HTTP checks, "/" by default
+	// Interval between checks, 10s by default
+	Interval string `json:"interval,omitempty"`
+	// Timeout of a check, 2s by default
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (c HealthCheck) validate(record Record) error {
+	switch record.Type {
+	case "A", "AAAA", "SRV":
+	default:
+		return fmt.Errorf("health checks are only supported for A, AAAA and SRV records")
+	}
+	switch c.Type {
+	case "tcp":
+		if c.Port == 0 && record.Type != "SRV" {
+			return fmt.Errorf("tcp health checks need a port")
+		}
+	case "http":
+		if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("health check path %q must start with /", c.Path)
+		}
+	default:
+		return fmt.Errorf("unknown health check type %q, use tcp or http", c.Type)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("health check port %d is not a valid port", c.Port)
+	}
+	if _, err := c.interval(); err != nil {
+		return err
+	}
+	if _, err := c.timeout(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c HealthCheck) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultRecordCheckInterval, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval < time.Second {
+		return 0, fmt.Errorf("invalid health check interval %q, it must be at least 1s", c.Interval)
+	}
+	return interval, nil
+}
+
+func (c HealthCheck) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return defaultRecordCheckTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid health check timeout %q", c.Timeout)
+	}
+	return timeout, nil
+}
+
+// checkTarget is a host and port probed by a health check
+type checkTarget struct {
+	check HealthCheck
+	host  string
+	port  int
+}
+
+func (t checkTarget) address() string {
+	return net.JoinHostPort(strings.TrimSuffix(t.host, "."), strconv.Itoa(t.port))
+}
+
+func (t checkTarget) probe() error {
+	// Validated with the config
+	timeout, _ := t.check.timeout()
+	if t.check.Type == "tcp" {
+		conn, err := net.DialTimeout("tcp", t.address(), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	client := http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	path := t.check.Path
+	if path == "" {
+		path = "/"
+	}
+	resp, err := client.Get("http://" + t.address() + path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// checkTargets returns the targets probed for record, one for its value and
+// for each scheduled value
+func checkTargets(record Record) []checkTarget {
+	if record.HealthCheck == nil {
+		return nil
+	}
+	values := []string{record.Value}
+	for _, entry := range record.Schedule {
+		values = append(values, entry.Value)
+	}
+	port := record.HealthCheck.Port
+	switch {
+	case port == 0 && record.Type == "SRV":
+		port = record.Port
+	case port == 0:
+		port = 80
+	}
+	targets := make([]checkTarget, 0, len(values))
+	for _, value := range values {
+		targets = append(targets, checkTarget{check: *record.HealthCheck, host: value, port: port})
+	}
+	return targets
+}
+
+type targetState struct {
+	healthy  bool
+	checking bool
+	next     time.Time
+}
+
+// recordHealth keeps the results of the health checks of records. Targets
+// are healthy until a check fails.
+type recordHealth struct {
+	mu         sync.Mutex
+	targets    map[checkTarget]*targetState
+	synced     bool
+	generation uint64 // Generation of the records the targets are from
+}
+
+func newRecordHealth() *recordHealth {
+	return &recordHealth{targets: map[checkTarget]*targetState{}}
+}
+
+// healthy reports whether the active target of record passed its last check
+func (h *recordHealth) healthy(record Record) bool {
+	targets := checkTargets(record.activeAt(now()))
+	if len(targets) == 0 {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, found := h.targets[targets[0]]
+	return !found || state.healthy
+}
+
+// filter drops the records of set whose target is down. If every record
+// is down all of them are kept, an answer is more useful than none.
+func (h *recordHealth) filter(set []Record) []Record {
+	var up []Record
+	for _, record := range set {
+		if h.healthy(record) {
+			up = append(up, record)
+		}
+	}
+	if len(up) == 0 {
+		return set
+	}
+	return up
+}
+
+// sync tracks the targets of current, dropping those of removed records
+func (h *recordHealth) sync(current *recordSet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.synced && current.generation == h.generation {
+		return
+	}
+	h.synced, h.generation = true, current.generation
+	sets := []Records{current.records}
+	for _, v := range current.views {
+		sets = append(sets, v.records)
+	}
+	seen := map[checkTarget]bool{}
+	for _, records := range sets {
+		for _, set := range records {
+			for _, record := range set {
+				for _, target := range checkTargets(record) {
+					seen[target] = true
+					if _, found := h.targets[target]; !found {
+						h.targets[target] = &targetState{healthy: true}
+					}
+				}
+			}
+		}
+	}
+	for target := range h.targets {
+		if !seen[target] {
+			delete(h.targets, target)
+		}
+	}
+}
+
+// due returns the targets to check now and marks them as being checked
+func (h *recordHealth) due(t time.Time) []checkTarget {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var due []checkTarget
+	for target, state := range h.targets {
+		if !state.checking && !t.Before(state.next) {
+			state.checking = true
+			due = append(due, target)
+		}
+	}
+	return due
+}
+
+// record stores the result of a check of target
+func (h *recordHealth) record(target checkTarget, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, found := h.targets[target]
+	if !found {
+		return
+	}
+	// Validated with the config
+	interval, _ := target.check.interval()
+	state.checking = false
+	state.next = now().Add(interval)
+	if healthy := err == nil; healthy != state.healthy {
+		state.healthy = healthy
+		if healthy {
+			log.Printf("%s health check of %s passed, serving it again", target.check.Type, target.address())
+		} else {
+			log.Printf("%s health check of %s failed, no longer serving it: %v", target.check.Type, target.address(), err)
+		}
+	}
+}
+
+// checkRecords runs the health checks of the records as they become due
+// until the server is shut down
+func (s *Server) checkRecords() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		s.recordHealth.sync(s.currentRecordSet())
+		for _, target := range s.recordHealth.due(now()) {
+			go func() {
+				s.recordHealth.record(target, target.probe())
+			}()
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package easydns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordHealthChecks(t *testing.T) {
+	base := time.Now()
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return base }
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	check := &HealthCheck{Type: "tcp", Port: listener.Addr().(*net.TCPAddr).Port}
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"app.test.com": {
+			{Type: "A", Value: "127.0.0.1", TTL: 60, HealthCheck: check},
+			// Nothing listens on the port of this address
+			{Type: "A", Value: "127.0.0.2", TTL: 60, HealthCheck: check},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runChecks := func() {
+		s.recordHealth.sync(s.currentRecordSet())
+		for _, target := range s.recordHealth.due(now()) {
+			s.recordHealth.record(target, target.probe())
+		}
+	}
+	tests := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{name: "unchecked records are served", want: []string{"127.0.0.1", "127.0.0.2"}},
+		{name: "record with a failed check is dropped", change: runChecks, want: []string{"127.0.0.1"}},
+		{
+			name: "all records are served when every check fails",
+			change: func() {
+				listener.Close()
+				base = base.Add(defaultRecordCheckInterval)
+				runChecks()
+			},
+			want: []string{"127.0.0.1", "127.0.0.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.change != nil {
+				tt.change()
+			}
+			if got := answerValues(ask(t, s, "app.test.com", dns.TypeA)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
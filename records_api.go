@@ -0,0 +1,143 @@
+package easydns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// withRecordSets returns a copy of records with the records of each name in
+// sets replaced. A nil set removes the name, removing a missing name does
+// nothing.
+func withRecordSets(records Records, sets Records) Records {
+	updated := make(Records, len(records)+len(sets))
+	for key, existing := range records {
+		updated[key] = existing
+	}
+	for name, set := range sets {
+		key, found := FindRecordKey(records, name)
+		if !found {
+			key = recordName(name)
+		}
+		if set == nil {
+			delete(updated, key)
+		} else {
+			updated[key] = set
+		}
+	}
+	return updated
+}
+
+// persistRecordSets applies record changes to the config file at path
+func persistRecordSets(path string, sets Records) error {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	cfg.Records = withRecordSets(cfg.Records, sets)
+	return WriteConfigFile(path, cfg)
+}
+
+// applyRecordSets replaces the records of each name in sets in the running
+// config and serves the result, see withRecordSets. The changes are written
+// to persistPath first if it is set, and to the record storage. The caller
+// must hold apiMu.
+func (s *Server) applyRecordSets(sets Records, persistPath string) error {
+	running := s.currentConfig()
+	candidate := *running
+	candidate.Records = withRecordSets(running.Records, sets)
+	records, err := loadRecords(&candidate)
+	if err != nil {
+		return err
+	}
+	if persistPath != "" {
+		if err := persistRecordSets(persistPath, sets); err != nil {
+			return fmt.Errorf("failed to persist records to %s: %v", persistPath, err)
+		}
+	}
+	if err := s.storeRecordSets(sets); err != nil {
+		return err
+	}
+	s.setConfig(&candidate)
+	generation := s.setRecords(records)
+	log.Printf("records of %d names changed at runtime, serving records generation %d", len(sets), generation)
+	return nil
+}
+
+// updateRecordSet replaces the records of name in the running config, or
+// removes them if set is nil
+func (s *Server) updateRecordSet(name string, set []Record) (int, error) {
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	cfg := s.currentConfig()
+	if _, found := FindRecordKey(cfg.Records, name); !found && set == nil {
+		return http.StatusNotFound, fmt.Errorf("no record for %s in the config", name)
+	}
+	if err := s.applyRecordSets(Records{name: set}, cfg.API.PersistPath); err != nil {
+		if errors.As(err, &ZoneFileError{}) || errors.As(err, &RecordsDirError{}) {
+			return http.StatusConflict, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleListRecords(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.currentRecords())
+}
+
+func (s *Server) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	name := recordName(r.PathValue("name"))
+	set, found := s.currentRecords()[name]
+	if !found {
+		http.Error(w, fmt.Sprintf("no record for %s", name), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, set)
+}
+
+func (s *Server) handlePutRecord(w http.ResponseWriter, r *http.Request) {
+	name := recordName(r.PathValue("name"))
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "malformed request body", http.StatusBadRequest)
+		return
+	}
+	set, err := decodeRecordSet(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("malformed request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateRecords(Records{name: set}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, existed := FindRecordKey(s.currentConfig().Records, name)
+	if status, err := s.updateRecordSet(name, set); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	status := http.StatusOK
+	if !existed {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, set)
+}
+
+func (s *Server) handleDeleteRecord(w http.ResponseWriter, r *http.Request) {
+	name := recordName(r.PathValue("name"))
+	if status, err := s.updateRecordSet(name, nil); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
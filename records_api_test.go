@@ -0,0 +1,107 @@
+package easydns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// apiRequest sends a request with body to the API of s and returns the
+// response status
+func apiRequest(t *testing.T, s *Server, method, path, token, body string) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.apiHandler().ServeHTTP(rec, r)
+	return rec.Code
+}
+
+func TestRecordsAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+		query      string
+		want       []string // Answers to an A query for query afterwards
+	}{
+		{name: "missing token", method: http.MethodGet, path: "/api/v1/records", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPut, path: "/api/v1/records/new.test.com", token: "wrong", body: `{"type": "A", "value": "10.0.0.9"}`, wantStatus: http.StatusUnauthorized, query: "new.test.com"},
+		{name: "list", method: http.MethodGet, path: "/api/v1/records", token: "secret", wantStatus: http.StatusOK},
+		{name: "get", method: http.MethodGet, path: "/api/v1/records/App.Test.com", token: "secret", wantStatus: http.StatusOK},
+		{name: "get a missing name", method: http.MethodGet, path: "/api/v1/records/new.test.com", token: "secret", wantStatus: http.StatusNotFound},
+		{name: "create", method: http.MethodPut, path: "/api/v1/records/new.test.com", token: "secret", body: `{"type": "A", "value": "10.0.0.9", "ttl": 60}`, wantStatus: http.StatusCreated, query: "new.test.com", want: []string{"10.0.0.9"}},
+		{name: "replace", method: http.MethodPut, path: "/api/v1/records/app.test.com", token: "secret", body: `[{"type": "A", "value": "10.0.0.2", "ttl": 60}, {"type": "A", "value": "10.0.0.3", "ttl": 60}]`, wantStatus: http.StatusOK, query: "app.test.com", want: []string{"10.0.0.2", "10.0.0.3"}},
+		{name: "delete", method: http.MethodDelete, path: "/api/v1/records/app.test.com", token: "secret", wantStatus: http.StatusNoContent, query: "app.test.com"},
+		{name: "delete a missing name", method: http.MethodDelete, path: "/api/v1/records/new.test.com", token: "secret", wantStatus: http.StatusNotFound},
+		{name: "invalid record", method: http.MethodPut, path: "/api/v1/records/app.test.com", token: "secret", body: `{"type": "A", "value": "not-an-address"}`, wantStatus: http.StatusBadRequest, query: "app.test.com", want: []string{"10.0.0.1"}},
+		{name: "unsupported type", method: http.MethodPut, path: "/api/v1/records/app.test.com", token: "secret", body: `{"type": "HINFO", "value": "x"}`, wantStatus: http.StatusBadRequest, query: "app.test.com", want: []string{"10.0.0.1"}},
+		{name: "malformed body", method: http.MethodPut, path: "/api/v1/records/app.test.com", token: "secret", body: `{"type": `, wantStatus: http.StatusBadRequest, query: "app.test.com", want: []string{"10.0.0.1"}},
+		{name: "name from a zone file", method: http.MethodPut, path: "/api/v1/records/host.zone.test", token: "secret", body: `{"type": "A", "value": "10.0.0.9"}`, wantStatus: http.StatusConflict, query: "host.zone.test", want: []string{"10.0.1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(&Config{
+				Version:   currentConfigVersion,
+				Server:    ServerConfig{Port: "53"},
+				API:       APIConfig{Token: "secret"},
+				Records:   Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+				ZoneFiles: []string{writeZone(t, testZone)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := apiRequest(t, s, tt.method, tt.path, tt.token, tt.body); status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", status, tt.wantStatus)
+			}
+			if tt.query == "" {
+				return
+			}
+			resp := ask(t, s, tt.query, dns.TypeA)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordsAPIPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		API:     APIConfig{PersistPath: path},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	if err := WriteConfigFile(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := apiRequest(t, s, http.MethodPut, "/api/v1/records/new.test.com", "", `{"type": "A", "value": "10.0.0.9", "ttl": 60}`); status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+	if status := apiRequest(t, s, http.MethodDelete, "/api/v1/records/app.test.com", "", ""); status != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+	}
+	saved, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Records{"new.test.com": {{Type: "A", Value: "10.0.0.9", TTL: 60}}}
+	if !reflect.DeepEqual(saved.Records, want) {
+		t.Errorf("got saved records %v, want %v", saved.Records, want)
+	}
+}
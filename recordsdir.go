@@ -0,0 +1,134 @@
+package easydns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultRecordsDirInterval = 5 * time.Second
+
+// RecordsDirConfig points at a directory of JSON record files that is
+// watched and applied on change, e.g. a checkout kept in sync with git
+type RecordsDirConfig struct {
+	Path     string `json:"path,omitempty"`
+	Interval string `json:"interval,omitempty"` // Poll interval, defaults to 5s
+}
+
+type RecordsDirError struct {
+	file          string
+	originalError error
+}
+
+func (e RecordsDirError) Error() string {
+	return fmt.Sprintf("records file %s is invalid: %v", e.file, e.originalError)
+}
+
+// ValidateRecords checks every record and reports the first problem found
+func ValidateRecords(records Records) error {
+	for name, set := range records {
+		if problems := recordSetProblems(name, set); len(problems) > 0 {
+			return fmt.Errorf("record %s: %s", name, problems[0])
+		}
+	}
+	return nil
+}
+
+// loadRecordsDir reads and validates every *.json file in dir and merges
+// them on top of base. A name defined twice is an error.
+func loadRecordsDir(dir string, base Records) (Records, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	merged := Records{}
+	definedIn := map[string]string{}
+	for name, record := range base {
+		merged[name] = record
+		definedIn[name] = "the config file or a zone file"
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, RecordsDirError{file: file, originalError: err}
+		}
+		var records Records
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, RecordsDirError{file: file, originalError: err}
+		}
+		if err := ValidateRecords(records); err != nil {
+			return nil, RecordsDirError{file: file, originalError: err}
+		}
+		for name, record := range records {
+			if other, found := definedIn[name]; found {
+				return nil, RecordsDirError{file: file, originalError: fmt.Errorf("record %s is already defined in %s", name, other)}
+			}
+			merged[name] = record
+			definedIn[name] = file
+		}
+	}
+	return merged, nil
+}
+
+// recordsDirFingerprint summarizes the record files in dir so changes can
+// be detected without reading them
+func recordsDirFingerprint(dir string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(files)
+	var b strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// watchRecordsDir polls the records directory of the active config and
+// applies the merged records whenever it changes, until the server is shut
+// down. Invalid states are rejected and the last good records keep being
+// served.
+func (s *Server) watchRecordsDir(cfg RecordsDirConfig) {
+	interval := defaultRecordsDirInterval
+	if cfg.Interval != "" {
+		if d, err := time.ParseDuration(cfg.Interval); err == nil && d > 0 {
+			interval = d
+		} else {
+			log.Printf("invalid records_dir interval %q, using %s", cfg.Interval, interval)
+		}
+	}
+	last := recordsDirFingerprint(cfg.Path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		active := s.currentConfig()
+		if active.RecordsDir.Path == "" {
+			continue
+		}
+		fingerprint := recordsDirFingerprint(active.RecordsDir.Path)
+		if fingerprint == last {
+			continue
+		}
+		last = fingerprint
+		records, err := loadRecords(active)
+		if err != nil {
+			log.Printf("rejecting records directory change, still serving generation %d: %v", s.records.Load().generation, err)
+			continue
+		}
+		generation := s.setRecords(records)
+		log.Printf("applied records generation %d (%d records)", generation, len(records))
+	}
+}
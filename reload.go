@@ -0,0 +1,197 @@
+package easydns
+
+import (
+	"log"
+	"reflect"
+)
+
+// restartRequired lists the settings of candidate that only take effect
+// after a restart and differ from the running config
+func restartRequired(running, candidate *Config) []string {
+	var changed []string
+	runningServer, candidateServer := running.Server, candidate.Server
+	runningServer.RoundRobinMode, candidateServer.RoundRobinMode = "", ""
+	runningServer.AnyQueries, candidateServer.AnyQueries = "", ""
+	runningServer.MinimalResponses, candidateServer.MinimalResponses = false, false
+	if !reflect.DeepEqual(runningServer, candidateServer) {
+		changed = append(changed, "server")
+	}
+	if running.API != candidate.API {
+		changed = append(changed, "api")
+	}
+	if running.Tracing != candidate.Tracing {
+		changed = append(changed, "tracing")
+	}
+	if running.Metrics != candidate.Metrics {
+		changed = append(changed, "metrics")
+	}
+	if !reflect.DeepEqual(running.Blocklist, candidate.Blocklist) {
+		changed = append(changed, "blocklist")
+	}
+	if running.DoT != candidate.DoT {
+		changed = append(changed, "dot")
+	}
+	if running.DoQ != candidate.DoQ {
+		changed = append(changed, "doq")
+	}
+	if running.DoH != candidate.DoH {
+		changed = append(changed, "doh")
+	}
+	if running.Logging != candidate.Logging {
+		changed = append(changed, "logging")
+	}
+	if running.Cache != candidate.Cache {
+		changed = append(changed, "cache")
+	}
+	if !reflect.DeepEqual(running.Transforms, candidate.Transforms) {
+		changed = append(changed, "transforms")
+	}
+	if !reflect.DeepEqual(running.Policies, candidate.Policies) {
+		changed = append(changed, "policies")
+	}
+	if !reflect.DeepEqual(running.Rewrites, candidate.Rewrites) {
+		changed = append(changed, "rewrites")
+	}
+	if !reflect.DeepEqual(running.MDNS, candidate.MDNS) {
+		changed = append(changed, "mdns")
+	}
+	if running.Control != candidate.Control {
+		changed = append(changed, "control")
+	}
+	if running.Discovery != candidate.Discovery {
+		changed = append(changed, "discovery")
+	}
+	if !reflect.DeepEqual(running.TSIG.Keys, candidate.TSIG.Keys) {
+		changed = append(changed, "tsig keys")
+	}
+	if !reflect.DeepEqual(running.ACL, candidate.ACL) {
+		changed = append(changed, "acl")
+	}
+	if !reflect.DeepEqual(running.Storage, candidate.Storage) {
+		changed = append(changed, "storage")
+	}
+	if running.Signing != candidate.Signing || !reflect.DeepEqual(running.signedZoneNames(), candidate.signedZoneNames()) {
+		changed = append(changed, "signing")
+	}
+	if !reflect.DeepEqual(running.Forwarding.TrustAnchors, candidate.Forwarding.TrustAnchors) {
+		changed = append(changed, "forwarding trust anchors")
+	}
+	if !reflect.DeepEqual(running.RateLimit, candidate.RateLimit) {
+		changed = append(changed, "rate_limit")
+	}
+	if !reflect.DeepEqual(running.Transfer.Keys, candidate.Transfer.Keys) || !reflect.DeepEqual(running.Transfer.Secondary, candidate.Transfer.Secondary) {
+		changed = append(changed, "transfer keys and secondary zones")
+	}
+	if !reflect.DeepEqual(running.Update, candidate.Update) {
+		changed = append(changed, "update")
+	}
+	return changed
+}
+
+// keepRestartSettings sets the settings of candidate that only take effect
+// after a restart to their running values, so a reload applies exactly the
+// settings restartRequired does not report
+func keepRestartSettings(running, candidate *Config) {
+	server := running.Server
+	server.RoundRobinMode = candidate.Server.RoundRobinMode
+	server.AnyQueries = candidate.Server.AnyQueries
+	server.MinimalResponses = candidate.Server.MinimalResponses
+	candidate.Server = server
+	candidate.API = running.API
+	candidate.Tracing = running.Tracing
+	candidate.Metrics = running.Metrics
+	candidate.Blocklist = running.Blocklist
+	candidate.DoT = running.DoT
+	candidate.DoQ = running.DoQ
+	candidate.DoH = running.DoH
+	candidate.Logging = running.Logging
+	candidate.Cache = running.Cache
+	candidate.Transforms = running.Transforms
+	candidate.Policies = running.Policies
+	candidate.Rewrites = running.Rewrites
+	candidate.MDNS = running.MDNS
+	candidate.Control = running.Control
+	candidate.Discovery = running.Discovery
+	candidate.TSIG.Keys = running.TSIG.Keys
+	candidate.ACL = running.ACL
+	candidate.Signing = running.Signing
+	if candidate.Zones != nil {
+		// The keys of signed zones are loaded at startup
+		zones := make(map[string]ZoneConfig, len(candidate.Zones))
+		for name, zone := range candidate.Zones {
+			zone.DNSSEC = running.Zones[name].DNSSEC
+			zones[name] = zone
+		}
+		candidate.Zones = zones
+	}
+	candidate.Forwarding.TrustAnchors = running.Forwarding.TrustAnchors
+	candidate.RateLimit = running.RateLimit
+	candidate.Transfer.Keys = running.Transfer.Keys
+	candidate.Transfer.Secondary = running.Transfer.Secondary
+	candidate.Update = running.Update
+}
+
+// Reload atomically swaps in the records and resolver settings of cfg.
+// If cfg is invalid the running config is kept and the error returned.
+func (s *Server) Reload(cfg *Config) error {
+	notifySystemd("RELOADING=1")
+	defer notifySystemd("READY=1")
+	running := s.currentConfig()
+	if err := ValidateConfig(cfg); err != nil {
+		return err
+	}
+	for _, section := range restartRequired(running, cfg) {
+		log.Printf("changes to %s require a restart and are ignored", section)
+	}
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	candidate := *cfg
+	candidate.Storage = running.Storage
+	stored, err := storedRecords(s.store, &candidate)
+	if err != nil {
+		return err
+	}
+	candidate.Records = stored
+	records, err := loadRecords(&candidate)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	addresses := s.addresses
+	s.mu.Unlock()
+	candidate.Forwarding = withoutOwnUpstreams(cfg.Forwarding, addresses, running.Server.Port)
+	keepRestartSettings(running, &candidate)
+	s.setConfig(&candidate)
+	generation := s.setRecords(records)
+	if candidate.Stats.ResetOnReload {
+		s.hits.reset()
+	}
+	log.Printf("reloaded config, serving records generation %d (%d records)", generation, len(records))
+	return nil
+}
+
+// SetRecords replaces the records of the running config with records. Zone
+// files and the records directory are still merged in, and the rest of the
+// config is kept.
+func (s *Server) SetRecords(records Records) error {
+	if err := ValidateRecords(records); err != nil {
+		return err
+	}
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	running := s.currentConfig()
+	candidate := *running
+	candidate.Records = normalizeRecords(records)
+	loaded, err := loadRecords(&candidate)
+	if err != nil {
+		return err
+	}
+	if err := s.storeRecordSets(replacedRecordSets(running.Records, records)); err != nil {
+		return err
+	}
+	s.setConfig(&candidate)
+	generation := s.setRecords(loaded)
+	log.Printf("records replaced, serving records generation %d (%d records)", generation, len(loaded))
+	return nil
+}
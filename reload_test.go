@@ -0,0 +1,193 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestReloadSwapsRecords(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		records Records
+		wantErr bool
+		want    []string
+	}{
+		{name: "changed record", records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.2", TTL: 60}}}, want: []string{"10.0.0.2"}},
+		{name: "invalid record keeps the running records", records: Records{"app.test.com": {{Type: "A", Value: "not-an-address", TTL: 60}}}, wantErr: true, want: []string{"10.0.0.2"}},
+		{name: "removed record", records: Records{"web.test.com": {{Type: "A", Value: "10.0.0.3", TTL: 60}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Reload(&Config{Version: currentConfigVersion, Server: ServerConfig{Port: "53"}, Records: tt.records})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			resp := ask(t, s, "app.test.com", dns.TypeA)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsRestartSettings(t *testing.T) {
+	running := func() *Config {
+		return &Config{
+			Version:    currentConfigVersion,
+			Server:     ServerConfig{Port: "53", TCPIdleTimeout: "8s"},
+			Forwarding: ForwardingConfig{Servers: []string{"192.0.2.53:53"}},
+			Cache:      CacheConfig{Enabled: true, MaxEntries: 100},
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		check  func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:   "tcp idle timeout is kept",
+			change: func(cfg *Config) { cfg.Server.TCPIdleTimeout = "30s" },
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Server.TCPIdleTimeout != "8s" {
+					t.Errorf("tcp_idle_timeout is %q, want 8s", cfg.Server.TCPIdleTimeout)
+				}
+			},
+		},
+		{
+			name:   "round robin mode is applied",
+			change: func(cfg *Config) { cfg.Server.RoundRobinMode = "sticky" },
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Server.RoundRobinMode != "sticky" {
+					t.Errorf("round_robin_mode is %q, want sticky", cfg.Server.RoundRobinMode)
+				}
+			},
+		},
+		{
+			name:   "cache is kept",
+			change: func(cfg *Config) { cfg.Cache = CacheConfig{} },
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Cache != (CacheConfig{Enabled: true, MaxEntries: 100}) {
+					t.Errorf("cache is %+v, want the running one", cfg.Cache)
+				}
+			},
+		},
+		{
+			name:   "transforms are kept",
+			change: func(cfg *Config) { cfg.Transforms = []TransformConfig{{Action: "clamp-ttl", MaxTTL: 30}} },
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.Transforms) != 0 {
+					t.Errorf("transforms are %+v, want none", cfg.Transforms)
+				}
+			},
+		},
+		{
+			name: "tsig keys are kept",
+			change: func(cfg *Config) {
+				cfg.TSIG.Keys = []TSIGKey{{Name: "client.", Secret: "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"}}
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.TSIG.Keys) != 0 {
+					t.Errorf("tsig keys are %v, want none", cfg.TSIG.Keys)
+				}
+			},
+		},
+		{
+			name: "tsig clients are applied",
+			change: func(cfg *Config) {
+				cfg.TSIG.Keys = []TSIGKey{{Name: "client.", Secret: "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"}}
+				cfg.TSIG.Clients = []string{"10.0.0.0/8"}
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if !reflect.DeepEqual(cfg.TSIG.Clients, []string{"10.0.0.0/8"}) || len(cfg.TSIG.clients) != 1 {
+					t.Errorf("tsig clients are %v, parsed %v, want 10.0.0.0/8", cfg.TSIG.Clients, cfg.TSIG.clients)
+				}
+			},
+		},
+		{
+			name:   "forwarding servers are applied",
+			change: func(cfg *Config) { cfg.Forwarding.Servers = []string{"192.0.2.54:53"} },
+			check: func(t *testing.T, cfg *Config) {
+				if !reflect.DeepEqual(cfg.Forwarding.Servers, []string{"192.0.2.54:53"}) {
+					t.Errorf("forwarding servers are %v, want 192.0.2.54:53", cfg.Forwarding.Servers)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(running())
+			if err != nil {
+				t.Fatal(err)
+			}
+			candidate := running()
+			tt.change(candidate)
+			if err := s.Reload(candidate); err != nil {
+				t.Fatal(err)
+			}
+			applied := s.currentConfig()
+			tt.check(t, applied)
+			if changed := restartRequired(running(), applied); len(changed) > 0 {
+				t.Errorf("reloaded config still differs in %v", changed)
+			}
+		})
+	}
+}
+
+func TestSetRecords(t *testing.T) {
+	s, err := New(&Config{
+		Version:   currentConfigVersion,
+		Server:    ServerConfig{Port: "53"},
+		Records:   Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+		ZoneFiles: []string{writeZone(t, testZone)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		records Records
+		wantErr bool
+		want    map[string][]string // A answers by name
+	}{
+		{
+			name:    "records are replaced and zone files kept",
+			records: Records{"web.test.com": {{Type: "A", Value: "10.0.0.2", TTL: 60}}},
+			want:    map[string][]string{"app.test.com": nil, "web.test.com": {"10.0.0.2"}, "host.zone.test": {"10.0.1.1"}},
+		},
+		{
+			name:    "invalid records keep the running records",
+			records: Records{"app.test.com": {{Type: "A", Value: "not-an-address", TTL: 60}}},
+			wantErr: true,
+			want:    map[string][]string{"app.test.com": nil, "web.test.com": {"10.0.0.2"}},
+		},
+		{
+			name:    "name also in a zone file",
+			records: Records{"host.zone.test": {{Type: "A", Value: "10.0.0.3", TTL: 60}}},
+			wantErr: true,
+			want:    map[string][]string{"web.test.com": {"10.0.0.2"}, "host.zone.test": {"10.0.1.1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetRecords(tt.records)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := answerValues(ask(t, s, name, dns.TypeA)); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
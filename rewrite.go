@@ -0,0 +1,211 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// RewriteConfig rewrites the names of queries before they are looked up or
+// forwarded, or the addresses of answers. Exactly one of Name, Regex and
+// Answer is set.
+type RewriteConfig struct {
+	// Name is looked up as To. Names starting with "*." swap the suffix of
+	// all names under it, "*.staging.example.com" to "*.prod.example.com".
+	Name string `json:"name,omitempty"`
+	// Regex matches whole query names, without the trailing dot, which are
+	// looked up as To with $1 etc. replaced by the groups of the match
+	Regex string `json:"regex,omitempty"`
+	// Answer is a network whose addresses in answers are replaced with the
+	// same host in the network To, or with To if it is an address
+	Answer  string   `json:"answer,omitempty"`
+	To      string   `json:"to"`
+	Clients []string `json:"clients,omitempty"` // Client networks, all clients when empty
+}
+
+type rewriteRule struct {
+	clients []*net.IPNet
+	name    string // Canonical name or "*." suffix to swap
+	regex   *regexp.Regexp
+	to      string
+	from    *net.IPNet // Network of answer rewrites
+	target  *net.IPNet
+}
+
+// rewrites are the rules of the rewrites section, in config order
+type rewrites struct {
+	names   []rewriteRule
+	answers []rewriteRule
+}
+
+func newRewrites(configs []RewriteConfig) (*rewrites, error) {
+	rw := &rewrites{}
+	for i, cfg := range configs {
+		rule, err := newRewriteRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite %d: %v", i, err)
+		}
+		if rule.from != nil {
+			rw.answers = append(rw.answers, rule)
+		} else {
+			rw.names = append(rw.names, rule)
+		}
+	}
+	return rw, nil
+}
+
+func newRewriteRule(cfg RewriteConfig) (rewriteRule, error) {
+	clients, err := parseNetworks(cfg.Clients)
+	if err != nil {
+		return rewriteRule{}, err
+	}
+	rule := rewriteRule{clients: clients}
+	set := 0
+	for _, field := range []string{cfg.Name, cfg.Regex, cfg.Answer} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return rewriteRule{}, fmt.Errorf("exactly one of name, regex and answer must be set")
+	}
+	switch {
+	case cfg.Name != "":
+		rule.name, rule.to = dns.CanonicalName(cfg.Name), dns.CanonicalName(cfg.To)
+		for _, name := range []string{cfg.Name, cfg.To} {
+			if _, ok := dns.IsDomainName(strings.TrimPrefix(name, "*.")); !ok || name == "" {
+				return rewriteRule{}, fmt.Errorf("invalid name %q", name)
+			}
+		}
+		if strings.HasPrefix(rule.name, "*.") != strings.HasPrefix(rule.to, "*.") {
+			return rewriteRule{}, fmt.Errorf("%s and %s must both start with *. to swap suffixes", cfg.Name, cfg.To)
+		}
+	case cfg.Regex != "":
+		rule.regex, err = regexp.Compile("^(?:" + cfg.Regex + ")$")
+		if err != nil {
+			return rewriteRule{}, fmt.Errorf("invalid regex %q: %v", cfg.Regex, err)
+		}
+		if cfg.To == "" {
+			return rewriteRule{}, fmt.Errorf("regex %q needs a name to rewrite to", cfg.Regex)
+		}
+		rule.to = cfg.To
+	default:
+		networks, err := parseNetworks([]string{cfg.Answer, cfg.To})
+		if err != nil {
+			return rewriteRule{}, err
+		}
+		rule.from, rule.target = networks[0], networks[1]
+		fromOnes, fromBits := rule.from.Mask.Size()
+		toOnes, toBits := rule.target.Mask.Size()
+		if fromBits != toBits {
+			return rewriteRule{}, fmt.Errorf("%s and %s are of different address families", cfg.Answer, cfg.To)
+		}
+		if toOnes != fromOnes && toOnes != toBits {
+			return rewriteRule{}, fmt.Errorf("%s must be an address or a network of the size of %s", cfg.To, cfg.Answer)
+		}
+	}
+	return rule, nil
+}
+
+func (r rewriteRule) appliesTo(client net.IP) bool {
+	return len(r.clients) == 0 || containsIP(r.clients, client)
+}
+
+// rewrite returns the name qname, a canonical name, is looked up as
+func (r rewriteRule) rewrite(qname string) (string, bool) {
+	if r.regex != nil {
+		match := r.regex.FindStringSubmatchIndex(strings.TrimSuffix(qname, "."))
+		if match == nil {
+			return "", false
+		}
+		name := r.regex.ExpandString(nil, r.to, strings.TrimSuffix(qname, "."), match)
+		if _, ok := dns.IsDomainName(string(name)); !ok {
+			return "", false
+		}
+		return dns.CanonicalName(string(name)), true
+	}
+	suffix, swap := strings.CutPrefix(r.name, "*")
+	if !swap {
+		return r.to, qname == r.name
+	}
+	if !strings.HasSuffix(qname, suffix) || qname == suffix[1:] {
+		return "", false
+	}
+	return strings.TrimSuffix(qname, suffix) + strings.TrimPrefix(r.to, "*"), true
+}
+
+// rewriteQuery returns r with the names of its questions rewritten for
+// client, and the names asked for by the names they are looked up as. It
+// returns r itself if no name is rewritten.
+func (rw *rewrites) rewriteQuery(r *dns.Msg, client net.IP) (*dns.Msg, map[string]string) {
+	var query *dns.Msg
+	var original map[string]string
+	for i, q := range r.Question {
+		for _, rule := range rw.names {
+			if !rule.appliesTo(client) {
+				continue
+			}
+			name, rewritten := rule.rewrite(dns.CanonicalName(q.Name))
+			if !rewritten {
+				continue
+			}
+			if query == nil {
+				query, original = r.Copy(), map[string]string{}
+			}
+			query.Question[i].Name = name
+			original[name] = q.Name
+			break
+		}
+	}
+	if query == nil {
+		return r, nil
+	}
+	return query, original
+}
+
+// restoreNames renames the records of msg owned by rewritten names back to
+// the names that were asked for
+func restoreNames(msg *dns.Msg, original map[string]string) {
+	eachRR(msg, func(rr dns.RR) {
+		if name, found := original[dns.CanonicalName(rr.Header().Name)]; found {
+			rr.Header().Name = name
+		}
+	})
+}
+
+// rewriteAnswers replaces the A and AAAA addresses of msg that answer
+// rewrites apply to for client. The first matching rule wins.
+func (rw *rewrites) rewriteAnswers(msg *dns.Msg, client net.IP) {
+	if len(rw.answers) == 0 {
+		return
+	}
+	rewrite := func(ip net.IP) net.IP {
+		for _, rule := range rw.answers {
+			if rule.appliesTo(client) && len(ip) == len(rule.from.IP) && rule.from.Contains(ip) {
+				return rule.mapAddress(ip)
+			}
+		}
+		return ip
+	}
+	eachRR(msg, func(rr dns.RR) {
+		switch rr := rr.(type) {
+		case *dns.A:
+			rr.A = rewrite(rr.A.To4())
+		case *dns.AAAA:
+			rr.AAAA = rewrite(rr.AAAA)
+		}
+	})
+}
+
+// mapAddress returns the address of the target network with the host part
+// of ip, the target itself if it is a single address
+func (r rewriteRule) mapAddress(ip net.IP) net.IP {
+	mapped := make(net.IP, len(r.target.IP))
+	for i := range mapped {
+		mapped[i] = r.target.IP[i]&r.target.Mask[i] | ip[i]&^r.target.Mask[i]
+	}
+	return mapped
+}
@@ -0,0 +1,132 @@
+package easydns
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRewrites(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		asked []string
+	)
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		asked = append(asked, r.Question[0].Name)
+		mu.Unlock()
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.10")
+		resp.Answer = append(resp.Answer, rr)
+		w.WriteMsg(resp)
+	})
+	s, err := New(&Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Rewrites: []RewriteConfig{
+			{Name: "*.staging.example.com", To: "*.prod.example.com"},
+			{Name: "legacy.test.com", To: "app.test.com"},
+			{Regex: `(.+)\.old\.test\.com`, To: "$1.test.com"},
+			{Name: "office.test.com", To: "app.test.com", Clients: []string{"10.0.0.0/8"}},
+			{Answer: "10.0.0.0/24", To: "172.16.5.0/24"},
+			{Answer: "192.0.2.10/32", To: "198.51.100.1"},
+		},
+		Records: Records{
+			"app.test.com":  {{Type: "A", Value: "10.0.0.7", TTL: 60}},
+			"mail.test.com": {{Type: "A", Value: "10.0.1.7", TTL: 60}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		client    string
+		qname     string
+		want      []string
+		wantAsked string // Name forwarded upstream, if any
+	}{
+		{name: "name", qname: "legacy.test.com.", want: []string{"172.16.5.7"}},
+		{name: "suffix swap", qname: "api.staging.example.com.", want: []string{"198.51.100.1"}, wantAsked: "api.prod.example.com."},
+		{name: "suffix itself is not swapped", qname: "staging.example.com.", want: []string{"198.51.100.1"}, wantAsked: "staging.example.com."},
+		{name: "regex", qname: "mail.old.test.com.", want: []string{"10.0.1.7"}},
+		{name: "client in the networks", client: "10.1.2.3", qname: "office.test.com.", want: []string{"172.16.5.7"}},
+		{name: "client outside the networks", client: "192.168.1.2", qname: "office.test.com.", want: []string{"198.51.100.1"}, wantAsked: "office.test.com."},
+		{name: "address outside the answer networks", qname: "mail.test.com.", want: []string{"10.0.1.7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			asked = nil
+			mu.Unlock()
+			client := tt.client
+			if client == "" {
+				client = "127.0.0.1"
+			}
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, dns.TypeA)
+			w := newRecorder("udp", client)
+			s.ServeDNS(w, query)
+			if w.msg == nil {
+				t.Fatal("query was dropped")
+			}
+			if got := answerValues(w.msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+			// The client sees the name it asked for
+			if w.msg.Question[0].Name != tt.qname {
+				t.Errorf("question is %s, want %s", w.msg.Question[0].Name, tt.qname)
+			}
+			for _, rr := range w.msg.Answer {
+				if rr.Header().Name != tt.qname {
+					t.Errorf("answer is owned by %s, want %s", rr.Header().Name, tt.qname)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			var wantAsked []string
+			if tt.wantAsked != "" {
+				wantAsked = []string{tt.wantAsked}
+			}
+			if !reflect.DeepEqual(asked, wantAsked) {
+				t.Errorf("forwarded %v, want %v", asked, wantAsked)
+			}
+		})
+	}
+}
+
+func TestValidateRewrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites []RewriteConfig
+		wantErr  bool
+	}{
+		{name: "name", rewrites: []RewriteConfig{{Name: "a.test.com", To: "b.test.com"}}},
+		{name: "answer network", rewrites: []RewriteConfig{{Answer: "10.0.0.0/24", To: "172.16.0.0/24"}}},
+		{name: "answer address", rewrites: []RewriteConfig{{Answer: "10.0.0.0/24", To: "172.16.0.1"}}},
+		{name: "nothing to rewrite", rewrites: []RewriteConfig{{To: "b.test.com"}}, wantErr: true},
+		{name: "name and answer", rewrites: []RewriteConfig{{Name: "a.test.com", Answer: "10.0.0.0/24", To: "b.test.com"}}, wantErr: true},
+		{name: "only one side swaps suffixes", rewrites: []RewriteConfig{{Name: "*.a.test.com", To: "b.test.com"}}, wantErr: true},
+		{name: "invalid regex", rewrites: []RewriteConfig{{Regex: "(", To: "b.test.com"}}, wantErr: true},
+		{name: "regex without a target", rewrites: []RewriteConfig{{Regex: "a.*"}}, wantErr: true},
+		{name: "mixed address families", rewrites: []RewriteConfig{{Answer: "10.0.0.0/24", To: "2001:db8::/120"}}, wantErr: true},
+		{name: "networks of different sizes", rewrites: []RewriteConfig{{Answer: "10.0.0.0/24", To: "172.16.0.0/16"}}, wantErr: true},
+		{name: "invalid client network", rewrites: []RewriteConfig{{Name: "a.test.com", To: "b.test.com", Clients: []string{"10.0.0.0/33"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(&Config{
+				Version:  currentConfigVersion,
+				Server:   ServerConfig{Port: "53"},
+				Rewrites: tt.rewrites,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package easydns
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// clientIP returns the IP address of the client that sent a query
+func clientIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func sameRRset(a, b dns.RR) bool {
+	return a.Header().Rrtype == b.Header().Rrtype && a.Header().Name == b.Header().Name
+}
+
+// eachRRset calls reorder with every RRset of answers, in a canonical order
+// since upstreams may rotate their answers. RRsets stay in place relative to
+// each other so CNAME chains keep their order.
+func eachRRset(answers []dns.RR, reorder func(rrset []dns.RR)) {
+	for start := 0; start < len(answers); {
+		end := start + 1
+		for end < len(answers) && sameRRset(answers[start], answers[end]) {
+			end++
+		}
+		rrset := answers[start:end]
+		sort.Slice(rrset, func(i, j int) bool { return rrset[i].String() < rrset[j].String() })
+		reorder(rrset)
+		start = end
+	}
+}
+
+// stickyShuffle reorders each RRset in answers using a shuffle seeded by
+// the client IP, so a client always sees the same order while different
+// clients are spread across the records.
+func stickyShuffle(answers []dns.RR, ip net.IP) {
+	h := fnv.New64a()
+	h.Write(ip)
+	seed := int64(h.Sum64())
+	eachRRset(answers, func(rrset []dns.RR) {
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(rrset), func(i, j int) { rrset[i], rrset[j] = rrset[j], rrset[i] })
+	})
+}
+
+// rotate moves the records of each RRset in answers offset places forward,
+// so consecutive queries start with the next record
+func rotate(answers []dns.RR, offset uint64) {
+	eachRRset(answers, func(rrset []dns.RR) {
+		n := int(offset % uint64(len(rrset)))
+		rotated := append(append([]dns.RR{}, rrset[n:]...), rrset[:n]...)
+		copy(rrset, rotated)
+	})
+}
+
+// randomShuffle reorders each RRset in answers randomly
+func randomShuffle(answers []dns.RR) {
+	eachRRset(answers, func(rrset []dns.RR) {
+		rand.Shuffle(len(rrset), func(i, j int) { rrset[i], rrset[j] = rrset[j], rrset[i] })
+	})
+}
+
+// weightedShuffle reorders each RRset in answers randomly, picking every
+// position with a chance proportional to the weight of the records left.
+// Records without a weight and answers without local records weigh 1.
+func weightedShuffle(answers []dns.RR, records Records) {
+	eachRRset(answers, func(rrset []dns.RR) {
+		weights := recordWeights(records, rrset[0].Header().Name)
+		total := 0
+		remaining := make([]int, len(rrset))
+		for i, rr := range rrset {
+			remaining[i] = weights[rdata(rr)]
+			if remaining[i] == 0 {
+				remaining[i] = 1
+			}
+			total += remaining[i]
+		}
+		for i := range rrset {
+			pick := rand.Intn(total)
+			j := i
+			for ; pick >= remaining[j]; j++ {
+				pick -= remaining[j]
+			}
+			total -= remaining[j]
+			rrset[i], rrset[j] = rrset[j], rrset[i]
+			remaining[i], remaining[j] = remaining[j], remaining[i]
+		}
+	})
+}
+
+// recordWeights maps the data of the records of name to their weight
+func recordWeights(records Records, name string) map[string]int {
+	weights := map[string]int{}
+	_, set, found := records.lookup(recordName(name))
+	if !found {
+		return weights
+	}
+	for _, record := range set {
+		if record.Weight == 0 {
+			continue
+		}
+		if rr, err := newRR(name, record.activeAt(now())); err == nil {
+			weights[rdata(rr)] = record.Weight
+		}
+	}
+	return weights
+}
+
+// orderAnswers reorders the records within each answer RRset as set by
+// round_robin_mode
+func (s *Server) orderAnswers(mode string, answers []dns.RR, client net.IP, records Records) {
+	switch mode {
+	case "sticky":
+		stickyShuffle(answers, client)
+	case "round-robin":
+		rotate(answers, s.rotation.Add(1)-1)
+	case "random":
+		randomShuffle(answers)
+	case "weighted":
+		weightedShuffle(answers, records)
+	}
+}
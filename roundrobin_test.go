@@ -0,0 +1,113 @@
+package easydns
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStickyOrdering(t *testing.T) {
+	var set []Record
+	var want []string
+	for i := 1; i <= 6; i++ {
+		value := fmt.Sprintf("10.0.0.%d", i)
+		set = append(set, Record{Type: "A", Value: value, TTL: 60})
+		want = append(want, value)
+	}
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53", RoundRobinMode: "sticky"},
+		Records: Records{"app.test.com": set},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(client string) []string {
+		msg := new(dns.Msg)
+		msg.SetQuestion("app.test.com.", dns.TypeA)
+		w := newRecorder("udp", client)
+		s.ServeDNS(w, msg)
+		if w.msg == nil {
+			t.Fatalf("query from %s was dropped", client)
+		}
+		return answerValues(w.msg)
+	}
+
+	first := map[string]bool{}
+	for i := 1; i <= 20; i++ {
+		client := fmt.Sprintf("192.0.2.%d", i)
+		order := query(client)
+		sorted := append([]string(nil), order...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(sorted, want) {
+			t.Fatalf("client %s got %v, want a reordering of %v", client, order, want)
+		}
+		for j := 0; j < 3; j++ {
+			if again := query(client); !reflect.DeepEqual(again, order) {
+				t.Fatalf("client %s got %v, then %v", client, order, again)
+			}
+		}
+		first[order[0]] = true
+	}
+	// 20 clients starting with fewer than 3 of 6 records would be far from
+	// an even spread
+	if len(first) < 3 {
+		t.Errorf("20 clients start with only %d different records", len(first))
+	}
+}
+
+func TestRoundRobinOrdering(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53", RoundRobinMode: "round-robin"},
+		Records: Records{"app.test.com": {
+			{Type: "A", Value: "10.0.0.1", TTL: 60},
+			{Type: "A", Value: "10.0.0.2", TTL: 60},
+			{Type: "A", Value: "10.0.0.3", TTL: 60},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+	}
+	for i, order := range want {
+		if got := answerValues(ask(t, s, "app.test.com", dns.TypeA)); !reflect.DeepEqual(got, order) {
+			t.Errorf("query %d: got %v, want %v", i+1, got, order)
+		}
+	}
+}
+
+func TestWeightedOrdering(t *testing.T) {
+	s, err := New(&Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53", RoundRobinMode: "weighted"},
+		Records: Records{"app.test.com": {
+			{Type: "A", Value: "10.0.0.1", TTL: 60, Weight: 9},
+			{Type: "A", Value: "10.0.0.2", TTL: 60},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := map[string]int{}
+	for range 1000 {
+		order := answerValues(ask(t, s, "app.test.com", dns.TypeA))
+		if len(order) != 2 {
+			t.Fatalf("got %v, want both records", order)
+		}
+		first[order[0]]++
+	}
+	// The heavier record comes first about 900 times
+	if first["10.0.0.1"] < 800 || first["10.0.0.2"] < 30 {
+		t.Errorf("got first records %v, want about 9 in 10 starting with 10.0.0.1", first)
+	}
+}
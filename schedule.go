@@ -0,0 +1,100 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// now is the clock used to pick scheduled record values, replaceable in tests
+var now = time.Now
+
+const scheduleTimeLayout = "15:04"
+
+// ScheduleEntry overrides the value of a record during a daily time window.
+// From and To are local "HH:MM" times; a window where To is before From
+// wraps around midnight. Days optionally restricts the window to the given
+// weekdays ("mon", "tue", ...), matched against the day the window starts.
+type ScheduleEntry struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Days  []string `json:"days,omitempty"`
+	Value string   `json:"value"`
+}
+
+func minutesOfDay(clock string) (int, error) {
+	t, err := time.Parse(scheduleTimeLayout, clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// isWeekday reports whether day names a weekday the way days expects it
+func isWeekday(day string) bool {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(day, d.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e ScheduleEntry) onDay(day time.Weekday) bool {
+	if len(e.Days) == 0 {
+		return true
+	}
+	for _, d := range e.Days {
+		if strings.EqualFold(d, day.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether t falls within the entry's window
+func (e ScheduleEntry) matches(t time.Time) (bool, error) {
+	for _, day := range e.Days {
+		if !isWeekday(day) {
+			return false, fmt.Errorf("invalid day %q, expected mon, tue, ...", day)
+		}
+	}
+	from, err := minutesOfDay(e.From)
+	if err != nil {
+		return false, err
+	}
+	to, err := minutesOfDay(e.To)
+	if err != nil {
+		return false, err
+	}
+	current := t.Hour()*60 + t.Minute()
+	if from <= to {
+		return current >= from && current < to && e.onDay(t.Weekday()), nil
+	}
+	// The window wraps around midnight: after midnight it belongs to the previous day
+	if current >= from {
+		return e.onDay(t.Weekday()), nil
+	}
+	if current < to {
+		return e.onDay(t.AddDate(0, 0, -1).Weekday()), nil
+	}
+	return false, nil
+}
+
+// activeAt returns the record with the value that applies at time t. The
+// first matching schedule entry wins, otherwise the base value is kept.
+func (r Record) activeAt(t time.Time) Record {
+	for _, entry := range r.Schedule {
+		ok, err := entry.matches(t)
+		if err != nil {
+			log.Printf("Skipping schedule entry: %v", err)
+			continue
+		}
+		if ok {
+			r.Value = entry.Value
+			break
+		}
+	}
+	return r
+}
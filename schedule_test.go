@@ -0,0 +1,105 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordActiveAt(t *testing.T) {
+	record := Record{
+		Type:  "A",
+		Value: "10.0.0.1",
+		Schedule: []ScheduleEntry{
+			{From: "02:00", To: "04:00", Value: "10.0.0.99"},
+			{From: "22:00", To: "01:00", Days: []string{"fri"}, Value: "10.0.0.50"},
+			{From: "broken", To: "05:00", Value: "10.0.0.66"},
+			{From: "05:00", To: "06:00", Days: []string{"someday"}, Value: "10.0.0.77"},
+		},
+	}
+	// 2024-01-05 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{name: "before the window", t: at(3, 1, 59), want: "10.0.0.1"},
+		{name: "start of the window", t: at(3, 2, 0), want: "10.0.0.99"},
+		{name: "inside the window", t: at(3, 3, 30), want: "10.0.0.99"},
+		{name: "end of the window is excluded", t: at(3, 4, 0), want: "10.0.0.1"},
+		{name: "wrapping window on its day", t: at(5, 23, 0), want: "10.0.0.50"},
+		{name: "wrapping window after midnight", t: at(6, 0, 30), want: "10.0.0.50"},
+		{name: "wrapping window on another day", t: at(4, 23, 0), want: "10.0.0.1"},
+		{name: "after midnight of another day", t: at(5, 0, 30), want: "10.0.0.1"},
+		{name: "unknown day never matches", t: at(3, 5, 30), want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := record.activeAt(tt.t).Value; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduledRecordAnswers(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Port: "53"},
+		Records: Records{
+			"app.test.com": {{
+				Type:     "A",
+				Value:    "10.0.0.1",
+				TTL:      60,
+				Schedule: []ScheduleEntry{{From: "02:00", To: "04:00", Value: "10.0.0.99"}},
+			}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(clock func() time.Time) { now = clock }(now)
+	tests := []struct {
+		clock time.Time
+		want  []string
+	}{
+		{clock: time.Date(2024, 1, 3, 1, 0, 0, 0, time.Local), want: []string{"10.0.0.1"}},
+		{clock: time.Date(2024, 1, 3, 3, 0, 0, 0, time.Local), want: []string{"10.0.0.99"}},
+		{clock: time.Date(2024, 1, 3, 5, 0, 0, 0, time.Local), want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clock.Format(scheduleTimeLayout), func(t *testing.T) {
+			now = func() time.Time { return tt.clock }
+			resp := ask(t, s, "app.test.com", dns.TypeA)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   ScheduleEntry
+		wantErr bool
+	}{
+		{name: "valid", entry: ScheduleEntry{From: "02:00", To: "04:00", Value: "10.0.0.2"}},
+		{name: "invalid time", entry: ScheduleEntry{From: "2am", To: "04:00", Value: "10.0.0.2"}, wantErr: true},
+		{name: "invalid day", entry: ScheduleEntry{From: "02:00", To: "04:00", Days: []string{"someday"}, Value: "10.0.0.2"}, wantErr: true},
+		{name: "invalid value", entry: ScheduleEntry{From: "02:00", To: "04:00", Value: "not-an-address"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60, Schedule: []ScheduleEntry{tt.entry}}}}
+			if err := ValidateRecords(records); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
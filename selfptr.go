@@ -0,0 +1,74 @@
+package easydns
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const selfPTRTTL = 300
+
+// selfPTRs answers reverse lookups of the server's own listen addresses
+// with the configured server name. Configured records always win, so
+// these never shadow an explicit reverse zone.
+type selfPTRs struct {
+	mu     sync.RWMutex
+	target string
+	names  map[string]bool
+}
+
+func newSelfPTRs() *selfPTRs {
+	return &selfPTRs{names: map[string]bool{}}
+}
+
+// listenIPs returns the IP addresses behind the resolved bind addresses.
+// A wildcard bind address stands for all addresses of the host.
+func listenIPs(addresses []string) []net.IP {
+	var ips []net.IP
+	for _, address := range addresses {
+		ip := net.ParseIP(strings.SplitN(address, "%", 2)[0])
+		if ip != nil && !ip.IsUnspecified() {
+			ips = append(ips, ip)
+			continue
+		}
+		hostAddrs, _ := net.InterfaceAddrs()
+		for _, addr := range hostAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	return ips
+}
+
+// set replaces the addresses answered for with ips, pointing at target
+func (s *selfPTRs) set(target string, ips []net.IP) {
+	names := map[string]bool{}
+	for _, ip := range ips {
+		if reverse, err := dns.ReverseAddr(ip.String()); err == nil {
+			names[reverse] = true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.target = dns.Fqdn(target)
+	s.names = names
+}
+
+// answer returns the PTR record for q if it asks for one of our addresses
+func (s *selfPTRs) answer(q dns.Question) []dns.RR {
+	if q.Qtype != dns.TypePTR {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.names[strings.ToLower(q.Name)] {
+		return nil
+	}
+	return []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: selfPTRTTL},
+		Ptr: s.target,
+	}}
+}
@@ -0,0 +1,398 @@
+package easydns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// Server is a DNS server answering from a Config. Queries are served
+// concurrently while Reload swaps in a new config.
+type Server struct {
+	config    atomic.Pointer[Config]
+	records   atomic.Pointer[recordSet]
+	recordsMu sync.Mutex
+	apiMu     sync.Mutex // Serializes record changes made through the API
+	holdDown  *recordHoldDown
+	rotation  atomic.Uint64 // Offset of the round-robin answer order
+
+	cache        *responseCache
+	upstreams    *upstreamClients
+	health       *upstreamHealth
+	recordHealth *recordHealth
+	transforms   []transformRule
+	policies     []policyRule
+	rewrites     *rewrites
+	acl          *acl
+	limiter      *rateLimiter
+	hits         *recordStats
+	challenges   *acmeChallenges
+	ownPTRs      *selfPTRs
+	secondaries  *secondaryZones
+	discovered   *discoveredRecords
+	serials      *zoneSerials
+	validator    *dnssecValidator
+	signer       *zoneSigner
+	store        RecordStore
+	blocklist    atomic.Pointer[blocklist]
+	metrics      *metrics
+	queryLog     *queryLog
+	listeners    *listeners
+	queries      *queryTracker
+	inflight     chan struct{} // Slots of queries being resolved, nil when unlimited
+
+	mu              sync.Mutex
+	addresses       []string
+	api             *http.Server
+	metricsServer   *http.Server
+	dohServer       *http.Server
+	dotServer       *dns.Server
+	dotConns        *connTracker // Open DoT connections
+	doqServer       *doqServer
+	mdns            *mdnsResponder
+	control         *http.Server
+	reloader        func() error
+	shutdownTracing func(context.Context) error
+	done            chan struct{}
+	stopOnce        sync.Once
+}
+
+// startQuery takes a slot for resolving a query, it reports false if
+// max_concurrent_queries are in flight
+func (s *Server) startQuery() bool {
+	if s.inflight == nil {
+		return true
+	}
+	select {
+	case s.inflight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// finishQuery frees the slot taken by startQuery
+func (s *Server) finishQuery() {
+	if s.inflight != nil {
+		<-s.inflight
+	}
+}
+
+// New validates cfg and sets up a server for it. The bind address is
+// resolved right away but nothing is listened on until ListenAndServe.
+func New(cfg *Config) (*Server, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+	for _, warning := range ConfigWarnings(cfg) {
+		log.Printf("warning: %s", warning)
+	}
+	store, err := OpenRecordStore(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record storage: %v", err)
+	}
+	stored, err := storedRecords(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	withStored := *cfg
+	withStored.Records = stored
+	cfg = &withStored
+	records, err := loadRecords(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load records: %v", err)
+	}
+	transforms, err := newTransforms(cfg.Transforms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up transforms: %v", err)
+	}
+	policies, err := newPolicies(cfg.Policies, upstreamPort(cfg.Forwarding.Transport))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up policies: %v", err)
+	}
+	rewrites, err := newRewrites(cfg.Rewrites)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up rewrites: %v", err)
+	}
+	access, err := newACL(cfg.ACL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up acl: %v", err)
+	}
+	limiter, err := newRateLimiter(cfg.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up rate limiting: %v", err)
+	}
+	addresses, err := resolveBindAddresses(cfg.Server.BindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bind address: %v", err)
+	}
+	// Validated above
+	bindRetry, _ := cfg.Server.bindRetry()
+	idleTimeout, _ := cfg.Server.tcpIdleTimeout()
+	protocols, _ := listenProtocols(cfg.Server.Protocols)
+
+	blocked, err := loadBlocklist(cfg.Blocklist, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load blocklist: %v", err)
+	}
+	queryLog, err := newQueryLog(cfg.Logging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query log: %v", err)
+	}
+	if (cfg.API.Enabled && cfg.API.Dashboard) || cfg.Control.Enabled {
+		queryLog.activity = newQueryActivity()
+	}
+	signer, err := newZoneSigner(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up DNSSEC signing: %v", err)
+	}
+	anchors, err := parseTrustAnchors(cfg.Forwarding.TrustAnchors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up DNSSEC validation: %v", err)
+	}
+
+	s := &Server{
+		holdDown:     newRecordHoldDown(),
+		upstreams:    newUpstreamClients(),
+		health:       newUpstreamHealth(),
+		recordHealth: newRecordHealth(),
+		transforms:   transforms,
+		policies:     policies,
+		rewrites:     rewrites,
+		acl:          access,
+		limiter:      limiter,
+		hits:         newRecordStats(),
+		challenges:   newACMEChallenges(),
+		ownPTRs:      newSelfPTRs(),
+		secondaries:  newSecondaryZones(),
+		discovered:   newDiscoveredRecords(),
+		serials:      newZoneSerials(),
+		signer:       signer,
+		store:        store,
+		metrics:      newMetrics(),
+		queryLog:     queryLog,
+		queries:      &queryTracker{},
+		addresses:    addresses,
+		done:         make(chan struct{}),
+	}
+	if cfg.Cache.Enabled {
+		s.cache = newResponseCache(cfg.Cache)
+	}
+	if cfg.Server.MaxConcurrentQueries > 0 {
+		s.inflight = make(chan struct{}, cfg.Server.MaxConcurrentQueries)
+	}
+	s.validator = newDNSSECValidator(anchors, s.queryDNSSEC)
+	s.blocklist.Store(blocked)
+	s.listeners = newListeners(cfg.Server.Port, protocols, bindRetry, idleTimeout, tsigSecrets(cfg), s.queries.track(s))
+	if cfg.DoT.Enabled {
+		s.dotServer, err = newDoTServer(cfg.DoT, idleTimeout, tsigSecrets(cfg), s.queries.track(s))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up DNS-over-TLS: %v", err)
+		}
+		s.dotConns = newConnTracker()
+	}
+	if cfg.DoQ.Enabled {
+		s.doqServer, err = newDoQServer(cfg.DoQ, cfg.DoT, idleTimeout, s.queries.track(s))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up DNS-over-QUIC: %v", err)
+		}
+	}
+
+	active := *cfg
+	active.Forwarding = withoutOwnUpstreams(cfg.Forwarding, addresses, cfg.Server.Port)
+	s.setConfig(&active)
+	s.loadSecondaryZones(cfg.Transfer)
+	s.setRecords(records)
+	if cfg.Server.SelfPTR != "" {
+		s.ownPTRs.set(cfg.Server.SelfPTR, listenIPs(addresses))
+	}
+	return s, nil
+}
+
+// ListenAndServe starts the DNS listeners, the DoT, DoQ, DoH, API and
+// metrics servers and the records directory watcher as configured, and
+// blocks until Shutdown is called.
+func (s *Server) ListenAndServe() error {
+	cfg := s.currentConfig()
+	errs := make(chan error, 1)
+	s.mu.Lock()
+	if cfg.Tracing.Enabled {
+		shutdown, err := setupTracing(cfg.Tracing)
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to set up tracing: %v", err)
+		}
+		s.shutdownTracing = shutdown
+	}
+	if cfg.API.Enabled {
+		s.api = &http.Server{Addr: cfg.API.Address, Handler: s.apiHandler()}
+		log.Printf("starting API server on %s", cfg.API.Address)
+		go func(api *http.Server) {
+			if err := api.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("failed to start API server: %v", err)
+			}
+		}(s.api)
+	}
+	if cfg.Control.Enabled {
+		listener, err := listenControl(cfg.Control.socket())
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to open control socket: %v", err)
+		}
+		s.control = &http.Server{Handler: s.controlHandler()}
+		log.Printf("starting control server on %s", cfg.Control.socket())
+		go func(control *http.Server) {
+			if err := control.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("failed to start control server: %v", err)
+			}
+		}(s.control)
+	}
+	if cfg.Metrics.Address != "" {
+		s.metricsServer = &http.Server{Addr: cfg.Metrics.Address, Handler: s.metrics.handler()}
+		log.Printf("starting metrics server on %s", cfg.Metrics.Address)
+		go func(metricsServer *http.Server) {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("failed to start metrics server: %v", err)
+			}
+		}(s.metricsServer)
+	}
+	if cfg.DoH.Enabled {
+		s.dohServer = &http.Server{Addr: cfg.DoH.Address, Handler: s.dohHandler()}
+		log.Printf("starting DNS-over-HTTPS server on %s", cfg.DoH.Address)
+		go func(dohServer *http.Server) {
+			if err := dohServer.ListenAndServeTLS(cfg.DoH.CertFile, cfg.DoH.KeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("failed to start DNS-over-HTTPS server: %v", err)
+			}
+		}(s.dohServer)
+	}
+	addresses := s.addresses
+	s.mu.Unlock()
+
+	if files := activationFiles(); len(files) > 0 {
+		if err := s.listeners.activate(files); err != nil {
+			return fmt.Errorf("failed to start server: %v", err)
+		}
+	} else if err := s.listeners.update(addresses); err != nil {
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	if s.dotServer != nil {
+		if err := startServer(s.dotServer, s.dotConns); err != nil {
+			return fmt.Errorf("failed to start DNS-over-TLS server: %v", err)
+		}
+		log.Printf("starting DNS-over-TLS server on %s", s.dotServer.Addr)
+	}
+	if s.doqServer != nil {
+		if err := s.doqServer.start(); err != nil {
+			return fmt.Errorf("failed to start DNS-over-QUIC server: %v", err)
+		}
+		log.Printf("starting DNS-over-QUIC server on %s", s.doqServer.addr())
+	}
+	if cfg.MDNS.Enabled {
+		responder, err := newMDNSResponder(cfg.MDNS, s)
+		if err != nil {
+			return fmt.Errorf("failed to start mDNS responder: %v", err)
+		}
+		s.mu.Lock()
+		s.mdns = responder
+		s.mu.Unlock()
+		log.Printf("starting mDNS responder on %s", mdnsGroup)
+		go responder.serve()
+	}
+	if cfg.RecordsDir.Path != "" {
+		go s.watchRecordsDir(cfg.RecordsDir)
+	}
+	if interval, _ := cfg.Forwarding.healthCheckInterval(); interval > 0 {
+		go s.probeUpstreams(interval)
+	}
+	if interval, _ := cfg.Blocklist.refreshInterval(); interval > 0 {
+		go s.refreshBlocklist(cfg.Blocklist, interval)
+	}
+	for _, zone := range cfg.Transfer.Secondary {
+		go s.followPrimary(zone)
+	}
+	if cfg.Discovery.enabled() {
+		go s.watchDiscovery(cfg.Discovery)
+	}
+	go s.watchRecordStore()
+	go s.watchHostsFiles()
+	go s.checkRecords()
+	notifySystemd("READY=1")
+	select {
+	case <-s.done:
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+// Shutdown stops the DNS listeners and the DoT and DoQ servers, draining
+// the queries in flight until ctx is done, then stops the DoH, API and
+// metrics servers and the records directory watcher, flushes pending traces
+// and closes the query log
+func (s *Server) Shutdown(ctx context.Context) error {
+	notifySystemd("STOPPING=1")
+	s.stopOnce.Do(func() { close(s.done) })
+	errs := []error{s.queries.drain(func() error {
+		errs := []error{s.listeners.close(ctx)}
+		if s.dotServer != nil {
+			errs = append(errs, s.dotConns.drain("DNS-over-TLS server", func() error { return s.dotServer.ShutdownContext(ctx) }))
+		}
+		if s.doqServer != nil {
+			errs = append(errs, s.doqServer.shutdown(ctx))
+		}
+		return errors.Join(errs...)
+	})}
+	s.holdDown.schedule(0, nil)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.api != nil {
+		errs = append(errs, s.api.Shutdown(ctx))
+	}
+	if s.metricsServer != nil {
+		errs = append(errs, s.metricsServer.Shutdown(ctx))
+	}
+	if s.control != nil {
+		errs = append(errs, s.control.Shutdown(ctx))
+	}
+	if s.dohServer != nil {
+		errs = append(errs, s.dohServer.Shutdown(ctx))
+	}
+	if s.mdns != nil {
+		errs = append(errs, s.mdns.close())
+	}
+	if s.shutdownTracing != nil {
+		errs = append(errs, s.shutdownTracing(ctx))
+	}
+	errs = append(errs, s.queryLog.close(), s.store.Close())
+	s.upstreams.close()
+	return errors.Join(errs...)
+}
+
+// RebindInterfaces re-resolves an interface bind address, e.g. after a DHCP
+// renewal, and listens on its current addresses
+func (s *Server) RebindInterfaces() error {
+	cfg := s.currentConfig()
+	if !isInterfaceBinding(cfg.Server.BindAddress) {
+		return nil
+	}
+	addresses, err := resolveBindAddresses(cfg.Server.BindAddress)
+	if err != nil {
+		return fmt.Errorf("failed to re-resolve bind address: %v", err)
+	}
+	s.mu.Lock()
+	s.addresses = addresses
+	s.mu.Unlock()
+	if err := s.listeners.update(addresses); err != nil {
+		return fmt.Errorf("failed to update listeners: %v", err)
+	}
+	if cfg.Server.SelfPTR != "" {
+		s.ownPTRs.set(cfg.Server.SelfPTR, listenIPs(addresses))
+	}
+	return nil
+}
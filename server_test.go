@@ -0,0 +1,130 @@
+package easydns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// recorder is a dns.ResponseWriter keeping the response written to it
+type recorder struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func newRecorder(network, client string) *recorder {
+	if network == "tcp" {
+		return &recorder{remote: &net.TCPAddr{IP: net.ParseIP(client), Port: 40000}}
+	}
+	return &recorder{remote: &net.UDPAddr{IP: net.ParseIP(client), Port: 40000}}
+}
+
+func (r *recorder) LocalAddr() net.Addr {
+	if _, ok := r.remote.(*net.TCPAddr); ok {
+		return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+	}
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (r *recorder) RemoteAddr() net.Addr        { return r.remote }
+func (r *recorder) WriteMsg(m *dns.Msg) error   { r.msg = m; return nil }
+func (r *recorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *recorder) Close() error                { return nil }
+func (r *recorder) TsigStatus() error           { return nil }
+func (r *recorder) TsigTimersOnly(bool)         {}
+func (r *recorder) Hijack()                     {}
+
+// serve sends query to s over UDP from 127.0.0.1 and returns the response,
+// nil if the query was dropped
+func serve(s *Server, query *dns.Msg) *dns.Msg {
+	w := newRecorder("udp", "127.0.0.1")
+	s.ServeDNS(w, query)
+	return w.msg
+}
+
+// ask queries s for name and qtype, see serve
+func ask(t *testing.T, s *Server, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	resp := serve(s, query)
+	if resp == nil {
+		t.Fatalf("query for %s %s was dropped", name, dns.TypeToString[qtype])
+	}
+	return resp
+}
+
+// answerValues returns the data of the answer records of resp
+func answerValues(resp *dns.Msg) []string {
+	var values []string
+	for _, rr := range resp.Answer {
+		values = append(values, rdata(rr))
+	}
+	return values
+}
+
+// upstream is a DNS server on 127.0.0.1 answering forwarded queries with
+// handler
+type upstream struct {
+	addr    string
+	queries atomic.Int64
+}
+
+// startUpstream starts an upstream server on a free UDP port, stopped when
+// the test ends
+func startUpstream(t *testing.T, handler func(w dns.ResponseWriter, r *dns.Msg)) *upstream {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{addr: conn.LocalAddr().String()}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			u.queries.Add(1)
+			handler(w, r)
+		}),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return u
+}
+
+// answerA is an upstream handler answering every A query with address
+func answerA(address string) func(w dns.ResponseWriter, r *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A " + address)
+			resp.Answer = append(resp.Answer, rr)
+		}
+		w.WriteMsg(resp)
+	}
+}
+
+// deadUpstream returns the address of a UDP port nothing listens on
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+// answerRcode is an upstream handler answering every query with rcode
+func answerRcode(rcode int) func(w dns.ResponseWriter, r *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetRcode(r, rcode)
+		w.WriteMsg(resp)
+	}
+}
@@ -0,0 +1,484 @@
+package easydns
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Signatures are valid from an hour ago, for clock skew, for a week, and
+	// are made again after a day
+	signatureInception = time.Hour
+	signatureValidity  = 7 * 24 * time.Hour
+	signatureRefresh   = 24 * time.Hour
+	maxCachedSigs      = 10000
+	dsTTL              = 3600
+)
+
+// signingAlgorithms are the algorithms keys can be generated for and their
+// key sizes
+var signingAlgorithms = map[string]struct {
+	algorithm uint8
+	bits      int
+}{
+	"ECDSAP256SHA256": {dns.ECDSAP256SHA256, 256},
+	"ECDSAP384SHA384": {dns.ECDSAP384SHA384, 384},
+	"ED25519":         {dns.ED25519, 256},
+	"RSASHA256":       {dns.RSASHA256, 2048},
+}
+
+// SigningConfig holds the keys of the zones signed with DNSSEC
+type SigningConfig struct {
+	// KeyDir holds the keys of the signed zones as BIND style
+	// K<zone>+<algorithm>+<tag>.key and .private files. Missing keys are
+	// generated.
+	KeyDir string `json:"key_dir,omitempty"`
+	// Algorithm of generated keys: ECDSAP256SHA256 (default),
+	// ECDSAP384SHA384, ED25519 or RSASHA256
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+func (c SigningConfig) validate() error {
+	if _, found := signingAlgorithms[c.algorithm()]; !found {
+		return fmt.Errorf("unknown algorithm %q, expected ECDSAP256SHA256, ECDSAP384SHA384, ED25519 or RSASHA256", c.Algorithm)
+	}
+	return nil
+}
+
+func (c SigningConfig) algorithm() string {
+	if c.Algorithm == "" {
+		return "ECDSAP256SHA256"
+	}
+	return strings.ToUpper(c.Algorithm)
+}
+
+// signedZoneNames returns the zones with DNSSEC signing enabled
+func (c *Config) signedZoneNames() []string {
+	var names []string
+	for zone, cfg := range c.Zones {
+		if cfg.DNSSEC {
+			names = append(names, recordName(zone))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// signingKey is a DNSKEY and its private key
+type signingKey struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+// zoneSigningKeys are the key signing key, which signs the DNSKEY records
+// and is referenced by the DS record in the parent zone, and the zone
+// signing key, which signs everything else
+type zoneSigningKeys struct {
+	ksk, zsk signingKey
+}
+
+// keyFileName returns the BIND style base name of the files of key
+func keyFileName(key *dns.DNSKEY) string {
+	return fmt.Sprintf("K%s+%03d+%05d", key.Hdr.Name, key.Algorithm, key.KeyTag())
+}
+
+// readSigningKey reads a key from its .key and .private files
+func readSigningKey(path string) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	rr, err := dns.NewRR(string(data))
+	if err != nil {
+		return signingKey{}, fmt.Errorf("%s: %v", path, err)
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return signingKey{}, fmt.Errorf("%s: not a DNSKEY record", path)
+	}
+	privatePath := strings.TrimSuffix(path, ".key") + ".private"
+	file, err := os.Open(privatePath)
+	if err != nil {
+		return signingKey{}, err
+	}
+	defer file.Close()
+	priv, err := key.ReadPrivateKey(file, privatePath)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("%s: %v", privatePath, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return signingKey{}, fmt.Errorf("%s: unsupported private key", privatePath)
+	}
+	return signingKey{key: key, priv: signer}, nil
+}
+
+// generateSigningKey generates a key for zone with flags and writes it to
+// dir
+func generateSigningKey(dir, zone string, flags uint16, algorithm string) (signingKey, error) {
+	alg := signingAlgorithms[algorithm]
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: alg.algorithm,
+	}
+	priv, err := key.Generate(alg.bits)
+	if err != nil {
+		return signingKey{}, err
+	}
+	signer := priv.(crypto.Signer)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return signingKey{}, err
+	}
+	base := filepath.Join(dir, keyFileName(key))
+	if err := os.WriteFile(base+".private", []byte(key.PrivateKeyString(priv)), 0o600); err != nil {
+		return signingKey{}, err
+	}
+	if err := os.WriteFile(base+".key", []byte(key.String()+"\n"), 0o644); err != nil {
+		return signingKey{}, err
+	}
+	log.Printf("generated %s for zone %s", base, zone)
+	return signingKey{key: key, priv: signer}, nil
+}
+
+// loadZoneSigningKeys reads the keys of zone from the key directory and
+// generates the ones that are missing
+func loadZoneSigningKeys(cfg SigningConfig, zone string) (zoneSigningKeys, error) {
+	var keys zoneSigningKeys
+	paths, err := filepath.Glob(filepath.Join(cfg.KeyDir, "K"+dns.Fqdn(zone)+"+*.key"))
+	if err != nil {
+		return keys, err
+	}
+	for _, path := range paths {
+		key, err := readSigningKey(path)
+		if err != nil {
+			return keys, err
+		}
+		switch key.key.Flags {
+		case 257:
+			keys.ksk = key
+		case 256:
+			keys.zsk = key
+		}
+	}
+	if keys.ksk.key == nil {
+		if keys.ksk, err = generateSigningKey(cfg.KeyDir, zone, 257, cfg.algorithm()); err != nil {
+			return keys, fmt.Errorf("failed to generate key signing key: %v", err)
+		}
+	}
+	if keys.zsk.key == nil {
+		if keys.zsk, err = generateSigningKey(cfg.KeyDir, zone, 256, cfg.algorithm()); err != nil {
+			return keys, fmt.Errorf("failed to generate zone signing key: %v", err)
+		}
+	}
+	return keys, nil
+}
+
+// ZoneDS returns the DS record of a signed zone in zone file notation, to
+// be added to the parent zone. The keys are generated if they don't exist
+// yet.
+func ZoneDS(cfg *Config, zone string) (string, error) {
+	if !slices.Contains(cfg.signedZoneNames(), recordName(zone)) {
+		return "", fmt.Errorf("zone %s is not signed", zone)
+	}
+	keys, err := loadZoneSigningKeys(cfg.Signing, recordName(zone))
+	if err != nil {
+		return "", err
+	}
+	return keys.ds().String(), nil
+}
+
+// ds returns the DS record of the key signing key
+func (k zoneSigningKeys) ds() *dns.DS {
+	ds := k.ksk.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = dsTTL
+	return ds
+}
+
+type cachedSig struct {
+	sig    *dns.RRSIG
+	expiry time.Time
+}
+
+// zoneSigner signs the answers for the signed zones on the fly
+type zoneSigner struct {
+	zones map[string]zoneSigningKeys // By recordName
+
+	mu   sync.Mutex
+	sigs map[string]cachedSig // By signed RRset
+}
+
+// newZoneSigner loads the keys of the signed zones. It returns nil when no
+// zone is signed.
+func newZoneSigner(cfg *Config) (*zoneSigner, error) {
+	names := cfg.signedZoneNames()
+	if len(names) == 0 {
+		return nil, nil
+	}
+	z := &zoneSigner{zones: map[string]zoneSigningKeys{}, sigs: map[string]cachedSig{}}
+	for _, zone := range names {
+		keys, err := loadZoneSigningKeys(cfg.Signing, zone)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %v", zone, err)
+		}
+		z.zones[zone] = keys
+		log.Printf("zone %s is signed, its DS record for the parent zone is %s", zone, keys.ds())
+	}
+	return z, nil
+}
+
+// withKeys adds the DNSKEY records of the signed zones at their apex
+func (z *zoneSigner) withKeys(records Records, zones map[string]ZoneConfig) Records {
+	if z == nil {
+		return records
+	}
+	for name, cfg := range zones {
+		zone := recordName(name)
+		keys, found := z.zones[zone]
+		if !found || !cfg.DNSSEC {
+			continue
+		}
+		set := withoutType(records[zone], "DNSKEY")
+		for _, key := range []*dns.DNSKEY{keys.ksk.key, keys.zsk.key} {
+			set = append(set, Record{Type: "DNSKEY", Value: rdata(key), TTL: cfg.TTL})
+		}
+		records[zone] = set
+	}
+	return records
+}
+
+// nsecChains returns the names of each signed zone in canonical order, the
+// NSEC chain proving which names exist
+func (z *zoneSigner) nsecChains(records Records) map[string][]string {
+	if z == nil {
+		return nil
+	}
+	chains := map[string][]string{}
+	for zone := range z.zones {
+		var names []string
+		for name := range records {
+			if name == zone || dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+				names = append(names, dns.Fqdn(name))
+			}
+		}
+		sort.Slice(names, func(i, j int) bool { return canonicalCompare(names[i], names[j]) < 0 })
+		chains[zone] = names
+	}
+	return chains
+}
+
+// zoneFor returns the longest signed zone containing name
+func (z *zoneSigner) zoneFor(name string) (string, zoneSigningKeys, bool) {
+	if z == nil {
+		return "", zoneSigningKeys{}, false
+	}
+	zones := make([]string, 0, len(z.zones))
+	for zone := range z.zones {
+		zones = append(zones, zone)
+	}
+	zone, found := authoritativeZone(zones, recordName(name))
+	return zone, z.zones[zone], found
+}
+
+// signature returns the RRSIG of rrset made with key, from the cache if it
+// was signed recently
+func (z *zoneSigner) signature(rrset []dns.RR, key signingKey) (*dns.RRSIG, error) {
+	var id strings.Builder
+	for _, rr := range rrset {
+		id.WriteString(rr.String())
+		id.WriteByte('\n')
+	}
+	z.mu.Lock()
+	cached, found := z.sigs[id.String()]
+	z.mu.Unlock()
+	if found && now().Before(cached.expiry) {
+		return cached.sig, nil
+	}
+	h := rrset[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: h.Class, Ttl: h.Ttl},
+		Inception:  uint32(now().Add(-signatureInception).Unix()),
+		Expiration: uint32(now().Add(signatureValidity).Unix()),
+		KeyTag:     key.key.KeyTag(),
+		SignerName: key.key.Hdr.Name,
+		Algorithm:  key.key.Algorithm,
+	}
+	if err := sig.Sign(key.priv, rrset); err != nil {
+		return nil, err
+	}
+	z.mu.Lock()
+	if len(z.sigs) >= maxCachedSigs {
+		z.sigs = map[string]cachedSig{}
+	}
+	z.sigs[id.String()] = cachedSig{sig: sig, expiry: now().Add(signatureRefresh)}
+	z.mu.Unlock()
+	return sig, nil
+}
+
+// signSection adds signatures to the RRsets of section that belong to zone.
+// RRsets answered from a wildcard are signed as the wildcard, see RFC 4035
+// 5.3.4.
+func (z *zoneSigner) signSection(section []dns.RR, zone string, keys zoneSigningKeys, records Records) []dns.RR {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var order []rrsetKey
+	rrsets := map[rrsetKey][]dns.RR{}
+	for _, rr := range section {
+		k := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if _, found := rrsets[k]; !found {
+			order = append(order, k)
+		}
+		rrsets[k] = append(rrsets[k], rr)
+	}
+	signed := make([]dns.RR, 0, len(section)+len(order))
+	for _, k := range order {
+		rrset := rrsets[k]
+		signed = append(signed, rrset...)
+		if k.rrtype == dns.TypeRRSIG || k.rrtype == dns.TypeOPT || !dns.IsSubDomain(dns.Fqdn(zone), k.name) {
+			continue
+		}
+		owner := rrset[0].Header().Name
+		if key, _, found := records.lookup(recordName(owner)); found && strings.HasPrefix(key, "*.") {
+			owner = dns.Fqdn(key)
+		}
+		toSign := make([]dns.RR, len(rrset))
+		for i, rr := range rrset {
+			toSign[i] = dns.Copy(rr)
+			toSign[i].Header().Name = owner
+		}
+		key := keys.zsk
+		if k.rrtype == dns.TypeDNSKEY {
+			key = keys.ksk
+		}
+		sig, err := z.signature(toSign, key)
+		if err != nil {
+			log.Printf("failed to sign %s %s: %v", k.name, dns.TypeToString[k.rrtype], err)
+			continue
+		}
+		sig = dns.Copy(sig).(*dns.RRSIG)
+		sig.Hdr.Name = rrset[0].Header().Name
+		signed = append(signed, sig)
+	}
+	return signed
+}
+
+// nameExists reports whether name has records or is an empty non-terminal
+// above names with records
+func nameExists(records Records, name string) bool {
+	for other := range records {
+		if dns.IsSubDomain(name, dns.Fqdn(other)) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsecFor returns the NSEC record of the chain that matches or covers name
+func nsecFor(chain []string, records Records, name string, ttl uint32) *dns.NSEC {
+	if len(chain) == 0 {
+		return nil
+	}
+	// The last name not after name, wrapping around to the last name of the
+	// zone
+	i := sort.Search(len(chain), func(i int) bool { return canonicalCompare(chain[i], name) > 0 }) - 1
+	if i < 0 {
+		i = len(chain) - 1
+	}
+	owner := chain[i]
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: chain[(i+1)%len(chain)],
+		TypeBitMap: []uint16{dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	for _, record := range records[recordName(owner)] {
+		if rrtype, found := dns.StringToType[record.Type]; found && !slices.Contains(nsec.TypeBitMap, rrtype) {
+			nsec.TypeBitMap = append(nsec.TypeBitMap, rrtype)
+		}
+	}
+	slices.Sort(nsec.TypeBitMap)
+	return nsec
+}
+
+// sign adds the DNSSEC records to msg, the response to a query with the
+// DO bit for a name in a signed zone: signatures, and NSEC records proving
+// NXDOMAIN, NODATA and wildcard answers
+func (z *zoneSigner) sign(msg *dns.Msg, records Records, chains map[string][]string) {
+	if z == nil || len(msg.Question) != 1 {
+		return
+	}
+	q := msg.Question[0]
+	zone, keys, found := z.zoneFor(q.Name)
+	if !found {
+		return
+	}
+	// Follow CNAMEs within the zone to the name the answer is about
+	name := dns.CanonicalName(q.Name)
+	for _, rr := range msg.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) && q.Qtype != dns.TypeCNAME {
+			name = dns.CanonicalName(cname.Target)
+		}
+	}
+	if !dns.IsSubDomain(dns.Fqdn(zone), name) {
+		msg.Answer = z.signSection(msg.Answer, zone, keys, records)
+		return
+	}
+	key, _, exists := records.lookup(recordName(name))
+	if msg.Rcode == dns.RcodeNameError && !exists && nameExists(records, name) {
+		// An empty non-terminal exists, it just has no data (RFC 8020)
+		msg.Rcode = dns.RcodeSuccess
+	}
+	// NSEC records have the negative caching TTL of the zone
+	var soa dns.Msg
+	appendZoneSOA(&soa, records, zone)
+	ttl := uint32(defaultSOAMinimum)
+	if len(soa.Ns) > 0 {
+		ttl = soa.Ns[0].Header().Ttl
+	}
+	answered := false
+	for _, rr := range msg.Answer {
+		if strings.EqualFold(rr.Header().Name, name) && (rr.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeANY) {
+			answered = true
+		}
+	}
+	var proofs []*dns.NSEC
+	chain := chains[zone]
+	switch {
+	case msg.Rcode == dns.RcodeNameError:
+		// The name and a wildcard at its closest encloser don't exist
+		proofs = append(proofs, nsecFor(chain, records, name, ttl))
+		encloser := parentName(name)
+		for encloser != dns.Fqdn(zone) && !nameExists(records, encloser) {
+			encloser = parentName(encloser)
+		}
+		proofs = append(proofs, nsecFor(chain, records, "*."+encloser, ttl))
+	case !answered && msg.Rcode == dns.RcodeSuccess:
+		// The name, or the wildcard it matched, has no data of this type
+		proofs = append(proofs, nsecFor(chain, records, name, ttl))
+		if exists && strings.HasPrefix(key, "*.") {
+			proofs = append(proofs, nsecFor(chain, records, dns.Fqdn(key), ttl))
+		}
+	case exists && strings.HasPrefix(key, "*."):
+		// A wildcard answer, the name itself doesn't exist
+		proofs = append(proofs, nsecFor(chain, records, name, ttl))
+	}
+	for _, proof := range proofs {
+		if proof != nil && !slices.ContainsFunc(msg.Ns, func(rr dns.RR) bool { return dns.IsDuplicate(rr, proof) }) {
+			msg.Ns = append(msg.Ns, proof)
+		}
+	}
+	msg.Answer = z.signSection(msg.Answer, zone, keys, records)
+	msg.Ns = z.signSection(msg.Ns, zone, keys, records)
+}
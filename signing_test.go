@@ -0,0 +1,215 @@
+package easydns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// signedZoneConfig returns a config signing home.arpa with the keys in
+// keyDir
+func signedZoneConfig(keyDir string) *Config {
+	return &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Zones: map[string]ZoneConfig{"home.arpa": {
+			MName:  "ns1.home.arpa",
+			RName:  "hostmaster@home.arpa",
+			NS:     []string{"ns1.home.arpa"},
+			DNSSEC: true,
+		}},
+		Signing: SigningConfig{KeyDir: keyDir},
+		Records: Records{
+			"ns1.home.arpa":    {{Type: "A", Value: "10.0.0.53", TTL: 60}},
+			"host.home.arpa":   {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"a.b.home.arpa":    {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+			"*.wild.home.arpa": {{Type: "A", Value: "10.0.0.3", TTL: 60}},
+		},
+	}
+}
+
+// askDNSSEC queries s for name and qtype with the DO bit set
+func askDNSSEC(t *testing.T, s *Server, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	query.SetEdns0(dns.DefaultMsgSize, true)
+	resp := serve(s, query)
+	if resp == nil {
+		t.Fatalf("query for %s %s was dropped", name, dns.TypeToString[qtype])
+	}
+	return resp
+}
+
+// zoneKeys returns the validated DNSKEY records of a signed zone served by
+// s
+func servedZoneKeys(t *testing.T, s *Server, zone string) zoneTrust {
+	t.Helper()
+	resp := askDNSSEC(t, s, zone, dns.TypeDNSKEY)
+	set, found := rrsets(resp.Answer)[dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET}]
+	if !found || len(set.rrs) != 2 {
+		t.Fatalf("got DNSKEY answer %v, want the key and zone signing keys", resp.Answer)
+	}
+	trust := zoneTrust{secure: true, zone: zone}
+	for _, rr := range set.rrs {
+		trust.keys = append(trust.keys, rr.(*dns.DNSKEY))
+	}
+	// The DNSKEY records are signed by the key signing key
+	if err := trust.verify(set); err != nil {
+		t.Fatalf("DNSKEY records: %v", err)
+	}
+	return trust
+}
+
+func TestSignedZone(t *testing.T) {
+	s, err := New(signedZoneConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust := servedZoneKeys(t, s, "home.arpa.")
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		wantNSECs int
+		// check validates the NSEC records of the authority section
+		check func(t *testing.T, nsecs []*dns.NSEC)
+	}{
+		{name: "answer", qname: "host.home.arpa.", qtype: dns.TypeA},
+		{name: "apex soa", qname: "home.arpa.", qtype: dns.TypeSOA},
+		{
+			name:      "nxdomain",
+			qname:     "nosuch.home.arpa.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+			wantNSECs: 2,
+			check: func(t *testing.T, nsecs []*dns.NSEC) {
+				if !nsecDenies(nsecs, "nosuch.home.arpa.") {
+					t.Errorf("NSEC records %v don't prove that the name and a wildcard don't exist", nsecs)
+				}
+			},
+		},
+		{
+			name:      "nodata",
+			qname:     "host.home.arpa.",
+			qtype:     dns.TypeAAAA,
+			wantNSECs: 1,
+			check: func(t *testing.T, nsecs []*dns.NSEC) {
+				nsec := nsecs[0]
+				if nsec.Hdr.Name != "host.home.arpa." || hasType(nsec.TypeBitMap, dns.TypeAAAA) || !hasType(nsec.TypeBitMap, dns.TypeA) {
+					t.Errorf("got NSEC %v, want one at the name with A and without AAAA", nsec)
+				}
+			},
+		},
+		{
+			name:      "empty non-terminal",
+			qname:     "b.home.arpa.",
+			qtype:     dns.TypeA,
+			wantNSECs: 1,
+			check: func(t *testing.T, nsecs []*dns.NSEC) {
+				if !nsecCovers(nsecs[0], "b.home.arpa.") {
+					t.Errorf("got NSEC %v, want one covering the empty non-terminal", nsecs[0])
+				}
+			},
+		},
+		{
+			name:      "wildcard answer",
+			qname:     "host.wild.home.arpa.",
+			qtype:     dns.TypeA,
+			wantNSECs: 1,
+			check: func(t *testing.T, nsecs []*dns.NSEC) {
+				if !nsecCovers(nsecs[0], "host.wild.home.arpa.") {
+					t.Errorf("got NSEC %v, want one proving the name itself doesn't exist", nsecs[0])
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := askDNSSEC(t, s, tt.qname, tt.qtype)
+			if resp.Rcode != tt.wantRcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			// Every RRset is signed by the zone signing key
+			for _, section := range [][]dns.RR{resp.Answer, resp.Ns} {
+				for header, set := range rrsets(section) {
+					if len(set.rrs) == 0 {
+						continue
+					}
+					if err := trust.verify(set); err != nil {
+						t.Errorf("%s %s: %v", header.Name, dns.TypeToString[header.Rrtype], err)
+					}
+				}
+			}
+			var nsecs []*dns.NSEC
+			for _, rr := range resp.Ns {
+				if nsec, ok := rr.(*dns.NSEC); ok {
+					nsecs = append(nsecs, nsec)
+				}
+			}
+			if len(nsecs) != tt.wantNSECs {
+				t.Fatalf("got NSEC records %v, want %d", nsecs, tt.wantNSECs)
+			}
+			if tt.check != nil {
+				tt.check(t, nsecs)
+			}
+		})
+	}
+}
+
+func TestSignedZoneWithoutDO(t *testing.T) {
+	s, err := New(signedZoneConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, qname := range []string{"host.home.arpa", "nosuch.home.arpa"} {
+		resp := ask(t, s, qname, dns.TypeA)
+		for _, rr := range append(resp.Answer, resp.Ns...) {
+			if rrtype := rr.Header().Rrtype; rrtype == dns.TypeRRSIG || rrtype == dns.TypeNSEC {
+				t.Errorf("%s: got %v for a query without the DO bit", qname, rr)
+			}
+		}
+	}
+}
+
+func TestZoneDS(t *testing.T) {
+	keyDir := t.TempDir()
+	cfg := signedZoneConfig(keyDir)
+	printed, err := ZoneDS(cfg, "home.arpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := dns.NewRR(printed)
+	if err != nil {
+		t.Fatalf("DS record %q: %v", printed, err)
+	}
+	ds := rr.(*dns.DS)
+	// The server uses the keys ZoneDS generated
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched := false
+	for _, key := range servedZoneKeys(t, s, "home.arpa.").keys {
+		if key.Flags == 257 && key.KeyTag() == ds.KeyTag && strings.EqualFold(key.ToDS(ds.DigestType).Digest, ds.Digest) {
+			matched = true
+		}
+	}
+	if !matched {
+		t.Errorf("DS record %s matches no key signing key of the zone", ds)
+	}
+	// The keys are kept across restarts
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedZoneKeys(t, restarted, "home.arpa.").keys; !reflect.DeepEqual(got, servedZoneKeys(t, s, "home.arpa.").keys) {
+		t.Errorf("got keys %v after a restart, want the saved ones", got)
+	}
+	if _, err := ZoneDS(cfg, "other.arpa"); err == nil {
+		t.Error("got a DS record for a zone that is not signed")
+	}
+}
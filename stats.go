@@ -0,0 +1,96 @@
+package easydns
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// StatsConfig controls the record hit counters
+type StatsConfig struct {
+	// ResetOnReload clears the counters when the config is reloaded,
+	// otherwise they are kept cumulative
+	ResetOnReload bool `json:"reset_on_reload,omitempty"`
+}
+
+type recordKey struct {
+	name       string
+	recordType string
+}
+
+// recordStats counts how often each record was used in an answer
+type recordStats struct {
+	mu   sync.RWMutex
+	hits map[recordKey]*atomic.Uint64
+}
+
+func newRecordStats() *recordStats {
+	return &recordStats{hits: map[recordKey]*atomic.Uint64{}}
+}
+
+// hit records that the given record was served
+func (s *recordStats) hit(name string, record Record) {
+	key := recordKey{name, record.Type}
+	s.mu.RLock()
+	counter, found := s.hits[key]
+	s.mu.RUnlock()
+	if !found {
+		s.mu.Lock()
+		if counter, found = s.hits[key]; !found {
+			counter = new(atomic.Uint64)
+			s.hits[key] = counter
+		}
+		s.mu.Unlock()
+	}
+	counter.Add(1)
+}
+
+// reset clears all counters
+func (s *recordStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits = map[recordKey]*atomic.Uint64{}
+}
+
+type recordHitCount struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Hits uint64 `json:"hits"`
+}
+
+// snapshot returns the counters of the given records ordered by name and
+// type, with records of the same name and type sharing a counter. Records that were never served are included with zero hits.
+func (s *recordStats) snapshot(records Records) []recordHitCount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make([]recordHitCount, 0, len(records))
+	seen := map[recordKey]bool{}
+	for name, set := range records {
+		for _, record := range set {
+			key := recordKey{name, record.Type}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			count := recordHitCount{Name: name, Type: record.Type}
+			if counter, found := s.hits[key]; found {
+				count.Hits = counter.Load()
+			}
+			counts = append(counts, count)
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Name != counts[j].Name {
+			return counts[i].Name < counts[j].Name
+		}
+		return counts[i].Type < counts[j].Type
+	})
+	return counts
+}
+
+func (s *Server) handleRecordStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hits.snapshot(s.currentRecords()))
+}
@@ -0,0 +1,440 @@
+package easydns
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	defaultStorageInterval = 5 * time.Second
+	defaultEtcdPrefix      = "/easydns/records/"
+	storageTimeout         = 5 * time.Second
+	// maxEtcdTxnOps is the default limit of operations in one etcd
+	// transaction
+	maxEtcdTxnOps = 128
+)
+
+// StorageConfig selects where the records of the config are kept. The
+// default keeps them in memory. With a shared backend, several instances
+// serve the same records and see each other's changes made through the API
+// and dynamic updates.
+type StorageConfig struct {
+	// Backend is "memory" (default), "sqlite" or "etcd"
+	Backend string `json:"backend,omitempty"`
+	// Path is the SQLite database file
+	Path string `json:"path,omitempty"`
+	// Interval is how often the SQLite database is checked for changes by
+	// other instances, defaults to 5s
+	Interval  string   `json:"interval,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"` // etcd cluster members, e.g. 127.0.0.1:2379
+	// Prefix of the etcd keys the records are stored under, defaults to
+	// /easydns/records/
+	Prefix   string `json:"prefix,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (c StorageConfig) validate() error {
+	switch c.Backend {
+	case "", "memory":
+	case "sqlite":
+		if c.Path == "" {
+			return fmt.Errorf("the sqlite backend needs a path")
+		}
+	case "etcd":
+		if len(c.Endpoints) == 0 {
+			return fmt.Errorf("the etcd backend needs at least one endpoint")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q, expected memory, sqlite or etcd", c.Backend)
+	}
+	if _, err := c.interval(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c StorageConfig) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultStorageInterval, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", c.Interval)
+	}
+	return interval, nil
+}
+
+// shared reports whether the records are kept outside the config file
+func (c StorageConfig) shared() bool {
+	return c.Backend != "" && c.Backend != "memory"
+}
+
+// RecordStore keeps the records served by easydns
+type RecordStore interface {
+	// Records returns all stored records
+	Records(ctx context.Context) (Records, error)
+	// SetRecordSets replaces the records of each name in sets, a nil set
+	// removes the name
+	SetRecordSets(ctx context.Context, sets Records) error
+	// Watch calls changed whenever the stored records may have changed,
+	// also by other instances, until ctx is done
+	Watch(ctx context.Context, changed func()) error
+	Close() error
+}
+
+// OpenRecordStore opens the storage backend of cfg, e.g. to manage shared
+// records from other programs
+func OpenRecordStore(cfg StorageConfig) (RecordStore, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case "sqlite":
+		// Validated above
+		interval, _ := cfg.interval()
+		return openSQLiteStore(cfg.Path, interval)
+	case "etcd":
+		return openEtcdStore(cfg)
+	}
+	return &memoryStore{records: Records{}}, nil
+}
+
+// replacedRecordSets returns the changes that turn current into records
+func replacedRecordSets(current, records Records) Records {
+	sets := Records{}
+	for name := range current {
+		sets[name] = nil
+	}
+	for name, set := range normalizeRecords(records) {
+		delete(sets, name)
+		sets[name] = set
+	}
+	return sets
+}
+
+// memoryStore keeps the records in memory. They come from the config file,
+// and changes are written back to it by the API and dynamic updates when
+// their persist_path is set.
+type memoryStore struct {
+	mu      sync.Mutex
+	records Records
+}
+
+func (m *memoryStore) Records(ctx context.Context) (Records, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return normalizeRecords(m.records), nil
+}
+
+func (m *memoryStore) SetRecordSets(ctx context.Context, sets Records) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = withRecordSets(m.records, sets)
+	return nil
+}
+
+// Watch returns when ctx is done, nothing else changes the records
+func (m *memoryStore) Watch(ctx context.Context, changed func()) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// sqliteStore keeps the records in a SQLite database, one row of JSON
+// encoded records per name. A revision counter bumped with every change
+// lets other instances using the same file notice it.
+type sqliteStore struct {
+	db       *sql.DB
+	interval time.Duration
+}
+
+func openSQLiteStore(path string, interval time.Duration) (*sqliteStore, error) {
+	// Wait for locks held by other instances instead of failing
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS records (name TEXT PRIMARY KEY, records TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS revision (id INTEGER PRIMARY KEY CHECK (id = 0), revision INTEGER NOT NULL)",
+		"INSERT OR IGNORE INTO revision VALUES (0, 0)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to set up %s: %v", path, err)
+		}
+	}
+	return &sqliteStore{db: db, interval: interval}, nil
+}
+
+func (s *sqliteStore) Records(ctx context.Context) (Records, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, records FROM records")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := Records{}
+	for rows.Next() {
+		var name, data string
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, err
+		}
+		set, err := decodeRecordSet([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %v", name, err)
+		}
+		records[name] = set
+	}
+	return records, rows.Err()
+}
+
+func (s *sqliteStore) SetRecordSets(ctx context.Context, sets Records) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, set := range sets {
+		if set == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM records WHERE name = ?", recordName(name))
+		} else {
+			var data []byte
+			if data, err = json.Marshal(set); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO records (name, records) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET records = excluded.records", recordName(name), string(data))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE revision SET revision = revision + 1"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) revision(ctx context.Context) (int64, error) {
+	var revision int64
+	err := s.db.QueryRowContext(ctx, "SELECT revision FROM revision").Scan(&revision)
+	return revision, err
+}
+
+// Watch polls the revision, as SQLite can't notify other processes
+func (s *sqliteStore) Watch(ctx context.Context, changed func()) error {
+	last, err := s.revision(ctx)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		revision, err := s.revision(ctx)
+		if err != nil {
+			return err
+		}
+		if revision != last {
+			last = revision
+			changed()
+		}
+	}
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// etcdStore keeps the JSON encoded records of each name under a key below
+// prefix
+type etcdStore struct {
+	client *clientv3.Client
+	prefix string
+}
+
+func openEtcdStore(cfg StorageConfig) (*etcdStore, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		DialTimeout: storageTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+	return &etcdStore{client: client, prefix: prefix}, nil
+}
+
+func (e *etcdStore) Records(ctx context.Context) (Records, error) {
+	resp, err := e.client.Get(ctx, e.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	records := Records{}
+	for _, kv := range resp.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), e.prefix)
+		set, err := decodeRecordSet(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %v", name, err)
+		}
+		records[name] = set
+	}
+	return records, nil
+}
+
+// SetRecordSets applies the changes in transactions of at most
+// maxEtcdTxnOps names each
+func (e *etcdStore) SetRecordSets(ctx context.Context, sets Records) error {
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for start := 0; start < len(names); start += maxEtcdTxnOps {
+		var ops []clientv3.Op
+		for _, name := range names[start:min(start+maxEtcdTxnOps, len(names))] {
+			key := e.prefix + recordName(name)
+			if sets[name] == nil {
+				ops = append(ops, clientv3.OpDelete(key))
+				continue
+			}
+			data, err := json.Marshal(sets[name])
+			if err != nil {
+				return err
+			}
+			ops = append(ops, clientv3.OpPut(key, string(data)))
+		}
+		if _, err := e.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *etcdStore) Watch(ctx context.Context, changed func()) error {
+	for resp := range e.client.Watch(clientv3.WithRequireLeader(ctx), e.prefix, clientv3.WithPrefix()) {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		changed()
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("watch of %s closed", e.prefix)
+}
+
+func (e *etcdStore) Close() error {
+	return e.client.Close()
+}
+
+// storedRecords returns the records to serve for cfg: the records of the
+// config file, kept in memory, or the records of a shared backend. An empty
+// shared backend is filled with the records of the config file first.
+func storedRecords(store RecordStore, cfg *Config) (Records, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+	stored, err := store.Records(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records from storage: %v", err)
+	}
+	if cfg.Storage.shared() && len(stored) > 0 {
+		return stored, nil
+	}
+	if err := store.SetRecordSets(ctx, replacedRecordSets(stored, cfg.Records)); err != nil {
+		return nil, fmt.Errorf("failed to store records: %v", err)
+	}
+	if cfg.Storage.shared() && len(cfg.Records) > 0 {
+		log.Printf("stored the records of %d names from the config in the empty %s storage", len(cfg.Records), cfg.Storage.Backend)
+	}
+	return normalizeRecords(cfg.Records), nil
+}
+
+// storeRecordSets writes record changes to the storage backend
+func (s *Server) storeRecordSets(sets Records) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+	if err := s.store.SetRecordSets(ctx, sets); err != nil {
+		return fmt.Errorf("failed to store records: %v", err)
+	}
+	return nil
+}
+
+// reloadStoredRecords serves the records of the storage backend after
+// another instance changed them
+func (s *Server) reloadStoredRecords() {
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+	stored, err := s.store.Records(ctx)
+	if err != nil {
+		log.Printf("failed to read records from storage: %v", err)
+		return
+	}
+	running := s.currentConfig()
+	if reflect.DeepEqual(normalizeRecords(stored), normalizeRecords(running.Records)) {
+		// Our own change
+		return
+	}
+	if err := ValidateRecords(stored); err != nil {
+		log.Printf("ignoring invalid records in storage: %v", err)
+		return
+	}
+	candidate := *running
+	candidate.Records = stored
+	records, err := loadRecords(&candidate)
+	if err != nil {
+		log.Printf("ignoring records in storage: %v", err)
+		return
+	}
+	s.setConfig(&candidate)
+	generation := s.setRecords(records)
+	log.Printf("records changed in storage, serving records generation %d (%d records)", generation, len(records))
+}
+
+// watchRecordStore applies changes to the stored records until the server
+// is shut down
+func (s *Server) watchRecordStore() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.done
+		cancel()
+	}()
+	for {
+		err := s.store.Watch(ctx, s.reloadStoredRecords)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("watching the record storage failed: %v", err)
+		select {
+		case <-s.done:
+			return
+		case <-time.After(defaultStorageInterval):
+		}
+	}
+}
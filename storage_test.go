@@ -0,0 +1,109 @@
+package easydns
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// storageConfig returns an update config keeping its records in the SQLite
+// database at path
+func storageConfig(path string) *Config {
+	cfg := updateConfig()
+	cfg.API = APIConfig{Token: "secret"}
+	cfg.Storage = StorageConfig{Backend: "sqlite", Path: path}
+	return cfg
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.db")
+	cfg := storageConfig(path)
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.store.Close() })
+	if status := apiRequest(t, s, http.MethodPut, "/api/v1/records/new.test.com", "secret", `{"type": "A", "value": "10.0.0.9", "ttl": 60}`); status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+	if status := apiRequest(t, s, http.MethodDelete, "/api/v1/records/app.test.com", "secret", ""); status != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+	}
+	update := new(dns.Msg)
+	update.SetUpdate("lab.test.com.")
+	update.Insert([]dns.RR{mustRR(t, "dhcp.lab.test.com. 60 A 10.0.0.10")})
+	if rcode := sendUpdate(t, startUpdateServer(t, s, cfg), update, testUpdateSecret); rcode != dns.RcodeSuccess {
+		t.Fatalf("got rcode %s, want NOERROR", dns.RcodeToString[rcode])
+	}
+
+	// The config file still has the original records
+	restarted, err := New(storageConfig(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { restarted.store.Close() })
+	other, err := New(storageConfig(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.store.Close() })
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "record created through the api", query: "new.test.com", want: []string{"10.0.0.9"}},
+		{name: "record deleted through the api", query: "app.test.com"},
+		{name: "record added by an update", query: "dhcp.lab.test.com", want: []string{"10.0.0.10"}},
+		{name: "unchanged record", query: "host.lab.test.com", want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerValues(ask(t, restarted, tt.query, dns.TypeA)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v after a restart, want %v", got, tt.want)
+			}
+			if err := restarted.Reload(storageConfig(path)); err != nil {
+				t.Fatal(err)
+			}
+			if got := answerValues(ask(t, restarted, tt.query, dns.TypeA)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v after a reload, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Changes by another instance are served once they are noticed
+	if status := apiRequest(t, other, http.MethodPut, "/api/v1/records/shared.test.com", "secret", `{"type": "A", "value": "10.0.0.11", "ttl": 60}`); status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+	restarted.reloadStoredRecords()
+	if got := answerValues(ask(t, restarted, "shared.test.com", dns.TypeA)); !reflect.DeepEqual(got, []string{"10.0.0.11"}) {
+		t.Errorf("got answers %v, want the record stored by the other instance", got)
+	}
+}
+
+func TestValidateStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(cfg *Config)
+		wantErr bool
+	}{
+		{name: "sqlite", change: func(cfg *Config) {}},
+		{name: "sqlite without a path", change: func(cfg *Config) { cfg.Storage.Path = "" }, wantErr: true},
+		{name: "etcd without endpoints", change: func(cfg *Config) { cfg.Storage = StorageConfig{Backend: "etcd"} }, wantErr: true},
+		{name: "unknown backend", change: func(cfg *Config) { cfg.Storage.Backend = "redis" }, wantErr: true},
+		{name: "invalid interval", change: func(cfg *Config) { cfg.Storage.Interval = "soon" }, wantErr: true},
+		{name: "persist path with shared storage", change: func(cfg *Config) { cfg.API.PersistPath = "config.json" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := storageConfig("records.db")
+			tt.change(cfg)
+			if err := ValidateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
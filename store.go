@@ -0,0 +1,133 @@
+package easydns
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// recordSet is an immutable snapshot of the records being served
+type recordSet struct {
+	records    Records
+	generation uint64
+	chains     map[string][]string // NSEC chains of the signed zones
+	views      []view
+}
+
+// recordName turns a query or record name into the key used in the active
+// record set: lowercase and without the trailing dot
+func recordName(name string) string {
+	return strings.TrimSuffix(dns.CanonicalName(name), ".")
+}
+
+// normalizeRecords keys records by recordName so lookups are
+// case-insensitive, and parses the subnets of subnet-tagged records. The
+// record lists are copied, so the result can be modified without touching
+// records.
+func normalizeRecords(records Records) Records {
+	normalized := make(Records, len(records))
+	for name, set := range records {
+		set = append([]Record(nil), set...)
+		for i := range set {
+			if len(set[i].Subnets) > 0 {
+				// Validated before, invalid subnets never match
+				set[i].subnets, _ = parseNetworks(set[i].Subnets)
+			}
+		}
+		normalized[recordName(name)] = set
+	}
+	return normalized
+}
+
+// loadRecords merges the records of the config with its zone files,
+// records directory and hosts files
+func loadRecords(cfg *Config) (Records, error) {
+	records, err := loadZoneFiles(cfg.ZoneFiles, cfg.Records)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RecordsDir.Path != "" {
+		if records, err = loadRecordsDir(cfg.RecordsDir.Path, records); err != nil {
+			return nil, err
+		}
+	}
+	return loadHostsFiles(cfg.HostsFiles, records)
+}
+
+// setRecords makes records the active record set and returns its
+// generation. Discovered names are added beneath them, the default TTL is
+// applied and, with auto_ptr enabled, the reverse records of its addresses
+// are added. The records of each view are built on top of the result. The
+// set is swapped atomically so updates never block or tear in-flight
+// queries. Changes in zones with a hold-down are kept back until they are
+// stable.
+func (s *Server) setRecords(records Records) uint64 {
+	input := records
+	records = s.discovered.merge(s.secondaries.merge(normalizeRecords(records)))
+	var views []view
+	if cfg := s.currentConfig(); cfg != nil {
+		records = s.serials.withZones(records, cfg.Zones)
+		records = s.signer.withKeys(records, cfg.Zones)
+		records = withDefaultTTL(records, cfg.TTL.DefaultTTL)
+		if cfg.AutoPTR {
+			records = withAutoPTRs(records)
+		}
+		views = s.withViews(records, cfg)
+	}
+	s.recordsMu.Lock()
+	defer s.recordsMu.Unlock()
+	current := s.records.Load()
+	if cfg := s.currentConfig(); cfg != nil && current != nil {
+		if holdDowns, _ := parseHoldDowns(cfg.HoldDown); len(holdDowns) > 0 {
+			// Kept back changes are applied by setting the latest records
+			// again once they are due
+			var due time.Duration
+			records, due = s.holdDown.apply(holdDowns, current.records, records, now())
+			s.holdDown.schedule(due, func() { s.setRecords(input) })
+		}
+	}
+	generation := uint64(1)
+	if current != nil {
+		generation = current.generation + 1
+	}
+	s.records.Store(&recordSet{records: records, generation: generation, chains: s.signer.nsecChains(records), views: views})
+	return generation
+}
+
+// currentRecords returns the active records
+func (s *Server) currentRecords() Records {
+	return s.currentRecordSet().records
+}
+
+// currentRecordSet returns the active record set
+func (s *Server) currentRecordSet() *recordSet {
+	if current := s.records.Load(); current != nil {
+		return current
+	}
+	return &recordSet{}
+}
+
+// setConfig makes config the config used to answer queries. Its client
+// networks are parsed here once instead of on every query.
+func (s *Server) setConfig(config *Config) {
+	config.ECS.prepare()
+	config.TSIG.prepare()
+	s.config.Store(config)
+}
+
+// currentConfig returns the active config
+func (s *Server) currentConfig() *Config {
+	return s.config.Load()
+}
+
+// FindRecordKey returns the key name is stored under in records, which may
+// differ from name in case or a trailing dot
+func FindRecordKey(records Records, name string) (string, bool) {
+	for key := range records {
+		if strings.EqualFold(strings.TrimSuffix(key, "."), strings.TrimSuffix(name, ".")) {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,71 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "test.com", want: "test.com"},
+		{name: "Test.com.", want: "test.com"},
+		{name: "WWW.TEST.COM.", want: "www.test.com"},
+		{name: "*.Dev.Test.com", want: "*.dev.test.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recordName(tt.name); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveMatching(t *testing.T) {
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{
+			"test.com":     {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"WWW.Test.Com": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		qname string
+		want  []string
+	}{
+		{qname: "test.com.", want: []string{"10.0.0.1"}},
+		{qname: "Test.com.", want: []string{"10.0.0.1"}},
+		{qname: "TEST.COM.", want: []string{"10.0.0.1"}},
+		{qname: "www.test.com.", want: []string{"10.0.0.2"}},
+		{qname: "WWW.TEST.COM.", want: []string{"10.0.0.2"}},
+		{qname: "wWw.TeSt.CoM.", want: []string{"10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qname, func(t *testing.T) {
+			resp := ask(t, s, tt.qname, dns.TypeA)
+			if resp.Rcode != dns.RcodeSuccess {
+				t.Fatalf("got rcode %s", dns.RcodeToString[resp.Rcode])
+			}
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			// The answer keeps the casing of the question
+			if got := resp.Answer[0].Header().Name; got != tt.qname {
+				t.Errorf("got answer name %q, want %q", got, tt.qname)
+			}
+			if got := resp.Question[0].Name; got != tt.qname {
+				t.Errorf("got question name %q, want %q", got, tt.qname)
+			}
+		})
+	}
+}
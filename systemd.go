@@ -0,0 +1,57 @@
+package easydns
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// notifySystemd sends state, e.g. "READY=1", to the service manager when
+// running as a systemd notify service. It does nothing otherwise.
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// Abstract socket names starting with @ are handled by the net package
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		log.Printf("failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("failed to notify systemd: %v", err)
+	}
+}
+
+// activationFiles returns the sockets passed to this process by systemd
+// socket activation, or nil when it was not socket activated. The
+// environment variables are removed so child processes don't pick them up.
+func activationFiles() []*os.File {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	files := make([]*os.File, 0, count)
+	for i := range count {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+	return files
+}
@@ -0,0 +1,481 @@
+package easydns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	transferChunkSize    = 100 // Records per message of an outgoing transfer
+	transferTimeout      = 30 * time.Second
+	defaultTransferRetry = time.Minute
+	minTransferInterval  = 10 * time.Second
+)
+
+// TransferConfig serves zones to secondary servers with AXFR and IXFR, and
+// transfers zones from primary servers when this server is a secondary
+type TransferConfig struct {
+	// Zones may be transferred by clients in Allow. With Keys set,
+	// transfer requests must also be signed with one of them.
+	Zones []string  `json:"zones,omitempty"`
+	Allow []string  `json:"allow,omitempty"`
+	Keys  []TSIGKey `json:"keys,omitempty"`
+	// Secondary zones are transferred from their primary and served
+	Secondary []SecondaryZone `json:"secondary,omitempty"`
+}
+
+// SecondaryZone is a zone transferred from a primary server. It is checked
+// for a new serial at the refresh interval of its SOA record.
+type SecondaryZone struct {
+	Zone    string `json:"zone"`
+	Primary string `json:"primary"`           // Host or host:port
+	Key     string `json:"key,omitempty"`     // Name of the key in keys to sign requests with
+	File    string `json:"file,omitempty"`    // Zone file keeping the zone across restarts
+	Refresh string `json:"refresh,omitempty"` // Overrides the refresh interval of the SOA record
+}
+
+func (c TransferConfig) validate() error {
+	for _, zone := range c.Zones {
+		if _, ok := dns.IsDomainName(zone); !ok || recordName(zone) == "" {
+			return fmt.Errorf("zone %q is not a valid zone", zone)
+		}
+	}
+	if len(c.Zones) > 0 && len(c.Allow) == 0 && len(c.Keys) == 0 {
+		return fmt.Errorf("allow or keys must be set to serve zones")
+	}
+	if _, err := parseNetworks(c.Allow); err != nil {
+		return fmt.Errorf("allow: %v", err)
+	}
+	if err := validateTSIGKeys(c.Keys); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, zone := range c.Secondary {
+		if _, ok := dns.IsDomainName(zone.Zone); !ok || recordName(zone.Zone) == "" {
+			return fmt.Errorf("secondary zone %q is not a valid zone", zone.Zone)
+		}
+		if seen[recordName(zone.Zone)] {
+			return fmt.Errorf("secondary zone %s is listed twice", zone.Zone)
+		}
+		seen[recordName(zone.Zone)] = true
+		if zone.Primary == "" {
+			return fmt.Errorf("secondary zone %s: primary must be set", zone.Zone)
+		}
+		if _, err := normalizeHostPort(zone.Primary, defaultUpstreamPort); err != nil {
+			return fmt.Errorf("secondary zone %s: %v", zone.Zone, err)
+		}
+		if _, found := findTSIGKey(c.Keys, dns.Fqdn(zone.Key)); zone.Key != "" && !found {
+			return fmt.Errorf("secondary zone %s: key %s is not in keys", zone.Zone, zone.Key)
+		}
+		if _, err := zone.refresh(); err != nil {
+			return fmt.Errorf("secondary zone %s: %v", zone.Zone, err)
+		}
+	}
+	return nil
+}
+
+// refresh returns the configured refresh interval, 0 to use the one of the
+// SOA record
+func (z SecondaryZone) refresh() (time.Duration, error) {
+	if z.Refresh == "" {
+		return 0, nil
+	}
+	refresh, err := time.ParseDuration(z.Refresh)
+	if err != nil || refresh < minTransferInterval {
+		return 0, fmt.Errorf("refresh must be a duration of at least %s", minTransferInterval)
+	}
+	return refresh, nil
+}
+
+// secondaryZoneNames returns the names of the secondary zones
+func (c TransferConfig) secondaryZoneNames() []string {
+	names := make([]string, 0, len(c.Secondary))
+	for _, zone := range c.Secondary {
+		names = append(names, zone.Zone)
+	}
+	return names
+}
+
+// serialNewer reports whether serial a is newer than b in RFC 1982 serial
+// number arithmetic
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// zoneSOA returns the SOA record of zone in records
+func zoneSOA(records Records, zone string) (*dns.SOA, bool) {
+	for _, record := range records[zone] {
+		if record.Type != "SOA" {
+			continue
+		}
+		if rr, err := newRR(dns.Fqdn(zone), record); err == nil {
+			return rr.(*dns.SOA), true
+		}
+	}
+	return nil, false
+}
+
+// zoneRRs returns the records at and below zone, without its SOA record,
+// sorted by name
+func zoneRRs(records Records, zone string) []dns.RR {
+	names := make([]string, 0, len(records))
+	for name := range records {
+		if dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var rrs []dns.RR
+	for _, name := range names {
+		for _, record := range records[name] {
+			if name == zone && record.Type == "SOA" {
+				continue
+			}
+			rr, err := newRR(dns.Fqdn(name), record.activeAt(now()))
+			if err != nil {
+				log.Printf("zone transfer of %s: skipping record %s: %v", zone, name, err)
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// serveTransfer answers an AXFR or IXFR request. IXFR requests get the full
+// zone, or only the SOA record when the client is up to date. It reports
+// whether the zone was sent; otherwise msg holds the response to write.
+func (s *Server) serveTransfer(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg, cfg *Config, records Records) bool {
+	q := r.Question[0]
+	zone := recordName(q.Name)
+	tsig := r.IsTsig()
+	if tsig != nil && w.TsigStatus() == nil {
+		// Sign the response with the key of the request, streamed
+		// responses are signed by dns.Transfer
+		defer msg.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+	if !slices.ContainsFunc(cfg.Transfer.Zones, func(z string) bool { return recordName(z) == zone }) {
+		msg.Rcode = dns.RcodeNotAuth
+		return false
+	}
+	allow, _ := parseNetworks(cfg.Transfer.Allow)
+	if len(allow) > 0 && !containsIP(allow, clientIP(w.RemoteAddr())) {
+		log.Printf("refusing transfer of zone %s to %s, it is not in allow", zone, w.RemoteAddr())
+		msg.Rcode = dns.RcodeRefused
+		return false
+	}
+	if len(cfg.Transfer.Keys) > 0 {
+		if tsig == nil {
+			log.Printf("refusing unsigned transfer of zone %s to %s", zone, w.RemoteAddr())
+			msg.Rcode = dns.RcodeRefused
+			return false
+		}
+		key, found := findTSIGKey(cfg.Transfer.Keys, tsig.Hdr.Name)
+		algorithm, _ := key.algorithm()
+		if err := w.TsigStatus(); err != nil || !found || !strings.EqualFold(tsig.Algorithm, algorithm) {
+			log.Printf("refusing transfer of zone %s to %s with invalid TSIG key %s: %v", zone, w.RemoteAddr(), tsig.Hdr.Name, err)
+			msg.Rcode = dns.RcodeNotAuth
+			return false
+		}
+	}
+	soa, found := zoneSOA(records, zone)
+	if !found {
+		log.Printf("cannot transfer zone %s, it has no SOA record", zone)
+		msg.Rcode = dns.RcodeServerFailure
+		return false
+	}
+	msg.Authoritative = true
+	if q.Qtype == dns.TypeIXFR {
+		// A single SOA record tells the client it is up to date, or over
+		// UDP that it has to retry over TCP
+		var clientSerial uint32
+		if len(r.Ns) > 0 {
+			if clientSOA, ok := r.Ns[0].(*dns.SOA); ok {
+				clientSerial = clientSOA.Serial
+			}
+		}
+		if w.RemoteAddr().Network() == "udp" || (len(r.Ns) > 0 && !serialNewer(soa.Serial, clientSerial)) {
+			msg.Answer = []dns.RR{soa}
+			return false
+		}
+	} else if w.RemoteAddr().Network() == "udp" {
+		msg.Rcode = dns.RcodeRefused
+		return false
+	}
+
+	rrs := append([]dns.RR{soa}, zoneRRs(records, zone)...)
+	rrs = append(rrs, soa)
+	envelopes := make(chan *dns.Envelope, len(rrs)/transferChunkSize+1)
+	for start := 0; start < len(rrs); start += transferChunkSize {
+		envelopes <- &dns.Envelope{RR: rrs[start:min(start+transferChunkSize, len(rrs))]}
+	}
+	close(envelopes)
+	transfer := new(dns.Transfer)
+	if err := transfer.Out(w, r, envelopes); err != nil {
+		log.Printf("transfer of zone %s to %s failed: %v", zone, w.RemoteAddr(), err)
+	} else {
+		log.Printf("transferred zone %s serial %d to %s (%d records)", zone, soa.Serial, w.RemoteAddr(), len(rrs)-1)
+	}
+	// dns.Transfer switches the connection to signing timers only
+	w.TsigTimersOnly(false)
+	return true
+}
+
+// secondaryZones holds the records of the zones transferred from primaries
+type secondaryZones struct {
+	mu    sync.Mutex
+	zones map[string]Records
+}
+
+func newSecondaryZones() *secondaryZones {
+	return &secondaryZones{zones: map[string]Records{}}
+}
+
+func (z *secondaryZones) set(zone string, records Records) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.zones[zone] = records
+}
+
+// serial returns the serial of the transferred zone
+func (z *secondaryZones) serial(zone string) (uint32, bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	soa, found := zoneSOA(z.zones[zone], zone)
+	if !found {
+		return 0, false
+	}
+	return soa.Serial, true
+}
+
+// merge replaces the names at and below each transferred zone in records
+// with the transferred ones
+func (z *secondaryZones) merge(records Records) Records {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for zone, transferred := range z.zones {
+		for name := range records {
+			if dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+				delete(records, name)
+			}
+		}
+		for name, set := range transferred {
+			records[name] = append([]Record(nil), set...)
+		}
+	}
+	return records
+}
+
+// TransferError is returned when a zone cannot be transferred from its
+// primary
+type TransferError struct {
+	zone          string
+	primary       string
+	originalError error
+}
+
+func (e TransferError) Error() string {
+	return fmt.Sprintf("failed to transfer zone %s from %s: %v", e.zone, e.primary, e.originalError)
+}
+
+func (e TransferError) Unwrap() error {
+	return e.originalError
+}
+
+// signWith signs m with the key of zone, if it has one, and returns the
+// secrets needed to verify the response
+func signWith(m *dns.Msg, zone SecondaryZone, keys []TSIGKey) map[string]string {
+	if zone.Key == "" {
+		return nil
+	}
+	key, _ := findTSIGKey(keys, dns.Fqdn(zone.Key))
+	algorithm, _ := key.algorithm()
+	m.SetTsig(dns.Fqdn(key.Name), algorithm, 300, time.Now().Unix())
+	return map[string]string{dns.Fqdn(key.Name): key.Secret}
+}
+
+// primarySOA queries the primary of zone for the zone's SOA record
+func primarySOA(zone SecondaryZone, keys []TSIGKey) (*dns.SOA, error) {
+	primary, _ := normalizeHostPort(zone.Primary, defaultUpstreamPort)
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone.Zone), dns.TypeSOA)
+	client := &dns.Client{Timeout: defaultUpstreamTimeout}
+	client.TsigSecret = signWith(m, zone, keys)
+	resp, _, err := client.Exchange(m, primary)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, UpstreamRcodeError{server: primary, rcode: resp.Rcode}
+	}
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa, nil
+		}
+	}
+	return nil, errors.New("no SOA record in the response")
+}
+
+// transferFromPrimary transfers zone from its primary with AXFR
+func transferFromPrimary(zone SecondaryZone, keys []TSIGKey) (Records, error) {
+	primary, _ := normalizeHostPort(zone.Primary, defaultUpstreamPort)
+	name := recordName(zone.Zone)
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(name))
+	transfer := &dns.Transfer{DialTimeout: transferTimeout, ReadTimeout: transferTimeout}
+	transfer.TsigSecret = signWith(m, zone, keys)
+	envelopes, err := transfer.In(m, primary)
+	if err != nil {
+		return nil, TransferError{zone: name, primary: primary, originalError: err}
+	}
+	records := Records{}
+	skipped := 0
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, TransferError{zone: name, primary: primary, originalError: envelope.Error}
+		}
+		for _, rr := range envelope.RR {
+			owner := recordName(rr.Header().Name)
+			if !dns.IsSubDomain(dns.Fqdn(name), dns.Fqdn(owner)) {
+				skipped++
+				continue
+			}
+			if _, isSOA := rr.(*dns.SOA); isSOA && owner == name {
+				if _, found := zoneSOA(records, name); found {
+					// The closing SOA record of the transfer
+					continue
+				}
+			}
+			record, supported := zoneRecord(rr)
+			if !supported {
+				skipped++
+				continue
+			}
+			records[owner] = append(records[owner], record)
+		}
+	}
+	if _, found := zoneSOA(records, name); !found {
+		return nil, TransferError{zone: name, primary: primary, originalError: errors.New("the transfer has no SOA record")}
+	}
+	if skipped > 0 {
+		log.Printf("zone %s: skipped %d unsupported or out of zone records from %s", name, skipped, primary)
+	}
+	return records, nil
+}
+
+// writeSecondaryZone saves a transferred zone to file, replacing it
+// atomically
+func writeSecondaryZone(file string, records Records) error {
+	var buf bytes.Buffer
+	if err := WriteZoneFile(&buf, records); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadSecondaryZones loads the secondary zones saved by an earlier run, so
+// they are served before the first transfer completes
+func (s *Server) loadSecondaryZones(cfg TransferConfig) {
+	for _, zone := range cfg.Secondary {
+		if zone.File == "" {
+			continue
+		}
+		records, err := loadZoneFile(zone.File)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("secondary zone %s: ignoring saved copy: %v", zone.Zone, err)
+			}
+			continue
+		}
+		s.secondaries.set(recordName(zone.Zone), records)
+	}
+}
+
+// refreshSecondaryZone transfers zone from its primary when the primary has
+// a newer serial, and returns how long to wait before checking again
+func (s *Server) refreshSecondaryZone(zone SecondaryZone) time.Duration {
+	keys := s.currentConfig().Transfer.Keys
+	name := recordName(zone.Zone)
+	soa, err := primarySOA(zone, keys)
+	if err != nil {
+		log.Printf("secondary zone %s: failed to query the SOA record of %s: %v", name, zone.Primary, err)
+		return defaultTransferRetry
+	}
+	refresh := max(time.Duration(soa.Refresh)*time.Second, minTransferInterval)
+	retry := max(time.Duration(soa.Retry)*time.Second, minTransferInterval)
+	// Validated with the config
+	if configured, _ := zone.refresh(); configured > 0 {
+		refresh, retry = configured, min(configured, retry)
+	}
+	if serial, found := s.secondaries.serial(name); found && !serialNewer(soa.Serial, serial) {
+		return refresh
+	}
+	records, err := transferFromPrimary(zone, keys)
+	if err != nil {
+		log.Print(err)
+		return retry
+	}
+	s.secondaries.set(name, records)
+	if zone.File != "" {
+		if err := writeSecondaryZone(zone.File, records); err != nil {
+			log.Printf("secondary zone %s: failed to save it to %s: %v", name, zone.File, err)
+		}
+	}
+	if err := s.reloadRecords(); err != nil {
+		log.Printf("secondary zone %s: failed to serve the transferred zone: %v", name, err)
+		return retry
+	}
+	serial, _ := s.secondaries.serial(name)
+	log.Printf("transferred zone %s serial %d from %s", name, serial, zone.Primary)
+	return refresh
+}
+
+// followPrimary keeps zone up to date with its primary until the server is
+// shut down
+func (s *Server) followPrimary(zone SecondaryZone) {
+	for {
+		timer := time.NewTimer(s.refreshSecondaryZone(zone))
+		select {
+		case <-timer.C:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// reloadRecords rebuilds the served records from the running config, e.g.
+// to pick up a transferred zone
+func (s *Server) reloadRecords() error {
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	records, err := loadRecords(s.currentConfig())
+	if err != nil {
+		return err
+	}
+	s.setRecords(records)
+	return nil
+}
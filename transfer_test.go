@@ -0,0 +1,226 @@
+package easydns
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testTransferSecret = "dHJhbnNmZXJ0cmFuc2ZlcnRyYW5zZmVy"
+
+// transferConfig returns a config serving lab.test.com to transfers from
+// 127.0.0.1 signed with transfer-key
+func transferConfig(serial string) *Config {
+	return &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Transfer: TransferConfig{
+			Zones: []string{"lab.test.com"},
+			Allow: []string{"127.0.0.1/32"},
+			Keys:  []TSIGKey{{Name: "transfer-key", Secret: testTransferSecret}},
+		},
+		Records: Records{
+			"lab.test.com": {
+				{Type: "SOA", Value: "ns.lab.test.com. admin.lab.test.com. " + serial + " 3600 600 86400 60", TTL: 60},
+				{Type: "NS", Value: "ns.lab.test.com.", TTL: 60},
+			},
+			"host.lab.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"app.test.com":      {{Type: "A", Value: "10.0.1.1", TTL: 60}},
+		},
+	}
+}
+
+// startTransferServer serves s on one port of 127.0.0.1 over both UDP and
+// TCP with the keys of cfg and returns its address
+func startTransferServer(t *testing.T, s *Server, cfg *Config) string {
+	t.Helper()
+	for range 10 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+		l := newListeners(port, []string{"udp", "tcp"}, 0, defaultTCPIdleTimeout, tsigSecrets(cfg), s)
+		if err := l.update([]string{"127.0.0.1"}); err != nil {
+			l.close(context.Background())
+			continue
+		}
+		t.Cleanup(func() { l.close(context.Background()) })
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	t.Fatal("no free port")
+	return ""
+}
+
+// transferRRs lists the owner and type of each record in rrs
+func transferRRs(rrs []dns.RR) []string {
+	var names []string
+	for _, rr := range rrs {
+		names = append(names, rr.Header().Name+" "+dns.TypeToString[rr.Header().Rrtype])
+	}
+	return names
+}
+
+func TestTransfer(t *testing.T) {
+	fullZone := []string{"lab.test.com. SOA", "host.lab.test.com. A", "lab.test.com. NS", "lab.test.com. SOA"}
+	tests := []struct {
+		name      string
+		change    func(cfg *Config)
+		network   string
+		zone      string
+		qtype     uint16
+		serial    uint32 // Of the client for IXFR
+		secret    string
+		wantRcode int
+		want      []string
+	}{
+		{name: "axfr starts and ends with the soa record", secret: testTransferSecret, want: fullZone},
+		{name: "unsigned request", wantRcode: dns.RcodeRefused},
+		{name: "wrong key", secret: "b3RoZXJvdGhlcm90aGVyb3RoZXI=", wantRcode: dns.RcodeNotAuth},
+		{
+			name:      "client not in allow",
+			change:    func(cfg *Config) { cfg.Transfer.Allow = []string{"192.0.2.0/24"} },
+			secret:    testTransferSecret,
+			wantRcode: dns.RcodeRefused,
+		},
+		{
+			name:   "allow without keys",
+			change: func(cfg *Config) { cfg.Transfer.Keys = nil },
+			want:   fullZone,
+		},
+		{name: "zone not served", zone: "test.com.", secret: testTransferSecret, wantRcode: dns.RcodeNotAuth},
+		{name: "axfr over udp", network: "udp", secret: testTransferSecret, wantRcode: dns.RcodeRefused},
+		{name: "ixfr falls back to the full zone", qtype: dns.TypeIXFR, serial: 1, secret: testTransferSecret, want: fullZone},
+		{name: "ixfr of an up to date client", qtype: dns.TypeIXFR, serial: 5, secret: testTransferSecret, want: []string{"lab.test.com. SOA"}},
+		{name: "ixfr over udp", network: "udp", qtype: dns.TypeIXFR, serial: 1, secret: testTransferSecret, want: []string{"lab.test.com. SOA"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := transferConfig("5")
+			if tt.change != nil {
+				tt.change(cfg)
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := startTransferServer(t, s, cfg)
+			zone, network, qtype := tt.zone, tt.network, tt.qtype
+			if zone == "" {
+				zone = "lab.test.com."
+			}
+			if network == "" {
+				network = "tcp"
+			}
+			query := new(dns.Msg)
+			if qtype == dns.TypeIXFR {
+				query.SetIxfr(zone, tt.serial, "ns.lab.test.com.", "admin.lab.test.com.")
+			} else {
+				query.SetAxfr(zone)
+			}
+			client := &dns.Client{Net: network, Timeout: 2 * time.Second}
+			if tt.secret != "" {
+				query.SetTsig("transfer-key.", dns.HmacSHA256, 300, time.Now().Unix())
+				client.TsigSecret = map[string]string{"transfer-key.": tt.secret}
+			}
+			resp, _, err := client.Exchange(query, addr)
+			if resp == nil {
+				t.Fatalf("no response: %v", err)
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := transferRRs(resp.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecondaryZone(t *testing.T) {
+	primaryConfig := transferConfig("5")
+	primary, err := New(primaryConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTransferServer(t, primary, primaryConfig)
+	zone := SecondaryZone{
+		Zone:    "lab.test.com",
+		Primary: addr,
+		Key:     "transfer-key",
+		File:    filepath.Join(t.TempDir(), "lab.test.com.zone"),
+	}
+	secondaryConfig := func() *Config {
+		return &Config{
+			Version: currentConfigVersion,
+			Server:  ServerConfig{Port: "53"},
+			Transfer: TransferConfig{
+				Keys:      []TSIGKey{{Name: "transfer-key", Secret: testTransferSecret}},
+				Secondary: []SecondaryZone{zone},
+			},
+			Records: Records{"old.lab.test.com": {{Type: "A", Value: "10.9.9.9", TTL: 60}}},
+		}
+	}
+	secondary, err := New(secondaryConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(records Records)
+		query  string
+		want   []string
+	}{
+		{name: "zone is transferred", query: "host.lab.test.com", want: []string{"10.0.0.1"}},
+		{name: "transferred zone replaces local records", query: "old.lab.test.com"},
+		{
+			name: "change without a new serial is not transferred",
+			change: func(records Records) {
+				records["host.lab.test.com"] = []Record{{Type: "A", Value: "10.0.0.2", TTL: 60}}
+			},
+			query: "host.lab.test.com",
+			want:  []string{"10.0.0.1"},
+		},
+		{
+			name: "new serial is transferred",
+			change: func(records Records) {
+				records["lab.test.com"][0].Value = "ns.lab.test.com. admin.lab.test.com. 6 3600 600 86400 60"
+				records["host.lab.test.com"] = []Record{{Type: "A", Value: "10.0.0.2", TTL: 60}}
+			},
+			query: "host.lab.test.com",
+			want:  []string{"10.0.0.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.change != nil {
+				tt.change(primaryConfig.Records)
+				if err := primary.Reload(primaryConfig); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if refresh := secondary.refreshSecondaryZone(zone); refresh != time.Hour {
+				t.Errorf("got refresh %s, want the SOA refresh of 1h", refresh)
+			}
+			if got := answerValues(ask(t, secondary, tt.query, dns.TypeA)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The saved copy is served after a restart until the next transfer
+	restarted, err := New(secondaryConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := answerValues(ask(t, restarted, "host.lab.test.com", dns.TypeA)); !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("got answers %v after a restart, want [10.0.0.2]", got)
+	}
+}
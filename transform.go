@@ -0,0 +1,211 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Transform post-processes a resolved response before it is written to
+// the client. New transforms only need to implement this interface and be
+// registered in newTransform.
+type Transform interface {
+	Apply(msg *dns.Msg)
+}
+
+// TransformConfig configures one step of the answer transform chain. Clients
+// and Zone restrict which queries the step applies to; the remaining fields
+// are read depending on Action ("drop-type", "rewrite-value" or "clamp-ttl").
+type TransformConfig struct {
+	Action  string   `json:"action"`
+	Clients []string `json:"clients,omitempty"` // Client networks, all clients when empty
+	Zone    string   `json:"zone,omitempty"`    // Query name suffix, all names when empty
+	Type    string   `json:"type,omitempty"`    // drop-type: record type to remove
+	From    string   `json:"from,omitempty"`    // rewrite-value: address or network to rewrite
+	To      string   `json:"to,omitempty"`      // rewrite-value: replacement address
+	MinTTL  uint32   `json:"min_ttl,omitempty"` // clamp-ttl: lower bound
+	MaxTTL  uint32   `json:"max_ttl,omitempty"` // clamp-ttl: upper bound, unbounded when 0
+}
+
+type transformRule struct {
+	clients   []*net.IPNet
+	zone      string
+	transform Transform
+}
+
+// parseNetworks parses a list of CIDRs. Plain addresses are treated as
+// single host networks.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether ip is part of any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTransforms builds the transform chain from its config
+func newTransforms(configs []TransformConfig) ([]transformRule, error) {
+	rules := make([]transformRule, 0, len(configs))
+	for i, cfg := range configs {
+		clients, err := parseNetworks(cfg.Clients)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %v", i, err)
+		}
+		transform, err := newTransform(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %v", i, err)
+		}
+		zone := ""
+		if cfg.Zone != "" {
+			zone = dns.CanonicalName(cfg.Zone)
+		}
+		rules = append(rules, transformRule{clients: clients, zone: zone, transform: transform})
+	}
+	return rules, nil
+}
+
+func newTransform(cfg TransformConfig) (Transform, error) {
+	switch cfg.Action {
+	case "drop-type":
+		rrtype, found := dns.StringToType[strings.ToUpper(cfg.Type)]
+		if !found {
+			return nil, fmt.Errorf("unknown record type %q", cfg.Type)
+		}
+		return dropType{rrtype: rrtype}, nil
+	case "rewrite-value":
+		from, err := parseNetworks([]string{cfg.From})
+		if err != nil {
+			return nil, err
+		}
+		to := net.ParseIP(cfg.To)
+		if to == nil {
+			return nil, fmt.Errorf("invalid address %q", cfg.To)
+		}
+		return rewriteValue{from: from[0], to: to}, nil
+	case "clamp-ttl":
+		if cfg.MaxTTL != 0 && cfg.MaxTTL < cfg.MinTTL {
+			return nil, fmt.Errorf("max_ttl %d is below min_ttl %d", cfg.MaxTTL, cfg.MinTTL)
+		}
+		return clampTTL{min: cfg.MinTTL, max: cfg.MaxTTL}, nil
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+}
+
+func (t transformRule) matches(client net.IP, qname string) bool {
+	if len(t.clients) > 0 && !containsIP(t.clients, client) {
+		return false
+	}
+	return t.zone == "" || dns.IsSubDomain(t.zone, dns.CanonicalName(qname))
+}
+
+// applyTransforms runs the matching transforms of the chain over msg
+func applyTransforms(rules []transformRule, msg *dns.Msg, client net.IP) {
+	if len(msg.Question) == 0 {
+		return
+	}
+	for _, rule := range rules {
+		if rule.matches(client, msg.Question[0].Name) {
+			rule.transform.Apply(msg)
+		}
+	}
+}
+
+// eachRR calls fn for every record in the answer, authority and additional
+// sections, skipping the EDNS0 OPT pseudo record
+func eachRR(msg *dns.Msg, fn func(dns.RR)) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				fn(rr)
+			}
+		}
+	}
+}
+
+// dropType removes all records of one type, e.g. AAAA for IPv4-only clients
+type dropType struct {
+	rrtype uint16
+}
+
+func (t dropType) Apply(msg *dns.Msg) {
+	filter := func(rrs []dns.RR) []dns.RR {
+		kept := rrs[:0]
+		for _, rr := range rrs {
+			if rr.Header().Rrtype != t.rrtype {
+				kept = append(kept, rr)
+			}
+		}
+		return kept
+	}
+	msg.Answer = filter(msg.Answer)
+	msg.Ns = filter(msg.Ns)
+	msg.Extra = filter(msg.Extra)
+}
+
+// rewriteValue replaces A and AAAA addresses inside a network with another address
+type rewriteValue struct {
+	from *net.IPNet
+	to   net.IP
+}
+
+func (t rewriteValue) Apply(msg *dns.Msg) {
+	eachRR(msg, func(rr dns.RR) {
+		switch rr := rr.(type) {
+		case *dns.A:
+			if t.from.Contains(rr.A) && t.to.To4() != nil {
+				rr.A = t.to.To4()
+			}
+		case *dns.AAAA:
+			if t.from.Contains(rr.AAAA) && t.to.To4() == nil {
+				rr.AAAA = t.to
+			}
+		}
+	})
+}
+
+// clampTTL keeps record TTLs within a range
+type clampTTL struct {
+	min uint32
+	max uint32
+}
+
+func (t clampTTL) Apply(msg *dns.Msg) {
+	eachRR(msg, func(rr dns.RR) {
+		if rr.Header().Ttl < t.min {
+			rr.Header().Ttl = t.min
+		}
+		if t.max != 0 && rr.Header().Ttl > t.max {
+			rr.Header().Ttl = t.max
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TSIGConfig holds the keys queries can be signed with (RFC 8945). Signed
+// queries get signed responses, and queries for Zones or from Clients are
+// only answered when signed with one of Keys.
+type TSIGConfig struct {
+	Keys    []TSIGKey `json:"keys,omitempty"`
+	Zones   []string  `json:"zones,omitempty"`   // Names below these only answer signed queries
+	Clients []string  `json:"clients,omitempty"` // Client networks whose queries must be signed
+
+	clients []*net.IPNet // Clients, parsed by prepare
+}
+
+// prepare parses the client networks once, so they are not parsed again
+// for every query. The config has been validated before.
+func (c *TSIGConfig) prepare() {
+	c.clients, _ = parseNetworks(c.Clients)
+}
+
+func (c TSIGConfig) validate() error {
+	if err := validateTSIGKeys(c.Keys); err != nil {
+		return err
+	}
+	for _, zone := range c.Zones {
+		if _, ok := dns.IsDomainName(zone); !ok || zone == "" {
+			return fmt.Errorf("zone %q is not a valid domain name", zone)
+		}
+	}
+	if _, err := parseNetworks(c.Clients); err != nil {
+		return err
+	}
+	if (len(c.Zones) > 0 || len(c.Clients) > 0) && len(c.Keys) == 0 {
+		return fmt.Errorf("at least one key must be set to require signed queries")
+	}
+	return nil
+}
+
+// requiresKey reports whether the query r from client is only answered
+// when signed
+func (c TSIGConfig) requiresKey(r *dns.Msg, client net.IP) bool {
+	if containsIP(c.clients, client) {
+		return true
+	}
+	for _, q := range r.Question {
+		for _, zone := range c.Zones {
+			if dns.IsSubDomain(dns.CanonicalName(zone), dns.CanonicalName(q.Name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryKey checks the TSIG record of the query r against keys, which
+// include the update and transfer keys since tools like nsupdate sign their
+// queries too. It returns the record to sign the response with, nil for
+// unsigned queries, and the response code for queries that must not be
+// answered: NOTAUTH for bad signatures and unknown keys, REFUSED for
+// unsigned queries that require a key.
+func (c TSIGConfig) queryKey(w dns.ResponseWriter, r *dns.Msg, client net.IP, keys []TSIGKey) (*dns.TSIG, int) {
+	tsig := r.IsTsig()
+	if tsig == nil {
+		if c.requiresKey(r, client) {
+			return nil, dns.RcodeRefused
+		}
+		return nil, dns.RcodeSuccess
+	}
+	key, found := findTSIGKey(keys, tsig.Hdr.Name)
+	algorithm, _ := key.algorithm()
+	if err := w.TsigStatus(); err != nil || !found || !strings.EqualFold(tsig.Algorithm, algorithm) {
+		return nil, dns.RcodeNotAuth
+	}
+	return tsig, dns.RcodeSuccess
+}
+
+// withoutTSIG returns r without its TSIG record, which must not be passed
+// on to the upstream servers. It returns r itself if it is unsigned.
+func withoutTSIG(r *dns.Msg) *dns.Msg {
+	if r.IsTsig() == nil {
+		return r
+	}
+	query := r.Copy()
+	query.Extra = query.Extra[:len(query.Extra)-1]
+	return query
+}
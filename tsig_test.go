@@ -0,0 +1,120 @@
+package easydns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+
+func TestRequiresKey(t *testing.T) {
+	cfg := TSIGConfig{
+		Keys:    []TSIGKey{{Name: "client.", Secret: testTSIGSecret}},
+		Zones:   []string{"Secret.Test.com"},
+		Clients: []string{"10.0.0.0/8", "192.0.2.7"},
+	}
+	cfg.prepare()
+	tests := []struct {
+		name   string
+		qname  string
+		client string
+		want   bool
+	}{
+		{name: "other name and client", qname: "app.test.com.", client: "192.0.2.1"},
+		{name: "name in a zone", qname: "db.secret.test.com.", client: "192.0.2.1", want: true},
+		{name: "zone apex", qname: "SECRET.test.com.", client: "192.0.2.1", want: true},
+		{name: "client network", qname: "app.test.com.", client: "10.1.2.3", want: true},
+		{name: "client address", qname: "app.test.com.", client: "192.0.2.7", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, dns.TypeA)
+			if got := cfg.requiresKey(query, net.ParseIP(tt.client)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedQueries(t *testing.T) {
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		TSIG: TSIGConfig{
+			Keys:  []TSIGKey{{Name: "client.", Secret: testTSIGSecret}},
+			Zones: []string{"secret.test.com"},
+		},
+		Records: Records{
+			"app.test.com":       {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"db.secret.test.com": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newListeners("0", []string{"udp"}, 0, defaultTCPIdleTimeout, tsigSecrets(cfg), s)
+	if err := l.update([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	defer l.close(context.Background())
+	var addr string
+	for _, server := range l.servers {
+		addr = server.PacketConn.LocalAddr().String()
+	}
+
+	// reload replaces the zones and clients that require a signature
+	reload := func(tsig TSIGConfig) {
+		t.Helper()
+		candidate := *cfg
+		candidate.TSIG = tsig
+		if err := s.Reload(&candidate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name      string
+		zones     []string
+		clients   []string
+		qname     string
+		secret    string // Key the query is signed with, unsigned when empty
+		wantRcode int
+	}{
+		{name: "unsigned query outside the zones", zones: cfg.TSIG.Zones, qname: "app.test.com.", wantRcode: dns.RcodeSuccess},
+		{name: "unsigned query in a zone", zones: cfg.TSIG.Zones, qname: "db.secret.test.com.", wantRcode: dns.RcodeRefused},
+		{name: "signed query in a zone", zones: cfg.TSIG.Zones, qname: "db.secret.test.com.", secret: testTSIGSecret, wantRcode: dns.RcodeSuccess},
+		{name: "wrong secret", zones: cfg.TSIG.Zones, qname: "app.test.com.", secret: "b3RoZXJvdGhlcm90aGVyb3RoZXI=", wantRcode: dns.RcodeNotAuth},
+		{name: "unsigned query from a client network", clients: []string{"127.0.0.0/8"}, qname: "app.test.com.", wantRcode: dns.RcodeRefused},
+		{name: "signed query from a client network", clients: []string{"127.0.0.0/8"}, qname: "app.test.com.", secret: testTSIGSecret, wantRcode: dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reload(TSIGConfig{Keys: cfg.TSIG.Keys, Zones: tt.zones, Clients: tt.clients})
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, dns.TypeA)
+			client := &dns.Client{Timeout: 2 * time.Second}
+			if tt.secret != "" {
+				query.SetTsig("client.", dns.HmacSHA256, 300, time.Now().Unix())
+				client.TsigSecret = map[string]string{"client.": tt.secret}
+			}
+			resp, _, err := client.Exchange(query, addr)
+			if err != nil && tt.wantRcode == dns.RcodeSuccess {
+				t.Fatal(err)
+			}
+			if resp == nil {
+				t.Fatalf("no response: %v", err)
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if signed := resp.IsTsig() != nil; tt.wantRcode == dns.RcodeSuccess && signed != (tt.secret != "") {
+				t.Errorf("response signed is %v, want %v", signed, tt.secret != "")
+			}
+		})
+	}
+}
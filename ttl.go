@@ -0,0 +1,104 @@
+package easydns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// TTLConfig bounds the TTLs of all answers, local and forwarded
+type TTLConfig struct {
+	// DefaultTTL replaces the TTL of local records that have none
+	DefaultTTL uint32 `json:"default_ttl,omitempty"`
+	MinTTL     uint32 `json:"min_ttl,omitempty"`
+	MaxTTL     uint32 `json:"max_ttl,omitempty"` // Unbounded when 0
+	// Zones bound the answers for names in a zone, keyed by zone name,
+	// instead of MinTTL and MaxTTL. The most specific zone wins.
+	Zones map[string]TTLBounds `json:"zones,omitempty"`
+}
+
+// TTLBounds is the TTL range of the answers for names in a zone
+type TTLBounds struct {
+	MinTTL uint32 `json:"min_ttl,omitempty"`
+	MaxTTL uint32 `json:"max_ttl,omitempty"` // Unbounded when 0
+}
+
+// validate checks that the TTLs are allowed and the bounds form a range
+func (c TTLConfig) validate() error {
+	if c.DefaultTTL > maxRecordTTL {
+		return fmt.Errorf("default_ttl %d is above the maximum of %d", c.DefaultTTL, maxRecordTTL)
+	}
+	if err := (TTLBounds{MinTTL: c.MinTTL, MaxTTL: c.MaxTTL}).validate(); err != nil {
+		return err
+	}
+	for zone, bounds := range c.Zones {
+		if _, ok := dns.IsDomainName(zone); !ok || zone == "" {
+			return fmt.Errorf("zone %q is not a valid domain name", zone)
+		}
+		if err := bounds.validate(); err != nil {
+			return fmt.Errorf("zone %s: %v", zone, err)
+		}
+	}
+	return nil
+}
+
+func (b TTLBounds) validate() error {
+	for _, ttl := range []struct {
+		field string
+		value uint32
+	}{{"min_ttl", b.MinTTL}, {"max_ttl", b.MaxTTL}} {
+		if ttl.value > maxRecordTTL {
+			return fmt.Errorf("%s %d is above the maximum of %d", ttl.field, ttl.value, maxRecordTTL)
+		}
+	}
+	if b.MaxTTL != 0 && b.MaxTTL < b.MinTTL {
+		return fmt.Errorf("max_ttl %d is below min_ttl %d", b.MaxTTL, b.MinTTL)
+	}
+	return nil
+}
+
+// bounds returns the TTL range for answers to questions for name: that of
+// the most specific zone containing it, or the global one
+func (c TTLConfig) bounds(name string) TTLBounds {
+	zones := make([]string, 0, len(c.Zones))
+	for zone := range c.Zones {
+		zones = append(zones, zone)
+	}
+	if zone, found := authoritativeZone(zones, recordName(name)); found {
+		for configured, bounds := range c.Zones {
+			if recordName(configured) == zone {
+				return bounds
+			}
+		}
+	}
+	return TTLBounds{MinTTL: c.MinTTL, MaxTTL: c.MaxTTL}
+}
+
+// apply clamps the TTLs of every record in msg into the range configured
+// for the name it answers
+func (c TTLConfig) apply(msg *dns.Msg) {
+	bounds := TTLBounds{MinTTL: c.MinTTL, MaxTTL: c.MaxTTL}
+	if len(msg.Question) > 0 {
+		bounds = c.bounds(msg.Question[0].Name)
+	}
+	if bounds.MinTTL == 0 && bounds.MaxTTL == 0 {
+		return
+	}
+	clampTTL{min: bounds.MinTTL, max: bounds.MaxTTL}.Apply(msg)
+}
+
+// withDefaultTTL sets the TTL of records without one to ttl. records is
+// modified in place.
+func withDefaultTTL(records Records, ttl uint32) Records {
+	if ttl == 0 {
+		return records
+	}
+	for _, set := range records {
+		for i := range set {
+			if set[i].TTL == 0 {
+				set[i].TTL = ttl
+			}
+		}
+	}
+	return records
+}
@@ -0,0 +1,181 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTTLBounds(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		for _, rr := range []string{" 5 IN A 192.0.2.1", " 100000 IN A 192.0.2.2", " 600 IN A 192.0.2.3"} {
+			a, _ := dns.NewRR(r.Question[0].Name + rr)
+			resp.Answer = append(resp.Answer, a)
+		}
+		w.WriteMsg(resp)
+	})
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		TTL:        TTLConfig{DefaultTTL: 300, MinTTL: 60, MaxTTL: 3600},
+		Records: Records{
+			"zero.test.com":  {{Type: "A", Value: "10.0.0.1"}},
+			"short.test.com": {{Type: "A", Value: "10.0.0.2", TTL: 10}},
+			"long.test.com":  {{Type: "A", Value: "10.0.0.3", TTL: 86400}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want []uint32
+	}{
+		{name: "zero.test.com", want: []uint32{300}},
+		{name: "short.test.com", want: []uint32{60}},
+		{name: "long.test.com", want: []uint32{3600}},
+		{name: "www.example.com", want: []uint32{60, 3600, 600}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ask(t, s, tt.name, dns.TypeA)
+			if len(resp.Answer) != len(tt.want) {
+				t.Fatalf("got %d answers, want %d", len(resp.Answer), len(tt.want))
+			}
+			for i, rr := range resp.Answer {
+				if rr.Header().Ttl != tt.want[i] {
+					t.Errorf("answer %d has TTL %d, want %d", i, rr.Header().Ttl, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWithDefaultTTL(t *testing.T) {
+	records := Records{"app.test.com": {{Type: "A", Value: "10.0.0.1"}, {Type: "A", Value: "10.0.0.2", TTL: 30}}}
+	tests := []struct {
+		name string
+		ttl  uint32
+		want []uint32
+	}{
+		{name: "no default", want: []uint32{0, 30}},
+		{name: "default", ttl: 300, want: []uint32{300, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withDefaultTTL(normalizeRecords(records), tt.ttl)
+			for i, record := range got["app.test.com"] {
+				if record.TTL != tt.want[i] {
+					t.Errorf("record %d has TTL %d, want %d", i, record.TTL, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TTLConfig
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "range", config: TTLConfig{DefaultTTL: 300, MinTTL: 60, MaxTTL: 3600}},
+		{name: "only a minimum", config: TTLConfig{MinTTL: 60}},
+		{name: "maximum below the minimum", config: TTLConfig{MinTTL: 600, MaxTTL: 60}, wantErr: true},
+		{name: "above the maximum TTL", config: TTLConfig{DefaultTTL: maxRecordTTL + 1}, wantErr: true},
+		{name: "zone", config: TTLConfig{Zones: map[string]TTLBounds{"cdn.example.com": {MinTTL: 300, MaxTTL: 3600}}}},
+		{name: "zone maximum below its minimum", config: TTLConfig{Zones: map[string]TTLBounds{"cdn.example.com": {MinTTL: 300, MaxTTL: 60}}}, wantErr: true},
+		{name: "zone above the maximum TTL", config: TTLConfig{Zones: map[string]TTLBounds{"cdn.example.com": {MaxTTL: maxRecordTTL + 1}}}, wantErr: true},
+		{name: "invalid zone", config: TTLConfig{Zones: map[string]TTLBounds{"": {MinTTL: 60}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestZoneTTLBounds(t *testing.T) {
+	up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		a, _ := dns.NewRR(r.Question[0].Name + " 5 IN A 192.0.2.1")
+		resp.Answer = append(resp.Answer, a)
+		w.WriteMsg(resp)
+	})
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		TTL: TTLConfig{
+			MinTTL: 30,
+			Zones: map[string]TTLBounds{
+				"test.com":            {MaxTTL: 600},
+				"Cdn.Example.com.":    {MinTTL: 300, MaxTTL: 3600},
+				"img.cdn.example.com": {MinTTL: 60},
+			},
+		},
+		Records: Records{
+			"app.test.com":  {{Type: "A", Value: "10.0.0.1", TTL: 86400}},
+			"fast.test.com": {{Type: "A", Value: "10.0.0.2", TTL: 5}},
+			"app.other.com": {{Type: "A", Value: "10.0.0.3", TTL: 5}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{name: "app.test.com", want: 600},
+		{name: "fast.test.com", want: 5},
+		{name: "app.other.com", want: 30},
+		{name: "www.cdn.example.com", want: 300},
+		{name: "a.img.cdn.example.com", want: 60},
+		{name: "www.example.com", want: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ask(t, s, tt.name, dns.TypeA)
+			if len(resp.Answer) != 1 {
+				t.Fatalf("got answers %v, want one", resp.Answer)
+			}
+			if got := resp.Answer[0].Header().Ttl; got != tt.want {
+				t.Errorf("got TTL %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigWarnings(t *testing.T) {
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Records: Records{
+			"app.test.com": {{Type: "A", Value: "10.0.0.1"}},
+			"db.test.com":  {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		},
+		Views: []ViewConfig{{Name: "office", Networks: []string{"10.0.0.0/8"}, Records: Records{"app.test.com": {{Type: "A", Value: "10.1.0.1"}}}}},
+	}
+	want := []string{
+		"record app.test.com: A 10.0.0.1 has no ttl and is served with TTL 0, set ttl or ttl.default_ttl",
+		"view office: record app.test.com: A 10.1.0.1 has no ttl and is served with TTL 0, set ttl or ttl.default_ttl",
+	}
+	if got := ConfigWarnings(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got warnings %q, want %q", got, want)
+	}
+	cfg.TTL.DefaultTTL = 300
+	if got := ConfigWarnings(cfg); len(got) != 0 {
+		t.Errorf("got warnings %q with a default_ttl, want none", got)
+	}
+}
@@ -0,0 +1,416 @@
+package easydns
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// tsigAlgorithms maps the supported TSIG algorithm settings to their names
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// TSIGKey is a shared secret used to sign queries, dynamic updates and
+// zone transfers
+type TSIGKey struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm,omitempty"` // Defaults to hmac-sha256
+	Secret    string `json:"secret"`              // Base64, as generated by tsig-keygen
+}
+
+// UpdateConfig accepts RFC 2136 dynamic updates signed with one of Keys for
+// names in Zones
+type UpdateConfig struct {
+	Enabled bool      `json:"enabled"`
+	Zones   []string  `json:"zones,omitempty"`
+	Keys    []TSIGKey `json:"keys,omitempty"`
+	// PersistPath is the config file accepted updates are written back to.
+	// Updates are kept in memory only when empty.
+	PersistPath string `json:"persist_path,omitempty"`
+}
+
+// algorithm returns the TSIG algorithm name of the key
+func (k TSIGKey) algorithm() (string, error) {
+	if k.Algorithm == "" {
+		return dns.HmacSHA256, nil
+	}
+	algorithm, found := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(k.Algorithm), ".")]
+	if !found {
+		return "", fmt.Errorf("unsupported algorithm %q", k.Algorithm)
+	}
+	return algorithm, nil
+}
+
+func (c UpdateConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Zones) == 0 {
+		return fmt.Errorf("zones must be set")
+	}
+	for _, zone := range c.Zones {
+		if _, ok := dns.IsDomainName(zone); !ok || zone == "" {
+			return fmt.Errorf("zone %q is not a valid domain name", zone)
+		}
+	}
+	if len(c.Keys) == 0 {
+		return fmt.Errorf("at least one TSIG key must be set")
+	}
+	return validateTSIGKeys(c.Keys)
+}
+
+// validateTSIGKeys checks the names, algorithms and secrets of keys
+func validateTSIGKeys(keys []TSIGKey) error {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if _, ok := dns.IsDomainName(key.Name); !ok || key.Name == "" {
+			return fmt.Errorf("key name %q is not a valid domain name", key.Name)
+		}
+		if seen[dns.Fqdn(key.Name)] {
+			return fmt.Errorf("key %s is listed twice", key.Name)
+		}
+		seen[dns.Fqdn(key.Name)] = true
+		if _, err := key.algorithm(); err != nil {
+			return fmt.Errorf("key %s: %v", key.Name, err)
+		}
+		if secret, err := base64.StdEncoding.DecodeString(key.Secret); err != nil || len(secret) == 0 {
+			return fmt.Errorf("key %s: secret must be base64 encoded", key.Name)
+		}
+	}
+	return nil
+}
+
+// tsigKeys returns the query, update and transfer keys
+func tsigKeys(cfg *Config) []TSIGKey {
+	keys := append(slices.Clone(cfg.TSIG.Keys), cfg.Transfer.Keys...)
+	if cfg.Update.Enabled {
+		keys = append(slices.Clone(cfg.Update.Keys), keys...)
+	}
+	return keys
+}
+
+// tsigSecrets returns the secrets of all keys by key name as expected by
+// dns.Server
+func tsigSecrets(cfg *Config) map[string]string {
+	keys := tsigKeys(cfg)
+	if len(keys) == 0 {
+		return nil
+	}
+	secrets := map[string]string{}
+	for _, key := range keys {
+		secrets[dns.Fqdn(key.Name)] = key.Secret
+	}
+	return secrets
+}
+
+// findTSIGKey returns the key named name in keys
+func findTSIGKey(keys []TSIGKey, name string) (TSIGKey, bool) {
+	for _, key := range keys {
+		if strings.EqualFold(dns.Fqdn(key.Name), name) {
+			return key, true
+		}
+	}
+	return TSIGKey{}, false
+}
+
+// acceptMsg is dns.DefaultMsgAcceptFunc extended to let UPDATE messages
+// through, which carry records in the answer and authority sections
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	const qr = 1 << 15
+	if dh.Bits&qr == 0 && int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+		if dh.Qdcount != 1 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// updateError carries the response code of a rejected update
+type updateError struct {
+	rcode  int
+	reason string
+}
+
+func (e updateError) Error() string {
+	return fmt.Sprintf("%s: %s", dns.RcodeToString[e.rcode], e.reason)
+}
+
+// asRecordRR returns a copy of rr with class IN, so delete and prerequisite
+// RRs can be compared to stored records
+func asRecordRR(rr dns.RR) dns.RR {
+	rr = dns.Copy(rr)
+	rr.Header().Class = dns.ClassINET
+	return rr
+}
+
+// sameRecord reports whether record holds the data of rr
+func sameRecord(record Record, rr dns.RR) bool {
+	stored, err := newRR(rr.Header().Name, record)
+	if err != nil {
+		return false
+	}
+	return dns.IsDuplicate(stored, asRecordRR(rr))
+}
+
+// checkPrerequisites evaluates the prerequisite section of an update
+// against the served records, see RFC 2136 section 3.2
+func checkPrerequisites(records Records, zone string, prereqs []dns.RR) error {
+	type rrsetKey struct {
+		name  string
+		rtype string
+	}
+	var keys []rrsetKey
+	expected := map[rrsetKey][]dns.RR{}
+	for _, rr := range prereqs {
+		header := rr.Header()
+		name := recordName(header.Name)
+		if !dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+			return updateError{dns.RcodeNotZone, fmt.Sprintf("prerequisite %s is outside zone %s", name, zone)}
+		}
+		rtype := dns.TypeToString[header.Rrtype]
+		set, inUse := records[name]
+		hasType := slices.ContainsFunc(set, func(r Record) bool { return r.Type == rtype })
+		switch header.Class {
+		case dns.ClassANY:
+			if header.Rrtype == dns.TypeANY && !inUse {
+				return updateError{dns.RcodeNameError, fmt.Sprintf("%s is not in use", name)}
+			}
+			if header.Rrtype != dns.TypeANY && !hasType {
+				return updateError{dns.RcodeNXRrset, fmt.Sprintf("%s has no %s records", name, rtype)}
+			}
+		case dns.ClassNONE:
+			if header.Rrtype == dns.TypeANY && inUse {
+				return updateError{dns.RcodeYXDomain, fmt.Sprintf("%s is in use", name)}
+			}
+			if header.Rrtype != dns.TypeANY && hasType {
+				return updateError{dns.RcodeYXRrset, fmt.Sprintf("%s has %s records", name, rtype)}
+			}
+		case dns.ClassINET:
+			key := rrsetKey{name: name, rtype: rtype}
+			if _, found := expected[key]; !found {
+				keys = append(keys, key)
+			}
+			expected[key] = append(expected[key], rr)
+		default:
+			return updateError{dns.RcodeFormatError, fmt.Sprintf("prerequisite for %s has class %s", name, dns.ClassToString[header.Class])}
+		}
+	}
+	// Value dependent prerequisites must match the whole RRset
+	for _, key := range keys {
+		var stored []Record
+		for _, record := range records[key.name] {
+			if record.Type == key.rtype {
+				stored = append(stored, record)
+			}
+		}
+		matches := len(stored) == len(expected[key])
+		for _, rr := range expected[key] {
+			matches = matches && slices.ContainsFunc(stored, func(r Record) bool { return sameRecord(r, rr) })
+		}
+		if !matches {
+			return updateError{dns.RcodeNXRrset, fmt.Sprintf("%s %s records differ", key.name, key.rtype)}
+		}
+	}
+	return nil
+}
+
+// checkUpdates prescans the update section, see RFC 2136 section 3.4.1
+func checkUpdates(zone string, updates []dns.RR) error {
+	for _, rr := range updates {
+		header := rr.Header()
+		name := recordName(header.Name)
+		if !dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+			return updateError{dns.RcodeNotZone, fmt.Sprintf("%s is outside zone %s", name, zone)}
+		}
+		switch header.Class {
+		case dns.ClassINET:
+			if _, supported := zoneRecord(rr); !supported {
+				return updateError{dns.RcodeRefused, fmt.Sprintf("%s records are not supported", dns.TypeToString[header.Rrtype])}
+			}
+		case dns.ClassANY:
+			if header.Ttl != 0 || header.Rdlength != 0 {
+				return updateError{dns.RcodeFormatError, fmt.Sprintf("delete of %s must have no TTL or data", name)}
+			}
+		case dns.ClassNONE:
+			if header.Ttl != 0 {
+				return updateError{dns.RcodeFormatError, fmt.Sprintf("delete of %s must have no TTL", name)}
+			}
+		default:
+			return updateError{dns.RcodeFormatError, fmt.Sprintf("update of %s has class %s", name, dns.ClassToString[header.Class])}
+		}
+	}
+	return nil
+}
+
+// applyUpdate applies one update RR to set, the records of a name in zone
+func applyUpdate(set []Record, zone string, rr dns.RR) []Record {
+	header := rr.Header()
+	apex := recordName(header.Name) == zone
+	rtype := dns.TypeToString[header.Rrtype]
+	// The SOA and NS records of the zone apex can't be deleted
+	protected := func(r Record) bool { return apex && (r.Type == "SOA" || r.Type == "NS") }
+	switch header.Class {
+	case dns.ClassINET:
+		record, _ := zoneRecord(rr)
+		hasCNAME := slices.ContainsFunc(set, func(r Record) bool { return r.Type == "CNAME" })
+		hasOther := slices.ContainsFunc(set, func(r Record) bool { return r.Type != "CNAME" })
+		if (record.Type == "CNAME" && hasOther) || (record.Type != "CNAME" && hasCNAME) {
+			// CNAMEs can't be combined with other data, RFC 2136 ignores the add
+			return set
+		}
+		if record.Type == "SOA" || record.Type == "CNAME" {
+			set = slices.DeleteFunc(set, func(r Record) bool { return r.Type == record.Type })
+		}
+		for i, existing := range set {
+			if sameRecord(existing, rr) {
+				set[i] = record
+				return set
+			}
+		}
+		return append(set, record)
+	case dns.ClassANY:
+		return slices.DeleteFunc(set, func(r Record) bool {
+			return !protected(r) && (header.Rrtype == dns.TypeANY || r.Type == rtype)
+		})
+	case dns.ClassNONE:
+		return slices.DeleteFunc(set, func(r Record) bool {
+			return !(apex && r.Type == "SOA") && sameRecord(r, rr)
+		})
+	}
+	return set
+}
+
+// bumpSerial increments the serial of the SOA record in set
+func bumpSerial(zone string, set []Record) {
+	for i, record := range set {
+		if record.Type != "SOA" {
+			continue
+		}
+		rr, err := newRR(dns.Fqdn(zone), record)
+		if err != nil {
+			return
+		}
+		soa := rr.(*dns.SOA)
+		soa.Serial++
+		set[i].Value = strings.TrimSpace(rdata(soa))
+	}
+}
+
+// update validates and applies the dynamic update r for zone to the
+// records in the running config
+func (s *Server) update(zone string, r *dns.Msg) error {
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	cfg := s.currentConfig()
+	if err := checkPrerequisites(s.currentRecords(), zone, r.Answer); err != nil {
+		return err
+	}
+	if err := checkUpdates(zone, r.Ns); err != nil {
+		return err
+	}
+	configured := func(name string) []Record {
+		if key, found := FindRecordKey(cfg.Records, name); found {
+			return append([]Record(nil), cfg.Records[key]...)
+		}
+		return nil
+	}
+	changes := Records{}
+	for _, rr := range r.Ns {
+		name := recordName(rr.Header().Name)
+		set, found := changes[name]
+		if !found {
+			set = configured(name)
+		}
+		changes[name] = applyUpdate(set, zone, rr)
+	}
+	for name, set := range changes {
+		if reflect.DeepEqual(set, configured(name)) || (len(set) == 0 && len(configured(name)) == 0) {
+			delete(changes, name)
+		}
+	}
+	if len(changes) == 0 {
+		// Nothing changed, e.g. a delete of a missing record
+		return nil
+	}
+	if _, found := changes[zone]; !found && configured(zone) != nil {
+		changes[zone] = configured(zone)
+	}
+	if set, found := changes[zone]; found && !slices.ContainsFunc(r.Ns, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeSOA }) {
+		bumpSerial(zone, set)
+	}
+	for name, set := range changes {
+		if len(set) == 0 {
+			changes[name] = nil
+			continue
+		}
+		if problems := recordSetProblems(name, set); len(problems) > 0 {
+			return updateError{dns.RcodeRefused, fmt.Sprintf("record %s: %s", name, problems[0])}
+		}
+	}
+	if err := s.applyRecordSets(changes, cfg.Update.PersistPath); err != nil {
+		if errors.As(err, &ZoneFileError{}) || errors.As(err, &RecordsDirError{}) {
+			return updateError{dns.RcodeRefused, err.Error()}
+		}
+		return updateError{dns.RcodeServerFailure, err.Error()}
+	}
+	return nil
+}
+
+// serveUpdate answers an RFC 2136 UPDATE message. Only updates signed with
+// a configured TSIG key for one of the update zones are accepted.
+func (s *Server) serveUpdate(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg, cfg *Config) {
+	if !cfg.Update.Enabled {
+		msg.Rcode = dns.RcodeRefused
+		return
+	}
+	tsig := r.IsTsig()
+	if tsig == nil {
+		log.Printf("refusing unsigned update from %s", w.RemoteAddr())
+		msg.Rcode = dns.RcodeRefused
+		return
+	}
+	key, found := findTSIGKey(cfg.Update.Keys, tsig.Hdr.Name)
+	algorithm, _ := key.algorithm()
+	if err := w.TsigStatus(); err != nil || !found || !strings.EqualFold(tsig.Algorithm, algorithm) {
+		log.Printf("refusing update from %s with invalid TSIG key %s: %v", w.RemoteAddr(), tsig.Hdr.Name, err)
+		msg.Rcode = dns.RcodeNotAuth
+		return
+	}
+	// Sign the response with the key of the request
+	defer msg.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		msg.Rcode = dns.RcodeFormatError
+		return
+	}
+	zone := recordName(r.Question[0].Name)
+	if !slices.ContainsFunc(cfg.Update.Zones, func(z string) bool { return recordName(z) == zone }) {
+		log.Printf("refusing update from %s for zone %s, updates are not enabled for it", w.RemoteAddr(), zone)
+		msg.Rcode = dns.RcodeNotAuth
+		return
+	}
+	if err := s.update(zone, r); err != nil {
+		var rejected updateError
+		if errors.As(err, &rejected) {
+			msg.Rcode = rejected.rcode
+		} else {
+			msg.Rcode = dns.RcodeServerFailure
+		}
+		log.Printf("rejected update of zone %s from %s: %v", zone, w.RemoteAddr(), err)
+		return
+	}
+	log.Printf("applied update of zone %s from %s signed with key %s", zone, w.RemoteAddr(), tsig.Hdr.Name)
+}
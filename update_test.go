@@ -0,0 +1,266 @@
+package easydns
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testUpdateSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+
+// updateConfig returns a config accepting updates for lab.test.com
+func updateConfig() *Config {
+	return &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Update: UpdateConfig{
+			Enabled: true,
+			Zones:   []string{"lab.test.com"},
+			Keys:    []TSIGKey{{Name: "dhcp-key", Secret: testUpdateSecret}},
+		},
+		Records: Records{
+			"lab.test.com": {
+				{Type: "SOA", Value: "ns.lab.test.com. admin.lab.test.com. 1 3600 600 86400 60", TTL: 60},
+				{Type: "NS", Value: "ns.lab.test.com.", TTL: 60},
+			},
+			"host.lab.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"app.test.com":      {{Type: "A", Value: "10.0.1.1", TTL: 60}},
+		},
+	}
+}
+
+// startUpdateServer serves s on a UDP port of 127.0.0.1 with the update
+// keys of cfg and returns its address
+func startUpdateServer(t *testing.T, s *Server, cfg *Config) string {
+	t.Helper()
+	l := newListeners("0", []string{"udp"}, 0, defaultTCPIdleTimeout, tsigSecrets(cfg), s)
+	if err := l.update([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.close(context.Background()) })
+	for _, server := range l.servers {
+		return server.PacketConn.LocalAddr().String()
+	}
+	t.Fatal("no listener started")
+	return ""
+}
+
+// sendUpdate sends update to addr, signed with secret unless it is empty,
+// and returns the response code
+func sendUpdate(t *testing.T, addr string, update *dns.Msg, secret string) int {
+	t.Helper()
+	client := &dns.Client{Timeout: 2 * time.Second}
+	if secret != "" {
+		update.SetTsig("dhcp-key.", dns.HmacSHA256, 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{"dhcp-key.": secret}
+	}
+	resp, _, err := client.Exchange(update, addr)
+	if resp == nil {
+		t.Fatalf("no response: %v", err)
+	}
+	return resp.Rcode
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		zone      string
+		secret    string
+		build     func(t *testing.T, m *dns.Msg)
+		wantRcode int
+		query     string
+		qtype     uint16
+		want      []string
+	}{
+		{
+			name:      "unsigned update is refused",
+			build:     func(t *testing.T, m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "new.lab.test.com. 60 A 10.0.0.9")}) },
+			wantRcode: dns.RcodeRefused,
+			query:     "new.lab.test.com",
+		},
+		{
+			name:      "bad signature",
+			secret:    "b3RoZXJvdGhlcm90aGVyb3RoZXI=",
+			build:     func(t *testing.T, m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "new.lab.test.com. 60 A 10.0.0.9")}) },
+			wantRcode: dns.RcodeNotAuth,
+			query:     "new.lab.test.com",
+		},
+		{
+			name:      "zone without updates",
+			zone:      "test.com.",
+			build:     func(t *testing.T, m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "app.test.com. 60 A 10.0.1.2")}) },
+			wantRcode: dns.RcodeNotAuth,
+			query:     "app.test.com",
+			want:      []string{"10.0.1.1"},
+		},
+		{
+			name:      "name outside the zone",
+			build:     func(t *testing.T, m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "app.test.com. 60 A 10.0.1.2")}) },
+			wantRcode: dns.RcodeNotZone,
+			query:     "app.test.com",
+			want:      []string{"10.0.1.1"},
+		},
+		{
+			name:      "add a new name",
+			build:     func(t *testing.T, m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "new.lab.test.com. 60 A 10.0.0.9")}) },
+			wantRcode: dns.RcodeSuccess,
+			query:     "new.lab.test.com",
+			want:      []string{"10.0.0.9"},
+		},
+		{
+			name:      "add to an rrset",
+			build:     func(t *testing.T, m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.2")}) },
+			wantRcode: dns.RcodeSuccess,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name: "delete one record",
+			build: func(t *testing.T, m *dns.Msg) {
+				m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.2")})
+				m.Remove([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.1")})
+			},
+			wantRcode: dns.RcodeSuccess,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.2"},
+		},
+		{
+			name:      "delete an rrset",
+			build:     func(t *testing.T, m *dns.Msg) { m.RemoveRRset([]dns.RR{mustRR(t, "host.lab.test.com. 0 A 0.0.0.0")}) },
+			wantRcode: dns.RcodeSuccess,
+			query:     "host.lab.test.com",
+		},
+		{
+			name:      "apex soa can't be deleted",
+			build:     func(t *testing.T, m *dns.Msg) { m.RemoveName([]dns.RR{mustRR(t, "lab.test.com. 0 A 0.0.0.0")}) },
+			wantRcode: dns.RcodeSuccess,
+			query:     "lab.test.com",
+			qtype:     dns.TypeNS,
+			want:      []string{"ns.lab.test.com."},
+		},
+		{
+			name: "name in use prerequisite met",
+			build: func(t *testing.T, m *dns.Msg) {
+				m.NameUsed([]dns.RR{mustRR(t, "host.lab.test.com. 0 A 0.0.0.0")})
+				m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.2")})
+			},
+			wantRcode: dns.RcodeSuccess,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name: "name not in use prerequisite failed",
+			build: func(t *testing.T, m *dns.Msg) {
+				m.NameNotUsed([]dns.RR{mustRR(t, "host.lab.test.com. 0 A 0.0.0.0")})
+				m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.2")})
+			},
+			wantRcode: dns.RcodeYXDomain,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.1"},
+		},
+		{
+			name: "rrset not in use prerequisite failed",
+			build: func(t *testing.T, m *dns.Msg) {
+				m.RRsetNotUsed([]dns.RR{mustRR(t, "host.lab.test.com. 0 A 0.0.0.0")})
+				m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.2")})
+			},
+			wantRcode: dns.RcodeYXRrset,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.1"},
+		},
+		{
+			name: "value dependent prerequisite met",
+			build: func(t *testing.T, m *dns.Msg) {
+				m.Used([]dns.RR{mustRR(t, "host.lab.test.com. 0 A 10.0.0.1")})
+				m.Remove([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.1")})
+				m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.3")})
+			},
+			wantRcode: dns.RcodeSuccess,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.3"},
+		},
+		{
+			name: "value dependent prerequisite failed",
+			build: func(t *testing.T, m *dns.Msg) {
+				m.Used([]dns.RR{mustRR(t, "host.lab.test.com. 0 A 10.0.0.9")})
+				m.Insert([]dns.RR{mustRR(t, "host.lab.test.com. 60 A 10.0.0.2")})
+			},
+			wantRcode: dns.RcodeNXRrset,
+			query:     "host.lab.test.com",
+			want:      []string{"10.0.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := updateConfig()
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := startUpdateServer(t, s, cfg)
+			zone, secret := tt.zone, tt.secret
+			if zone == "" {
+				zone = "lab.test.com."
+			}
+			if secret == "" && tt.wantRcode != dns.RcodeRefused {
+				secret = testUpdateSecret
+			}
+			update := new(dns.Msg)
+			update.SetUpdate(zone)
+			tt.build(t, update)
+			if rcode := sendUpdate(t, addr, update, secret); rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dns.TypeA
+			}
+			if got := answerValues(ask(t, s, tt.query, qtype)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePersists(t *testing.T) {
+	cfg := updateConfig()
+	cfg.Update.PersistPath = filepath.Join(t.TempDir(), "config.json")
+	if err := WriteConfigFile(cfg.Update.PersistPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startUpdateServer(t, s, cfg)
+	update := new(dns.Msg)
+	update.SetUpdate("lab.test.com.")
+	update.Insert([]dns.RR{mustRR(t, "new.lab.test.com. 60 A 10.0.0.9")})
+	if rcode := sendUpdate(t, addr, update, testUpdateSecret); rcode != dns.RcodeSuccess {
+		t.Fatalf("got rcode %s, want NOERROR", dns.RcodeToString[rcode])
+	}
+	saved, err := LoadConfig(cfg.Update.PersistPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := saved.Records["new.lab.test.com"], []Record{{Type: "A", Value: "10.0.0.9", TTL: 60}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got saved records %v, want %v", got, want)
+	}
+	// The serial of the zone is incremented
+	if got, want := saved.Records["lab.test.com"][0].Value, "ns.lab.test.com. admin.lab.test.com. 2 3600 600 86400 60"; got != want {
+		t.Errorf("got SOA %q, want %q", got, want)
+	}
+}
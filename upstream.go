@@ -0,0 +1,193 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultUpstreamPort    = "53"
+	defaultUpstreamTLSPort = "853"
+	defaultUpstreamTimeout = 2 * time.Second
+)
+
+// upstreamPort returns the default upstream port for a transport
+func upstreamPort(transport string) string {
+	if transport == "tcp-tls" {
+		return defaultUpstreamTLSPort
+	}
+	return defaultUpstreamPort
+}
+
+// splitUpstream returns the transport and address of an upstream server.
+// Servers may carry a udp://, tcp://, tls:// or https:// scheme, otherwise
+// defaultTransport is used. The address of https servers is their URL.
+func splitUpstream(server, defaultTransport string) (string, string) {
+	scheme, address, found := strings.Cut(server, "://")
+	switch {
+	case !found && defaultTransport == "":
+		return "udp", server
+	case !found:
+		return defaultTransport, server
+	case scheme == "tls":
+		return "tcp-tls", address
+	case scheme == "https":
+		return "https", server
+	default:
+		return scheme, address
+	}
+}
+
+// normalizeUpstream returns server as host:port, adding defaultPort when
+// none is given. Servers with a scheme keep it and use the default port of
+// their transport.
+func normalizeUpstream(server, defaultPort string) (string, error) {
+	scheme, address, found := strings.Cut(server, "://")
+	if !found {
+		return normalizeHostPort(server, defaultPort)
+	}
+	switch scheme {
+	case "https":
+		if u, err := url.Parse(server); err != nil || u.Host == "" {
+			return "", fmt.Errorf("invalid upstream server %q: not a valid URL", server)
+		}
+		return server, nil
+	case "udp", "tcp":
+		defaultPort = defaultUpstreamPort
+	case "tls":
+		defaultPort = defaultUpstreamTLSPort
+	default:
+		return "", fmt.Errorf("invalid upstream server %q: unknown scheme %q", server, scheme)
+	}
+	hostPort, err := normalizeHostPort(address, defaultPort)
+	if err != nil {
+		return "", err
+	}
+	return scheme + "://" + hostPort, nil
+}
+
+// normalizeHostPort returns server as host:port, adding defaultPort when
+// none is given
+func normalizeHostPort(server, defaultPort string) (string, error) {
+	// Bare IPv4 and IPv6 addresses, optionally in brackets
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultPort), nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		if strings.Contains(server, ":") {
+			return "", fmt.Errorf("invalid upstream server %q: %v", server, err)
+		}
+		host, port = server, defaultPort
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid upstream server %q: missing host", server)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid upstream server %q: invalid port %q", server, port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// normalizeUpstreams applies normalizeUpstream to every server
+func normalizeUpstreams(servers []string, defaultPort string) ([]string, error) {
+	normalized := make([]string, 0, len(servers))
+	for _, server := range servers {
+		s, err := normalizeUpstream(server, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, s)
+	}
+	return normalized, nil
+}
+
+// timeout returns how long a single upstream exchange may take
+func (f ForwardingConfig) timeout() (time.Duration, error) {
+	if f.Timeout == "" {
+		return defaultUpstreamTimeout, nil
+	}
+	timeout, err := time.ParseDuration(f.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", f.Timeout)
+	}
+	return timeout, nil
+}
+
+// healthCheckInterval returns how often the servers are probed, 0 when
+// health checks are disabled
+func (f ForwardingConfig) healthCheckInterval() (time.Duration, error) {
+	if f.HealthCheckInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(f.HealthCheckInterval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid health_check_interval %q", f.HealthCheckInterval)
+	}
+	return interval, nil
+}
+
+// upstreamSettings returns the timeout and retries for server, timeout and
+// Retries unless its entry in Upstreams overrides them
+func (f ForwardingConfig) upstreamSettings(server string, timeout time.Duration) (time.Duration, int) {
+	retries := f.Retries
+	port := upstreamPort(f.Transport)
+	for address, settings := range f.Upstreams {
+		if normalized, err := normalizeUpstream(address, port); err != nil || normalized != server {
+			continue
+		}
+		// Validated with the config
+		if t, _ := settings.timeout(); t > 0 {
+			timeout = t
+		}
+		if settings.Retries != nil {
+			retries = *settings.Retries
+		}
+	}
+	return timeout, retries
+}
+
+// timeout returns the timeout of exchanges with the server, 0 when the
+// forwarding timeout applies
+func (u UpstreamSettings) timeout() (time.Duration, error) {
+	if u.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(u.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", u.Timeout)
+	}
+	return timeout, nil
+}
+
+// forName returns the forwarding settings for name, with the servers of the
+// longest matching conditional forwarding zone if there is one. A zone
+// written as "*.<zone>" is the same as "<zone>".
+func (f ForwardingConfig) forName(name string) ForwardingConfig {
+	if servers, conditional := f.conditionalServers(name); conditional {
+		f.Servers = servers
+	}
+	return f
+}
+
+// conditionalServers returns the servers of the longest conditional
+// forwarding zone matching name
+func (f ForwardingConfig) conditionalServers(name string) ([]string, bool) {
+	name = dns.CanonicalName(name)
+	longest := -1
+	var matching []string
+	for zone, servers := range f.ConditionalForwarding {
+		zone = dns.CanonicalName(strings.TrimPrefix(zone, "*."))
+		if labels := dns.CountLabel(zone); labels > longest && dns.IsSubDomain(zone, name) {
+			longest = labels
+			matching = servers
+		}
+	}
+	return matching, longest >= 0
+}
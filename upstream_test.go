@@ -0,0 +1,154 @@
+package easydns
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNormalizeUpstream(t *testing.T) {
+	tests := []struct {
+		server      string
+		defaultPort string
+		want        string
+		wantErr     bool
+	}{
+		{server: "1.1.1.1", defaultPort: "53", want: "1.1.1.1:53"},
+		{server: "1.1.1.1:5353", defaultPort: "53", want: "1.1.1.1:5353"},
+		{server: "1.1.1.1", defaultPort: "853", want: "1.1.1.1:853"},
+		{server: "2606:4700:4700::1111", defaultPort: "53", want: "[2606:4700:4700::1111]:53"},
+		{server: "[2606:4700:4700::1111]", defaultPort: "53", want: "[2606:4700:4700::1111]:53"},
+		{server: "[2606:4700:4700::1111]:5353", defaultPort: "53", want: "[2606:4700:4700::1111]:5353"},
+		{server: "dns.example.com", defaultPort: "53", want: "dns.example.com:53"},
+		{server: "dns.example.com:5353", defaultPort: "53", want: "dns.example.com:5353"},
+		{server: "tls://1.1.1.1", defaultPort: "53", want: "tls://1.1.1.1:853"},
+		{server: "tcp://dns.example.com", defaultPort: "853", want: "tcp://dns.example.com:53"},
+		{server: "https://dns.example.com/dns-query", defaultPort: "53", want: "https://dns.example.com/dns-query"},
+		{server: "dns.example.com:0", defaultPort: "53", wantErr: true},
+		{server: "dns.example.com:domain", defaultPort: "53", wantErr: true},
+		{server: ":53", defaultPort: "53", wantErr: true},
+		{server: "quic://1.1.1.1", defaultPort: "53", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			got, err := normalizeUpstream(tt.server, tt.defaultPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigNormalizesUpstreams(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarding string
+		want       []string
+		wantEasy   []string
+		wantErr    bool
+	}{
+		{name: "servers", forwarding: `{"enabled": true, "servers": ["1.1.1.1", "dns.example.com"]}`, want: []string{"1.1.1.1:53", "dns.example.com:53"}},
+		{name: "easydns servers", forwarding: `{"enabled": true, "servers": ["10.0.0.53"], "easydns_servers": ["10.0.0.53"]}`, want: []string{"10.0.0.53:53"}, wantEasy: []string{"10.0.0.53:53"}},
+		{name: "invalid port", forwarding: `{"enabled": true, "servers": ["1.1.1.1:99999"]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			data := `{"version": 1, "server": {"port": "53"}, "forwarding": ` + tt.forwarding + `}`
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(cfg.Forwarding.Servers, tt.want) {
+				t.Errorf("got servers %v, want %v", cfg.Forwarding.Servers, tt.want)
+			}
+			if len(tt.wantEasy) > 0 && !reflect.DeepEqual(cfg.Forwarding.EasyDNSServers, tt.wantEasy) {
+				t.Errorf("got easydns servers %v, want %v", cfg.Forwarding.EasyDNSServers, tt.wantEasy)
+			}
+		})
+	}
+}
+
+func TestForwardingForName(t *testing.T) {
+	forwarding := ForwardingConfig{
+		Servers: []string{"192.0.2.53:53"},
+		ConditionalForwarding: map[string][]string{
+			"corp.internal":     {"10.0.0.53:53"},
+			"lab.corp.internal": {"10.0.1.53:53"},
+			"*.home.arpa":       {"192.168.1.1:53"},
+		},
+	}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "corp.internal.", want: []string{"10.0.0.53:53"}},
+		{name: "host.corp.internal.", want: []string{"10.0.0.53:53"}},
+		{name: "host.lab.corp.internal.", want: []string{"10.0.1.53:53"}},
+		{name: "HOST.Lab.Corp.Internal.", want: []string{"10.0.1.53:53"}},
+		{name: "notcorp.internal.", want: []string{"192.0.2.53:53"}},
+		{name: "www.example.com.", want: []string{"192.0.2.53:53"}},
+		{name: "nas.home.arpa.", want: []string{"192.168.1.1:53"}},
+		{name: "home.arpa.", want: []string{"192.168.1.1:53"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwarding.forName(tt.name).Servers; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionalForwarding(t *testing.T) {
+	public := startUpstream(t, answerA("192.0.2.1"))
+	corp := startUpstream(t, answerA("10.0.0.1"))
+	lab := startUpstream(t, answerA("10.0.1.1"))
+	cfg := &Config{
+		Version: currentConfigVersion,
+		Server:  ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{
+			Enabled: true,
+			Servers: []string{public.addr},
+			ConditionalForwarding: map[string][]string{
+				"corp.internal":     {corp.addr},
+				"lab.corp.internal": {lab.addr},
+			},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "app.corp.internal", want: []string{"10.0.0.1"}},
+		{name: "app.lab.corp.internal", want: []string{"10.0.1.1"}},
+		{name: "www.example.com", want: []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ask(t, s, tt.name, dns.TypeA)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := public.queries.Load(); got != 1 {
+		t.Errorf("default servers got %d queries, want 1", got)
+	}
+}
@@ -0,0 +1,156 @@
+package easydns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxIdleUpstreamConns caps the idle connections kept per upstream server
+const maxIdleUpstreamConns = 4
+
+// upstreamClients holds the connections and TLS sessions shared by all
+// exchanges with the upstream servers, so TCP, TLS and HTTPS upstreams
+// don't pay for a new handshake on every query
+type upstreamClients struct {
+	tlsConfig *tls.Config
+	http      *http.Client
+
+	mu   sync.Mutex
+	idle map[string][]*dns.Conn
+}
+
+func newUpstreamClients() *upstreamClients {
+	tlsConfig := &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	return &upstreamClients{
+		tlsConfig: tlsConfig,
+		http: &http.Client{Transport: &http.Transport{
+			TLSClientConfig:     tlsConfig,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConnsPerHost: maxIdleUpstreamConns,
+			IdleConnTimeout:     90 * time.Second,
+		}},
+		idle: map[string][]*dns.Conn{},
+	}
+}
+
+// exchange sends query to an upstream server over transport, "udp", "tcp",
+// "tcp-tls" or "https"
+func (u *upstreamClients) exchange(ctx context.Context, query *dns.Msg, transport, address string, timeout time.Duration) (*dns.Msg, error) {
+	switch transport {
+	case "https":
+		return u.exchangeHTTPS(ctx, query, address, timeout)
+	case "tcp", "tcp-tls":
+		return u.exchangeStream(ctx, query, transport, address, timeout)
+	default:
+		c := &dns.Client{Net: "udp", Timeout: timeout}
+		resp, _, err := c.ExchangeContext(ctx, query, address)
+		return resp, err
+	}
+}
+
+// exchangeStream sends query over an idle connection to the server if
+// there is one, or over a new connection that is kept for reuse
+func (u *upstreamClients) exchangeStream(ctx context.Context, query *dns.Msg, transport, address string, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{Net: transport, Timeout: timeout, TLSConfig: u.tlsConfig}
+	key := transport + "://" + address
+	if conn := u.takeIdle(key); conn != nil {
+		resp, _, err := c.ExchangeWithConnContext(ctx, query, conn)
+		if err == nil {
+			u.putIdle(key, conn)
+			return resp, nil
+		}
+		// The server may have closed the idle connection, try a new one
+		conn.Close()
+	}
+	conn, err := c.DialContext(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := c.ExchangeWithConnContext(ctx, query, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	u.putIdle(key, conn)
+	return resp, nil
+}
+
+func (u *upstreamClients) takeIdle(key string) *dns.Conn {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	conns := u.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	conn := conns[len(conns)-1]
+	u.idle[key] = conns[:len(conns)-1]
+	return conn
+}
+
+func (u *upstreamClients) putIdle(key string, conn *dns.Conn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.idle[key]) >= maxIdleUpstreamConns {
+		conn.Close()
+		return
+	}
+	u.idle[key] = append(u.idle[key], conn)
+}
+
+// exchangeHTTPS posts query to a DNS-over-HTTPS endpoint (RFC 8484)
+func (u *upstreamClients) exchangeHTTPS(ctx context.Context, query *dns.Msg, endpoint string, timeout time.Duration) (*dns.Msg, error) {
+	wire := query.Copy()
+	// A zero ID makes responses cacheable by HTTP caches
+	wire.Id = 0
+	packed, err := wire.Pack()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered with HTTP status %s", endpoint, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(body); err != nil {
+		return nil, err
+	}
+	msg.Id = query.Id
+	return msg, nil
+}
+
+// close drops all idle connections
+func (u *upstreamClients) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, conns := range u.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		delete(u.idle, key)
+	}
+	u.http.CloseIdleConnections()
+}
@@ -0,0 +1,238 @@
+package easydns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startUDPAndTCPUpstream starts an upstream server answering with handler
+// on the same free port over UDP and TCP, stopped when the test ends
+func startUDPAndTCPUpstream(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		t.Skipf("UDP port of %s is taken: %v", listener.Addr(), err)
+	}
+	for _, server := range []*dns.Server{{Listener: listener, Handler: handler}, {PacketConn: conn, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+	return listener.Addr().String()
+}
+
+func TestUpstreamTransports(t *testing.T) {
+	var udpQueries, tcpQueries atomic.Int64
+	// Over UDP the answer is cut off, over TCP it is complete
+	addr := startUDPAndTCPUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		if w.RemoteAddr().Network() == "udp" {
+			udpQueries.Add(1)
+			resp.Truncated = true
+		} else {
+			tcpQueries.Add(1)
+			for _, address := range []string{"192.0.2.1", "192.0.2.2"} {
+				rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A " + address)
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		w.WriteMsg(resp)
+	})
+	tests := []struct {
+		name      string
+		transport string
+		server    string
+		wantUDP   int64
+		wantTCP   int64
+	}{
+		{name: "truncated UDP answer is retried over TCP", server: addr, wantUDP: 1, wantTCP: 1},
+		{name: "TCP transport", transport: "tcp", server: addr, wantTCP: 1},
+		{name: "TCP scheme", server: "tcp://" + addr, wantTCP: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			udpQueries.Store(0)
+			tcpQueries.Store(0)
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{tt.server}, Transport: tt.transport},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, "www.example.com", dns.TypeA)
+			if got, want := answerValues(resp), []string{"192.0.2.1", "192.0.2.2"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if resp.Truncated {
+				t.Error("response is truncated")
+			}
+			if udpQueries.Load() != tt.wantUDP || tcpQueries.Load() != tt.wantTCP {
+				t.Errorf("got %d UDP and %d TCP queries, want %d and %d", udpQueries.Load(), tcpQueries.Load(), tt.wantUDP, tt.wantTCP)
+			}
+		})
+	}
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		wantRcode int
+	}{
+		{name: "no retries", wantRcode: dns.RcodeServerFailure},
+		{name: "retry after a lost query", retries: 1, wantRcode: dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first query is never answered
+			var queries atomic.Int64
+			up := startUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				if queries.Add(1) > 1 {
+					answerA("192.0.2.1")(w, r)
+				}
+			})
+			cfg := &Config{
+				Version:    currentConfigVersion,
+				Server:     ServerConfig{Port: "53"},
+				Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}, Timeout: "100ms", Retries: tt.retries},
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := ask(t, s, "www.example.com", dns.TypeA)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("got rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorAfterRetries(t *testing.T) {
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{deadUpstream(t), deadUpstream(t)}, Timeout: "100ms", Retries: 2},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	_, err = s.requestFromUpsreamServers(context.Background(), query, cfg.Forwarding)
+	var upstreamErr UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		t.Fatalf("got error %v, want an UpstreamError", err)
+	}
+	if want := "from 2 upstream servers after 3 attempts each"; !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %q, want it to contain %q", err, want)
+	}
+}
+
+// countingListener counts the connections it accepts
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestUpstreamConnectionReuse(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &countingListener{Listener: inner}
+	server := &dns.Server{Listener: listener, Handler: dns.HandlerFunc(answerA("192.0.2.1"))}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{"tcp://" + inner.Addr().String()}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if got := answerValues(ask(t, s, name, dns.TypeA)); !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+			t.Fatalf("%s: got %v, want [192.0.2.1]", name, got)
+		}
+	}
+	if got := listener.accepted.Load(); got != 1 {
+		t.Errorf("got %d connections for 3 queries, want 1", got)
+	}
+}
+
+func TestHTTPSUpstream(t *testing.T) {
+	var gotType atomic.Value
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotType.Store(req.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(req.Body)
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(query)
+		rr, _ := dns.NewRR(query.Question[0].Name + " 60 IN A 192.0.2.7")
+		resp.Answer = append(resp.Answer, rr)
+		packed, _ := resp.Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(packed)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{upstream.URL + "/dns-query"}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Trust the test server's certificate
+	s.upstreams.http = upstream.Client()
+	resp := ask(t, s, "www.example.com", dns.TypeA)
+	if got := answerValues(resp); !reflect.DeepEqual(got, []string{"192.0.2.7"}) {
+		t.Errorf("got %v, want [192.0.2.7]", got)
+	}
+	if got, _ := gotType.Load().(string); got != dohMediaType {
+		t.Errorf("got content type %q, want %q", got, dohMediaType)
+	}
+}
@@ -0,0 +1,395 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type ConfigInvalidError struct {
+	problems []string
+}
+
+func (e ConfigInvalidError) Error() string {
+	return fmt.Sprintf("config is invalid: %s", strings.Join(e.problems, "; "))
+}
+
+// Problems returns every problem found, one per record or setting
+func (e ConfigInvalidError) Problems() []string {
+	return e.problems
+}
+
+// maxRecordTTL is the largest TTL allowed by RFC 2181 section 8
+const maxRecordTTL = 1<<31 - 1
+
+// valueProblem checks that value is usable as the data of a record of
+// the given type
+func valueProblem(recordType, value string) string {
+	switch recordType {
+	case "A":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return fmt.Sprintf("value %q is not an IPv4 address", value)
+		}
+	case "AAAA":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() != nil {
+			return fmt.Sprintf("value %q is not an IPv6 address", value)
+		}
+	case "CNAME", "MX", "NS", "PTR", "SRV":
+		if _, ok := dns.IsDomainName(value); !ok || value == "" {
+			return fmt.Sprintf("value %q is not a valid hostname", value)
+		}
+	}
+	return ""
+}
+
+// recordProblems lists everything that is wrong with a record
+func recordProblems(name string, record Record) []string {
+	var problems []string
+	if _, ok := dns.IsDomainName(name); !ok {
+		problems = append(problems, "name is not a valid domain name")
+	}
+	switch record.Type {
+	case "A", "AAAA", "CNAME", "TXT", "NS", "PTR", "MX", "SRV", "SOA", "CAA", "NAPTR", "SSHFP", "TLSA", "SVCB", "HTTPS":
+	default:
+		return append(problems, UnsupportedRecordTypeError{recordType: record.Type}.Error())
+	}
+	if problem := valueProblem(record.Type, record.Value); problem != "" {
+		problems = append(problems, problem)
+	}
+	if record.TTL > maxRecordTTL {
+		problems = append(problems, fmt.Sprintf("ttl %d is above the maximum of %d", record.TTL, maxRecordTTL))
+	}
+	if _, err := parseNetworks(record.Subnets); err != nil {
+		problems = append(problems, fmt.Sprintf("subnets: %v", err))
+	}
+	if record.Type == "SRV" {
+		if record.Weight > 65535 {
+			problems = append(problems, fmt.Sprintf("SRV records need a weight between 0 and 65535, got %d", record.Weight))
+		}
+		if record.Port <= 0 || record.Port > 65535 {
+			problems = append(problems, "SRV records need a port between 1 and 65535")
+		}
+		if labels := dns.SplitDomainName(name); len(labels) < 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+			problems = append(problems, fmt.Sprintf("%q is not of the form _service._proto.<name>", name))
+		}
+	}
+	if record.HealthCheck != nil {
+		if err := record.HealthCheck.validate(record); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if record.Weight < 0 {
+		problems = append(problems, fmt.Sprintf("weight must not be negative, got %d", record.Weight))
+	}
+	if record.Type == "MX" || record.Type == "SRV" {
+		if record.Priority < 0 || record.Priority > 65535 {
+			problems = append(problems, fmt.Sprintf("%s records need a priority between 0 and 65535", record.Type))
+		}
+	}
+	for i, entry := range record.Schedule {
+		if _, err := entry.matches(now()); err != nil {
+			problems = append(problems, fmt.Sprintf("schedule %d: %v", i, err))
+		}
+		scheduled := record
+		scheduled.Value, scheduled.Schedule = entry.Value, nil
+		if problem := valueProblem(record.Type, entry.Value); problem != "" {
+			problems = append(problems, fmt.Sprintf("schedule %d: %s", i, problem))
+		} else if _, err := newRR(dns.Fqdn(name), scheduled); err != nil {
+			problems = append(problems, fmt.Sprintf("schedule %d: %v", i, err))
+		}
+	}
+	if len(problems) == 0 {
+		if _, err := newRR(dns.Fqdn(name), record); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// recordSetProblems lists everything that is wrong with the records of a
+// name, including combinations that are not allowed
+func recordSetProblems(name string, set []Record) []string {
+	if len(set) == 0 {
+		return []string{"no records"}
+	}
+	var problems []string
+	cname := false
+	for _, record := range set {
+		problems = append(problems, recordProblems(name, record)...)
+		cname = cname || record.Type == "CNAME"
+	}
+	if cname && len(set) > 1 {
+		problems = append(problems, "a CNAME record cannot be combined with other records")
+	}
+	return problems
+}
+
+// validateBindAddress accepts an empty address (all addresses), an
+// interface binding or an IP address with an optional zone
+func validateBindAddress(bindAddress string) error {
+	if bindAddress == "" || isInterfaceBinding(bindAddress) {
+		return nil
+	}
+	host, _, _ := strings.Cut(bindAddress, "%")
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("bind_address %q is not an IP address or iface:<name>", bindAddress)
+	}
+	return nil
+}
+
+// ValidateConfig checks the records and settings of config and reports
+// every problem found
+func ValidateConfig(config *Config) error {
+	var problems []string
+	names := make([]string, 0, len(config.Records))
+	for name := range config.Records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := map[string]string{}
+	for _, name := range names {
+		for _, problem := range recordSetProblems(name, config.Records[name]) {
+			problems = append(problems, fmt.Sprintf("record %s: %s", name, problem))
+		}
+		// Names are matched case-insensitively, so these would shadow each other
+		if other, found := seen[recordName(name)]; found {
+			problems = append(problems, fmt.Sprintf("record %s: same name as record %s", name, other))
+		}
+		seen[recordName(name)] = name
+	}
+
+	if port, err := strconv.Atoi(config.Server.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("server: port %q is not a valid port", config.Server.Port))
+	}
+	if err := validateBindAddress(config.Server.BindAddress); err != nil {
+		problems = append(problems, fmt.Sprintf("server: %v", err))
+	}
+	switch config.Server.RoundRobinMode {
+	case "", "sticky", "round-robin", "random", "weighted":
+	default:
+		problems = append(problems, fmt.Sprintf("server: unknown round_robin_mode %q", config.Server.RoundRobinMode))
+	}
+	switch config.Server.AnyQueries {
+	case "", "all", "hinfo":
+	default:
+		problems = append(problems, fmt.Sprintf("server: unknown any_queries %q", config.Server.AnyQueries))
+	}
+	if _, err := listenProtocols(config.Server.Protocols); err != nil {
+		problems = append(problems, fmt.Sprintf("server: %v", err))
+	}
+	if size := config.Server.EDNSUDPSize; size != 0 && (size < dns.MinMsgSize || size > dns.MaxMsgSize) {
+		problems = append(problems, fmt.Sprintf("server: edns_udp_size must be between %d and %d, got %d", dns.MinMsgSize, dns.MaxMsgSize, size))
+	}
+	if config.Server.MaxConcurrentQueries < 0 {
+		problems = append(problems, fmt.Sprintf("server: max_concurrent_queries must not be negative, got %d", config.Server.MaxConcurrentQueries))
+	}
+	for _, duration := range []func() (time.Duration, error){
+		config.Server.bindRetry,
+		config.Server.shutdownTimeout,
+		config.Server.tcpIdleTimeout,
+	} {
+		if _, err := duration(); err != nil {
+			problems = append(problems, fmt.Sprintf("server: %v", err))
+		}
+	}
+	if err := config.Fallback.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("fallback: %v", err))
+	}
+	switch config.Forwarding.Strategy {
+	case "", "sequential", "round-robin", "fastest", "parallel":
+	default:
+		problems = append(problems, fmt.Sprintf("forwarding: unknown strategy %q, expected sequential, round-robin, fastest or parallel", config.Forwarding.Strategy))
+	}
+	if config.Forwarding.ParallelServers < 0 {
+		problems = append(problems, fmt.Sprintf("forwarding: parallel_servers must not be negative, got %d", config.Forwarding.ParallelServers))
+	}
+	if config.Forwarding.FailureThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("forwarding: failure_threshold must not be negative, got %d", config.Forwarding.FailureThreshold))
+	}
+	if _, err := config.Forwarding.healthCheckInterval(); err != nil {
+		problems = append(problems, fmt.Sprintf("forwarding: %v", err))
+	}
+	if _, err := config.Forwarding.timeout(); err != nil {
+		problems = append(problems, fmt.Sprintf("forwarding: %v", err))
+	}
+	for _, zone := range config.AuthoritativeZones {
+		if _, ok := dns.IsDomainName(zone); !ok || recordName(zone) == "" {
+			problems = append(problems, fmt.Sprintf("authoritative_zones: %q is not a valid zone", zone))
+		}
+	}
+	zoneNames := make([]string, 0, len(config.Zones))
+	for zone := range config.Zones {
+		zoneNames = append(zoneNames, zone)
+	}
+	sort.Strings(zoneNames)
+	for _, zone := range zoneNames {
+		if _, ok := dns.IsDomainName(zone); !ok || recordName(zone) == "" {
+			problems = append(problems, fmt.Sprintf("zones: %q is not a valid zone", zone))
+			continue
+		}
+		if err := config.Zones[zone].validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("zones: %s: %v", zone, err))
+		}
+		if key, found := FindRecordKey(config.Records, zone); found && slices.ContainsFunc(config.Records[key], func(r Record) bool { return r.Type == "SOA" }) {
+			problems = append(problems, fmt.Sprintf("zones: %s: its SOA record is generated, remove the SOA record from records", zone))
+		}
+	}
+	viewNames := map[string]bool{}
+	for _, view := range config.Views {
+		if err := view.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("views: %v", err))
+		}
+		if viewNames[view.Name] {
+			problems = append(problems, fmt.Sprintf("views: view %s is defined twice", view.Name))
+		}
+		viewNames[view.Name] = true
+	}
+	if err := config.Storage.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("storage: %v", err))
+	}
+	if config.Storage.shared() && (config.API.PersistPath != "" || config.Update.PersistPath != "") {
+		problems = append(problems, fmt.Sprintf("storage: records are kept in the %s storage, remove persist_path from api and update", config.Storage.Backend))
+	}
+	if err := config.Signing.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("signing: %v", err))
+	}
+	if len(config.signedZoneNames()) > 0 && config.Signing.KeyDir == "" {
+		problems = append(problems, "signing: key_dir is required to keep the keys of zones with dnssec enabled")
+	}
+	switch config.Forwarding.Transport {
+	case "", "udp", "tcp", "tcp-tls":
+	default:
+		problems = append(problems, fmt.Sprintf("forwarding: unknown transport %q, expected udp, tcp or tcp-tls", config.Forwarding.Transport))
+	}
+	if _, err := parseTrustAnchors(config.Forwarding.TrustAnchors); err != nil {
+		problems = append(problems, fmt.Sprintf("forwarding: %v", err))
+	}
+	if config.Forwarding.Retries < 0 {
+		problems = append(problems, fmt.Sprintf("forwarding: retries must not be negative, got %d", config.Forwarding.Retries))
+	}
+	for server, settings := range config.Forwarding.Upstreams {
+		if _, err := normalizeUpstream(server, upstreamPort(config.Forwarding.Transport)); err != nil {
+			problems = append(problems, fmt.Sprintf("forwarding: upstreams: %v", err))
+		}
+		if _, err := settings.timeout(); err != nil {
+			problems = append(problems, fmt.Sprintf("forwarding: upstreams: %v for %s", err, server))
+		}
+		if settings.Retries != nil && *settings.Retries < 0 {
+			problems = append(problems, fmt.Sprintf("forwarding: upstreams: retries of %s must not be negative, got %d", server, *settings.Retries))
+		}
+	}
+	zones := make([]string, 0, len(config.Forwarding.ConditionalForwarding))
+	for zone := range config.Forwarding.ConditionalForwarding {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		if _, ok := dns.IsDomainName(zone); !ok || len(config.Forwarding.ConditionalForwarding[zone]) == 0 {
+			problems = append(problems, fmt.Sprintf("forwarding: conditional forwarding for %q needs a valid zone and at least one server", zone))
+		}
+	}
+	if err := config.Blocklist.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("blocklist: %v", err))
+	}
+	if err := config.DoT.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("dot: %v", err))
+	}
+	if err := config.DoQ.validate(config.DoT); err != nil {
+		problems = append(problems, fmt.Sprintf("doq: %v", err))
+	}
+	if err := config.DoH.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("doh: %v", err))
+	}
+	if err := config.Logging.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("logging: %v", err))
+	}
+	if err := config.TTL.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("ttl: %v", err))
+	}
+	if _, err := newACL(config.ACL); err != nil {
+		problems = append(problems, fmt.Sprintf("acl: %v", err))
+	}
+	if err := config.RateLimit.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("rate_limit: %v", err))
+	}
+	if err := config.Transfer.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("transfer: %v", err))
+	}
+	for _, key := range config.Transfer.Keys {
+		if other, found := findTSIGKey(config.Update.Keys, dns.Fqdn(key.Name)); found && config.Update.Enabled && other.Secret != key.Secret {
+			problems = append(problems, fmt.Sprintf("transfer: key %s has a different secret than the update key of the same name", key.Name))
+		}
+	}
+	if err := config.Update.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("update: %v", err))
+	}
+	if _, err := newTransforms(config.Transforms); err != nil {
+		problems = append(problems, fmt.Sprintf("transforms: %v", err))
+	}
+	if _, err := parseHoldDowns(config.HoldDown); err != nil {
+		problems = append(problems, fmt.Sprintf("hold_down: %v", err))
+	}
+	if _, err := newRewrites(config.Rewrites); err != nil {
+		problems = append(problems, fmt.Sprintf("rewrites: %v", err))
+	}
+	if err := config.TSIG.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("tsig: %v", err))
+	}
+	if err := config.ECS.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("ecs: %v", err))
+	}
+	if err := config.Discovery.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("discovery: %v", err))
+	}
+	if config.MDNS.Enabled {
+		if _, err := config.MDNS.interfaces(); err != nil {
+			problems = append(problems, fmt.Sprintf("mdns: %v", err))
+		}
+	}
+	if err := config.DNS64.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("dns64: %v", err))
+	}
+	if _, err := newPolicies(config.Policies, upstreamPort(config.Forwarding.Transport)); err != nil {
+		problems = append(problems, fmt.Sprintf("policies: %v", err))
+	}
+
+	if len(problems) > 0 {
+		return ConfigInvalidError{problems: problems}
+	}
+	return nil
+}
+
+// ConfigWarnings reports settings of config that are valid but likely
+// mistakes. Records without a TTL are served with TTL 0 unless
+// ttl.default_ttl is set, and some resolvers handle that badly.
+func ConfigWarnings(config *Config) []string {
+	if config.TTL.DefaultTTL != 0 {
+		return nil
+	}
+	var warnings []string
+	zeroTTL := func(prefix string, records Records) {
+		names := make([]string, 0, len(records))
+		for name := range records {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, record := range records[name] {
+				if record.TTL == 0 {
+					warnings = append(warnings, fmt.Sprintf("%srecord %s: %s %s has no ttl and is served with TTL 0, set ttl or ttl.default_ttl", prefix, name, record.Type, record.Value))
+				}
+			}
+		}
+	}
+	zeroTTL("", config.Records)
+	for _, v := range config.Views {
+		zeroTTL(fmt.Sprintf("view %s: ", v.Name), v.Records)
+	}
+	return warnings
+}
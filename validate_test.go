@@ -0,0 +1,76 @@
+package easydns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(cfg *Config)
+		wantErr string // Part of the error, valid when empty
+	}{
+		{name: "valid", change: func(cfg *Config) {}},
+		{name: "MX priority 0", change: func(cfg *Config) {
+			cfg.Records["test.com"] = []Record{{Type: "MX", Value: "mail.test.com.", Priority: 0, TTL: 60}}
+		}},
+		{name: "SRV priority 65535", change: func(cfg *Config) {
+			cfg.Records["_sip._udp.test.com"] = []Record{{Type: "SRV", Value: "sip.test.com.", Priority: 65535, Port: 5060, TTL: 60}}
+		}},
+		{name: "SRV name without service and protocol", change: func(cfg *Config) {
+			cfg.Records["sip.test.com"] = []Record{{Type: "SRV", Value: "sip.test.com.", Port: 5060, TTL: 60}}
+		}, wantErr: `record sip.test.com: "sip.test.com" is not of the form _service._proto.<name>`},
+		{name: "SRV without a port", change: func(cfg *Config) {
+			cfg.Records["_sip._udp.test.com"] = []Record{{Type: "SRV", Value: "sip.test.com.", TTL: 60}}
+		}, wantErr: "SRV records need a port between 1 and 65535"},
+		{name: "SRV weight over 65535", change: func(cfg *Config) {
+			cfg.Records["_sip._udp.test.com"] = []Record{{Type: "SRV", Value: "sip.test.com.", Weight: 65536, Port: 5060, TTL: 60}}
+		}, wantErr: "SRV records need a weight between 0 and 65535, got 65536"},
+		{name: "negative priority", change: func(cfg *Config) {
+			cfg.Records["test.com"] = []Record{{Type: "MX", Value: "mail.test.com.", Priority: -1, TTL: 60}}
+		}, wantErr: "record test.com: MX records need a priority between 0 and 65535"},
+		{name: "priority over 65535", change: func(cfg *Config) {
+			cfg.Records["test.com"] = []Record{{Type: "MX", Value: "mail.test.com.", Priority: 65536, TTL: 60}}
+		}, wantErr: "priority between 0 and 65535"},
+		{name: "invalid address", change: func(cfg *Config) {
+			cfg.Records["app.test.com"] = []Record{{Type: "A", Value: "10.0.0.300"}}
+		}, wantErr: `record app.test.com: value "10.0.0.300" is not an IPv4 address`},
+		{name: "invalid port", change: func(cfg *Config) { cfg.Server.Port = "dns" }, wantErr: `server: port "dns" is not a valid port`},
+		{name: "invalid bind address", change: func(cfg *Config) { cfg.Server.BindAddress = "localhost" }, wantErr: "bind_address"},
+		{name: "invalid duration", change: func(cfg *Config) { cfg.Server.ShutdownTimeout = "soon" }, wantErr: `server: invalid shutdown_timeout "soon"`},
+		{name: "edns_udp_size below 512", change: func(cfg *Config) { cfg.Server.EDNSUDPSize = 100 }, wantErr: "server: edns_udp_size must be between 512 and 65535, got 100"},
+		{name: "negative max_concurrent_queries", change: func(cfg *Config) { cfg.Server.MaxConcurrentQueries = -1 }, wantErr: "server: max_concurrent_queries must not be negative, got -1"},
+		{name: "invalid hold_down", change: func(cfg *Config) { cfg.HoldDown = map[string]string{"test.com": "soon"} }, wantErr: "hold_down:"},
+		{name: "unknown strategy", change: func(cfg *Config) { cfg.Forwarding.Strategy = "random" }, wantErr: `forwarding: unknown strategy "random"`},
+		{name: "negative failure_threshold", change: func(cfg *Config) { cfg.Forwarding.FailureThreshold = -1 }, wantErr: "failure_threshold must not be negative"},
+		{name: "invalid health_check_interval", change: func(cfg *Config) { cfg.Forwarding.HealthCheckInterval = "0s" }, wantErr: `forwarding: invalid health_check_interval "0s"`},
+		{name: "invalid upstream timeout", change: func(cfg *Config) {
+			cfg.Forwarding.Upstreams = map[string]UpstreamSettings{"192.0.2.1": {Timeout: "soon"}}
+		}, wantErr: `forwarding: upstreams: invalid timeout "soon" for 192.0.2.1`},
+		{name: "every problem is reported", change: func(cfg *Config) {
+			cfg.Server.Port = "0"
+			cfg.Fallback.Mode = "unknown"
+		}, wantErr: "is not a valid port; fallback:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Version: currentConfigVersion,
+				Server:  ServerConfig{Port: "53"},
+				Records: Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+			}
+			tt.change(cfg)
+			err := ValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package easydns
+
+import (
+	"fmt"
+	"net"
+)
+
+// ViewConfig gives the clients in its networks their own records, e.g.
+// internal addresses for internal clients (split horizon)
+type ViewConfig struct {
+	Name     string   `json:"name"`
+	Networks []string `json:"networks"`
+	// Records replace the default records of the same name for clients of
+	// the view, other names are answered from the default records
+	Records Records `json:"records"`
+}
+
+func (c ViewConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("a view needs a name")
+	}
+	if len(c.Networks) == 0 {
+		return fmt.Errorf("view %s needs at least one network", c.Name)
+	}
+	if _, err := parseNetworks(c.Networks); err != nil {
+		return fmt.Errorf("view %s: %v", c.Name, err)
+	}
+	if err := ValidateRecords(c.Records); err != nil {
+		return fmt.Errorf("view %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// view is the record set served to the clients of a view
+type view struct {
+	networks []*net.IPNet
+	records  Records
+	chains   map[string][]string
+}
+
+// matchLength returns the prefix length of the most specific network of the
+// view containing client, or -1 if none does
+func (v view) matchLength(client net.IP) int {
+	longest := -1
+	for _, network := range v.networks {
+		if network.Contains(client) {
+			ones, _ := network.Mask.Size()
+			longest = max(longest, ones)
+		}
+	}
+	return longest
+}
+
+// withViews builds the record set of every view: the default records, as
+// served, with the records of the view in place of the names it defines
+func (s *Server) withViews(records Records, cfg *Config) []view {
+	views := make([]view, 0, len(cfg.Views))
+	for _, v := range cfg.Views {
+		networks, err := parseNetworks(v.Networks)
+		if err != nil {
+			continue
+		}
+		merged := make(Records, len(records)+len(v.Records))
+		for name, set := range records {
+			merged[name] = set
+		}
+		for name, set := range withDefaultTTL(normalizeRecords(v.Records), cfg.TTL.DefaultTTL) {
+			merged[name] = set
+		}
+		if cfg.AutoPTR {
+			merged = withAutoPTRs(merged)
+		}
+		views = append(views, view{networks: networks, records: merged, chains: s.signer.nsecChains(merged)})
+	}
+	return views
+}
+
+// recordsFor returns the records and NSEC chains served to client: those of
+// the view with the most specific network containing it, the default records
+// if there is none. The first view wins a tie.
+func (r *recordSet) recordsFor(client net.IP) (Records, map[string][]string) {
+	best, longest := -1, -1
+	for i, v := range r.views {
+		if length := v.matchLength(client); length > longest {
+			best, longest = i, length
+		}
+	}
+	if best < 0 {
+		return r.records, r.chains
+	}
+	return r.views[best].records, r.views[best].chains
+}
@@ -0,0 +1,128 @@
+package easydns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// askFrom queries s for the A records of name from client
+func askFrom(t *testing.T, s *Server, client, name string) *dns.Msg {
+	t.Helper()
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	w := newRecorder("udp", client)
+	s.ServeDNS(w, query)
+	if w.msg == nil {
+		t.Fatalf("query for %s from %s was dropped", name, client)
+	}
+	return w.msg
+}
+
+func TestViews(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	s, err := New(&Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Cache:      CacheConfig{Enabled: true},
+		Records: Records{
+			"app.test.com":    {{Type: "A", Value: "203.0.113.5", TTL: 60}},
+			"public.test.com": {{Type: "A", Value: "203.0.113.6", TTL: 60}},
+		},
+		Views: []ViewConfig{
+			{
+				Name:     "office",
+				Networks: []string{"10.0.0.0/8"},
+				Records: Records{
+					"app.test.com":      {{Type: "A", Value: "10.0.0.5", TTL: 60}},
+					"internal.test.com": {{Type: "A", Value: "10.0.0.6", TTL: 60}},
+				},
+			},
+			{
+				Name:     "vpn",
+				Networks: []string{"10.8.0.0/16"},
+				Records:  Records{"app.test.com": {{Type: "A", Value: "10.8.0.1", TTL: 60}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In order, so the cache is filled by the earlier queries
+	tests := []struct {
+		name   string
+		client string
+		query  string
+		want   []string
+	}{
+		{name: "default records", client: "198.51.100.1", query: "app.test.com", want: []string{"203.0.113.5"}},
+		{name: "view records", client: "10.1.2.3", query: "app.test.com", want: []string{"10.0.0.5"}},
+		{name: "most specific view", client: "10.8.0.9", query: "app.test.com", want: []string{"10.8.0.1"}},
+		{name: "default record not in the view", client: "10.1.2.3", query: "public.test.com", want: []string{"203.0.113.6"}},
+		{name: "view only record", client: "10.1.2.3", query: "internal.test.com", want: []string{"10.0.0.6"}},
+		{name: "view only record is forwarded outside the view", client: "198.51.100.1", query: "internal.test.com", want: []string{"192.0.2.1"}},
+		{name: "cached answer doesn't leak into the view", client: "10.1.2.3", query: "internal.test.com", want: []string{"10.0.0.6"}},
+		{name: "view does not inherit another view", client: "10.8.0.9", query: "internal.test.com", want: []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerValues(askFrom(t, s, tt.client, tt.query)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got answers %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := up.queries.Load(); got != 1 {
+		t.Errorf("upstream got %d queries, want 1 answered from the cache afterwards", got)
+	}
+}
+
+func TestValidateViews(t *testing.T) {
+	tests := []struct {
+		name    string
+		views   []ViewConfig
+		wantErr string
+	}{
+		{name: "valid", views: []ViewConfig{{Name: "office", Networks: []string{"10.0.0.0/8"}}}},
+		{name: "missing name", views: []ViewConfig{{Networks: []string{"10.0.0.0/8"}}}, wantErr: "views: a view needs a name"},
+		{name: "missing networks", views: []ViewConfig{{Name: "office"}}, wantErr: "views: view office needs at least one network"},
+		{name: "invalid network", views: []ViewConfig{{Name: "office", Networks: []string{"10.0.0.0/33"}}}, wantErr: "views: view office:"},
+		{
+			name: "invalid record",
+			views: []ViewConfig{{
+				Name:     "office",
+				Networks: []string{"10.0.0.0/8"},
+				Records:  Records{"app.test.com": {{Type: "A", Value: "not-an-address"}}},
+			}},
+			wantErr: "views: view office:",
+		},
+		{
+			name: "duplicate name",
+			views: []ViewConfig{
+				{Name: "office", Networks: []string{"10.0.0.0/8"}},
+				{Name: "office", Networks: []string{"192.168.0.0/16"}},
+			},
+			wantErr: "views: view office is defined twice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(&Config{
+				Version: currentConfigVersion,
+				Server:  ServerConfig{Port: "53"},
+				Views:   tt.views,
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
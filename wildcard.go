@@ -0,0 +1,26 @@
+package easydns
+
+import "strings"
+
+// lookup finds the records of a normalized name. Exact matches win; failing
+// that the wildcard "*.<parent>" of the closest existing parent is used, as
+// in RFC 4592. It also returns the key the records were found under.
+func (records Records) lookup(name string) (string, []Record, bool) {
+	if set, found := records[name]; found {
+		return name, set, true
+	}
+	for parent := name; ; {
+		_, rest, more := strings.Cut(parent, ".")
+		if !more {
+			return "", nil, false
+		}
+		parent = rest
+		if set, found := records["*."+parent]; found {
+			return "*." + parent, set, true
+		}
+		if _, found := records[parent]; found {
+			// An existing name blocks wildcards further up
+			return "", nil, false
+		}
+	}
+}
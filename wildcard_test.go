@@ -0,0 +1,83 @@
+package easydns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordsLookup(t *testing.T) {
+	records := Records{
+		"*.dev.test.com":     {{Type: "A", Value: "10.0.0.1"}},
+		"app.dev.test.com":   {{Type: "A", Value: "10.0.0.2"}},
+		"web.dev.test.com":   {{Type: "TXT", Value: "\"web\""}},
+		"*.web.dev.test.com": {{Type: "A", Value: "10.0.0.3"}},
+	}
+	tests := []struct {
+		name      string
+		wantKey   string
+		wantFound bool
+	}{
+		{name: "a.dev.test.com", wantKey: "*.dev.test.com", wantFound: true},
+		{name: "app.dev.test.com", wantKey: "app.dev.test.com", wantFound: true},
+		{name: "x.y.dev.test.com", wantKey: "*.dev.test.com", wantFound: true},
+		{name: "x.web.dev.test.com", wantKey: "*.web.dev.test.com", wantFound: true},
+		// app.dev.test.com exists, so it is the closest encloser
+		{name: "x.app.dev.test.com", wantFound: false},
+		{name: "dev.test.com", wantFound: false},
+		{name: "other.test.com", wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _, found := records.lookup(tt.name)
+			if key != tt.wantKey || found != tt.wantFound {
+				t.Errorf("got %q, %v, want %q, %v", key, found, tt.wantKey, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestWildcardAnswers(t *testing.T) {
+	up := startUpstream(t, answerA("192.0.2.1"))
+	cfg := &Config{
+		Version:    currentConfigVersion,
+		Server:     ServerConfig{Port: "53"},
+		Forwarding: ForwardingConfig{Enabled: true, Servers: []string{up.addr}},
+		Records: Records{
+			"*.dev.test.com":   {{Type: "A", Value: "10.0.0.1", TTL: 60}},
+			"app.dev.test.com": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+		},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		qname       string
+		want        []string
+		wantForward bool
+	}{
+		{name: "wildcard", qname: "a.dev.test.com.", want: []string{"10.0.0.1"}},
+		{name: "exact match shadows the wildcard", qname: "app.dev.test.com.", want: []string{"10.0.0.2"}},
+		{name: "wildcard covers deeper names", qname: "x.b.dev.test.com.", want: []string{"10.0.0.1"}},
+		{name: "wildcard does not cover its parent", qname: "dev.test.com.", want: []string{"192.0.2.1"}, wantForward: true},
+		{name: "miss falls through to forwarding", qname: "a.prod.test.com.", want: []string{"192.0.2.1"}, wantForward: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := up.queries.Load()
+			resp := ask(t, s, tt.qname, dns.TypeA)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if got := resp.Answer[0].Header().Name; got != tt.qname {
+				t.Errorf("got answer name %q, want %q", got, tt.qname)
+			}
+			if forwarded := up.queries.Load() > before; forwarded != tt.wantForward {
+				t.Errorf("forwarded is %v, want %v", forwarded, tt.wantForward)
+			}
+		})
+	}
+}
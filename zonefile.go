@@ -0,0 +1,128 @@
+package easydns
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+type ZoneFileError struct {
+	file          string
+	originalError error
+}
+
+func (e ZoneFileError) Error() string {
+	return fmt.Sprintf("zone file %s is invalid: %v", e.file, e.originalError)
+}
+
+// zoneRecord converts a record parsed from a zone file
+func zoneRecord(rr dns.RR) (Record, bool) {
+	header := rr.Header()
+	record := Record{Type: dns.TypeToString[header.Rrtype], TTL: header.Ttl}
+	switch rr := rr.(type) {
+	case *dns.A, *dns.AAAA, *dns.CAA, *dns.CNAME, *dns.NS, *dns.PTR, *dns.SOA, *dns.TXT,
+		*dns.NAPTR, *dns.SSHFP, *dns.TLSA, *dns.SVCB, *dns.HTTPS:
+		record.Value = rdata(rr)
+	case *dns.MX:
+		record.Value = rr.Mx
+		record.Priority = int(rr.Preference)
+	case *dns.SRV:
+		record.Value = rr.Target
+		record.Priority = int(rr.Priority)
+		record.Weight = int(rr.Weight)
+		record.Port = int(rr.Port)
+	default:
+		return Record{}, false
+	}
+	return record, true
+}
+
+// loadZoneFile parses an RFC 1035 zone file. $ORIGIN and $TTL are
+// supported, $INCLUDE is not. Records of unsupported types are skipped.
+func loadZoneFile(file string) (Records, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := Records{}
+	parser := dns.NewZoneParser(f, "", "")
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		name := recordName(rr.Header().Name)
+		record, supported := zoneRecord(rr)
+		if !supported {
+			log.Printf("zone file %s: skipping unsupported %s record for %s", file, dns.TypeToString[rr.Header().Rrtype], name)
+			continue
+		}
+		records[name] = append(records[name], record)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// LoadZoneFile parses an RFC 1035 zone file into records, e.g. to import
+// it into a config
+func LoadZoneFile(file string) (Records, error) {
+	records, err := loadZoneFile(file)
+	if err != nil {
+		return nil, ZoneFileError{file: file, originalError: err}
+	}
+	return records, nil
+}
+
+// WriteZoneFile writes records in RFC 1035 zone file notation, one line per
+// record sorted by name. Schedules are not exported, records are written
+// with their base value.
+func WriteZoneFile(w io.Writer, records Records) error {
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, record := range records[name] {
+			rr, err := newRR(dns.Fqdn(name), record)
+			if err != nil {
+				return fmt.Errorf("record %s: %v", name, err)
+			}
+			if _, err := fmt.Fprintln(w, rr.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadZoneFiles parses the zone files and merges them on top of base. A
+// name defined twice is an error.
+func loadZoneFiles(files []string, base Records) (Records, error) {
+	if len(files) == 0 {
+		return base, nil
+	}
+	merged := Records{}
+	definedIn := map[string]string{}
+	for name, record := range base {
+		merged[recordName(name)] = record
+		definedIn[recordName(name)] = "the config file"
+	}
+	for _, file := range files {
+		records, err := loadZoneFile(file)
+		if err != nil {
+			return nil, ZoneFileError{file: file, originalError: err}
+		}
+		for name, record := range records {
+			if other, found := definedIn[name]; found {
+				return nil, ZoneFileError{file: file, originalError: fmt.Errorf("record %s is already defined in %s", name, other)}
+			}
+			merged[name] = record
+			definedIn[name] = file
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,167 @@
+package easydns
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testZone = `$ORIGIN zone.test.
+$TTL 600
+@       IN SOA ns1 hostmaster 1 7200 3600 1209600 300
+@       IN NS  ns1
+ns1     IN A   10.0.1.53
+host    300 IN A 10.0.1.1
+host    IN AAAA 2001:db8::1
+www     IN CNAME host
+@       IN MX  10 mail
+mail    IN A   10.0.1.25
+@       IN TXT "v=spf1 mx -all"
+_sip._tcp IN SRV 10 60 5060 host
+1.1.1.10.in-addr.arpa. IN PTR host
+`
+
+// writeZone writes a zone file to the test directory
+func writeZone(t *testing.T, zone string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "zone.db")
+	if err := os.WriteFile(file, []byte(zone), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadZoneFile(t *testing.T) {
+	records, err := loadZoneFile(writeZone(t, testZone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want []Record
+	}{
+		{name: "zone.test", want: []Record{
+			{Type: "SOA", Value: "ns1.zone.test. hostmaster.zone.test. 1 7200 3600 1209600 300", TTL: 600},
+			{Type: "NS", Value: "ns1.zone.test.", TTL: 600},
+			{Type: "MX", Value: "mail.zone.test.", Priority: 10, TTL: 600},
+			{Type: "TXT", Value: `"v=spf1 mx -all"`, TTL: 600},
+		}},
+		{name: "host.zone.test", want: []Record{
+			{Type: "A", Value: "10.0.1.1", TTL: 300},
+			{Type: "AAAA", Value: "2001:db8::1", TTL: 600},
+		}},
+		{name: "www.zone.test", want: []Record{{Type: "CNAME", Value: "host.zone.test.", TTL: 600}}},
+		{name: "_sip._tcp.zone.test", want: []Record{{Type: "SRV", Value: "host.zone.test.", Priority: 10, Weight: 60, Port: 5060, TTL: 600}}},
+		{name: "1.1.1.10.in-addr.arpa", want: []Record{{Type: "PTR", Value: "host.zone.test.", TTL: 600}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := records[tt.name]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		base    Records
+		wantErr string
+	}{
+		{name: "parse error with its line", zone: "$ORIGIN zone.test.\nhost IN A 10.0.1.1\nbroken IN A not-an-address\n", wantErr: "line: 3"},
+		{name: "name also in the config", zone: testZone, base: Records{"Host.Zone.Test": {{Type: "A", Value: "10.0.0.1"}}}, wantErr: "already defined in the config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeZone(t, tt.zone)
+			_, err := loadZoneFiles([]string{file}, tt.base)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), file) {
+				t.Errorf("got error %v, want one naming %s and containing %q", err, file, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestZoneFileAnswers(t *testing.T) {
+	cfg := &Config{
+		Version:   currentConfigVersion,
+		Server:    ServerConfig{Port: "53"},
+		ZoneFiles: []string{writeZone(t, testZone)},
+		Records:   Records{"app.test.com": {{Type: "A", Value: "10.0.0.1", TTL: 60}}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []string
+	}{
+		{name: "host.zone.test", qtype: dns.TypeA, want: []string{"10.0.1.1"}},
+		{name: "zone.test", qtype: dns.TypeMX, want: []string{"10 mail.zone.test."}},
+		{name: "app.test.com", qtype: dns.TypeA, want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ask(t, s, tt.name, tt.qtype)
+			if got := answerValues(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteZoneFileRoundTrip(t *testing.T) {
+	records, err := LoadZoneFile(writeZone(t, testZone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteZoneFile(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadZoneFile(writeZone(t, buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, records) {
+		t.Errorf("got %v, want %v", again, records)
+	}
+}
+
+func TestImportedZoneValidates(t *testing.T) {
+	records, err := LoadZoneFile(writeZone(t, `$ORIGIN zone.test.
+$TTL 600
+@       IN MX  0 mail
+@       IN CAA 0 issue "letsencrypt.org"
+mail    IN A   10.0.1.25
+_sip._tcp IN SRV 0 60 5060 mail
+@       IN NAPTR 100 10 "U" "E2U+sip" "!^.*$!sip:info@zone.test!" .
+mail    IN SSHFP 4 2 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+_25._tcp.mail IN TLSA 3 1 1 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+_dns    IN SVCB 1 mail alpn=dot
+www     IN HTTPS 1 . alpn=h2,h3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRecords(records); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+	want := []Record{
+		{Type: "MX", Value: "mail.zone.test.", TTL: 600},
+		{Type: "CAA", Value: `0 issue "letsencrypt.org"`, TTL: 600},
+		{Type: "NAPTR", Value: `100 10 "U" "E2U+sip" "!^.*$!sip:info@zone.test!" .`, TTL: 600},
+	}
+	if got := records["zone.test"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
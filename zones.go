@@ -0,0 +1,166 @@
+package easydns
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultSOARefresh = 3600
+	defaultSOARetry   = 600
+	defaultSOAExpire  = 604800
+	defaultSOAMinimum = 300
+)
+
+// ZoneConfig makes easydns authoritative for a zone. The SOA record of the
+// zone is built from these fields and served at its apex along with NS.
+type ZoneConfig struct {
+	MName string `json:"mname"` // Primary name server of the zone
+	// RName is the mailbox of the zone's administrator, either as
+	// hostmaster.example.com or hostmaster@example.com
+	RName string `json:"rname"`
+	// Serial is incremented automatically whenever the records of the zone
+	// change when 0
+	Serial  uint32   `json:"serial,omitempty"`
+	Refresh uint32   `json:"refresh,omitempty"` // Seconds, defaults to 3600
+	Retry   uint32   `json:"retry,omitempty"`   // Seconds, defaults to 600
+	Expire  uint32   `json:"expire,omitempty"`  // Seconds, defaults to 604800
+	Minimum uint32   `json:"minimum,omitempty"` // Negative caching TTL in seconds, defaults to 300
+	NS      []string `json:"ns,omitempty"`
+	TTL     uint32   `json:"ttl,omitempty"` // TTL of the SOA and NS records, the default TTL when 0
+	// DNSSEC signs the zone on the fly with the keys in signing.key_dir
+	DNSSEC bool `json:"dnssec,omitempty"`
+}
+
+func (c ZoneConfig) validate() error {
+	if _, ok := dns.IsDomainName(c.MName); !ok || c.MName == "" {
+		return fmt.Errorf("mname %q is not a valid domain name", c.MName)
+	}
+	if _, ok := dns.IsDomainName(c.mailbox()); !ok || c.RName == "" {
+		return fmt.Errorf("rname %q is not a valid mailbox", c.RName)
+	}
+	for _, ns := range c.NS {
+		if _, ok := dns.IsDomainName(ns); !ok || ns == "" {
+			return fmt.Errorf("ns %q is not a valid domain name", ns)
+		}
+	}
+	return nil
+}
+
+// mailbox returns RName in the domain name form used in SOA records
+func (c ZoneConfig) mailbox() string {
+	return strings.Replace(c.RName, "@", ".", 1)
+}
+
+// apexRecords returns the SOA and NS records of the zone with serial
+func (c ZoneConfig) apexRecords(serial uint32) []Record {
+	orDefault := func(value, fallback uint32) uint32 {
+		if value == 0 {
+			return fallback
+		}
+		return value
+	}
+	records := []Record{{
+		Type: "SOA",
+		Value: fmt.Sprintf("%s %s %d %d %d %d %d", dns.Fqdn(c.MName), dns.Fqdn(c.mailbox()), serial,
+			orDefault(c.Refresh, defaultSOARefresh), orDefault(c.Retry, defaultSOARetry),
+			orDefault(c.Expire, defaultSOAExpire), orDefault(c.Minimum, defaultSOAMinimum)),
+		TTL: c.TTL,
+	}}
+	for _, ns := range c.NS {
+		records = append(records, Record{Type: "NS", Value: dns.Fqdn(ns), TTL: c.TTL})
+	}
+	return records
+}
+
+// authoritativeZoneNames returns every zone easydns answers authoritatively:
+// the authoritative zones, the configured zones and the secondary zones
+func (c *Config) authoritativeZoneNames() []string {
+	names := append([]string(nil), c.AuthoritativeZones...)
+	for zone := range c.Zones {
+		names = append(names, zone)
+	}
+	return append(names, c.Transfer.secondaryZoneNames()...)
+}
+
+type zoneSerial struct {
+	serial  uint32
+	records Records // The records of the zone the serial was set for
+}
+
+// zoneSerials keeps the automatic serials of the configured zones. A serial
+// is the Unix time of the first records seen, and increases by at least one
+// whenever the records of the zone change, so it also increases across
+// restarts.
+type zoneSerials struct {
+	mu      sync.Mutex
+	serials map[string]zoneSerial
+}
+
+func newZoneSerials() *zoneSerials {
+	return &zoneSerials{serials: map[string]zoneSerial{}}
+}
+
+// serial returns the serial of zone for its current records
+func (z *zoneSerials) serial(zone string, records Records) uint32 {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	previous, found := z.serials[zone]
+	if found && reflect.DeepEqual(previous.records, records) {
+		return previous.serial
+	}
+	serial := uint32(now().Unix())
+	if found {
+		serial = max(previous.serial+1, serial)
+		log.Printf("records of zone %s changed, serial is now %d", zone, serial)
+	}
+	// Copied as the served records are modified in place, e.g. by the
+	// default TTL
+	z.serials[zone] = zoneSerial{serial: serial, records: normalizeRecords(records)}
+	return serial
+}
+
+// withZones adds the SOA and NS records of zones to records, replacing the
+// SOA record and, if the zone lists name servers, the NS records at the
+// apex
+func (z *zoneSerials) withZones(records Records, zones map[string]ZoneConfig) Records {
+	for name, cfg := range zones {
+		zone := recordName(name)
+		apex := withoutType(records[zone], "SOA")
+		if len(cfg.NS) > 0 {
+			apex = withoutType(apex, "NS")
+		}
+		contents := Records{}
+		for name, set := range records {
+			if name != zone && dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+				contents[name] = set
+			}
+		}
+		if len(apex) > 0 {
+			contents[zone] = apex
+		}
+		serial := cfg.Serial
+		if serial == 0 {
+			serial = z.serial(zone, contents)
+		}
+		records[zone] = append(cfg.apexRecords(serial), apex...)
+	}
+	return records
+}
+
+// withoutType returns a copy of set without the records of type
+// recordType
+func withoutType(set []Record, recordType string) []Record {
+	var kept []Record
+	for _, record := range set {
+		if record.Type != recordType {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,122 @@
+package easydns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestZones(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return base }
+	config := func(records Records) *Config {
+		return &Config{
+			Version: currentConfigVersion,
+			Server:  ServerConfig{Port: "53"},
+			Zones: map[string]ZoneConfig{"home.arpa": {
+				MName: "ns1.home.arpa",
+				RName: "hostmaster@home.arpa",
+				NS:    []string{"ns1.home.arpa", "ns2.home.arpa"},
+				TTL:   3600,
+			}},
+			Records: records,
+		}
+	}
+	s, err := New(config(Records{"host.home.arpa": {{Type: "A", Value: "10.0.0.1", TTL: 60}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := uint32(base.Unix())
+	tests := []struct {
+		name       string
+		records    Records // Reloaded before the queries when set
+		wantSerial uint32
+	}{
+		{name: "serial starts at the current time", wantSerial: first},
+		{name: "unchanged records keep the serial", records: Records{"host.home.arpa": {{Type: "A", Value: "10.0.0.1", TTL: 60}}}, wantSerial: first},
+		{name: "changed records increment the serial", records: Records{"host.home.arpa": {{Type: "A", Value: "10.0.0.2", TTL: 60}}}, wantSerial: first + 1},
+		{name: "records outside the zone don't change it", records: Records{
+			"host.home.arpa": {{Type: "A", Value: "10.0.0.2", TTL: 60}},
+			"app.test.com":   {{Type: "A", Value: "10.0.1.1", TTL: 60}},
+		}, wantSerial: first + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.records != nil {
+				if err := s.Reload(config(tt.records)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp := ask(t, s, "home.arpa", dns.TypeSOA)
+			if len(resp.Answer) != 1 {
+				t.Fatalf("got answers %v, want the SOA record", resp.Answer)
+			}
+			soa := resp.Answer[0].(*dns.SOA)
+			if soa.Serial != tt.wantSerial {
+				t.Errorf("got serial %d, want %d", soa.Serial, tt.wantSerial)
+			}
+			if soa.Ns != "ns1.home.arpa." || soa.Mbox != "hostmaster.home.arpa." || soa.Refresh != defaultSOARefresh || soa.Minttl != defaultSOAMinimum {
+				t.Errorf("got SOA %v, want one built from the zone config", soa)
+			}
+			if got := answerValues(ask(t, s, "home.arpa", dns.TypeNS)); !reflect.DeepEqual(got, []string{"ns1.home.arpa.", "ns2.home.arpa."}) {
+				t.Errorf("got NS %v, want both name servers", got)
+			}
+			// The zone is authoritative without listing it
+			resp = ask(t, s, "missing.home.arpa", dns.TypeA)
+			if resp.Rcode != dns.RcodeNameError || !resp.Authoritative || len(resp.Ns) != 1 {
+				t.Errorf("got rcode %s, AA %v and authority %v, want an authoritative NXDOMAIN with the SOA record", dns.RcodeToString[resp.Rcode], resp.Authoritative, resp.Ns)
+			}
+		})
+	}
+}
+
+func TestValidateZones(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		config  ZoneConfig
+		records Records
+		wantErr string
+	}{
+		{name: "valid", zone: "home.arpa", config: ZoneConfig{MName: "ns1.home.arpa", RName: "hostmaster.home.arpa"}},
+		{name: "missing mname", zone: "home.arpa", config: ZoneConfig{RName: "hostmaster.home.arpa"}, wantErr: `zones: home.arpa: mname "" is not a valid domain name`},
+		{name: "missing rname", zone: "home.arpa", config: ZoneConfig{MName: "ns1.home.arpa"}, wantErr: `zones: home.arpa: rname "" is not a valid mailbox`},
+		{name: "invalid zone", zone: "home..arpa", config: ZoneConfig{MName: "ns1.home.arpa", RName: "hostmaster.home.arpa"}, wantErr: `zones: "home..arpa" is not a valid zone`},
+		{
+			name:    "soa record in records",
+			zone:    "home.arpa",
+			config:  ZoneConfig{MName: "ns1.home.arpa", RName: "hostmaster.home.arpa"},
+			records: Records{"home.arpa": {{Type: "SOA", Value: "ns1.home.arpa. hostmaster.home.arpa. 1 3600 600 86400 60", TTL: 60}}},
+			wantErr: "zones: home.arpa: its SOA record is generated",
+		},
+		{
+			name:    "dnssec without a key directory",
+			zone:    "home.arpa",
+			config:  ZoneConfig{MName: "ns1.home.arpa", RName: "hostmaster.home.arpa", DNSSEC: true},
+			wantErr: "signing: key_dir is required to keep the keys of zones with dnssec enabled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(&Config{
+				Version: currentConfigVersion,
+				Server:  ServerConfig{Port: "53"},
+				Zones:   map[string]ZoneConfig{tt.zone: tt.config},
+				Records: tt.records,
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}